@@ -76,6 +76,10 @@ func walletMain() error {
 	}
 	defer wallet.Db().Close()
 
+	// The deposit watchers of the voting pools loaded by RPC requests use
+	// the database, so they are stopped before it is closed.
+	defer stopVotingPools()
+
 	// Create and start HTTP server to serve wallet client connections.
 	// This will be updated with the wallet and chain server RPC client
 	// created below after each is created.
@@ -85,6 +89,23 @@ func walletMain() error {
 		log.Errorf("Unable to create HTTP server: %v", err)
 		return err
 	}
+
+	// Deliver wallet events to any configured webhooks.  The dispatcher
+	// must be set before the server is started.
+	webhooks, err := openWebhooks(wallet)
+	if err != nil {
+		log.Errorf("Unable to open webhooks: %v", err)
+		return err
+	}
+	if webhooks != nil {
+		webhooks.Start()
+		defer func() {
+			webhooks.Stop()
+			webhooks.WaitForShutdown()
+		}()
+		server.webhooks = webhooks
+	}
+
 	server.Start()
 	server.SetWallet(wallet)
 
@@ -68,6 +68,13 @@ type config struct {
 	ShowVersion      bool     `short:"V" long:"version" description:"Display version information and exit"`
 	Create           bool     `long:"create" description:"Create the wallet if it does not exist"`
 	CreateTemp       bool     `long:"createtemp" description:"Create a temporary simulation wallet (pass=password) in the data directory indicated; must call with --datadir"`
+	EncryptDB        bool     `long:"encryptdb" description:"Encrypt all wallet database and transaction store contents with the public passphrase -- Applies to wallets created with --create; an existing unencrypted wallet is migrated"`
+	ReadOnly         bool     `long:"readonly" description:"Open the wallet read-only to inspect it: the wallet database and transaction store are never written, the wallet is not synced with the chain server, and requests which would modify the wallet are rejected"`
+	RestoreBackup    string   `long:"restorebackup" description:"Replace the wallet with the contents of an archive written by the backupwallet RPC, then exit"`
+	UpgradeDryRun    bool     `long:"upgradedryrun" description:"Check that the pending upgrades of the wallet database succeed without applying them, then exit"`
+	CheckDB          bool     `long:"checkdb" description:"Check the wallet database file, the address manager and the transaction store for consistency and print a report, then exit"`
+	RepairDB         bool     `long:"repairdb" description:"Like --checkdb, but also repair the problems which can be repaired, then exit -- The wallet database is backed up first, and an inconsistent transaction store is rebuilt by a rescan on the next start"`
+	CompactDB        bool     `long:"compactdb" description:"Compact the wallet database file to reclaim the space of deleted data, then exit -- Runs after --checkdb and --repairdb when combined with them"`
 	CAFile           string   `long:"cafile" description:"File containing root certificates to authenticate a TLS connections with btcd"`
 	RPCConnect       string   `short:"c" long:"rpcconnect" description:"Hostname/IP and port of btcd RPC server to connect to (default localhost:18334, mainnet: localhost:8334, simnet: localhost:18556)"`
 	DebugLevel       string   `short:"d" long:"debuglevel" description:"Logging level {trace, debug, info, warn, error, critical}"`
@@ -94,6 +101,8 @@ type config struct {
 	ProxyUser        string   `long:"proxyuser" description:"Username for proxy server"`
 	ProxyPass        string   `long:"proxypass" default-mask:"-" description:"Password for proxy server"`
 	Profile          string   `long:"profile" description:"Enable HTTP profiling on given port -- NOTE port must be between 1024 and 65536"`
+	WebhookURLs      []string `long:"webhookurl" description:"POST wallet event notifications to this URL (may be specified multiple times)"`
+	WebhookSecret    string   `long:"webhooksecret" default-mask:"-" description:"Secret used to sign webhook notifications with HMAC-SHA256"`
 }
 
 // cleanAndExpandPath expands environement variables and leading ~ in the
@@ -449,6 +458,54 @@ func loadConfig() (*config, []string, error) {
 
 		// Created successfully, so exit now with success.
 		os.Exit(0)
+	} else if cfg.RestoreBackup != "" {
+		// Ensure the data directory for the network exists.
+		if err := checkCreateDir(netDir); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return nil, nil, err
+		}
+
+		// Validate the archive and replace the wallet files with it.
+		if err := restoreWalletBackup(&cfg); err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to restore wallet backup:",
+				err)
+			return nil, nil, err
+		}
+
+		// Restored successfully, so exit now with success.
+		os.Exit(0)
+	} else if cfg.UpgradeDryRun && fileExists(dbPath) {
+		// Try the pending upgrades of the wallet database.
+		if err := checkWalletDbUpgrades(&cfg); err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to upgrade wallet database:",
+				err)
+			return nil, nil, err
+		}
+
+		// Checked successfully, so exit now with success.
+		os.Exit(0)
+	} else if (cfg.CheckDB || cfg.RepairDB || cfg.CompactDB) &&
+		fileExists(dbPath) {
+
+		// Check, repair or compact the wallet files offline.
+		if err := maintainWalletDb(&cfg); err != nil {
+			fmt.Fprintln(os.Stderr, "Wallet database maintenance "+
+				"failed:", err)
+			return nil, nil, err
+		}
+
+		// Maintained successfully, so exit now with success.
+		os.Exit(0)
+	} else if cfg.EncryptDB && fileExists(dbPath) {
+		// Migrate the existing wallet to an encrypted database.
+		if err := encryptWalletDb(&cfg); err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to encrypt wallet database:",
+				err)
+			return nil, nil, err
+		}
+
+		// Migrated successfully, so exit now with success.
+		os.Exit(0)
 	} else if !fileExists(dbPath) {
 		var err error
 		keystorePath := filepath.Join(netDir, keystore.Filename)
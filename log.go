@@ -23,7 +23,9 @@ import (
 	"github.com/btcsuite/btclog"
 	"github.com/monetas/btcwallet/chain"
 	"github.com/monetas/btcwallet/txstore"
+	"github.com/monetas/btcwallet/votingpool"
 	"github.com/monetas/btcwallet/wallet"
+	"github.com/monetas/btcwallet/webhook"
 	"github.com/btcsuite/seelog"
 )
 
@@ -46,6 +48,8 @@ var (
 	walletLog  = btclog.Disabled
 	txstLog    = btclog.Disabled
 	chainLog   = btclog.Disabled
+	whksLog    = btclog.Disabled
+	vtplLog    = btclog.Disabled
 )
 
 // subsystemLoggers maps each subsystem identifier to its associated logger.
@@ -54,6 +58,8 @@ var subsystemLoggers = map[string]btclog.Logger{
 	"WLLT": walletLog,
 	"TXST": txstLog,
 	"CHNS": chainLog,
+	"WHKS": whksLog,
+	"VTPL": vtplLog,
 }
 
 // logClosure is used to provide a closure over expensive logging operations
@@ -92,6 +98,12 @@ func useLogger(subsystemID string, logger btclog.Logger) {
 	case "CHNS":
 		chainLog = logger
 		chain.UseLogger(logger)
+	case "WHKS":
+		whksLog = logger
+		webhook.UseLogger(logger)
+	case "VTPL":
+		vtplLog = logger
+		votingpool.UseLogger(logger)
 	}
 }
 
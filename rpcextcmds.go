@@ -0,0 +1,797 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package main
+
+// This file defines btcwallet extension commands which are not (yet) part of
+// the btcws package.  They are registered with btcjson as custom commands so
+// they are parsed like every other request.
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/btcsuite/btcd/btcjson"
+)
+
+func init() {
+	btcjson.RegisterCustomCmd("cancelrescan", parseCancelRescanCmd, nil,
+		`cancelrescan id
+Cancel the queued, running, or interrupted rescan with the given ID.`)
+	btcjson.RegisterCustomCmd("getwalletinfo", parseGetWalletInfoCmd, nil,
+		`getwalletinfo
+Return statistics about the wallet database and its address manager,
+transaction store, and lock state.`)
+	btcjson.RegisterCustomCmd("listrescans", parseListRescansCmd, nil,
+		`listrescans
+List all rescans which have not yet finished.`)
+	btcjson.RegisterCustomCmd("rescanblockchain", parseRescanBlockchainCmd, nil,
+		`rescanblockchain ( startheight stopheight )
+Rescan the block chain for transactions relevant to the wallet from
+startheight (default: the earliest address birthday) through stopheight
+(default: the best block).`)
+	btcjson.RegisterCustomCmd("subscribenotifications",
+		parseSubscribeNotificationsCmd, nil,
+		`subscribenotifications ( ["type",...] {"accounts":["name",...],"addresses":["address",...],"minamount":n} )
+Only send notifications of the given types (default: all types) to this
+websocket client.  Transaction notifications may be further limited to
+accounts, addresses, and a minimum amount.  Replaces any previous
+subscription.`)
+	btcjson.RegisterCustomCmd("unsubscribenotifications",
+		parseUnsubscribeNotificationsCmd, nil,
+		`unsubscribenotifications
+Remove the subscription of this websocket client so all notifications are
+sent.`)
+	btcjson.RegisterCustomCmd("watchconfirmations",
+		parseWatchConfirmationsCmd, nil,
+		`watchconfirmations "txid|address" target
+Notify websocket clients when the transaction, or any wallet transaction
+paying to the address, reaches target confirmations, and again if a
+reorganize drops it below target.  Returns the watch ID.`)
+	btcjson.RegisterCustomCmd("unwatchconfirmations",
+		parseUnwatchConfirmationsCmd, nil,
+		`unwatchconfirmations id
+Remove the confirmation watch with the given ID.`)
+	btcjson.RegisterCustomCmd("listconfirmationwatches",
+		parseListConfirmationWatchesCmd, nil,
+		`listconfirmationwatches
+List all registered confirmation watches.`)
+}
+
+// marshalCmd marshals a command with the given ID, method, and parameters
+// into a JSON-RPC request.
+func marshalCmd(id interface{}, method string, params []interface{}) ([]byte, error) {
+	raw, err := btcjson.NewRawCmd(id, method, params)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+// unmarshalRawCmd unmarshals a JSON-RPC request into a RawCmd and parses it
+// using the passed parser.
+func unmarshalRawCmd(b []byte, parser btcjson.RawCmdParser) (btcjson.Cmd, error) {
+	var r btcjson.RawCmd
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, err
+	}
+	return parser(&r)
+}
+
+// GetWalletInfoCmd is a type handling custom marshaling and unmarshaling of
+// getwalletinfo JSON-RPC commands.
+type GetWalletInfoCmd struct {
+	id interface{}
+}
+
+// Enforce that GetWalletInfoCmd satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &GetWalletInfoCmd{}
+
+// NewGetWalletInfoCmd creates a new GetWalletInfoCmd.
+func NewGetWalletInfoCmd(id interface{}) *GetWalletInfoCmd {
+	return &GetWalletInfoCmd{id: id}
+}
+
+// parseGetWalletInfoCmd parses a RawCmd into a concrete type satisifying the
+// btcjson.Cmd interface.  This is used when registering the custom command
+// with the btcjson parser.
+func parseGetWalletInfoCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) != 0 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+	return NewGetWalletInfoCmd(r.Id), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *GetWalletInfoCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *GetWalletInfoCmd) Method() string {
+	return "getwalletinfo"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *GetWalletInfoCmd) MarshalJSON() ([]byte, error) {
+	return marshalCmd(cmd.id, cmd.Method(), []interface{}{})
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of
+// the Cmd interface.
+func (cmd *GetWalletInfoCmd) UnmarshalJSON(b []byte) error {
+	newCmd, err := unmarshalRawCmd(b, parseGetWalletInfoCmd)
+	if err != nil {
+		return err
+	}
+	concreteCmd, ok := newCmd.(*GetWalletInfoCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// ListRescansCmd is a type handling custom marshaling and unmarshaling of
+// listrescans JSON-RPC commands.
+type ListRescansCmd struct {
+	id interface{}
+}
+
+// Enforce that ListRescansCmd satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &ListRescansCmd{}
+
+// NewListRescansCmd creates a new ListRescansCmd.
+func NewListRescansCmd(id interface{}) *ListRescansCmd {
+	return &ListRescansCmd{id: id}
+}
+
+// parseListRescansCmd parses a RawCmd into a concrete type satisifying the
+// btcjson.Cmd interface.  This is used when registering the custom command
+// with the btcjson parser.
+func parseListRescansCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) != 0 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+	return NewListRescansCmd(r.Id), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *ListRescansCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *ListRescansCmd) Method() string {
+	return "listrescans"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *ListRescansCmd) MarshalJSON() ([]byte, error) {
+	return marshalCmd(cmd.id, cmd.Method(), []interface{}{})
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of
+// the Cmd interface.
+func (cmd *ListRescansCmd) UnmarshalJSON(b []byte) error {
+	newCmd, err := unmarshalRawCmd(b, parseListRescansCmd)
+	if err != nil {
+		return err
+	}
+	concreteCmd, ok := newCmd.(*ListRescansCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// CancelRescanCmd is a type handling custom marshaling and unmarshaling of
+// cancelrescan JSON-RPC commands.
+type CancelRescanCmd struct {
+	id     interface{}
+	Rescan uint64
+}
+
+// Enforce that CancelRescanCmd satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &CancelRescanCmd{}
+
+// NewCancelRescanCmd creates a new CancelRescanCmd.
+func NewCancelRescanCmd(id interface{}, rescan uint64) *CancelRescanCmd {
+	return &CancelRescanCmd{id: id, Rescan: rescan}
+}
+
+// parseCancelRescanCmd parses a RawCmd into a concrete type satisifying the
+// btcjson.Cmd interface.  This is used when registering the custom command
+// with the btcjson parser.
+func parseCancelRescanCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) != 1 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+
+	var rescan uint64
+	if err := json.Unmarshal(r.Params[0], &rescan); err != nil {
+		return nil, errors.New("first parameter 'id' must be a " +
+			"non-negative integer: " + err.Error())
+	}
+
+	return NewCancelRescanCmd(r.Id, rescan), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *CancelRescanCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *CancelRescanCmd) Method() string {
+	return "cancelrescan"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *CancelRescanCmd) MarshalJSON() ([]byte, error) {
+	return marshalCmd(cmd.id, cmd.Method(), []interface{}{cmd.Rescan})
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of
+// the Cmd interface.
+func (cmd *CancelRescanCmd) UnmarshalJSON(b []byte) error {
+	newCmd, err := unmarshalRawCmd(b, parseCancelRescanCmd)
+	if err != nil {
+		return err
+	}
+	concreteCmd, ok := newCmd.(*CancelRescanCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// RescanBlockchainCmd is a type handling custom marshaling and unmarshaling
+// of rescanblockchain JSON-RPC commands.
+type RescanBlockchainCmd struct {
+	id          interface{}
+	StartHeight *int32
+	StopHeight  *int32
+}
+
+// Enforce that RescanBlockchainCmd satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &RescanBlockchainCmd{}
+
+// NewRescanBlockchainCmd creates a new RescanBlockchainCmd.  Optionally a
+// start and stop height may be given as the first and second optional
+// parameters.
+func NewRescanBlockchainCmd(id interface{}, optArgs ...int32) (*RescanBlockchainCmd, error) {
+	if len(optArgs) > 2 {
+		return nil, btcjson.ErrTooManyOptArgs
+	}
+	cmd := &RescanBlockchainCmd{id: id}
+	if len(optArgs) > 0 {
+		cmd.StartHeight = &optArgs[0]
+	}
+	if len(optArgs) > 1 {
+		cmd.StopHeight = &optArgs[1]
+	}
+	return cmd, nil
+}
+
+// parseRescanBlockchainCmd parses a RawCmd into a concrete type satisifying
+// the btcjson.Cmd interface.  This is used when registering the custom
+// command with the btcjson parser.
+func parseRescanBlockchainCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) > 2 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+
+	optArgs := make([]int32, 0, len(r.Params))
+	for i, name := range []string{"startheight", "stopheight"} {
+		if len(r.Params) <= i {
+			break
+		}
+		var height int32
+		if err := json.Unmarshal(r.Params[i], &height); err != nil {
+			return nil, errors.New("parameter '" + name + "' must " +
+				"be an integer: " + err.Error())
+		}
+		optArgs = append(optArgs, height)
+	}
+
+	return NewRescanBlockchainCmd(r.Id, optArgs...)
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *RescanBlockchainCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *RescanBlockchainCmd) Method() string {
+	return "rescanblockchain"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *RescanBlockchainCmd) MarshalJSON() ([]byte, error) {
+	params := make([]interface{}, 0, 2)
+	if cmd.StartHeight != nil {
+		params = append(params, *cmd.StartHeight)
+		if cmd.StopHeight != nil {
+			params = append(params, *cmd.StopHeight)
+		}
+	}
+	return marshalCmd(cmd.id, cmd.Method(), params)
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of
+// the Cmd interface.
+func (cmd *RescanBlockchainCmd) UnmarshalJSON(b []byte) error {
+	newCmd, err := unmarshalRawCmd(b, parseRescanBlockchainCmd)
+	if err != nil {
+		return err
+	}
+	concreteCmd, ok := newCmd.(*RescanBlockchainCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// NotificationFilter limits the transaction notifications sent to a websocket
+// client subscribed with a subscribenotifications request.
+type NotificationFilter struct {
+	Accounts  []string `json:"accounts,omitempty"`
+	Addresses []string `json:"addresses,omitempty"`
+	MinAmount float64  `json:"minamount,omitempty"`
+}
+
+// SubscribeNotificationsCmd is a type handling custom marshaling and
+// unmarshaling of subscribenotifications JSON-RPC commands.
+type SubscribeNotificationsCmd struct {
+	id     interface{}
+	Types  []string
+	Filter *NotificationFilter
+}
+
+// Enforce that SubscribeNotificationsCmd satisifies the btcjson.Cmd
+// interface.
+var _ btcjson.Cmd = &SubscribeNotificationsCmd{}
+
+// NewSubscribeNotificationsCmd creates a new SubscribeNotificationsCmd.
+func NewSubscribeNotificationsCmd(id interface{}, types []string,
+	filter *NotificationFilter) *SubscribeNotificationsCmd {
+
+	return &SubscribeNotificationsCmd{id: id, Types: types, Filter: filter}
+}
+
+// parseSubscribeNotificationsCmd parses a RawCmd into a concrete type
+// satisifying the btcjson.Cmd interface.  This is used when registering the
+// custom command with the btcjson parser.
+func parseSubscribeNotificationsCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) > 2 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+
+	var types []string
+	if len(r.Params) > 0 {
+		if err := json.Unmarshal(r.Params[0], &types); err != nil {
+			return nil, errors.New("first optional parameter " +
+				"'types' must be an array of strings: " +
+				err.Error())
+		}
+	}
+	var filter *NotificationFilter
+	if len(r.Params) > 1 {
+		filter = new(NotificationFilter)
+		if err := json.Unmarshal(r.Params[1], filter); err != nil {
+			return nil, errors.New("second optional parameter " +
+				"'filter' must be a JSON object: " + err.Error())
+		}
+	}
+
+	return NewSubscribeNotificationsCmd(r.Id, types, filter), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *SubscribeNotificationsCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *SubscribeNotificationsCmd) Method() string {
+	return "subscribenotifications"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *SubscribeNotificationsCmd) MarshalJSON() ([]byte, error) {
+	params := make([]interface{}, 0, 2)
+	if cmd.Types != nil || cmd.Filter != nil {
+		types := cmd.Types
+		if types == nil {
+			types = []string{}
+		}
+		params = append(params, types)
+		if cmd.Filter != nil {
+			params = append(params, cmd.Filter)
+		}
+	}
+	return marshalCmd(cmd.id, cmd.Method(), params)
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of
+// the Cmd interface.
+func (cmd *SubscribeNotificationsCmd) UnmarshalJSON(b []byte) error {
+	newCmd, err := unmarshalRawCmd(b, parseSubscribeNotificationsCmd)
+	if err != nil {
+		return err
+	}
+	concreteCmd, ok := newCmd.(*SubscribeNotificationsCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// UnsubscribeNotificationsCmd is a type handling custom marshaling and
+// unmarshaling of unsubscribenotifications JSON-RPC commands.
+type UnsubscribeNotificationsCmd struct {
+	id interface{}
+}
+
+// Enforce that UnsubscribeNotificationsCmd satisifies the btcjson.Cmd
+// interface.
+var _ btcjson.Cmd = &UnsubscribeNotificationsCmd{}
+
+// NewUnsubscribeNotificationsCmd creates a new UnsubscribeNotificationsCmd.
+func NewUnsubscribeNotificationsCmd(id interface{}) *UnsubscribeNotificationsCmd {
+	return &UnsubscribeNotificationsCmd{id: id}
+}
+
+// parseUnsubscribeNotificationsCmd parses a RawCmd into a concrete type
+// satisifying the btcjson.Cmd interface.  This is used when registering the
+// custom command with the btcjson parser.
+func parseUnsubscribeNotificationsCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) != 0 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+	return NewUnsubscribeNotificationsCmd(r.Id), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *UnsubscribeNotificationsCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *UnsubscribeNotificationsCmd) Method() string {
+	return "unsubscribenotifications"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *UnsubscribeNotificationsCmd) MarshalJSON() ([]byte, error) {
+	return marshalCmd(cmd.id, cmd.Method(), []interface{}{})
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of
+// the Cmd interface.
+func (cmd *UnsubscribeNotificationsCmd) UnmarshalJSON(b []byte) error {
+	newCmd, err := unmarshalRawCmd(b, parseUnsubscribeNotificationsCmd)
+	if err != nil {
+		return err
+	}
+	concreteCmd, ok := newCmd.(*UnsubscribeNotificationsCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// WatchConfirmationsCmd is a type handling custom marshaling and
+// unmarshaling of watchconfirmations JSON-RPC commands.
+type WatchConfirmationsCmd struct {
+	id interface{}
+
+	// Watched is either a transaction hash or an address.
+	Watched string
+	Target  int32
+}
+
+// Enforce that WatchConfirmationsCmd satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &WatchConfirmationsCmd{}
+
+// NewWatchConfirmationsCmd creates a new WatchConfirmationsCmd.
+func NewWatchConfirmationsCmd(id interface{}, watched string, target int32) *WatchConfirmationsCmd {
+	return &WatchConfirmationsCmd{id: id, Watched: watched, Target: target}
+}
+
+// parseWatchConfirmationsCmd parses a RawCmd into a concrete type satisifying
+// the btcjson.Cmd interface.  This is used when registering the custom
+// command with the btcjson parser.
+func parseWatchConfirmationsCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) != 2 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+
+	var watched string
+	if err := json.Unmarshal(r.Params[0], &watched); err != nil {
+		return nil, errors.New("first parameter 'txid|address' must " +
+			"be a string: " + err.Error())
+	}
+	var target int32
+	if err := json.Unmarshal(r.Params[1], &target); err != nil {
+		return nil, errors.New("second parameter 'target' must be " +
+			"an integer: " + err.Error())
+	}
+
+	return NewWatchConfirmationsCmd(r.Id, watched, target), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *WatchConfirmationsCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *WatchConfirmationsCmd) Method() string {
+	return "watchconfirmations"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *WatchConfirmationsCmd) MarshalJSON() ([]byte, error) {
+	return marshalCmd(cmd.id, cmd.Method(),
+		[]interface{}{cmd.Watched, cmd.Target})
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of
+// the Cmd interface.
+func (cmd *WatchConfirmationsCmd) UnmarshalJSON(b []byte) error {
+	newCmd, err := unmarshalRawCmd(b, parseWatchConfirmationsCmd)
+	if err != nil {
+		return err
+	}
+	concreteCmd, ok := newCmd.(*WatchConfirmationsCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// UnwatchConfirmationsCmd is a type handling custom marshaling and
+// unmarshaling of unwatchconfirmations JSON-RPC commands.
+type UnwatchConfirmationsCmd struct {
+	id    interface{}
+	Watch uint64
+}
+
+// Enforce that UnwatchConfirmationsCmd satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &UnwatchConfirmationsCmd{}
+
+// NewUnwatchConfirmationsCmd creates a new UnwatchConfirmationsCmd.
+func NewUnwatchConfirmationsCmd(id interface{}, watch uint64) *UnwatchConfirmationsCmd {
+	return &UnwatchConfirmationsCmd{id: id, Watch: watch}
+}
+
+// parseUnwatchConfirmationsCmd parses a RawCmd into a concrete type
+// satisifying the btcjson.Cmd interface.  This is used when registering the
+// custom command with the btcjson parser.
+func parseUnwatchConfirmationsCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) != 1 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+
+	var watch uint64
+	if err := json.Unmarshal(r.Params[0], &watch); err != nil {
+		return nil, errors.New("first parameter 'id' must be a " +
+			"non-negative integer: " + err.Error())
+	}
+
+	return NewUnwatchConfirmationsCmd(r.Id, watch), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *UnwatchConfirmationsCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *UnwatchConfirmationsCmd) Method() string {
+	return "unwatchconfirmations"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *UnwatchConfirmationsCmd) MarshalJSON() ([]byte, error) {
+	return marshalCmd(cmd.id, cmd.Method(), []interface{}{cmd.Watch})
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of
+// the Cmd interface.
+func (cmd *UnwatchConfirmationsCmd) UnmarshalJSON(b []byte) error {
+	newCmd, err := unmarshalRawCmd(b, parseUnwatchConfirmationsCmd)
+	if err != nil {
+		return err
+	}
+	concreteCmd, ok := newCmd.(*UnwatchConfirmationsCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// ListConfirmationWatchesCmd is a type handling custom marshaling and
+// unmarshaling of listconfirmationwatches JSON-RPC commands.
+type ListConfirmationWatchesCmd struct {
+	id interface{}
+}
+
+// Enforce that ListConfirmationWatchesCmd satisifies the btcjson.Cmd
+// interface.
+var _ btcjson.Cmd = &ListConfirmationWatchesCmd{}
+
+// NewListConfirmationWatchesCmd creates a new ListConfirmationWatchesCmd.
+func NewListConfirmationWatchesCmd(id interface{}) *ListConfirmationWatchesCmd {
+	return &ListConfirmationWatchesCmd{id: id}
+}
+
+// parseListConfirmationWatchesCmd parses a RawCmd into a concrete type
+// satisifying the btcjson.Cmd interface.  This is used when registering the
+// custom command with the btcjson parser.
+func parseListConfirmationWatchesCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	if len(r.Params) != 0 {
+		return nil, btcjson.ErrWrongNumberOfParams
+	}
+	return NewListConfirmationWatchesCmd(r.Id), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *ListConfirmationWatchesCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *ListConfirmationWatchesCmd) Method() string {
+	return "listconfirmationwatches"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *ListConfirmationWatchesCmd) MarshalJSON() ([]byte, error) {
+	return marshalCmd(cmd.id, cmd.Method(), []interface{}{})
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of
+// the Cmd interface.
+func (cmd *ListConfirmationWatchesCmd) UnmarshalJSON(b []byte) error {
+	newCmd, err := unmarshalRawCmd(b, parseListConfirmationWatchesCmd)
+	if err != nil {
+		return err
+	}
+	concreteCmd, ok := newCmd.(*ListConfirmationWatchesCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// TxConfirmationsNtfn is the txconfirmations notification sent to websocket
+// clients when a watched transaction reaches, or is reorganized below, the
+// confirmation target of a watch.
+type TxConfirmationsNtfn struct {
+	Watch         uint64
+	TxID          string
+	Confirmations int32
+	Target        int32
+	Reached       bool
+}
+
+// Enforce that TxConfirmationsNtfn satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &TxConfirmationsNtfn{}
+
+// Id satisifies the Cmd interface by returning nil, as notifications do not
+// have IDs.
+func (n *TxConfirmationsNtfn) Id() interface{} {
+	return nil
+}
+
+// Method satisfies the Cmd interface by returning the notification method.
+func (n *TxConfirmationsNtfn) Method() string {
+	return "txconfirmations"
+}
+
+// MarshalJSON returns the JSON encoding of n.  Part of the Cmd interface.
+func (n *TxConfirmationsNtfn) MarshalJSON() ([]byte, error) {
+	return marshalCmd(nil, n.Method(), []interface{}{n.Watch, n.TxID,
+		n.Confirmations, n.Target, n.Reached})
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of n into n.  Part of the Cmd
+// interface.
+func (n *TxConfirmationsNtfn) UnmarshalJSON(b []byte) error {
+	var r btcjson.RawCmd
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+	if len(r.Params) != 5 {
+		return btcjson.ErrWrongNumberOfParams
+	}
+	dests := []interface{}{&n.Watch, &n.TxID, &n.Confirmations,
+		&n.Target, &n.Reached}
+	for i, dest := range dests {
+		if err := json.Unmarshal(r.Params[i], dest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetWalletInfoResult models the data returned from the getwalletinfo command.
+// The fields up to UnlockedUntil match the reply of the reference
+// implementation.  UnlockedUntil is 0 when the wallet is locked or was
+// unlocked without a timeout, so Locked must be checked to tell them apart.
+type GetWalletInfoResult struct {
+	WalletVersion     int32             `json:"walletversion"`
+	Balance           float64           `json:"balance"`
+	TxCount           int               `json:"txcount"`
+	KeypoolOldest     int64             `json:"keypoololdest"`
+	KeypoolSize       int32             `json:"keypoolsize"`
+	UnlockedUntil     int64             `json:"unlocked_until"`
+	Locked            bool              `json:"locked"`
+	DatabaseSize      int64             `json:"databasesize"`
+	TxStoreSize       int64             `json:"txstoresize"`
+	Accounts          int               `json:"accounts"`
+	DerivedAddresses  int               `json:"derivedaddresses"`
+	ImportedAddresses int               `json:"importedaddresses"`
+	Scripts           int               `json:"scripts"`
+	UnspentOutputs    int               `json:"unspentoutputs"`
+	SyncedHeight      int32             `json:"syncedheight"`
+	SyncedHash        string            `json:"syncedhash"`
+	BirthdayHeight    int32             `json:"birthdayheight"`
+	BirthdayHash      string            `json:"birthdayhash"`
+	NamespaceVersions map[string]uint32 `json:"namespaceversions"`
+}
+
+// ListRescansResult models a single object in the reply to a listrescans
+// request.
+type ListRescansResult struct {
+	ID             uint64 `json:"id"`
+	State          string `json:"state"`
+	InitialSync    bool   `json:"initialsync"`
+	Addresses      int    `json:"addresses"`
+	OutPoints      int    `json:"outpoints"`
+	StartHeight    int32  `json:"startheight"`
+	EndHeight      *int32 `json:"endheight,omitempty"`
+	ProgressHeight int32  `json:"progressheight"`
+	ProgressHash   string `json:"progresshash"`
+}
+
+// RescanBlockchainResult models the data returned from the rescanblockchain
+// command.
+type RescanBlockchainResult struct {
+	StartHeight int32 `json:"start_height"`
+	StopHeight  int32 `json:"stop_height"`
+}
+
+// ConfirmationWatchResult models a single object in the reply to a
+// listconfirmationwatches request.  Exactly one of TxID and Address is set.
+type ConfirmationWatchResult struct {
+	ID      uint64 `json:"id"`
+	TxID    string `json:"txid,omitempty"`
+	Address string `json:"address,omitempty"`
+	Target  int32  `json:"target"`
+}
@@ -46,8 +46,10 @@ import (
 	"github.com/btcsuite/btcutil"
 	"github.com/monetas/btcwallet/chain"
 	"github.com/monetas/btcwallet/txstore"
+	"github.com/monetas/btcwallet/votingpool"
 	"github.com/monetas/btcwallet/waddrmgr"
 	"github.com/monetas/btcwallet/wallet"
+	"github.com/monetas/btcwallet/webhook"
 	"github.com/btcsuite/websocket"
 )
 
@@ -114,6 +116,11 @@ var (
 		Code:    btcjson.ErrWallet.Code,
 		Message: "Request requires chain connected chain server",
 	}
+
+	ErrReadOnlyWallet = btcjson.Error{
+		Code:    btcjson.ErrWallet.Code,
+		Message: "Request would modify the wallet, which is read-only",
+	}
 )
 
 // TODO(jrick): There are several error paths which 'replace' various errors
@@ -150,6 +157,10 @@ type websocketClient struct {
 	responses     chan []byte
 	quit          chan struct{} // closed on disconnect
 	wg            sync.WaitGroup
+
+	// filter describes the notifications sent to the client.  It is only
+	// accessed by the notification handler goroutine.
+	filter *wsNotificationFilter
 }
 
 func newWebsocketClient(c *websocket.Conn, authenticated bool, remoteAddr string) *websocketClient {
@@ -305,6 +316,10 @@ type rpcServer struct {
 	registerWSC   chan *websocketClient
 	unregisterWSC chan *websocketClient
 
+	// filterWSC passes subscription changes of websocket clients to the
+	// notification handler.
+	filterWSC chan wsFilterUpdate
+
 	// Channels read from other components from which notifications are
 	// created.
 	connectedBlocks    <-chan waddrmgr.BlockStamp
@@ -316,6 +331,7 @@ type rpcServer struct {
 	managerLocked      <-chan bool
 	confirmedBalance   <-chan btcutil.Amount
 	unconfirmedBalance <-chan btcutil.Amount
+	txConfirmations    <-chan wallet.ConfirmationNotification
 	//chainServerConnected  <-chan bool
 	registerWalletNtfns chan struct{}
 
@@ -324,6 +340,10 @@ type rpcServer struct {
 	enqueueNotification chan wsClientNotification
 	dequeueNotification chan wsClientNotification
 
+	// webhooks, if non-nil, queues webhook events for notifications.  It
+	// must be set before the server is started.
+	webhooks *webhook.Dispatcher
+
 	// notificationHandlerQuit is closed when the notification handler
 	// goroutine shuts down.  After this is closed, no more notifications
 	// will be sent to any websocket client response channel.
@@ -351,6 +371,7 @@ func newRPCServer(listenAddrs []string, maxPost, maxWebsockets int64) (*rpcServe
 		},
 		registerWSC:             make(chan *websocketClient),
 		unregisterWSC:           make(chan *websocketClient),
+		filterWSC:               make(chan wsFilterUpdate),
 		registerWalletNtfns:     make(chan struct{}),
 		enqueueNotification:     make(chan wsClientNotification),
 		dequeueNotification:     make(chan wsClientNotification),
@@ -579,7 +600,7 @@ func (s *rpcServer) SetWallet(wallet *wallet.Wallet) {
 
 		// With both the wallet and chain server set, all handlers are
 		// ok to run.
-		s.handlerLookup = lookupAnyHandler
+		s.handlerLookup = walletHandlerLookup(wallet)
 	}
 }
 
@@ -602,7 +623,7 @@ func (s *rpcServer) SetChainServer(chainSvr *chain.Client) {
 
 		// With both the chain server and wallet set, all handlers are
 		// ok to run.
-		s.handlerLookup = lookupAnyHandler
+		s.handlerLookup = walletHandlerLookup(s.wallet)
 	}
 }
 
@@ -850,6 +871,23 @@ out:
 				}
 
 			default:
+				// Websocket-only requests modify the state of
+				// the client and are handled immediately.
+				if _, ok := wsHandlers[raw.Method]; ok {
+					resp := s.handleWebsocketRequest(wsc,
+						request, &raw)
+					mresp, err := json.Marshal(resp)
+					// Expected to never fail.
+					if err != nil {
+						panic(err)
+					}
+					err = wsc.send(mresp)
+					if err != nil {
+						break out
+					}
+					continue
+				}
+
 				f := s.HandlerClosure(raw.Method)
 				wsc.wg.Add(1)
 				go func(request []byte, raw *rawRequest) {
@@ -1012,6 +1050,10 @@ func (s *rpcServer) PostClientRPC(w http.ResponseWriter, r *http.Request) {
 		s.Stop()
 		resp = makeResponse(raw.ID, "btcwallet stopping.", nil)
 	default:
+		if _, ok := wsHandlers[raw.Method]; ok {
+			resp = makeResponse(raw.ID, nil, ErrWebsocketOnly)
+			break
+		}
 		resp = s.HandlerClosure(raw.Method)(rpcRequest, &raw)
 	}
 
@@ -1046,6 +1088,8 @@ type (
 	confirmedBalance   btcutil.Amount
 	unconfirmedBalance btcutil.Amount
 
+	txConfirmations wallet.ConfirmationNotification
+
 	btcdConnected bool
 )
 
@@ -1059,7 +1103,8 @@ func (b blockDisconnected) notificationCmds(w *wallet.Wallet) []btcjson.Cmd {
 	return []btcjson.Cmd{n}
 }
 
-func (c txCredit) notificationCmds(w *wallet.Wallet) []btcjson.Cmd {
+// toJSON returns the name of the account of the credit and its JSON result.
+func (c txCredit) toJSON(w *wallet.Wallet) (string, btcjson.ListTransactionsResult, error) {
 	blk := w.Manager.SyncedTo()
 	acctName := waddrmgr.DefaultAccountName
 	if creditAccount, err := w.CreditAccount(txstore.Credit(c)); err == nil {
@@ -1067,6 +1112,11 @@ func (c txCredit) notificationCmds(w *wallet.Wallet) []btcjson.Cmd {
 		acctName, _ = w.Manager.AccountName(creditAccount)
 	}
 	ltr, err := txstore.Credit(c).ToJSON(acctName, blk.Height, activeNet.Params)
+	return acctName, ltr, err
+}
+
+func (c txCredit) notificationCmds(w *wallet.Wallet) []btcjson.Cmd {
+	acctName, ltr, err := c.toJSON(w)
 	if err != nil {
 		log.Errorf("Cannot create notification for transaction "+
 			"credit: %v", err)
@@ -1108,6 +1158,17 @@ func (b unconfirmedBalance) notificationCmds(w *wallet.Wallet) []btcjson.Cmd {
 	return []btcjson.Cmd{n}
 }
 
+func (c txConfirmations) notificationCmds(w *wallet.Wallet) []btcjson.Cmd {
+	n := &TxConfirmationsNtfn{
+		Watch:         c.WatchID,
+		TxID:          c.TxHash.String(),
+		Confirmations: c.Confirmations,
+		Target:        c.Target,
+		Reached:       c.Reached,
+	}
+	return []btcjson.Cmd{n}
+}
+
 func (b btcdConnected) notificationCmds(w *wallet.Wallet) []btcjson.Cmd {
 	n := btcws.NewBtcdConnectedNtfn(bool(b))
 	return []btcjson.Cmd{n}
@@ -1135,6 +1196,8 @@ out:
 			s.enqueueNotification <- confirmedBalance(n)
 		case n := <-s.unconfirmedBalance:
 			s.enqueueNotification <- unconfirmedBalance(n)
+		case n := <-s.txConfirmations:
+			s.enqueueNotification <- txConfirmations(n)
 
 		// Registration of all notifications is done by the handler so
 		// it doesn't require another rpcServer mutex.
@@ -1195,6 +1258,12 @@ out:
 					"balance changes: %v", err)
 				continue
 			}
+			txConfirmations, err := s.wallet.ListenConfirmations()
+			if err != nil {
+				log.Errorf("Could not register for transaction "+
+					"confirmation notifications: %v", err)
+				continue
+			}
 			s.connectedBlocks = connectedBlocks
 			s.disconnectedBlocks = disconnectedBlocks
 			s.newCredits = newCredits
@@ -1204,6 +1273,7 @@ out:
 			s.managerLocked = managerLocked
 			s.confirmedBalance = confirmedBalance
 			s.unconfirmedBalance = unconfirmedBalance
+			s.txConfirmations = txConfirmations
 
 		case <-s.quit:
 			break out
@@ -1225,6 +1295,7 @@ func (s *rpcServer) drainNotifications() {
 		case <-s.minedDebits:
 		case <-s.confirmedBalance:
 		case <-s.unconfirmedBalance:
+		case <-s.txConfirmations:
 		case <-s.registerWalletNtfns:
 		}
 	}
@@ -1286,18 +1357,37 @@ out:
 		case c := <-s.unregisterWSC:
 			delete(clients, c.quit)
 
+		case u := <-s.filterWSC:
+			u.wsc.filter = u.filter
+
 		case nmsg, ok := <-s.dequeueNotification:
 			// No more notifications.
 			if !ok {
 				break out
 			}
 
+			if s.webhooks != nil {
+				s.sendWebhook(nmsg)
+			}
+
 			// Ignore if there are no clients to receive the
 			// notification.
 			if len(clients) == 0 {
 				continue
 			}
 
+			// Only send the notification to clients whose
+			// subscriptions match it.
+			var recipients []*websocketClient
+			for _, c := range clients {
+				if c.filter.matches(s.wallet, nmsg) {
+					recipients = append(recipients, c)
+				}
+			}
+			if len(recipients) == 0 {
+				continue
+			}
+
 			ns := nmsg.notificationCmds(s.wallet)
 			for _, n := range ns {
 				mn, err := n.MarshalJSON()
@@ -1306,7 +1396,10 @@ out:
 				if err != nil {
 					panic(err)
 				}
-				for _, c := range clients {
+				for _, c := range recipients {
+					if _, ok := clients[c.quit]; !ok {
+						continue
+					}
 					if err := c.send(mn); err != nil {
 						delete(clients, c.quit)
 					}
@@ -1321,6 +1414,31 @@ out:
 	s.wg.Done()
 }
 
+// wsRequestHandler is a handler function for requests which are only
+// available to websocket clients, such as requests modifying the client's
+// notification subscriptions.
+type wsRequestHandler func(*rpcServer, *websocketClient, btcjson.Cmd) (interface{}, error)
+
+var wsHandlers = map[string]wsRequestHandler{
+	"subscribenotifications":   (*rpcServer).SubscribeNotifications,
+	"unsubscribenotifications": (*rpcServer).UnsubscribeNotifications,
+}
+
+// handleWebsocketRequest parses and handles a websocket-only request from a
+// websocket client.
+func (s *rpcServer) handleWebsocketRequest(wsc *websocketClient, request []byte, raw *rawRequest) btcjson.Reply {
+	handler, ok := wsHandlers[raw.Method]
+	if !ok {
+		return makeResponse(raw.ID, nil, btcjson.ErrMethodNotFound)
+	}
+	cmd, err := btcjson.ParseMarshaledCmd(request)
+	if err != nil {
+		return makeResponse(raw.ID, nil, btcjson.ErrInvalidRequest)
+	}
+	result, err := handler(s, wsc, cmd)
+	return makeResponse(raw.ID, result, err)
+}
+
 // requestHandler is a handler function to handle an unmarshaled and parsed
 // request into a marshalable response.  If the error is a btcjson.Error
 // or any of the above special error classes, the server will respond with
@@ -1331,8 +1449,10 @@ type requestHandler func(*wallet.Wallet, *chain.Client, btcjson.Cmd) (interface{
 var rpcHandlers = map[string]requestHandler{
 	// Reference implementation wallet methods (implemented)
 	"addmultisigaddress":     AddMultiSigAddress,
+	"backupwallet":           BackupWallet,
 	"createmultisig":         CreateMultiSig,
 	"dumpprivkey":            DumpPrivKey,
+	"dumpwallet":             DumpWallet,
 	"getaccount":             GetAccount,
 	"getaccountaddress":      GetAccountAddress,
 	"getaddressesbyaccount":  GetAddressesByAccount,
@@ -1345,7 +1465,9 @@ var rpcHandlers = map[string]requestHandler{
 	"getreceivedbyaccount":   GetReceivedByAccount,
 	"getreceivedbyaddress":   GetReceivedByAddress,
 	"gettransaction":         GetTransaction,
+	"getwalletinfo":          GetWalletInfo,
 	"importprivkey":          ImportPrivKey,
+	"importwallet":           ImportWallet,
 	"keypoolrefill":          KeypoolRefill,
 	"listaccounts":           ListAccounts,
 	"listlockunspent":        ListLockUnspent,
@@ -1368,10 +1490,6 @@ var rpcHandlers = map[string]requestHandler{
 	"walletpassphrasechange": WalletPassphraseChange,
 
 	// Reference implementation methods (still unimplemented)
-	"backupwallet":         Unimplemented,
-	"dumpwallet":           Unimplemented,
-	"getwalletinfo":        Unimplemented,
-	"importwallet":         Unimplemented,
 	"listaddressgroupings": Unimplemented,
 
 	// Reference methods which can't be implemented by btcwallet due to
@@ -1381,6 +1499,7 @@ var rpcHandlers = map[string]requestHandler{
 	"setaccount":    Unsupported,
 
 	// Extensions to the reference client JSON-RPC API
+	"cancelrescan":         CancelRescan,
 	"createnewaccount":     CreateNewAccount,
 	"exportwatchingwallet": ExportWatchingWallet,
 	"getbestblock":         GetBestBlock,
@@ -1391,8 +1510,24 @@ var rpcHandlers = map[string]requestHandler{
 	"getunconfirmedbalance":   GetUnconfirmedBalance,
 	"listaddresstransactions": ListAddressTransactions,
 	"listalltransactions":     ListAllTransactions,
+	"listconfirmationwatches": ListConfirmationWatches,
+	"listrescans":             ListRescans,
 	"renameaccount":           RenameAccount,
+	"rescanblockchain":        RescanBlockchain,
+	"unwatchconfirmations":    UnwatchConfirmations,
 	"walletislocked":          WalletIsLocked,
+	"watchconfirmations":      WatchConfirmations,
+
+	// Voting pool extensions
+	"activatevotingpoolseries":    ActivateVotingPoolSeries,
+	"createvotingpool":            CreateVotingPool,
+	"createvotingpoolseries":      CreateVotingPoolSeries,
+	"empowervotingpoolseries":     EmpowerVotingPoolSeries,
+	"getvotingpooldepositaddress": GetVotingPoolDepositAddress,
+	"getvotingpooldepositscript":  GetVotingPoolDepositScript,
+	"replacevotingpoolseries":     ReplaceVotingPoolSeries,
+	"startvotingpoolwithdrawal":   StartVotingPoolWithdrawal,
+	"submitvotingpoolsignatures":  SubmitVotingPoolSignatures,
 }
 
 // Unimplemented handles an unimplemented RPC request with the
@@ -1401,6 +1536,12 @@ func Unimplemented(*wallet.Wallet, *chain.Client, btcjson.Cmd) (interface{}, err
 	return nil, btcjson.ErrUnimplemented
 }
 
+// ReadOnlyWallet handles a request which would modify a read-only wallet with
+// the appropriate error.
+func ReadOnlyWallet(*wallet.Wallet, *chain.Client, btcjson.Cmd) (interface{}, error) {
+	return nil, ErrReadOnlyWallet
+}
+
 // Unsupported handles a standard bitcoind RPC request which is
 // unsupported by btcwallet due to design differences.
 func Unsupported(*wallet.Wallet, *chain.Client, btcjson.Cmd) (interface{}, error) {
@@ -1442,6 +1583,57 @@ func lookupAnyHandler(method string) (f requestHandler, ok bool) {
 	return
 }
 
+// readOnlyWalletRejected holds the methods of rpcHandlers which modify the
+// wallet, and are rejected when it is read-only.
+var readOnlyWalletRejected = map[string]struct{}{
+	"activatevotingpoolseries":   {},
+	"addmultisigaddress":         {},
+	"cancelrescan":               {},
+	"createnewaccount":           {},
+	"createvotingpool":           {},
+	"createvotingpoolseries":     {},
+	"empowervotingpoolseries":    {},
+	"getaccountaddress":          {},
+	"getnewaddress":              {},
+	"getrawchangeaddress":        {},
+	"importprivkey":              {},
+	"importwallet":               {},
+	"keypoolrefill":              {},
+	"lockunspent":                {},
+	"renameaccount":              {},
+	"replacevotingpoolseries":    {},
+	"rescanblockchain":           {},
+	"sendfrom":                   {},
+	"sendmany":                   {},
+	"sendtoaddress":              {},
+	"settxfee":                   {},
+	"startvotingpoolwithdrawal":  {},
+	"submitvotingpoolsignatures": {},
+	"unwatchconfirmations":       {},
+	"walletpassphrasechange":     {},
+	"watchconfirmations":         {},
+}
+
+// walletHandlerLookup returns the function looking up request handlers once
+// both the passed wallet and the chain server are set.
+func walletHandlerLookup(w *wallet.Wallet) func(string) (requestHandler, bool) {
+	if w.ReadOnly() {
+		return lookupReadOnlyHandler
+	}
+	return lookupAnyHandler
+}
+
+// lookupReadOnlyHandler looks up a request handler func for the passed method
+// like lookupAnyHandler, except that the handlers of methods which would
+// modify the wallet are replaced with ReadOnlyWallet.
+func lookupReadOnlyHandler(method string) (f requestHandler, ok bool) {
+	f, ok = rpcHandlers[method]
+	if _, rejected := readOnlyWalletRejected[method]; ok && rejected {
+		f = ReadOnlyWallet
+	}
+	return
+}
+
 // unloadedWalletHandlerFunc looks up whether a request requires a wallet, and
 // if so, returns a specialized handler func to return errors for an unloaded
 // wallet component necessary to complete the request.  If ok is false, the
@@ -1510,6 +1702,8 @@ func jsonError(err error) *btcjson.Error {
 		jsonErr.Code = btcjson.ErrParse.Code
 	case InvalidAddressOrKeyError:
 		jsonErr.Code = btcjson.ErrInvalidAddressOrKey.Code
+	case votingpool.Error:
+		return votingPoolJSONError(e)
 	default: // All other errors get the wallet error code.
 		jsonErr.Code = btcjson.ErrWallet.Code
 	}
@@ -1590,6 +1784,21 @@ func AddMultiSigAddress(w *wallet.Wallet, chainSvr *chain.Client, icmd btcjson.C
 	return addr.Address().EncodeAddress(), nil
 }
 
+// BackupWallet handles a backupwallet request by writing an archive of the
+// wallet database and transaction store to the requested destination.  The
+// archive can be restored with the --restorebackup option.
+func BackupWallet(w *wallet.Wallet, chainSvr *chain.Client, icmd btcjson.Cmd) (interface{}, error) {
+	cmd := icmd.(*btcjson.BackupWalletCmd)
+
+	if err := w.Backup(cmd.Destination); err != nil {
+		return nil, btcjson.Error{
+			Code:    btcjson.ErrWallet.Code,
+			Message: err.Error(),
+		}
+	}
+	return nil, nil
+}
+
 // CreateMultiSig handles an createmultisig request by returning a
 // multisig address for the given inputs.
 func CreateMultiSig(w *wallet.Wallet, chainSvr *chain.Client, icmd btcjson.Cmd) (interface{}, error) {
@@ -1632,16 +1841,17 @@ func DumpPrivKey(w *wallet.Wallet, chainSvr *chain.Client, icmd btcjson.Cmd) (in
 	return key, err
 }
 
-// DumpWallet handles a dumpwallet request by returning  all private
-// keys in a wallet, or an appropiate error if the wallet is locked.
-// TODO: finish this to match bitcoind by writing the dump to a file.
+// DumpWallet handles a dumpwallet request by writing all private keys and
+// scripts of the wallet to a new file in the text format of bitcoind, or
+// returning an appropiate error if the wallet is locked.
 func DumpWallet(w *wallet.Wallet, chainSvr *chain.Client, icmd btcjson.Cmd) (interface{}, error) {
-	keys, err := w.DumpPrivKeys()
+	cmd := icmd.(*btcjson.DumpWalletCmd)
+
+	err := w.DumpWallet(cmd.Filename)
 	if isManagerLockedError(err) {
 		return nil, btcjson.ErrWalletUnlockNeeded
 	}
-
-	return keys, err
+	return nil, err
 }
 
 // ExportWatchingWallet handles an exportwatchingwallet request by exporting
@@ -1765,6 +1975,99 @@ func GetInfo(w *wallet.Wallet, chainSvr *chain.Client, icmd btcjson.Cmd) (interf
 	return info, nil
 }
 
+// GetWalletInfo handles a getwalletinfo request by returning statistics about
+// the wallet database, address manager, and transaction store, along with the
+// fields of the reference implementation's reply.
+func GetWalletInfo(w *wallet.Wallet, chainSvr *chain.Client, icmd btcjson.Cmd) (interface{}, error) {
+	netDir := networkDir(cfg.DataDir, activeNet.Params)
+	dbSize, err := fileSize(filepath.Join(netDir, walletDbName))
+	if err != nil {
+		return nil, err
+	}
+	txStoreSize, err := fileSize(filepath.Join(netDir, txstore.Filename))
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := w.Manager.Stats()
+	if err != nil {
+		return nil, err
+	}
+	unspent, err := w.TxStore.UnspentOutputs()
+	if err != nil {
+		return nil, err
+	}
+	bal, err := w.CalculateBalance(1)
+	if err != nil {
+		return nil, err
+	}
+
+	// The webhook namespace is not reported, as its delivery queue has no
+	// versioned format.  The transaction store is kept in its own file
+	// rather than a namespace, but its serialization version is reported
+	// along with the namespaces.
+	versions := map[string]uint32{
+		string(waddrmgrNamespaceKey): stats.Version,
+		string(walletNamespaceKey):   wallet.NamespaceVersion,
+		"txstore":                    w.TxStore.Version(),
+	}
+	// The voting pool namespace is only reported when it exists.  Asking
+	// for its version must not create it in wallets which have no pool.
+	exists, err := w.NamespaceExists(votingPoolNamespaceKey)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		namespace, err := w.Namespace(votingPoolNamespaceKey)
+		if err != nil {
+			return nil, err
+		}
+		version, err := votingpool.Version(namespace)
+		if err != nil {
+			return nil, err
+		}
+		versions[string(votingPoolNamespaceKey)] = version
+	}
+
+	info := &GetWalletInfoResult{
+		WalletVersion: int32(stats.Version),
+		Balance:       bal.ToBTC(),
+		TxCount:       len(w.TxStore.Records()),
+		// Keypool times are not tracked. set to current time.
+		KeypoolOldest:     time.Now().Unix(),
+		KeypoolSize:       int32(cfg.KeypoolSize),
+		Locked:            w.Locked(),
+		DatabaseSize:      dbSize,
+		TxStoreSize:       txStoreSize,
+		Accounts:          stats.Accounts,
+		DerivedAddresses:  stats.DerivedAddresses,
+		ImportedAddresses: stats.ImportedAddresses,
+		Scripts:           stats.ScriptAddresses,
+		UnspentOutputs:    len(unspent),
+		SyncedHeight:      stats.SyncedTo.Height,
+		SyncedHash:        stats.SyncedTo.Hash.String(),
+		BirthdayHeight:    stats.StartBlock.Height,
+		BirthdayHash:      stats.StartBlock.Hash.String(),
+		NamespaceVersions: versions,
+	}
+	if until := w.UnlockedUntil(); !until.IsZero() {
+		info.UnlockedUntil = until.Unix()
+	}
+	return info, nil
+}
+
+// fileSize returns the size of the file at path, or 0 if it does not exist.
+func fileSize(path string) (int64, error) {
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
 // GetAccount handles a getaccount request by returning the account name
 // associated with a single address.
 func GetAccount(w *wallet.Wallet, chainSvr *chain.Client, icmd btcjson.Cmd) (interface{}, error) {
@@ -1848,8 +2151,17 @@ func ImportPrivKey(w *wallet.Wallet, chainSvr *chain.Client, icmd btcjson.Cmd) (
 		return nil, btcjson.ErrInvalidAddressOrKey
 	}
 
+	// Without a rescan, no transactions of the key before the current
+	// block are found, so the key is born at the synced block rather than
+	// moving the start block of the wallet back to the genesis block.
+	var bs *waddrmgr.BlockStamp
+	if !cmd.Rescan {
+		syncedTo := w.Manager.SyncedTo()
+		bs = &syncedTo
+	}
+
 	// Import the private key, handling any errors.
-	_, err = w.ImportPrivateKey(wif, nil, cmd.Rescan)
+	_, err = w.ImportPrivateKey(wif, bs, cmd.Rescan)
 	switch {
 	case isManagerDuplicateAddressError(err):
 		// Do not return duplicate key errors to the client.
@@ -1861,12 +2173,182 @@ func ImportPrivKey(w *wallet.Wallet, chainSvr *chain.Client, icmd btcjson.Cmd) (
 	return nil, err
 }
 
+// ImportWallet handles an importwallet request by importing the private keys
+// and scripts of a file written by dumpwallet, from either bitcoind or
+// btcwallet, and rescanning for their transactions.
+func ImportWallet(w *wallet.Wallet, chainSvr *chain.Client, icmd btcjson.Cmd) (interface{}, error) {
+	cmd := icmd.(*btcjson.ImportWalletCmd)
+
+	fi, err := os.Open(cmd.Filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+
+	err = w.ImportWallet(fi)
+	if isManagerLockedError(err) {
+		return nil, btcjson.ErrWalletUnlockNeeded
+	}
+	return nil, err
+}
+
 // KeypoolRefill handles the keypoolrefill command. Since we handle the keypool
 // automatically this does nothing since refilling is never manually required.
 func KeypoolRefill(w *wallet.Wallet, chainSvr *chain.Client, icmd btcjson.Cmd) (interface{}, error) {
 	return nil, nil
 }
 
+// ListRescans handles a listrescans extension request by returning the ID,
+// state, and last checkpointed block of every rescan that has not finished.
+func ListRescans(w *wallet.Wallet, chainSvr *chain.Client, icmd btcjson.Cmd) (interface{}, error) {
+	statuses := w.RescanStatuses()
+	results := make([]ListRescansResult, len(statuses))
+	for i, status := range statuses {
+		results[i] = ListRescansResult{
+			ID:             status.ID,
+			State:          string(status.State),
+			InitialSync:    status.InitialSync,
+			Addresses:      status.NumAddrs,
+			OutPoints:      status.NumOutPoints,
+			StartHeight:    status.Start.Height,
+			ProgressHeight: status.Progress.Height,
+			ProgressHash:   status.Progress.Hash.String(),
+		}
+		if status.End != nil {
+			results[i].EndHeight = &status.End.Height
+		}
+	}
+	return results, nil
+}
+
+// RescanBlockchain handles a rescanblockchain extension request by rescanning
+// the block chain between the requested heights for transactions relevant to
+// the wallet.  The reply is not sent until the rescan finishes.
+func RescanBlockchain(w *wallet.Wallet, chainSvr *chain.Client, icmd btcjson.Cmd) (interface{}, error) {
+	cmd := icmd.(*RescanBlockchainCmd)
+
+	best, err := chainSvr.BlockStamp()
+	if err != nil {
+		return nil, err
+	}
+
+	// blockStamp looks up the block stamp of a requested height, which must
+	// be in the main chain.
+	blockStamp := func(height int32) (*waddrmgr.BlockStamp, error) {
+		if height < 0 || height > best.Height {
+			e := fmt.Errorf("block height %d out of range", height)
+			return nil, InvalidParameterError{e}
+		}
+		hash, err := chainSvr.GetBlockHash(int64(height))
+		if err != nil {
+			return nil, err
+		}
+		return &waddrmgr.BlockStamp{Height: height, Hash: *hash}, nil
+	}
+
+	var start, stop *waddrmgr.BlockStamp
+	if cmd.StartHeight != nil {
+		start, err = blockStamp(*cmd.StartHeight)
+		if err != nil {
+			return nil, err
+		}
+	}
+	stopHeight := best.Height
+	if cmd.StopHeight != nil {
+		if start != nil && *cmd.StopHeight < start.Height {
+			e := errors.New("stop height is before start height")
+			return nil, InvalidParameterError{e}
+		}
+		stop, err = blockStamp(*cmd.StopHeight)
+		if err != nil {
+			return nil, err
+		}
+		stopHeight = stop.Height
+	}
+
+	bs, err := w.RescanBlockchain(start, stop)
+	if err != nil {
+		return nil, err
+	}
+	return &RescanBlockchainResult{
+		StartHeight: bs.Height,
+		StopHeight:  stopHeight,
+	}, nil
+}
+
+// CancelRescan handles a cancelrescan extension request by cancelling the
+// rescan with the given ID.
+func CancelRescan(w *wallet.Wallet, chainSvr *chain.Client, icmd btcjson.Cmd) (interface{}, error) {
+	cmd := icmd.(*CancelRescanCmd)
+
+	err := w.CancelRescan(cmd.Rescan)
+	if err == wallet.ErrRescanNotFound {
+		return nil, InvalidParameterError{err}
+	}
+	return nil, err
+}
+
+// WatchConfirmations handles a watchconfirmations extension request by
+// registering a confirmation watch for a transaction hash or address.  The
+// parameter is treated as a transaction hash if it is 64 hexadecimal
+// characters, and as an address otherwise.
+func WatchConfirmations(w *wallet.Wallet, chainSvr *chain.Client, icmd btcjson.Cmd) (interface{}, error) {
+	cmd := icmd.(*WatchConfirmationsCmd)
+
+	var txSha *wire.ShaHash
+	var addr btcutil.Address
+	if len(cmd.Watched) == 2*wire.HashSize {
+		var err error
+		txSha, err = wire.NewShaHashFromStr(cmd.Watched)
+		if err != nil {
+			return nil, btcjson.ErrDecodeHexString
+		}
+	} else {
+		var err error
+		addr, err = btcutil.DecodeAddress(cmd.Watched, activeNet.Params)
+		if err != nil {
+			return nil, btcjson.ErrInvalidAddressOrKey
+		}
+	}
+
+	id, err := w.WatchConfirmations(txSha, addr, cmd.Target)
+	if err == wallet.ErrInvalidConfTarget {
+		return nil, InvalidParameterError{err}
+	}
+	return id, err
+}
+
+// UnwatchConfirmations handles an unwatchconfirmations extension request by
+// removing a confirmation watch.
+func UnwatchConfirmations(w *wallet.Wallet, chainSvr *chain.Client, icmd btcjson.Cmd) (interface{}, error) {
+	cmd := icmd.(*UnwatchConfirmationsCmd)
+
+	err := w.UnwatchConfirmations(cmd.Watch)
+	if err == wallet.ErrConfWatchNotFound {
+		return nil, InvalidParameterError{err}
+	}
+	return nil, err
+}
+
+// ListConfirmationWatches handles a listconfirmationwatches extension request
+// by returning all registered confirmation watches.
+func ListConfirmationWatches(w *wallet.Wallet, chainSvr *chain.Client, icmd btcjson.Cmd) (interface{}, error) {
+	watches := w.ConfirmationWatches()
+	results := make([]ConfirmationWatchResult, len(watches))
+	for i, watch := range watches {
+		results[i] = ConfirmationWatchResult{
+			ID:     watch.ID,
+			Target: watch.Target,
+		}
+		if watch.TxHash != nil {
+			results[i].TxID = watch.TxHash.String()
+		} else {
+			results[i].Address = watch.Address.EncodeAddress()
+		}
+	}
+	return results, nil
+}
+
 // CreateNewAccount handles a createnewaccount request by creating and
 // returning a new account. If the last account has no transaction history
 // as per BIP 0044 a new account cannot be created so an error will be returned.
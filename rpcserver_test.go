@@ -17,13 +17,123 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"reflect"
 	"testing"
 	"time"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/monetas/btcwallet/txstore"
+	"github.com/monetas/btcwallet/votingpool"
+	"github.com/monetas/btcwallet/waddrmgr"
+	"github.com/monetas/btcwallet/wallet"
+	"github.com/monetas/btcwallet/walletdb"
+	_ "github.com/monetas/btcwallet/walletdb/memdb"
+)
+
+var (
+	// testPubPass and testPrivPass are the passphrases of the address
+	// managers of test wallets.
+	testPubPass  = []byte("pub")
+	testPrivPass = []byte("priv")
+
+	// fastScrypt are options passed to the address managers of test
+	// wallets to speed up the scrypt derivations.
+	fastScrypt = &waddrmgr.Options{
+		ScryptN: 16,
+		ScryptR: 8,
+		ScryptP: 1,
+	}
 )
 
+// newTestWallet creates a wallet backed by a memdb database.  When readOnly is
+// set, the wallet is reopened read-only once its namespaces are created, and
+// started without a chain server, which only runs the goroutine handling
+// unlocks.  Writable wallets are not started.  The returned function stops the
+// wallet and closes the database.
+func newTestWallet(t *testing.T, readOnly bool) (*wallet.Wallet, func()) {
+	db, err := walletdb.Create("memdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	namespace, err := db.Namespace(waddrmgrNamespaceKey)
+	if err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	seed := bytes.Repeat([]byte{0x2a}, 32)
+	mgr, err := waddrmgr.Create(namespace, seed, testPubPass, testPrivPass,
+		activeNet.Params, fastScrypt)
+	if err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	config := &wallet.Config{
+		ChainParams: activeNet.Params,
+		Db:          &db,
+		TxStore:     txstore.New(""),
+		Waddrmgr:    mgr,
+	}
+	w, err := wallet.Open(config)
+	if err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	if readOnly {
+		config.ReadOnly = true
+		w, err = wallet.Open(config)
+		if err != nil {
+			db.Close()
+			t.Fatal(err)
+		}
+		w.Start(nil)
+	}
+
+	teardown := func() {
+		if readOnly {
+			w.Stop()
+			w.WaitForShutdown()
+		}
+		mgr.Close()
+		db.Close()
+	}
+	return w, teardown
+}
+
+// callHandler calls the handler of the passed command the way the RPC server
+// does for the wallet, failing the test if it does not return in time.
+func callHandler(t *testing.T, w *wallet.Wallet, cmd btcjson.Cmd) (interface{}, error) {
+	handler, ok := walletHandlerLookup(w)(cmd.Method())
+	if !ok {
+		t.Fatalf("No handler for %s", cmd.Method())
+	}
+	type reply struct {
+		result interface{}
+		err    error
+	}
+	c := make(chan reply, 1)
+	go func() {
+		result, err := handler(w, nil, cmd)
+		c <- reply{result, err}
+	}()
+	select {
+	case r := <-c:
+		return r.result, r.err
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s did not return", cmd.Method())
+		return nil, nil
+	}
+}
+
 func TestThrottle(t *testing.T) {
 	const threshold = 1
 
@@ -54,3 +164,270 @@ func TestThrottle(t *testing.T) {
 		t.Fatalf("status codes: want: %v, got: %v", want, got)
 	}
 }
+
+func TestNotificationFilterTypes(t *testing.T) {
+	var unfiltered *wsNotificationFilter
+	if !unfiltered.matches(nil, managerLocked(true)) {
+		t.Fatal("nil filter must match every notification")
+	}
+
+	cmd := NewSubscribeNotificationsCmd(1,
+		[]string{ntfnBlockConnected, ntfnWalletLockState}, nil)
+	f, err := newWSNotificationFilter(nil, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		n    wsClientNotification
+		want bool
+	}{
+		{blockConnected{}, true},
+		{blockDisconnected{}, false},
+		{managerLocked(false), true},
+		{confirmedBalance(0), false},
+		{btcdConnected(true), false},
+	}
+	for i, test := range tests {
+		if got := f.matches(nil, test.n); got != test.want {
+			t.Errorf("Test #%d (%T): got %v, want %v", i, test.n,
+				got, test.want)
+		}
+	}
+
+	cmd = NewSubscribeNotificationsCmd(1, []string{"notatype"}, nil)
+	if _, err := newWSNotificationFilter(nil, cmd); err == nil {
+		t.Fatal("Expected error for unknown notification type")
+	}
+}
+
+// TestNotificationFilterDebitAddresses ensures debits are matched against the
+// addresses of the spent credits rather than the outputs of the debiting
+// transaction.
+func TestNotificationFilterDebitAddresses(t *testing.T) {
+	newAddr := func(b byte) btcutil.Address {
+		addr, err := btcutil.NewAddressPubKeyHash(
+			bytes.Repeat([]byte{b}, 20), activeNet.Params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return addr
+	}
+	payTo := func(addr btcutil.Address) *wire.TxOut {
+		pkScript, err := txscript.PayToAddrScript(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return wire.NewTxOut(1e8, pkScript)
+	}
+	filtered, unfiltered := newAddr(1), newAddr(2)
+
+	// Pay to the filtered address, and spend that output to the unfiltered
+	// address.
+	recvTx := wire.NewMsgTx()
+	recvTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&wire.ShaHash{}, 0), nil))
+	recvTx.AddTxOut(payTo(filtered))
+	spendTx := wire.NewMsgTx()
+	recvHash := recvTx.TxSha()
+	spendTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&recvHash, 0), nil))
+	spendTx.AddTxOut(payTo(unfiltered))
+
+	s := txstore.New("")
+	r, err := s.InsertTx(btcutil.NewTx(recvTx), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.AddCredit(0, false); err != nil {
+		t.Fatal(err)
+	}
+	r, err = s.InsertTx(btcutil.NewTx(spendTx), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := r.AddDebits()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		addr btcutil.Address
+		want bool
+	}{
+		{filtered, true},
+		{unfiltered, false},
+	}
+	for _, test := range tests {
+		cmd := NewSubscribeNotificationsCmd(1, nil, &NotificationFilter{
+			Addresses: []string{test.addr.EncodeAddress()},
+		})
+		f, err := newWSNotificationFilter(nil, cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := f.matches(nil, txDebit(d)); got != test.want {
+			t.Errorf("Debit filtered by %v: got %v, want %v",
+				test.addr, got, test.want)
+		}
+	}
+}
+
+// TestImportPrivKeyWithoutRescanBirthday ensures a key imported without a
+// rescan is born at the synced block instead of the genesis block.
+func TestImportPrivKeyWithoutRescanBirthday(t *testing.T) {
+	w, teardown := newTestWallet(t, false)
+	defer teardown()
+
+	if err := w.Manager.Unlock(testPrivPass); err != nil {
+		t.Fatal(err)
+	}
+	syncedTo := waddrmgr.BlockStamp{Hash: wire.ShaHash{1}, Height: 100}
+	if err := w.Manager.SetSyncedTo(&syncedTo); err != nil {
+		t.Fatal(err)
+	}
+
+	privKey, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wif, err := btcutil.NewWIF(privKey, activeNet.Params, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := &btcjson.ImportPrivKeyCmd{PrivKey: wif.String(), Rescan: false}
+	if _, err := callHandler(t, w, cmd); err != nil {
+		t.Fatalf("importprivkey: unexpected error: %v", err)
+	}
+
+	addr, err := btcutil.NewAddressPubKey(wif.SerializePubKey(),
+		activeNet.Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	birthday, err := w.Manager.AddressBirthday(addr.AddressPubKeyHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if birthday != syncedTo {
+		t.Fatalf("Wrong birthday; got %+v, want %+v", birthday, syncedTo)
+	}
+}
+
+func TestStartVotingPoolWithdrawalCmdRoundTrip(t *testing.T) {
+	requests := []VotingPoolOutputRequest{
+		{Address: "1MirQ9bwyQcGVJPwKUgapu5ouK2E2Ey4gX", Amount: 0.5,
+			Server: "server", Transaction: 7},
+	}
+	cmd := NewStartVotingPoolWithdrawalCmd(1, "pool", 2, requests,
+		VotingPoolAddress{SeriesID: 1, Branch: 2, Index: 3}, 4,
+		VotingPoolAddress{SeriesID: 4, Index: 5}, 0.0001,
+		VotingPoolFeePolicy{RatePerKB: 0.00002, MinFee: 0.00001, MaxFeeShare: 0.0001})
+
+	marshaled, err := json.Marshal(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := btcjson.ParseMarshaledCmd(marshaled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, cmd) {
+		t.Fatalf("Wrong command after round trip; got %#v, want %#v", parsed, cmd)
+	}
+}
+
+func TestVotingPoolJSONError(t *testing.T) {
+	err := votingpool.Error{
+		ErrorCode:   votingpool.ErrSeriesNotExists,
+		Description: "series #1 does not exist",
+	}
+	jsonErr := jsonError(err)
+	wantCode := votingPoolErrorBase - int(votingpool.ErrSeriesNotExists)
+	if jsonErr.Code != wantCode {
+		t.Errorf("Wrong code; got %d, want %d", jsonErr.Code, wantCode)
+	}
+	wantMsg := "ErrSeriesNotExists: series #1 does not exist"
+	if jsonErr.Message != wantMsg {
+		t.Errorf("Wrong message; got %q, want %q", jsonErr.Message, wantMsg)
+	}
+}
+
+func TestListRescansReadOnly(t *testing.T) {
+	w, teardown := newTestWallet(t, true)
+	defer teardown()
+
+	result, err := callHandler(t, w, NewListRescansCmd(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rescans := result.([]ListRescansResult); len(rescans) != 0 {
+		t.Fatalf("Unexpected rescans: %+v", rescans)
+	}
+
+	// Cancelling a rescan would modify the wallet.
+	_, err = callHandler(t, w, NewCancelRescanCmd(1, 1))
+	if err != ErrReadOnlyWallet {
+		t.Fatalf("Wrong error; got %v, want %v", err, ErrReadOnlyWallet)
+	}
+}
+
+func TestGetWalletInfoVotingPoolNamespace(t *testing.T) {
+	w, teardown := newTestWallet(t, true)
+	defer teardown()
+
+	// The handler reports the sizes of the files in the data directory.
+	dataDir, err := ioutil.TempDir("", "getwalletinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+	defer func(oldCfg *config) { cfg = oldCfg }(cfg)
+	cfg = &config{DataDir: dataDir}
+
+	getVersions := func() map[string]uint32 {
+		result, err := callHandler(t, w, NewGetWalletInfoCmd(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.(*GetWalletInfoResult).NamespaceVersions
+	}
+
+	// Wallets without a voting pool don't report its namespace, nor get
+	// it created by the request.
+	versions := getVersions()
+	if _, ok := versions[string(waddrmgrNamespaceKey)]; !ok {
+		t.Errorf("Missing address manager version: %v", versions)
+	}
+	if versions[string(walletNamespaceKey)] != wallet.NamespaceVersion {
+		t.Errorf("Wrong wallet namespace version: %v", versions)
+	}
+	if versions["txstore"] != w.TxStore.Version() {
+		t.Errorf("Wrong transaction store version: %v", versions)
+	}
+	if version, ok := versions[string(votingPoolNamespaceKey)]; ok {
+		t.Errorf("Unexpected voting pool version %d", version)
+	}
+	exists, err := w.NamespaceExists(votingPoolNamespaceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("getwalletinfo created the voting pool namespace")
+	}
+
+	// Once created, the namespace is reported with its version.
+	namespace, err := w.Namespace(votingPoolNamespaceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := votingpool.Upgrade(namespace, nil); err != nil {
+		t.Fatal(err)
+	}
+	want, err := votingpool.Version(namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions = getVersions()
+	if got, ok := versions[string(votingPoolNamespaceKey)]; !ok || got != want {
+		t.Fatalf("Wrong voting pool version; got %d (reported: %v), "+
+			"want %d", got, ok, want)
+	}
+}
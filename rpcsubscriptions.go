@@ -0,0 +1,289 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcutil"
+	"github.com/monetas/btcwallet/txstore"
+	"github.com/monetas/btcwallet/wallet"
+)
+
+// Notification types websocket clients may subscribe to.  These match the
+// methods of the notifications sent to the clients.
+const (
+	ntfnBlockConnected    = "blockconnected"
+	ntfnBlockDisconnected = "blockdisconnected"
+	ntfnNewTx             = "newtx"
+	ntfnAccountBalance    = "accountbalance"
+	ntfnWalletLockState   = "walletlockstate"
+	ntfnBtcdConnected     = "btcdconnected"
+	ntfnTxConfirmations   = "txconfirmations"
+)
+
+// ErrWebsocketOnly describes an error where a request which modifies the
+// notifications of a websocket client is sent by an HTTP POST client.
+var ErrWebsocketOnly = btcjson.Error{
+	Code:    btcjson.ErrInvalidRequest.Code,
+	Message: "Request is only available to websocket clients",
+}
+
+// wsNotificationFilter describes which notifications are sent to a websocket
+// client.  A nil filter matches every notification, which is the behavior for
+// clients that have not subscribed.
+//
+// Transaction notifications must match every restriction set by the filter:
+// the minimum amount, at least one of the addresses (if any), and at least
+// one of the accounts (if any).  Account, address and amount restrictions do
+// not apply to other notification types.
+type wsNotificationFilter struct {
+	types     map[string]struct{}
+	accounts  map[uint32]struct{}
+	addresses map[string]struct{}
+	minAmount btcutil.Amount
+}
+
+// wsFilterUpdate sets or clears (when filter is nil) the notification filter
+// of a websocket client.
+type wsFilterUpdate struct {
+	wsc    *websocketClient
+	filter *wsNotificationFilter
+}
+
+// newWSNotificationFilter creates a notification filter from the parameters
+// of a subscribenotifications request.  Account names are looked up using
+// the passed wallet.
+func newWSNotificationFilter(w *wallet.Wallet, cmd *SubscribeNotificationsCmd) (*wsNotificationFilter, error) {
+	f := &wsNotificationFilter{}
+
+	if len(cmd.Types) != 0 {
+		f.types = make(map[string]struct{}, len(cmd.Types))
+		for _, t := range cmd.Types {
+			switch t {
+			case ntfnBlockConnected, ntfnBlockDisconnected,
+				ntfnNewTx, ntfnAccountBalance,
+				ntfnWalletLockState, ntfnBtcdConnected,
+				ntfnTxConfirmations:
+			default:
+				e := fmt.Errorf("unknown notification type '%s'", t)
+				return nil, InvalidParameterError{e}
+			}
+			f.types[t] = struct{}{}
+		}
+	}
+
+	if cmd.Filter == nil {
+		return f, nil
+	}
+
+	if cmd.Filter.MinAmount < 0 {
+		return nil, ErrNeedPositiveAmount
+	}
+	minAmount, err := btcutil.NewAmount(cmd.Filter.MinAmount)
+	if err != nil {
+		return nil, InvalidParameterError{err}
+	}
+	f.minAmount = minAmount
+
+	if len(cmd.Filter.Accounts) != 0 {
+		if w == nil {
+			return nil, ErrUnloadedWallet
+		}
+		f.accounts = make(map[uint32]struct{}, len(cmd.Filter.Accounts))
+		for _, name := range cmd.Filter.Accounts {
+			account, err := w.Manager.LookupAccount(name)
+			if err != nil {
+				return nil, ErrAccountNameNotFound
+			}
+			f.accounts[account] = struct{}{}
+		}
+	}
+
+	if len(cmd.Filter.Addresses) != 0 {
+		f.addresses = make(map[string]struct{}, len(cmd.Filter.Addresses))
+		for _, s := range cmd.Filter.Addresses {
+			addr, err := btcutil.DecodeAddress(s, activeNet.Params)
+			if err != nil {
+				return nil, btcjson.ErrInvalidAddressOrKey
+			}
+			f.addresses[addr.EncodeAddress()] = struct{}{}
+		}
+	}
+
+	return f, nil
+}
+
+// wsNotificationType returns the subscription type of a notification.
+func wsNotificationType(n wsClientNotification) string {
+	switch n.(type) {
+	case blockConnected:
+		return ntfnBlockConnected
+	case blockDisconnected:
+		return ntfnBlockDisconnected
+	case txCredit, txDebit:
+		return ntfnNewTx
+	case confirmedBalance, unconfirmedBalance:
+		return ntfnAccountBalance
+	case managerLocked:
+		return ntfnWalletLockState
+	case btcdConnected:
+		return ntfnBtcdConnected
+	case txConfirmations:
+		return ntfnTxConfirmations
+	}
+	return ""
+}
+
+// matches returns whether the notification should be sent to a client with
+// the filter.
+func (f *wsNotificationFilter) matches(w *wallet.Wallet, n wsClientNotification) bool {
+	if f == nil {
+		return true
+	}
+	if f.types != nil {
+		if _, ok := f.types[wsNotificationType(n)]; !ok {
+			return false
+		}
+	}
+
+	switch n := n.(type) {
+	case txCredit:
+		return f.matchesCredit(w, txstore.Credit(n))
+	case txDebit:
+		return f.matchesDebits(w, txstore.Debits(n))
+	}
+	return true
+}
+
+// matchesCredit returns whether a transaction credit matches the amount,
+// address and account restrictions of the filter.
+func (f *wsNotificationFilter) matchesCredit(w *wallet.Wallet, c txstore.Credit) bool {
+	if c.Amount() < f.minAmount {
+		return false
+	}
+	if f.addresses != nil {
+		_, addrs, _, _ := c.Addresses(activeNet.Params)
+		if !f.matchesAddress(addrs) {
+			return false
+		}
+	}
+	if f.accounts != nil {
+		account, err := w.CreditAccount(c)
+		if err != nil {
+			return false
+		}
+		if _, ok := f.accounts[account]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesDebits returns whether a debiting transaction matches the amount,
+// address and account restrictions of the filter.  The debited amount must
+// be at least the minimum amount.  A debit matches the address and account
+// restrictions when any of the previous credits it spends pays to a filtered
+// address or belongs to a filtered account.
+func (f *wsNotificationFilter) matchesDebits(w *wallet.Wallet, d txstore.Debits) bool {
+	if d.InputAmount() < f.minAmount {
+		return false
+	}
+	if f.addresses == nil && f.accounts == nil {
+		return true
+	}
+	spent, err := d.SpentCredits()
+	if err != nil {
+		return false
+	}
+	if f.addresses != nil {
+		matched := false
+		for _, c := range spent {
+			_, addrs, _, _ := c.Addresses(activeNet.Params)
+			if f.matchesAddress(addrs) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if f.accounts != nil {
+		for _, c := range spent {
+			account, err := w.CreditAccount(c)
+			if err != nil {
+				continue
+			}
+			if _, ok := f.accounts[account]; ok {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// matchesAddress returns whether any of the addresses are filtered.
+func (f *wsNotificationFilter) matchesAddress(addrs []btcutil.Address) bool {
+	for _, addr := range addrs {
+		if _, ok := f.addresses[addr.EncodeAddress()]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// SubscribeNotifications handles a subscribenotifications request from a
+// websocket client by replacing the client's notification filter.
+func (s *rpcServer) SubscribeNotifications(wsc *websocketClient, icmd btcjson.Cmd) (interface{}, error) {
+	cmd, ok := icmd.(*SubscribeNotificationsCmd)
+	if !ok {
+		return nil, btcjson.ErrInternal
+	}
+
+	s.handlerLock.Lock()
+	w := s.wallet
+	s.handlerLock.Unlock()
+
+	filter, err := newWSNotificationFilter(w, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return nil, s.updateWSCFilter(wsc, filter)
+}
+
+// UnsubscribeNotifications handles an unsubscribenotifications request from a
+// websocket client by removing the client's notification filter.  All
+// notifications are sent to the client afterwards.
+func (s *rpcServer) UnsubscribeNotifications(wsc *websocketClient, icmd btcjson.Cmd) (interface{}, error) {
+	return nil, s.updateWSCFilter(wsc, nil)
+}
+
+// updateWSCFilter passes a new notification filter for a websocket client to
+// the notification handler.
+func (s *rpcServer) updateWSCFilter(wsc *websocketClient, filter *wsNotificationFilter) error {
+	select {
+	case s.filterWSC <- wsFilterUpdate{wsc, filter}:
+		return nil
+	case <-s.quit:
+		return errors.New("server shutting down")
+	}
+}
@@ -0,0 +1,422 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"sort"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcutil"
+	"github.com/monetas/btcwallet/chain"
+	"github.com/monetas/btcwallet/votingpool"
+	"github.com/monetas/btcwallet/wallet"
+)
+
+const (
+	// votingPoolDepositLookahead is the number of deposit addresses past
+	// the highest used one that are watched for every branch of the voting
+	// pool series that take deposits.
+	votingPoolDepositLookahead = 20
+
+	// votingPoolErrorBase is the JSON-RPC error code used for a
+	// votingpool.Error with error code 0.  Other votingpool.Errors use
+	// votingPoolErrorBase minus their error code, so clients can tell
+	// them apart.
+	votingPoolErrorBase = -1000
+)
+
+// loadedVotingPool is a voting pool loaded by an RPC request, along with the
+// watcher for deposits made to it.
+type loadedVotingPool struct {
+	pool     *votingpool.Pool
+	deposits *votingpool.DepositWatcher
+}
+
+// votingPools holds all voting pools loaded by RPC requests, keyed by pool ID.
+// Pools are loaded only once, so the credit handler of their deposit watcher
+// is registered with the wallet only once.
+var votingPools = struct {
+	sync.Mutex
+	m map[string]*loadedVotingPool
+}{m: make(map[string]*loadedVotingPool)}
+
+// votingPoolJSONError creates a JSON-RPC error from a votingpool.Error.  The
+// message is prefixed with the name of the error code.
+func votingPoolJSONError(err votingpool.Error) *btcjson.Error {
+	return &btcjson.Error{
+		Code:    votingPoolErrorBase - int(err.ErrorCode),
+		Message: err.ErrorCode.String() + ": " + err.Error(),
+	}
+}
+
+// votingPool returns the voting pool with the passed ID, creating it in the
+// wallet database first if create is true.  Pools not loaded before are loaded
+// from the database and their deposit addresses are watched, unless the wallet
+// is read-only.  The wallet must be unlocked as the private keys of the pool's
+// series are decrypted when loading it.
+func votingPool(w *wallet.Wallet, poolID string, create bool) (*loadedVotingPool, error) {
+	if w.Manager.IsLocked() {
+		return nil, btcjson.ErrWalletUnlockNeeded
+	}
+
+	votingPools.Lock()
+	defer votingPools.Unlock()
+
+	lp, ok := votingPools.m[poolID]
+	if ok && !create {
+		return lp, nil
+	}
+
+	namespace, err := w.Namespace(votingPoolNamespaceKey)
+	if err != nil {
+		return nil, err
+	}
+	var pool *votingpool.Pool
+	if create {
+		pool, err = votingpool.Create(namespace, w.Manager, []byte(poolID))
+	} else {
+		pool, err = votingpool.Load(namespace, w.Manager, []byte(poolID))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Deposit addresses are watched by importing them into the address
+	// manager, which is not possible for read-only wallets.  The requests
+	// using the deposit watcher are rejected for them.
+	lp = &loadedVotingPool{pool: pool}
+	if !w.ReadOnly() {
+		lp.deposits = votingpool.NewDepositWatcher(pool, w,
+			votingPoolDepositLookahead)
+		if err := lp.deposits.Refresh(); err != nil {
+			return nil, err
+		}
+		lp.deposits.Start()
+		w.AddCreditHandler(lp.deposits.CreditReceived)
+	}
+	votingPools.m[poolID] = lp
+	return lp, nil
+}
+
+// stopVotingPools stops the deposit watchers of all loaded voting pools and
+// waits for them to finish, so that the wallet database can be closed.
+func stopVotingPools() {
+	votingPools.Lock()
+	defer votingPools.Unlock()
+
+	for _, lp := range votingPools.m {
+		if lp.deposits != nil {
+			lp.deposits.Stop()
+		}
+	}
+	for _, lp := range votingPools.m {
+		if lp.deposits != nil {
+			lp.deposits.WaitForShutdown()
+		}
+	}
+}
+
+// CreateVotingPool handles a createvotingpool extension request by creating a
+// voting pool in the wallet database.
+func CreateVotingPool(w *wallet.Wallet, chainSvr *chain.Client, icmd btcjson.Cmd) (interface{}, error) {
+	cmd := icmd.(*CreateVotingPoolCmd)
+
+	_, err := votingPool(w, cmd.PoolID, true)
+	return nil, err
+}
+
+// CreateVotingPoolSeries handles a createvotingpoolseries extension request by
+// creating a series in a voting pool and watching its deposit addresses.
+func CreateVotingPoolSeries(w *wallet.Wallet, chainSvr *chain.Client, icmd btcjson.Cmd) (interface{}, error) {
+	cmd := icmd.(*CreateVotingPoolSeriesCmd)
+
+	lp, err := votingPool(w, cmd.PoolID, false)
+	if err != nil {
+		return nil, err
+	}
+	err = lp.pool.CreateSeries(cmd.Version, cmd.SeriesID, cmd.ReqSigs, cmd.PubKeys)
+	if err != nil {
+		return nil, err
+	}
+	return nil, lp.deposits.Refresh()
+}
+
+// ReplaceVotingPoolSeries handles a replacevotingpoolseries extension request
+// by replacing the keys of a voting pool series which has not been activated
+// yet, and watching the deposit addresses of the new keys.
+func ReplaceVotingPoolSeries(w *wallet.Wallet, chainSvr *chain.Client, icmd btcjson.Cmd) (interface{}, error) {
+	cmd := icmd.(*ReplaceVotingPoolSeriesCmd)
+
+	lp, err := votingPool(w, cmd.PoolID, false)
+	if err != nil {
+		return nil, err
+	}
+	err = lp.pool.ReplaceSeries(cmd.Version, cmd.SeriesID, cmd.ReqSigs, cmd.PubKeys)
+	if err != nil {
+		return nil, err
+	}
+	return nil, lp.deposits.Refresh()
+}
+
+// ActivateVotingPoolSeries handles an activatevotingpoolseries extension
+// request by activating a voting pool series.
+func ActivateVotingPoolSeries(w *wallet.Wallet, chainSvr *chain.Client, icmd btcjson.Cmd) (interface{}, error) {
+	cmd := icmd.(*ActivateVotingPoolSeriesCmd)
+
+	lp, err := votingPool(w, cmd.PoolID, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := lp.pool.ActivateSeries(cmd.SeriesID); err != nil {
+		return nil, err
+	}
+	return nil, lp.deposits.Refresh()
+}
+
+// EmpowerVotingPoolSeries handles an empowervotingpoolseries extension request
+// by adding a private key to a voting pool series.
+func EmpowerVotingPoolSeries(w *wallet.Wallet, chainSvr *chain.Client, icmd btcjson.Cmd) (interface{}, error) {
+	cmd := icmd.(*EmpowerVotingPoolSeriesCmd)
+
+	lp, err := votingPool(w, cmd.PoolID, false)
+	if err != nil {
+		return nil, err
+	}
+	return nil, lp.pool.EmpowerSeries(cmd.SeriesID, cmd.PrivKey)
+}
+
+// GetVotingPoolDepositScript handles a getvotingpooldepositscript extension
+// request by returning the hex-encoded deposit script for a series, branch and
+// index of a voting pool.
+func GetVotingPoolDepositScript(w *wallet.Wallet, chainSvr *chain.Client, icmd btcjson.Cmd) (interface{}, error) {
+	cmd := icmd.(*GetVotingPoolDepositScriptCmd)
+
+	lp, err := votingPool(w, cmd.PoolID, false)
+	if err != nil {
+		return nil, err
+	}
+	script, err := lp.pool.DepositScript(cmd.SeriesID,
+		votingpool.Branch(cmd.Branch), votingpool.Index(cmd.Index))
+	if err != nil {
+		return nil, err
+	}
+	return hex.EncodeToString(script), nil
+}
+
+// GetVotingPoolDepositAddress handles a getvotingpooldepositaddress extension
+// request by returning the deposit address for a series, branch and index of a
+// voting pool.
+func GetVotingPoolDepositAddress(w *wallet.Wallet, chainSvr *chain.Client, icmd btcjson.Cmd) (interface{}, error) {
+	cmd := icmd.(*GetVotingPoolDepositAddressCmd)
+
+	lp, err := votingPool(w, cmd.PoolID, false)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := lp.pool.DepositScriptAddress(cmd.SeriesID,
+		votingpool.Branch(cmd.Branch), votingpool.Index(cmd.Index))
+	if err != nil {
+		return nil, err
+	}
+	return addr.EncodeAddress(), nil
+}
+
+// StartVotingPoolWithdrawal handles a startvotingpoolwithdrawal extension
+// request by building and signing the transactions of a voting pool withdrawal.
+func StartVotingPoolWithdrawal(w *wallet.Wallet, chainSvr *chain.Client, icmd btcjson.Cmd) (interface{}, error) {
+	cmd := icmd.(*StartVotingPoolWithdrawalCmd)
+
+	lp, err := votingPool(w, cmd.PoolID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	requests := make([]votingpool.OutputRequest, len(cmd.Requests))
+	for i, r := range cmd.Requests {
+		addr, err := btcutil.DecodeAddress(r.Address, activeNet.Params)
+		if err != nil {
+			return nil, btcjson.ErrInvalidAddressOrKey
+		}
+		amount, err := btcutil.NewAmount(r.Amount)
+		if err != nil || amount <= 0 {
+			return nil, btcjson.ErrInvalidParameter
+		}
+		pkScript, err := txscript.PayToAddrScript(addr)
+		if err != nil {
+			return nil, err
+		}
+		requests[i] = votingpool.OutputRequest{
+			Address:     addr,
+			Amount:      amount,
+			PkScript:    pkScript,
+			Server:      r.Server,
+			Transaction: r.Transaction,
+		}
+	}
+	dustThreshold, err := btcutil.NewAmount(cmd.DustThreshold)
+	if err != nil || dustThreshold < 0 {
+		return nil, btcjson.ErrInvalidParameter
+	}
+	var feePolicy votingpool.FeePolicy
+	feeAmounts := []struct {
+		amount *btcutil.Amount
+		btc    float64
+	}{
+		{&feePolicy.RatePerKB, cmd.FeePolicy.RatePerKB},
+		{&feePolicy.MinFee, cmd.FeePolicy.MinFee},
+		{&feePolicy.MaxFeeShare, cmd.FeePolicy.MaxFeeShare},
+	}
+	for _, a := range feeAmounts {
+		*a.amount, err = btcutil.NewAmount(a.btc)
+		if err != nil || *a.amount < 0 {
+			return nil, btcjson.ErrInvalidParameter
+		}
+	}
+
+	startAddress, err := lp.pool.WithdrawalAddress(cmd.StartAddress.SeriesID,
+		votingpool.Branch(cmd.StartAddress.Branch),
+		votingpool.Index(cmd.StartAddress.Index))
+	if err != nil {
+		return nil, err
+	}
+	changeStart, err := lp.pool.ChangeAddress(cmd.ChangeStart.SeriesID,
+		votingpool.Index(cmd.ChangeStart.Index))
+	if err != nil {
+		return nil, err
+	}
+
+	bs, err := chainSvr.BlockStamp()
+	if err != nil {
+		return nil, err
+	}
+	status, err := lp.pool.StartWithdrawal(cmd.RoundID, requests, *startAddress,
+		cmd.LastSeriesID, *changeStart, w.TxStore, bs.Height, dustThreshold, feePolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := status.Outputs()
+	ids := make([]string, 0, len(outputs))
+	for id := range outputs {
+		ids = append(ids, string(id))
+	}
+	sort.Strings(ids)
+	result := StartVotingPoolWithdrawalResult{
+		Outputs: make([]VotingPoolOutputResult, len(ids)),
+		Fees:    status.Fees().ToUnit(btcutil.AmountBTC),
+		NextInputAddr: VotingPoolAddress{
+			SeriesID: status.NextInputAddr().SeriesID(),
+			Branch:   uint32(status.NextInputAddr().Branch()),
+			Index:    uint32(status.NextInputAddr().Index()),
+		},
+		NextChangeAddr: VotingPoolAddress{
+			SeriesID: status.NextChangeAddr().SeriesID(),
+			Index:    uint32(status.NextChangeAddr().Index()),
+		},
+		Sigs: make(map[string][][]string),
+	}
+	for i, id := range ids {
+		output := outputs[votingpool.OutBailmentID(id)]
+		request := output.Request()
+		outpoints := make([]VotingPoolOutpointResult, len(output.Outpoints()))
+		for j, outpoint := range output.Outpoints() {
+			outpoints[j] = VotingPoolOutpointResult{
+				Ntxid:  string(outpoint.Ntxid()),
+				Index:  outpoint.Index(),
+				Amount: outpoint.Amount().ToUnit(btcutil.AmountBTC),
+			}
+		}
+		result.Outputs[i] = VotingPoolOutputResult{
+			Server:          request.Server,
+			Transaction:     request.Transaction,
+			Address:         output.Address(),
+			Amount:          request.Amount.ToUnit(btcutil.AmountBTC),
+			Status:          output.Status(),
+			Fulfilled:       output.Fulfilled().ToUnit(btcutil.AmountBTC),
+			ShortfallReason: output.ShortfallReason(),
+			Outpoints:       outpoints,
+		}
+	}
+	for ntxid, txSigs := range status.Sigs() {
+		result.Sigs[string(ntxid)] = encodeTxSigs(txSigs)
+	}
+	return result, nil
+}
+
+// SubmitVotingPoolSignatures handles a submitvotingpoolsignatures extension
+// request by merging the signatures of voting pool members for a withdrawal
+// transaction.
+func SubmitVotingPoolSignatures(w *wallet.Wallet, chainSvr *chain.Client, icmd btcjson.Cmd) (interface{}, error) {
+	cmd := icmd.(*SubmitVotingPoolSignaturesCmd)
+
+	lp, err := votingPool(w, cmd.PoolID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	memberSigs := make([]votingpool.TxSigs, len(cmd.MemberSigs))
+	for i, inputs := range cmd.MemberSigs {
+		memberSigs[i] = make(votingpool.TxSigs, len(inputs))
+		for j, sigs := range inputs {
+			memberSigs[i][j] = make([]votingpool.RawSig, len(sigs))
+			for k, sig := range sigs {
+				rawSig, err := hex.DecodeString(sig)
+				if err != nil {
+					return nil, btcjson.ErrDecodeHexString
+				}
+				memberSigs[i][j][k] = rawSig
+			}
+		}
+	}
+
+	status, err := lp.pool.MergeTxSigs(cmd.RoundID, votingpool.Ntxid(cmd.Ntxid),
+		memberSigs, w.TxStore)
+	if err != nil {
+		return nil, err
+	}
+
+	result := SubmitVotingPoolSignaturesResult{
+		Sigs:         encodeTxSigs(status.Sigs),
+		InputsSigned: status.InputsSigned,
+	}
+	if status.SignedTx != nil {
+		var buf bytes.Buffer
+		buf.Grow(status.SignedTx.SerializeSize())
+		if err := status.SignedTx.Serialize(&buf); err != nil {
+			panic(err)
+		}
+		result.Hex = hex.EncodeToString(buf.Bytes())
+	}
+	return result, nil
+}
+
+// encodeTxSigs hex-encodes the raw signatures of every input of a voting pool
+// withdrawal transaction.  Missing signatures are encoded as empty strings.
+func encodeTxSigs(txSigs votingpool.TxSigs) [][]string {
+	encoded := make([][]string, len(txSigs))
+	for i, sigs := range txSigs {
+		encoded[i] = make([]string, len(sigs))
+		for j, sig := range sigs {
+			encoded[i][j] = hex.EncodeToString(sig)
+		}
+	}
+	return encoded
+}
@@ -0,0 +1,326 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/monetas/btcwallet/votingpool"
+	"github.com/monetas/btcwallet/wallet"
+)
+
+// newVotingPoolTestWallet creates an unlocked writable wallet for voting pool
+// requests.  The returned function also forgets the voting pools loaded by the
+// requests, as they are tied to the wallet.
+func newVotingPoolTestWallet(t *testing.T) (*wallet.Wallet, func()) {
+	w, teardown := newTestWallet(t, false)
+	if err := w.Manager.Unlock(testPrivPass); err != nil {
+		teardown()
+		t.Fatal(err)
+	}
+	return w, func() {
+		forgetVotingPools()
+		teardown()
+	}
+}
+
+// forgetVotingPools stops the deposit watchers of all voting pools loaded by
+// requests and removes the pools.
+func forgetVotingPools() {
+	stopVotingPools()
+	votingPools.Lock()
+	votingPools.m = make(map[string]*loadedVotingPool)
+	votingPools.Unlock()
+}
+
+// votingPoolTestKeys returns count distinct extended private keys and their
+// public keys.
+func votingPoolTestKeys(t *testing.T, count int) (privKeys, pubKeys []string) {
+	privKeys = make([]string, count)
+	pubKeys = make([]string, count)
+	for i := range privKeys {
+		seed := bytes.Repeat([]byte{byte(i + 1)}, 32)
+		key, err := hdkeychain.NewMaster(seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubKey, err := key.Neuter()
+		if err != nil {
+			t.Fatal(err)
+		}
+		privKeys[i] = key.String()
+		pubKeys[i] = pubKey.String()
+	}
+	return privKeys, pubKeys
+}
+
+// checkRPCError fails the test unless err is sent to clients with the wanted
+// JSON-RPC error code.
+func checkRPCError(t *testing.T, what string, err error, wantCode int) {
+	if err == nil {
+		t.Fatalf("%s: expected error with code %d", what, wantCode)
+	}
+	if code := jsonError(err).Code; code != wantCode {
+		t.Fatalf("%s: wrong error code; got %d (%v), want %d", what,
+			code, err, wantCode)
+	}
+}
+
+// votingPoolErrorCode returns the JSON-RPC error code of votingpool.Errors
+// with the passed error code.
+func votingPoolErrorCode(code votingpool.ErrorCode) int {
+	return votingPoolErrorBase - int(code)
+}
+
+func TestCreateVotingPool(t *testing.T) {
+	w, teardown := newTestWallet(t, false)
+	defer teardown()
+
+	// Pools can only be created with the wallet unlocked.
+	_, err := callHandler(t, w, NewCreateVotingPoolCmd(1, "pool"))
+	checkRPCError(t, "locked", err, btcjson.ErrWalletUnlockNeeded.Code)
+
+	if err := w.Manager.Unlock(testPrivPass); err != nil {
+		t.Fatal(err)
+	}
+	defer forgetVotingPools()
+	if _, err := callHandler(t, w, NewCreateVotingPoolCmd(1, "pool")); err != nil {
+		t.Fatal(err)
+	}
+	_, err = callHandler(t, w, NewCreateVotingPoolCmd(1, "pool"))
+	checkRPCError(t, "existing pool", err,
+		votingPoolErrorCode(votingpool.ErrPoolAlreadyExists))
+
+	_, pubKeys := votingPoolTestKeys(t, 3)
+	_, err = callHandler(t, w, NewCreateVotingPoolSeriesCmd(1, "nopool", 1,
+		1, 2, pubKeys))
+	checkRPCError(t, "missing pool", err,
+		votingPoolErrorCode(votingpool.ErrPoolNotExists))
+}
+
+func TestVotingPoolReadOnly(t *testing.T) {
+	w, teardown := newTestWallet(t, true)
+	defer teardown()
+
+	_, err := callHandler(t, w, NewCreateVotingPoolCmd(1, "pool"))
+	if err != ErrReadOnlyWallet {
+		t.Fatalf("Wrong error; got %v, want %v", err, ErrReadOnlyWallet)
+	}
+}
+
+func TestVotingPoolSeries(t *testing.T) {
+	w, teardown := newVotingPoolTestWallet(t)
+	defer teardown()
+
+	if _, err := callHandler(t, w, NewCreateVotingPoolCmd(1, "pool")); err != nil {
+		t.Fatal(err)
+	}
+	privKeys, pubKeys := votingPoolTestKeys(t, 4)
+
+	errTests := []struct {
+		what     string
+		seriesID uint32
+		pubKeys  []string
+		code     votingpool.ErrorCode
+	}{
+		{"too few keys", 1, pubKeys[:2], votingpool.ErrTooFewPublicKeys},
+		{"private key", 1, append([]string{privKeys[0]}, pubKeys[1:3]...),
+			votingpool.ErrKeyIsPrivate},
+		{"invalid key", 1, []string{"a", "b", "c"}, votingpool.ErrKeyChain},
+		{"not sequential", 2, pubKeys[:3], votingpool.ErrSeriesIDNotSequential},
+	}
+	for _, test := range errTests {
+		_, err := callHandler(t, w, NewCreateVotingPoolSeriesCmd(1, "pool",
+			1, test.seriesID, 2, test.pubKeys))
+		checkRPCError(t, test.what, err, votingPoolErrorCode(test.code))
+	}
+
+	_, err := callHandler(t, w, NewCreateVotingPoolSeriesCmd(1, "pool", 1, 1,
+		2, pubKeys[:3]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = callHandler(t, w, NewCreateVotingPoolSeriesCmd(1, "pool", 1, 1,
+		2, pubKeys[:3]))
+	checkRPCError(t, "existing series", err,
+		votingPoolErrorCode(votingpool.ErrSeriesAlreadyExists))
+
+	// The keys of the series can be replaced until it is empowered.
+	_, err = callHandler(t, w, NewReplaceVotingPoolSeriesCmd(1, "pool", 1,
+		1, 2, pubKeys[1:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = callHandler(t, w, NewEmpowerVotingPoolSeriesCmd(1, "pool", 1,
+		pubKeys[1]))
+	checkRPCError(t, "empower with public key", err,
+		votingPoolErrorCode(votingpool.ErrKeyIsPublic))
+	_, err = callHandler(t, w, NewEmpowerVotingPoolSeriesCmd(1, "pool", 2,
+		privKeys[1]))
+	checkRPCError(t, "empower missing series", err,
+		votingPoolErrorCode(votingpool.ErrSeriesNotExists))
+	_, err = callHandler(t, w, NewEmpowerVotingPoolSeriesCmd(1, "pool", 1,
+		privKeys[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = callHandler(t, w, NewReplaceVotingPoolSeriesCmd(1, "pool", 1,
+		1, 2, pubKeys[:3]))
+	checkRPCError(t, "replace empowered series", err,
+		votingPoolErrorCode(votingpool.ErrSeriesAlreadyEmpowered))
+
+	_, err = callHandler(t, w, NewActivateVotingPoolSeriesCmd(1, "pool", 2))
+	checkRPCError(t, "activate missing series", err,
+		votingPoolErrorCode(votingpool.ErrSeriesNotExists))
+	_, err = callHandler(t, w, NewActivateVotingPoolSeriesCmd(1, "pool", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVotingPoolDeposit(t *testing.T) {
+	w, teardown := newVotingPoolTestWallet(t)
+	defer teardown()
+
+	if _, err := callHandler(t, w, NewCreateVotingPoolCmd(1, "pool")); err != nil {
+		t.Fatal(err)
+	}
+	_, pubKeys := votingPoolTestKeys(t, 3)
+	_, err := callHandler(t, w, NewCreateVotingPoolSeriesCmd(1, "pool", 1, 1,
+		2, pubKeys))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := callHandler(t, w, NewGetVotingPoolDepositScriptCmd(1,
+		"pool", 1, 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, err := hex.DecodeString(result.(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	scriptAddr, err := btcutil.NewAddressScriptHash(script, activeNet.Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err = callHandler(t, w, NewGetVotingPoolDepositAddressCmd(1,
+		"pool", 1, 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.(string) != scriptAddr.EncodeAddress() {
+		t.Fatalf("Wrong deposit address; got %v, want %v", result,
+			scriptAddr.EncodeAddress())
+	}
+
+	// Deposit addresses are watched once the series is created.
+	if _, err := w.Manager.Address(scriptAddr); err != nil {
+		t.Fatalf("Deposit address not imported: %v", err)
+	}
+
+	_, err = callHandler(t, w, NewGetVotingPoolDepositAddressCmd(1, "pool",
+		2, 0, 0))
+	checkRPCError(t, "missing series", err,
+		votingPoolErrorCode(votingpool.ErrSeriesNotExists))
+	_, err = callHandler(t, w, NewGetVotingPoolDepositScriptCmd(1, "pool",
+		1, 4, 0))
+	checkRPCError(t, "invalid branch", err,
+		votingPoolErrorCode(votingpool.ErrInvalidBranch))
+}
+
+func TestStartVotingPoolWithdrawalErrors(t *testing.T) {
+	w, teardown := newVotingPoolTestWallet(t)
+	defer teardown()
+
+	if _, err := callHandler(t, w, NewCreateVotingPoolCmd(1, "pool")); err != nil {
+		t.Fatal(err)
+	}
+	_, pubKeys := votingPoolTestKeys(t, 3)
+	_, err := callHandler(t, w, NewCreateVotingPoolSeriesCmd(1, "pool", 1, 1,
+		2, pubKeys))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr, err := btcutil.NewAddressPubKeyHash(make([]byte, 20),
+		activeNet.Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := VotingPoolOutputRequest{
+		Address:     addr.EncodeAddress(),
+		Amount:      0.5,
+		Server:      "server",
+		Transaction: 1,
+	}
+	feePolicy := VotingPoolFeePolicy{
+		RatePerKB:   0.00002,
+		MinFee:      0.00001,
+		MaxFeeShare: 0.0001,
+	}
+	newCmd := func(requests []VotingPoolOutputRequest, dustThreshold float64,
+		feePolicy VotingPoolFeePolicy) *StartVotingPoolWithdrawalCmd {
+
+		return NewStartVotingPoolWithdrawalCmd(1, "pool", 1, requests,
+			VotingPoolAddress{SeriesID: 1}, 1, VotingPoolAddress{SeriesID: 1},
+			dustThreshold, feePolicy)
+	}
+
+	badAddr := request
+	badAddr.Address = "notanaddress"
+	zeroAmount := request
+	zeroAmount.Amount = 0
+	negativeFee := feePolicy
+	negativeFee.MinFee = -1
+	tests := []struct {
+		what string
+		cmd  *StartVotingPoolWithdrawalCmd
+		code int
+	}{
+		{"invalid address", newCmd([]VotingPoolOutputRequest{badAddr}, 0,
+			feePolicy), btcjson.ErrInvalidAddressOrKey.Code},
+		{"zero amount", newCmd([]VotingPoolOutputRequest{zeroAmount}, 0,
+			feePolicy), btcjson.ErrInvalidParameter.Code},
+		{"negative dust threshold", newCmd([]VotingPoolOutputRequest{request},
+			-1, feePolicy), btcjson.ErrInvalidParameter.Code},
+		{"negative fee", newCmd([]VotingPoolOutputRequest{request}, 0,
+			negativeFee), btcjson.ErrInvalidParameter.Code},
+		// The series takes deposits but is not active yet.
+		{"inactive series", newCmd([]VotingPoolOutputRequest{request}, 0,
+			feePolicy), votingPoolErrorCode(votingpool.ErrSeriesNotHot)},
+	}
+	for _, test := range tests {
+		_, err := callHandler(t, w, test.cmd)
+		checkRPCError(t, test.what, err, test.code)
+	}
+
+	_, err = callHandler(t, w, NewActivateVotingPoolSeriesCmd(1, "pool", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = callHandler(t, w, newCmd([]VotingPoolOutputRequest{request}, 0,
+		feePolicy))
+	checkRPCError(t, "unused start address", err,
+		votingPoolErrorCode(votingpool.ErrWithdrawFromUnusedAddr))
+}
@@ -0,0 +1,760 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package main
+
+// This file defines the voting pool extension commands.  Like the commands in
+// rpcextcmds.go, they are registered with btcjson as custom commands.
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/btcsuite/btcd/btcjson"
+)
+
+func init() {
+	btcjson.RegisterCustomCmd("createvotingpool", parseCreateVotingPoolCmd, nil,
+		`createvotingpool "poolid"
+Create a voting pool with the given ID.`)
+	btcjson.RegisterCustomCmd("createvotingpoolseries",
+		parseCreateVotingPoolSeriesCmd, nil,
+		`createvotingpoolseries "poolid" version seriesid reqsigs ["pubkey",...]
+Create a series in the voting pool with the given extended public keys, of
+which reqsigs are required to sign withdrawals.`)
+	btcjson.RegisterCustomCmd("replacevotingpoolseries",
+		parseReplaceVotingPoolSeriesCmd, nil,
+		`replacevotingpoolseries "poolid" version seriesid reqsigs ["pubkey",...]
+Replace the keys of a voting pool series that has not been activated yet.`)
+	btcjson.RegisterCustomCmd("activatevotingpoolseries",
+		parseActivateVotingPoolSeriesCmd, nil,
+		`activatevotingpoolseries "poolid" seriesid
+Activate a voting pool series so its credits can be used in withdrawals.`)
+	btcjson.RegisterCustomCmd("empowervotingpoolseries",
+		parseEmpowerVotingPoolSeriesCmd, nil,
+		`empowervotingpoolseries "poolid" seriesid "privkey"
+Add the extended private key matching one of the public keys of a voting pool
+series, so this wallet can sign withdrawals from it.`)
+	btcjson.RegisterCustomCmd("getvotingpooldepositscript",
+		parseGetVotingPoolDepositScriptCmd, nil,
+		`getvotingpooldepositscript "poolid" seriesid branch index
+Return the hex-encoded deposit script for the given series, branch and index.`)
+	btcjson.RegisterCustomCmd("getvotingpooldepositaddress",
+		parseGetVotingPoolDepositAddressCmd, nil,
+		`getvotingpooldepositaddress "poolid" seriesid branch index
+Return the P2SH deposit address for the given series, branch and index.`)
+	btcjson.RegisterCustomCmd("startvotingpoolwithdrawal",
+		parseStartVotingPoolWithdrawalCmd, nil,
+		`startvotingpoolwithdrawal "poolid" roundid [{"address":"addr","amount":n,"server":"name","transaction":n},...] {"seriesid":n,"branch":n,"index":n} lastseriesid {"seriesid":n,"index":n} dustthreshold
+Build the transactions of a voting pool withdrawal and sign them with the
+private keys available to this wallet.  Returns the status of every requested
+output and the signatures, keyed by ntxid.`)
+	btcjson.RegisterCustomCmd("submitvotingpoolsignatures",
+		parseSubmitVotingPoolSignaturesCmd, nil,
+		`submitvotingpoolsignatures "poolid" roundid "ntxid" [[["sig",...],...],...]
+Merge the signatures of voting pool members for a withdrawal transaction.  For
+every member, a list of signatures (one per pubkey, or "" if missing) is given
+for every input.  Returns the hex-encoded transaction once it is fully signed.`)
+}
+
+// unmarshalParams unmarshals the params of r into the passed values, which
+// must be pointers.  The error returned for a param that can not be
+// unmarshaled names the param and the kind of value it must hold.
+func unmarshalParams(r *btcjson.RawCmd, names, kinds []string, values ...interface{}) error {
+	if len(r.Params) != len(values) {
+		return btcjson.ErrWrongNumberOfParams
+	}
+	for i, v := range values {
+		if err := json.Unmarshal(r.Params[i], v); err != nil {
+			return errors.New("parameter '" + names[i] + "' must be " +
+				kinds[i] + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// CreateVotingPoolCmd is a type handling custom marshaling and unmarshaling of
+// createvotingpool JSON-RPC commands.
+type CreateVotingPoolCmd struct {
+	id     interface{}
+	PoolID string
+}
+
+// Enforce that CreateVotingPoolCmd satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &CreateVotingPoolCmd{}
+
+// NewCreateVotingPoolCmd creates a new CreateVotingPoolCmd.
+func NewCreateVotingPoolCmd(id interface{}, poolID string) *CreateVotingPoolCmd {
+	return &CreateVotingPoolCmd{id: id, PoolID: poolID}
+}
+
+// parseCreateVotingPoolCmd parses a RawCmd into a concrete type satisifying
+// the btcjson.Cmd interface.  This is used when registering the custom command
+// with the btcjson parser.
+func parseCreateVotingPoolCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	var poolID string
+	err := unmarshalParams(r,
+		[]string{"poolid"},
+		[]string{"a string"},
+		&poolID)
+	if err != nil {
+		return nil, err
+	}
+	return NewCreateVotingPoolCmd(r.Id, poolID), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *CreateVotingPoolCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *CreateVotingPoolCmd) Method() string {
+	return "createvotingpool"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *CreateVotingPoolCmd) MarshalJSON() ([]byte, error) {
+	return marshalCmd(cmd.id, cmd.Method(), []interface{}{cmd.PoolID})
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of
+// the Cmd interface.
+func (cmd *CreateVotingPoolCmd) UnmarshalJSON(b []byte) error {
+	newCmd, err := unmarshalRawCmd(b, parseCreateVotingPoolCmd)
+	if err != nil {
+		return err
+	}
+	concreteCmd, ok := newCmd.(*CreateVotingPoolCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// CreateVotingPoolSeriesCmd is a type handling custom marshaling and
+// unmarshaling of createvotingpoolseries JSON-RPC commands.
+type CreateVotingPoolSeriesCmd struct {
+	id       interface{}
+	PoolID   string
+	Version  uint32
+	SeriesID uint32
+	ReqSigs  uint32
+	PubKeys  []string
+}
+
+// Enforce that CreateVotingPoolSeriesCmd satisifies the btcjson.Cmd interface.
+var _ btcjson.Cmd = &CreateVotingPoolSeriesCmd{}
+
+// NewCreateVotingPoolSeriesCmd creates a new CreateVotingPoolSeriesCmd.
+func NewCreateVotingPoolSeriesCmd(id interface{}, poolID string, version uint32, seriesID uint32, reqSigs uint32, pubKeys []string) *CreateVotingPoolSeriesCmd {
+	return &CreateVotingPoolSeriesCmd{id: id, PoolID: poolID, Version: version, SeriesID: seriesID, ReqSigs: reqSigs, PubKeys: pubKeys}
+}
+
+// parseCreateVotingPoolSeriesCmd parses a RawCmd into a concrete type
+// satisifying the btcjson.Cmd interface.  This is used when registering the
+// custom command with the btcjson parser.
+func parseCreateVotingPoolSeriesCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	var poolID string
+	var version uint32
+	var seriesID uint32
+	var reqSigs uint32
+	var pubKeys []string
+	err := unmarshalParams(r,
+		[]string{"poolid", "version", "seriesid", "reqsigs", "pubkeys"},
+		[]string{"a string", "a non-negative integer", "a non-negative integer", "a non-negative integer", "an array of strings"},
+		&poolID, &version, &seriesID, &reqSigs, &pubKeys)
+	if err != nil {
+		return nil, err
+	}
+	return NewCreateVotingPoolSeriesCmd(r.Id, poolID, version, seriesID, reqSigs, pubKeys), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *CreateVotingPoolSeriesCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *CreateVotingPoolSeriesCmd) Method() string {
+	return "createvotingpoolseries"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *CreateVotingPoolSeriesCmd) MarshalJSON() ([]byte, error) {
+	return marshalCmd(cmd.id, cmd.Method(), []interface{}{cmd.PoolID, cmd.Version, cmd.SeriesID, cmd.ReqSigs, cmd.PubKeys})
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of
+// the Cmd interface.
+func (cmd *CreateVotingPoolSeriesCmd) UnmarshalJSON(b []byte) error {
+	newCmd, err := unmarshalRawCmd(b, parseCreateVotingPoolSeriesCmd)
+	if err != nil {
+		return err
+	}
+	concreteCmd, ok := newCmd.(*CreateVotingPoolSeriesCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// ReplaceVotingPoolSeriesCmd is a type handling custom marshaling and
+// unmarshaling of replacevotingpoolseries JSON-RPC commands.
+type ReplaceVotingPoolSeriesCmd struct {
+	id       interface{}
+	PoolID   string
+	Version  uint32
+	SeriesID uint32
+	ReqSigs  uint32
+	PubKeys  []string
+}
+
+// Enforce that ReplaceVotingPoolSeriesCmd satisifies the btcjson.Cmd
+// interface.
+var _ btcjson.Cmd = &ReplaceVotingPoolSeriesCmd{}
+
+// NewReplaceVotingPoolSeriesCmd creates a new ReplaceVotingPoolSeriesCmd.
+func NewReplaceVotingPoolSeriesCmd(id interface{}, poolID string, version uint32, seriesID uint32, reqSigs uint32, pubKeys []string) *ReplaceVotingPoolSeriesCmd {
+	return &ReplaceVotingPoolSeriesCmd{id: id, PoolID: poolID, Version: version, SeriesID: seriesID, ReqSigs: reqSigs, PubKeys: pubKeys}
+}
+
+// parseReplaceVotingPoolSeriesCmd parses a RawCmd into a concrete type
+// satisifying the btcjson.Cmd interface.  This is used when registering the
+// custom command with the btcjson parser.
+func parseReplaceVotingPoolSeriesCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	var poolID string
+	var version uint32
+	var seriesID uint32
+	var reqSigs uint32
+	var pubKeys []string
+	err := unmarshalParams(r,
+		[]string{"poolid", "version", "seriesid", "reqsigs", "pubkeys"},
+		[]string{"a string", "a non-negative integer", "a non-negative integer", "a non-negative integer", "an array of strings"},
+		&poolID, &version, &seriesID, &reqSigs, &pubKeys)
+	if err != nil {
+		return nil, err
+	}
+	return NewReplaceVotingPoolSeriesCmd(r.Id, poolID, version, seriesID, reqSigs, pubKeys), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *ReplaceVotingPoolSeriesCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *ReplaceVotingPoolSeriesCmd) Method() string {
+	return "replacevotingpoolseries"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *ReplaceVotingPoolSeriesCmd) MarshalJSON() ([]byte, error) {
+	return marshalCmd(cmd.id, cmd.Method(), []interface{}{cmd.PoolID, cmd.Version, cmd.SeriesID, cmd.ReqSigs, cmd.PubKeys})
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of
+// the Cmd interface.
+func (cmd *ReplaceVotingPoolSeriesCmd) UnmarshalJSON(b []byte) error {
+	newCmd, err := unmarshalRawCmd(b, parseReplaceVotingPoolSeriesCmd)
+	if err != nil {
+		return err
+	}
+	concreteCmd, ok := newCmd.(*ReplaceVotingPoolSeriesCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// ActivateVotingPoolSeriesCmd is a type handling custom marshaling and
+// unmarshaling of activatevotingpoolseries JSON-RPC commands.
+type ActivateVotingPoolSeriesCmd struct {
+	id       interface{}
+	PoolID   string
+	SeriesID uint32
+}
+
+// Enforce that ActivateVotingPoolSeriesCmd satisifies the btcjson.Cmd
+// interface.
+var _ btcjson.Cmd = &ActivateVotingPoolSeriesCmd{}
+
+// NewActivateVotingPoolSeriesCmd creates a new ActivateVotingPoolSeriesCmd.
+func NewActivateVotingPoolSeriesCmd(id interface{}, poolID string, seriesID uint32) *ActivateVotingPoolSeriesCmd {
+	return &ActivateVotingPoolSeriesCmd{id: id, PoolID: poolID, SeriesID: seriesID}
+}
+
+// parseActivateVotingPoolSeriesCmd parses a RawCmd into a concrete type
+// satisifying the btcjson.Cmd interface.  This is used when registering the
+// custom command with the btcjson parser.
+func parseActivateVotingPoolSeriesCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	var poolID string
+	var seriesID uint32
+	err := unmarshalParams(r,
+		[]string{"poolid", "seriesid"},
+		[]string{"a string", "a non-negative integer"},
+		&poolID, &seriesID)
+	if err != nil {
+		return nil, err
+	}
+	return NewActivateVotingPoolSeriesCmd(r.Id, poolID, seriesID), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *ActivateVotingPoolSeriesCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *ActivateVotingPoolSeriesCmd) Method() string {
+	return "activatevotingpoolseries"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *ActivateVotingPoolSeriesCmd) MarshalJSON() ([]byte, error) {
+	return marshalCmd(cmd.id, cmd.Method(), []interface{}{cmd.PoolID, cmd.SeriesID})
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of
+// the Cmd interface.
+func (cmd *ActivateVotingPoolSeriesCmd) UnmarshalJSON(b []byte) error {
+	newCmd, err := unmarshalRawCmd(b, parseActivateVotingPoolSeriesCmd)
+	if err != nil {
+		return err
+	}
+	concreteCmd, ok := newCmd.(*ActivateVotingPoolSeriesCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// EmpowerVotingPoolSeriesCmd is a type handling custom marshaling and
+// unmarshaling of empowervotingpoolseries JSON-RPC commands.
+type EmpowerVotingPoolSeriesCmd struct {
+	id       interface{}
+	PoolID   string
+	SeriesID uint32
+	PrivKey  string
+}
+
+// Enforce that EmpowerVotingPoolSeriesCmd satisifies the btcjson.Cmd
+// interface.
+var _ btcjson.Cmd = &EmpowerVotingPoolSeriesCmd{}
+
+// NewEmpowerVotingPoolSeriesCmd creates a new EmpowerVotingPoolSeriesCmd.
+func NewEmpowerVotingPoolSeriesCmd(id interface{}, poolID string, seriesID uint32, privKey string) *EmpowerVotingPoolSeriesCmd {
+	return &EmpowerVotingPoolSeriesCmd{id: id, PoolID: poolID, SeriesID: seriesID, PrivKey: privKey}
+}
+
+// parseEmpowerVotingPoolSeriesCmd parses a RawCmd into a concrete type
+// satisifying the btcjson.Cmd interface.  This is used when registering the
+// custom command with the btcjson parser.
+func parseEmpowerVotingPoolSeriesCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	var poolID string
+	var seriesID uint32
+	var privKey string
+	err := unmarshalParams(r,
+		[]string{"poolid", "seriesid", "privkey"},
+		[]string{"a string", "a non-negative integer", "a string"},
+		&poolID, &seriesID, &privKey)
+	if err != nil {
+		return nil, err
+	}
+	return NewEmpowerVotingPoolSeriesCmd(r.Id, poolID, seriesID, privKey), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *EmpowerVotingPoolSeriesCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *EmpowerVotingPoolSeriesCmd) Method() string {
+	return "empowervotingpoolseries"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *EmpowerVotingPoolSeriesCmd) MarshalJSON() ([]byte, error) {
+	return marshalCmd(cmd.id, cmd.Method(), []interface{}{cmd.PoolID, cmd.SeriesID, cmd.PrivKey})
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of
+// the Cmd interface.
+func (cmd *EmpowerVotingPoolSeriesCmd) UnmarshalJSON(b []byte) error {
+	newCmd, err := unmarshalRawCmd(b, parseEmpowerVotingPoolSeriesCmd)
+	if err != nil {
+		return err
+	}
+	concreteCmd, ok := newCmd.(*EmpowerVotingPoolSeriesCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// GetVotingPoolDepositScriptCmd is a type handling custom marshaling and
+// unmarshaling of getvotingpooldepositscript JSON-RPC commands.
+type GetVotingPoolDepositScriptCmd struct {
+	id       interface{}
+	PoolID   string
+	SeriesID uint32
+	Branch   uint32
+	Index    uint32
+}
+
+// Enforce that GetVotingPoolDepositScriptCmd satisifies the btcjson.Cmd
+// interface.
+var _ btcjson.Cmd = &GetVotingPoolDepositScriptCmd{}
+
+// NewGetVotingPoolDepositScriptCmd creates a new
+// GetVotingPoolDepositScriptCmd.
+func NewGetVotingPoolDepositScriptCmd(id interface{}, poolID string, seriesID uint32, branch uint32, index uint32) *GetVotingPoolDepositScriptCmd {
+	return &GetVotingPoolDepositScriptCmd{id: id, PoolID: poolID, SeriesID: seriesID, Branch: branch, Index: index}
+}
+
+// parseGetVotingPoolDepositScriptCmd parses a RawCmd into a concrete type
+// satisifying the btcjson.Cmd interface.  This is used when registering the
+// custom command with the btcjson parser.
+func parseGetVotingPoolDepositScriptCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	var poolID string
+	var seriesID uint32
+	var branch uint32
+	var index uint32
+	err := unmarshalParams(r,
+		[]string{"poolid", "seriesid", "branch", "index"},
+		[]string{"a string", "a non-negative integer", "a non-negative integer", "a non-negative integer"},
+		&poolID, &seriesID, &branch, &index)
+	if err != nil {
+		return nil, err
+	}
+	return NewGetVotingPoolDepositScriptCmd(r.Id, poolID, seriesID, branch, index), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *GetVotingPoolDepositScriptCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *GetVotingPoolDepositScriptCmd) Method() string {
+	return "getvotingpooldepositscript"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *GetVotingPoolDepositScriptCmd) MarshalJSON() ([]byte, error) {
+	return marshalCmd(cmd.id, cmd.Method(), []interface{}{cmd.PoolID, cmd.SeriesID, cmd.Branch, cmd.Index})
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of
+// the Cmd interface.
+func (cmd *GetVotingPoolDepositScriptCmd) UnmarshalJSON(b []byte) error {
+	newCmd, err := unmarshalRawCmd(b, parseGetVotingPoolDepositScriptCmd)
+	if err != nil {
+		return err
+	}
+	concreteCmd, ok := newCmd.(*GetVotingPoolDepositScriptCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// GetVotingPoolDepositAddressCmd is a type handling custom marshaling and
+// unmarshaling of getvotingpooldepositaddress JSON-RPC commands.
+type GetVotingPoolDepositAddressCmd struct {
+	id       interface{}
+	PoolID   string
+	SeriesID uint32
+	Branch   uint32
+	Index    uint32
+}
+
+// Enforce that GetVotingPoolDepositAddressCmd satisifies the btcjson.Cmd
+// interface.
+var _ btcjson.Cmd = &GetVotingPoolDepositAddressCmd{}
+
+// NewGetVotingPoolDepositAddressCmd creates a new
+// GetVotingPoolDepositAddressCmd.
+func NewGetVotingPoolDepositAddressCmd(id interface{}, poolID string, seriesID uint32, branch uint32, index uint32) *GetVotingPoolDepositAddressCmd {
+	return &GetVotingPoolDepositAddressCmd{id: id, PoolID: poolID, SeriesID: seriesID, Branch: branch, Index: index}
+}
+
+// parseGetVotingPoolDepositAddressCmd parses a RawCmd into a concrete type
+// satisifying the btcjson.Cmd interface.  This is used when registering the
+// custom command with the btcjson parser.
+func parseGetVotingPoolDepositAddressCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	var poolID string
+	var seriesID uint32
+	var branch uint32
+	var index uint32
+	err := unmarshalParams(r,
+		[]string{"poolid", "seriesid", "branch", "index"},
+		[]string{"a string", "a non-negative integer", "a non-negative integer", "a non-negative integer"},
+		&poolID, &seriesID, &branch, &index)
+	if err != nil {
+		return nil, err
+	}
+	return NewGetVotingPoolDepositAddressCmd(r.Id, poolID, seriesID, branch, index), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *GetVotingPoolDepositAddressCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *GetVotingPoolDepositAddressCmd) Method() string {
+	return "getvotingpooldepositaddress"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *GetVotingPoolDepositAddressCmd) MarshalJSON() ([]byte, error) {
+	return marshalCmd(cmd.id, cmd.Method(), []interface{}{cmd.PoolID, cmd.SeriesID, cmd.Branch, cmd.Index})
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of
+// the Cmd interface.
+func (cmd *GetVotingPoolDepositAddressCmd) UnmarshalJSON(b []byte) error {
+	newCmd, err := unmarshalRawCmd(b, parseGetVotingPoolDepositAddressCmd)
+	if err != nil {
+		return err
+	}
+	concreteCmd, ok := newCmd.(*GetVotingPoolDepositAddressCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// StartVotingPoolWithdrawalCmd is a type handling custom marshaling and
+// unmarshaling of startvotingpoolwithdrawal JSON-RPC commands.
+type StartVotingPoolWithdrawalCmd struct {
+	id            interface{}
+	PoolID        string
+	RoundID       uint32
+	Requests      []VotingPoolOutputRequest
+	StartAddress  VotingPoolAddress
+	LastSeriesID  uint32
+	ChangeStart   VotingPoolAddress
+	DustThreshold float64
+	FeePolicy     VotingPoolFeePolicy
+}
+
+// Enforce that StartVotingPoolWithdrawalCmd satisifies the btcjson.Cmd
+// interface.
+var _ btcjson.Cmd = &StartVotingPoolWithdrawalCmd{}
+
+// NewStartVotingPoolWithdrawalCmd creates a new StartVotingPoolWithdrawalCmd.
+func NewStartVotingPoolWithdrawalCmd(id interface{}, poolID string, roundID uint32, requests []VotingPoolOutputRequest, startAddress VotingPoolAddress, lastSeriesID uint32, changeStart VotingPoolAddress, dustThreshold float64, feePolicy VotingPoolFeePolicy) *StartVotingPoolWithdrawalCmd {
+	return &StartVotingPoolWithdrawalCmd{id: id, PoolID: poolID, RoundID: roundID, Requests: requests, StartAddress: startAddress, LastSeriesID: lastSeriesID, ChangeStart: changeStart, DustThreshold: dustThreshold, FeePolicy: feePolicy}
+}
+
+// parseStartVotingPoolWithdrawalCmd parses a RawCmd into a concrete type
+// satisifying the btcjson.Cmd interface.  This is used when registering the
+// custom command with the btcjson parser.
+func parseStartVotingPoolWithdrawalCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	var poolID string
+	var roundID uint32
+	var requests []VotingPoolOutputRequest
+	var startAddress VotingPoolAddress
+	var lastSeriesID uint32
+	var changeStart VotingPoolAddress
+	var dustThreshold float64
+	var feePolicy VotingPoolFeePolicy
+	err := unmarshalParams(r,
+		[]string{"poolid", "roundid", "requests", "startaddress", "lastseriesid", "changestart", "dustthreshold", "feepolicy"},
+		[]string{"a string", "a non-negative integer", "an array of JSON objects", "a JSON object", "a non-negative integer", "a JSON object", "a number", "a JSON object"},
+		&poolID, &roundID, &requests, &startAddress, &lastSeriesID, &changeStart, &dustThreshold, &feePolicy)
+	if err != nil {
+		return nil, err
+	}
+	return NewStartVotingPoolWithdrawalCmd(r.Id, poolID, roundID, requests, startAddress, lastSeriesID, changeStart, dustThreshold, feePolicy), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *StartVotingPoolWithdrawalCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *StartVotingPoolWithdrawalCmd) Method() string {
+	return "startvotingpoolwithdrawal"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *StartVotingPoolWithdrawalCmd) MarshalJSON() ([]byte, error) {
+	return marshalCmd(cmd.id, cmd.Method(), []interface{}{cmd.PoolID, cmd.RoundID, cmd.Requests, cmd.StartAddress, cmd.LastSeriesID, cmd.ChangeStart, cmd.DustThreshold, cmd.FeePolicy})
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of
+// the Cmd interface.
+func (cmd *StartVotingPoolWithdrawalCmd) UnmarshalJSON(b []byte) error {
+	newCmd, err := unmarshalRawCmd(b, parseStartVotingPoolWithdrawalCmd)
+	if err != nil {
+		return err
+	}
+	concreteCmd, ok := newCmd.(*StartVotingPoolWithdrawalCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// SubmitVotingPoolSignaturesCmd is a type handling custom marshaling and
+// unmarshaling of submitvotingpoolsignatures JSON-RPC commands.
+type SubmitVotingPoolSignaturesCmd struct {
+	id         interface{}
+	PoolID     string
+	RoundID    uint32
+	Ntxid      string
+	MemberSigs [][][]string
+}
+
+// Enforce that SubmitVotingPoolSignaturesCmd satisifies the btcjson.Cmd
+// interface.
+var _ btcjson.Cmd = &SubmitVotingPoolSignaturesCmd{}
+
+// NewSubmitVotingPoolSignaturesCmd creates a new
+// SubmitVotingPoolSignaturesCmd.
+func NewSubmitVotingPoolSignaturesCmd(id interface{}, poolID string, roundID uint32, ntxid string, memberSigs [][][]string) *SubmitVotingPoolSignaturesCmd {
+	return &SubmitVotingPoolSignaturesCmd{id: id, PoolID: poolID, RoundID: roundID, Ntxid: ntxid, MemberSigs: memberSigs}
+}
+
+// parseSubmitVotingPoolSignaturesCmd parses a RawCmd into a concrete type
+// satisifying the btcjson.Cmd interface.  This is used when registering the
+// custom command with the btcjson parser.
+func parseSubmitVotingPoolSignaturesCmd(r *btcjson.RawCmd) (btcjson.Cmd, error) {
+	var poolID string
+	var roundID uint32
+	var ntxid string
+	var memberSigs [][][]string
+	err := unmarshalParams(r,
+		[]string{"poolid", "roundid", "ntxid", "sigs"},
+		[]string{"a string", "a non-negative integer", "a string", "an array of arrays of arrays of strings"},
+		&poolID, &roundID, &ntxid, &memberSigs)
+	if err != nil {
+		return nil, err
+	}
+	return NewSubmitVotingPoolSignaturesCmd(r.Id, poolID, roundID, ntxid, memberSigs), nil
+}
+
+// Id satisifies the Cmd interface by returning the ID of the command.
+func (cmd *SubmitVotingPoolSignaturesCmd) Id() interface{} {
+	return cmd.id
+}
+
+// Method satisfies the Cmd interface by returning the RPC method.
+func (cmd *SubmitVotingPoolSignaturesCmd) Method() string {
+	return "submitvotingpoolsignatures"
+}
+
+// MarshalJSON returns the JSON encoding of cmd.  Part of the Cmd interface.
+func (cmd *SubmitVotingPoolSignaturesCmd) MarshalJSON() ([]byte, error) {
+	return marshalCmd(cmd.id, cmd.Method(), []interface{}{cmd.PoolID, cmd.RoundID, cmd.Ntxid, cmd.MemberSigs})
+}
+
+// UnmarshalJSON unmarshals the JSON encoding of cmd into cmd.  Part of
+// the Cmd interface.
+func (cmd *SubmitVotingPoolSignaturesCmd) UnmarshalJSON(b []byte) error {
+	newCmd, err := unmarshalRawCmd(b, parseSubmitVotingPoolSignaturesCmd)
+	if err != nil {
+		return err
+	}
+	concreteCmd, ok := newCmd.(*SubmitVotingPoolSignaturesCmd)
+	if !ok {
+		return btcjson.ErrInternal
+	}
+	*cmd = *concreteCmd
+	return nil
+}
+
+// VotingPoolOutputRequest is a single output requested in a
+// startvotingpoolwithdrawal request.  Amount is in bitcoin.
+type VotingPoolOutputRequest struct {
+	Address     string  `json:"address"`
+	Amount      float64 `json:"amount"`
+	Server      string  `json:"server"`
+	Transaction uint32  `json:"transaction"`
+}
+
+// VotingPoolFeePolicy is the fee policy of a startvotingpoolwithdrawal
+// request.  All amounts are in bitcoin; see votingpool.FeePolicy for their
+// meaning.
+type VotingPoolFeePolicy struct {
+	RatePerKB   float64 `json:"rateperkb"`
+	MinFee      float64 `json:"minfee"`
+	MaxFeeShare float64 `json:"maxfeeshare"`
+}
+
+// VotingPoolAddress identifies a voting pool address by its series, branch
+// and index.  The branch of change addresses is always 0, so it is ignored
+// for them.
+type VotingPoolAddress struct {
+	SeriesID uint32 `json:"seriesid"`
+	Branch   uint32 `json:"branch"`
+	Index    uint32 `json:"index"`
+}
+
+// VotingPoolOutpointResult models an outpoint created to fulfill a requested
+// output in the reply to a startvotingpoolwithdrawal request.
+type VotingPoolOutpointResult struct {
+	Ntxid  string  `json:"ntxid"`
+	Index  uint32  `json:"index"`
+	Amount float64 `json:"amount"`
+}
+
+// VotingPoolOutputResult models the status of a requested output in the reply
+// to a startvotingpoolwithdrawal request.
+type VotingPoolOutputResult struct {
+	Server          string                     `json:"server"`
+	Transaction     uint32                     `json:"transaction"`
+	Address         string                     `json:"address"`
+	Amount          float64                    `json:"amount"`
+	Status          string                     `json:"status"`
+	Fulfilled       float64                    `json:"fulfilled"`
+	ShortfallReason string                     `json:"shortfallreason,omitempty"`
+	Outpoints       []VotingPoolOutpointResult `json:"outpoints"`
+}
+
+// StartVotingPoolWithdrawalResult models the data returned from the
+// startvotingpoolwithdrawal command.  Sigs holds, for every transaction keyed
+// by its ntxid, the signatures of every input, one per pubkey of the input's
+// series ("" where this wallet has no private key).
+type StartVotingPoolWithdrawalResult struct {
+	Outputs        []VotingPoolOutputResult `json:"outputs"`
+	Fees           float64                  `json:"fees"`
+	NextInputAddr  VotingPoolAddress        `json:"nextinputaddr"`
+	NextChangeAddr VotingPoolAddress        `json:"nextchangeaddr"`
+	Sigs           map[string][][]string    `json:"sigs"`
+}
+
+// SubmitVotingPoolSignaturesResult models the data returned from the
+// submitvotingpoolsignatures command.  Hex is only set once every input has
+// enough signatures.
+type SubmitVotingPoolSignaturesResult struct {
+	Sigs         [][]string `json:"sigs"`
+	InputsSigned []bool     `json:"inputssigned"`
+	Hex          string     `json:"hex,omitempty"`
+}
@@ -0,0 +1,181 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package txstore
+
+import (
+	"fmt"
+)
+
+// CheckIntegrity verifies that the credits and debits recorded by the store
+// refer to each other, returning a description of each inconsistency found.
+// The following is checked:
+//
+//   - Blocks are sorted by height and indexed by their height
+//   - Credits refer to existing outputs of their transactions
+//   - Mined credits spent by mined transactions are recorded as spent by the
+//     debits of the spending transaction, and the debits of mined
+//     transactions only spend mined credits marked spent by them
+//   - Mined credits spent by unconfirmed transactions and the unconfirmed
+//     transactions spending them refer to each other
+//   - Unconfirmed credits spent by unconfirmed transactions exist
+//
+// Inconsistent stores can't be repaired in place and must be rebuilt by a
+// rescan.
+func (s *Store) CheckIntegrity() []string {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	var problems []string
+	problem := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	for i, b := range s.blocks {
+		if i > 0 && s.blocks[i-1].Height >= b.Height {
+			problem("block %d is not sorted by height", b.Height)
+		}
+		if index, ok := s.blockIndexes[b.Height]; !ok || index != uint32(i) {
+			problem("block %d is not indexed by its height", b.Height)
+		}
+		for blockIndex, txIndex := range b.txIndexes {
+			if int(txIndex) >= len(b.txs) {
+				problem("transaction %d of block %d does not exist",
+					blockIndex, b.Height)
+				continue
+			}
+			key := BlockTxKey{blockIndex, b.Height}
+			r := b.txs[txIndex]
+			s.checkCredits(key, r, problem)
+			s.checkMinedDebits(key, r, problem)
+		}
+	}
+
+	// Debits of unconfirmed transactions are tracked by the maps of the
+	// unconfirmed store rather than the records themselves.
+	for _, r := range s.unconfirmed.txs {
+		s.checkCredits(BlockTxKey{BlockHeight: -1}, r, problem)
+	}
+	for spent, r := range s.unconfirmed.spentBlockOutPoints {
+		c, err := s.lookupBlockCredit(spent)
+		if err != nil {
+			problem("unconfirmed transaction %v spends unknown credit: "+
+				"%v", r.tx.Sha(), err)
+			continue
+		}
+		if c.spentBy == nil || c.spentBy.BlockHeight != -1 {
+			problem("output %d of transaction %d in block %d is not "+
+				"marked spent by unconfirmed transaction %v",
+				spent.OutputIndex, spent.BlockIndex,
+				spent.BlockHeight, r.tx.Sha())
+		}
+		if s.unconfirmed.txs[*r.tx.Sha()] != r {
+			problem("transaction %v spending output %d of "+
+				"transaction %d in block %d is not unconfirmed",
+				r.tx.Sha(), spent.OutputIndex, spent.BlockIndex,
+				spent.BlockHeight)
+		}
+	}
+	for op := range s.unconfirmed.spentUnconfirmed {
+		r, ok := s.unconfirmed.txs[op.Hash]
+		if !ok || int(op.Index) >= len(r.credits) ||
+			r.credits[op.Index] == nil {
+			problem("unconfirmed credit %v spent by an unconfirmed "+
+				"transaction does not exist", op)
+		}
+	}
+
+	return problems
+}
+
+// checkCredits checks that the credits of the transaction record with the
+// passed key refer to outputs of its transaction, and that mined credits are
+// spent by transactions recording them as spent.
+func (s *Store) checkCredits(key BlockTxKey, r *txRecord, problem func(string, ...interface{})) {
+	for i, c := range r.credits {
+		if c == nil {
+			continue
+		}
+		if i >= len(r.tx.MsgTx().TxOut) {
+			problem("transaction %v has a credit for missing output %d",
+				r.tx.Sha(), i)
+			continue
+		}
+		if key.BlockHeight == -1 || c.spentBy == nil {
+			continue
+		}
+
+		output := BlockOutputKey{key, uint32(i)}
+		if c.spentBy.BlockHeight == -1 {
+			if _, ok := s.unconfirmed.spentBlockOutPoints[output]; !ok {
+				problem("output %d of transaction %v is marked "+
+					"spent by an unknown unconfirmed "+
+					"transaction", i, r.tx.Sha())
+			}
+			continue
+		}
+		d, err := s.lookupBlockDebits(*c.spentBy)
+		if err != nil {
+			problem("output %d of transaction %v is marked spent by "+
+				"unknown debits: %v", i, r.tx.Sha(), err)
+			continue
+		}
+		if !d.spendsOutput(output) {
+			problem("output %d of transaction %v is marked spent by "+
+				"transaction %d in block %d, which does not "+
+				"debit it", i, r.tx.Sha(), c.spentBy.BlockIndex,
+				c.spentBy.BlockHeight)
+		}
+	}
+}
+
+// checkMinedDebits checks that the mined credits debited by the mined
+// transaction record with the passed key exist and are marked spent by it.
+func (s *Store) checkMinedDebits(key BlockTxKey, r *txRecord, problem func(string, ...interface{})) {
+	if r.debits == nil {
+		return
+	}
+	for _, spent := range r.debits.spends {
+		// Credits spent while unconfirmed keep their unconfirmed
+		// key after being mined.
+		if spent.BlockHeight == -1 {
+			continue
+		}
+		c, err := s.lookupBlockCredit(spent)
+		if err != nil {
+			problem("transaction %v debits unknown credit: %v",
+				r.tx.Sha(), err)
+			continue
+		}
+		if c.spentBy == nil || *c.spentBy != key {
+			problem("transaction %v debits output %d of transaction "+
+				"%d in block %d not marked spent by it",
+				r.tx.Sha(), spent.OutputIndex, spent.BlockIndex,
+				spent.BlockHeight)
+		}
+	}
+}
+
+// spendsOutput returns whether the debits spend the output with the passed
+// key.
+func (d *debits) spendsOutput(key BlockOutputKey) bool {
+	for _, spent := range d.spends {
+		if spent == key {
+			return true
+		}
+	}
+	return false
+}
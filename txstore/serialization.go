@@ -23,8 +23,8 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"os"
 	"path/filepath"
+	"sync/atomic"
 	"time"
 
 	"github.com/btcsuite/btcd/wire"
@@ -32,9 +32,21 @@ import (
 	"github.com/monetas/btcwallet/rename"
 )
 
-// filename is the name of the file typically used to save a transaction
+// Filename is the name of the file typically used to save a transaction
 // store on disk.
-const filename = "tx.bin"
+const Filename = "tx.bin"
+
+// encryptedMagic starts the file of an encrypted transaction store, and is
+// followed by the serialized store encrypted with its cipher.  Unencrypted
+// files start with their version instead, which never matches it.
+var encryptedMagic = []byte("txsenc")
+
+// Cipher describes a type which encrypts and decrypts a serialized
+// transaction store, so that it is never written to disk in plain text.
+type Cipher interface {
+	Encrypt(data []byte) ([]byte, error)
+	Decrypt(data []byte) ([]byte, error)
+}
 
 // All Store versions (both old and current).
 const (
@@ -87,6 +99,7 @@ func (s *Store) ReadFrom(r io.Reader) (int64, error) {
 
 	// Reset store.
 	*s = *New(s.path)
+	s.version = vers
 
 	// Read block structures.  Begin by reading the total number of block
 	// structures to be read, and then iterate that many times to read
@@ -160,6 +173,47 @@ func (s *Store) WriteTo(w io.Writer) (int64, error) {
 	return s.writeTo(w)
 }
 
+// Snapshot serializes the transaction store to an io.Writer in the format of
+// its file, encrypted if a cipher was set with SetCipher, and calls fn before
+// the store can be modified again.  This allows data kept outside of the store
+// to be saved in a state consistent with it.
+func (s *Store) Snapshot(w io.Writer, fn func() error) (int64, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	n, err := s.writeFile(w)
+	if err != nil {
+		return n, err
+	}
+	return n, fn()
+}
+
+// writeFile serializes the transaction store to an io.Writer in the format of
+// its file.  When the store has a cipher, the serialized store is encrypted
+// and prefixed by encryptedMagic.
+func (s *Store) writeFile(w io.Writer) (int64, error) {
+	if s.cipher == nil {
+		return s.writeTo(w)
+	}
+
+	var buf bytes.Buffer
+	if _, err := s.writeTo(&buf); err != nil {
+		return 0, err
+	}
+	encrypted, err := s.cipher.Encrypt(buf.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(encryptedMagic)
+	n64 := int64(n)
+	if err != nil {
+		return n64, err
+	}
+	n, err = w.Write(encrypted)
+	n64 += int64(n)
+	return n64, err
+}
+
 func (s *Store) writeTo(w io.Writer) (int64, error) {
 	var buf [4]byte
 	uint32Bytes := buf[:4]
@@ -1173,7 +1227,7 @@ func (s *Store) WriteIfDirty() error {
 	}
 	fiPath := fi.Name()
 
-	_, err = s.writeTo(fi)
+	_, err = s.writeFile(fi)
 	if err != nil {
 		s.mtx.RUnlock()
 		fi.Close()
@@ -1190,6 +1244,7 @@ func (s *Store) WriteIfDirty() error {
 	err = rename.Atomic(fiPath, s.path)
 	s.mtx.RUnlock()
 	if err == nil {
+		atomic.StoreUint32(&s.version, versCurrent)
 		s.mtx.Lock()
 		s.dirty = false
 		s.mtx.Unlock()
@@ -1198,19 +1253,56 @@ func (s *Store) WriteIfDirty() error {
 	return err
 }
 
-// OpenDir opens a new transaction store from the specified directory.
+// SetCipher sets the cipher used to encrypt the transaction store when it is
+// written to its file, or disables encryption when c is nil.  The store is
+// marked dirty so that its file is rewritten accordingly.
+func (s *Store) SetCipher(c Cipher) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.cipher = c
+	s.dirty = true
+}
+
+// Deserialize returns the transaction store serialized in the format of its
+// file.  Encrypted stores are decrypted with c, and ErrEncrypted is returned
+// if c is nil.  The returned store uses c to encrypt its file.  When c is not
+// nil but the serialized store is not encrypted, the store is marked dirty so
+// that it is encrypted the next time it is written.
+func Deserialize(serialized []byte, c Cipher) (*Store, error) {
+	encrypted := bytes.HasPrefix(serialized, encryptedMagic)
+	if encrypted {
+		if c == nil {
+			return nil, ErrEncrypted
+		}
+		var err error
+		serialized, err = c.Decrypt(serialized[len(encryptedMagic):])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	store := new(Store)
+	if _, err := store.ReadFrom(bytes.NewReader(serialized)); err != nil {
+		return nil, err
+	}
+	store.cipher = c
+	store.dirty = c != nil && !encrypted
+	return store, nil
+}
+
+// OpenDir opens a new transaction store from the specified directory,
+// decrypting it with c if it is encrypted (see Deserialize).
 // If the file does not exist, the error from the os package will be
 // returned, and can be checked with os.IsNotExist to differentiate missing
 // file errors from others (including deserialization).
-func OpenDir(dir string) (*Store, error) {
-	path := filepath.Join(dir, filename)
-	fi, err := os.OpenFile(path, os.O_RDONLY, 0)
+func OpenDir(dir string, c Cipher) (*Store, error) {
+	path := filepath.Join(dir, Filename)
+	serialized, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	defer fi.Close()
-	store := new(Store)
-	_, err = store.ReadFrom(fi)
+	store, err := Deserialize(serialized, c)
 	if err != nil {
 		return nil, err
 	}
@@ -22,6 +22,7 @@ import (
 	"path/filepath"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/btcsuite/btcd/blockchain"
@@ -45,6 +46,10 @@ var (
 	// object is marked with a version that is no longer supported
 	// during deserialization.
 	ErrUnsupportedVersion = errors.New("version no longer supported")
+
+	// ErrEncrypted describes an error where an encrypted transaction
+	// store is read without a cipher to decrypt it.
+	ErrEncrypted = errors.New("transaction store is encrypted")
 )
 
 // MissingValueError is a catch-all error interface for any error due to a
@@ -164,6 +169,15 @@ type Store struct {
 	dir   string
 	file  string
 
+	// cipher, when not nil, encrypts the store when it is written to its
+	// file.
+	cipher Cipher
+
+	// version is the serialization version of the file of the store.  It
+	// is accessed atomically, as the file is written with the reader lock
+	// held.
+	version uint32
+
 	mtx sync.RWMutex
 
 	// blocks holds wallet transaction records for each block they appear
@@ -276,9 +290,9 @@ type credit struct {
 // New allocates and initializes a new transaction store.
 func New(dir string) *Store {
 	return &Store{
-		path:         filepath.Join(dir, filename),
+		path:         filepath.Join(dir, Filename),
 		dir:          dir,
-		file:         filename,
+		file:         Filename,
 		blockIndexes: map[int32]uint32{},
 		unspent:      map[wire.OutPoint]BlockTxKey{},
 		unconfirmed: unconfirmedStore{
@@ -289,9 +303,17 @@ func New(dir string) *Store {
 			previousOutpoints:      map[wire.OutPoint]*txRecord{},
 		},
 		notificationLock: new(sync.Mutex),
+		version:          versCurrent,
 	}
 }
 
+// Version returns the serialization version of the file of the store.  This
+// is the version of the file the store was read from until the store is
+// written, after which it is the current version.
+func (s *Store) Version() uint32 {
+	return atomic.LoadUint32(&s.version)
+}
+
 func (s *Store) lookupBlock(height int32) (*blockTxCollection, error) {
 	if i, ok := s.blockIndexes[height]; ok {
 		return s.blocks[i], nil
@@ -1335,6 +1357,48 @@ func (s *Store) Records() (records []*TxRecord) {
 	return
 }
 
+// BlockRecords returns the transaction records saved by the store for the
+// block at the passed height, sorted by transaction index, or nil if there
+// are none.
+func (s *Store) BlockRecords(height int32) []*TxRecord {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	b, err := s.lookupBlock(height)
+	if err != nil {
+		return nil
+	}
+	records := make([]*TxRecord, 0, len(b.txs))
+	for _, r := range b.txs {
+		key := BlockTxKey{r.tx.Index(), height}
+		records = append(records, &TxRecord{key, r, s})
+	}
+	return records
+}
+
+// LookupTx returns the record of the transaction with the passed hash, or nil
+// if there is none.  Mined transactions are only looked up in the block at the
+// passed height, so the whole store is not searched; unmined transactions are
+// always looked up.
+func (s *Store) LookupTx(hash *wire.ShaHash, height int32) *TxRecord {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	if b, err := s.lookupBlock(height); err == nil {
+		for _, r := range b.txs {
+			if r.tx.Sha().IsEqual(hash) {
+				key := BlockTxKey{r.tx.Index(), height}
+				return &TxRecord{key, r, s}
+			}
+		}
+	}
+	if r, ok := s.unconfirmed.txs[*hash]; ok {
+		key := BlockTxKey{BlockHeight: -1}
+		return &TxRecord{key, r, s}
+	}
+	return nil
+}
+
 // Implementation of sort.Interface to sort transaction records by their
 // receive date.
 type byReceiveDate []*TxRecord
@@ -1390,6 +1454,53 @@ func (d Debits) InputAmount() btcutil.Amount {
 	return d.txRecord.debits.amount
 }
 
+// SpentCredits returns the previous wallet credits spent by the debiting
+// transaction.  Unlike FindPreviousCredits, which only searches unspent
+// credits, this returns the credits already marked spent by this transaction.
+func (d Debits) SpentCredits() ([]Credit, error) {
+	s := d.s
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	// Mined debits record the spent credits themselves.
+	if d.BlockHeight != -1 {
+		credits := make([]Credit, 0, len(d.txRecord.debits.spends))
+		for _, key := range d.txRecord.debits.spends {
+			r, err := s.lookupBlockTx(key.BlockTxKey)
+			if err != nil {
+				return nil, err
+			}
+			t := &TxRecord{key.BlockTxKey, r, s}
+			credits = append(credits, Credit{t, key.OutputIndex})
+		}
+		return credits, nil
+	}
+
+	// Spends by unconfirmed transactions are tracked by the unconfirmed
+	// store's maps instead.
+	var credits []Credit
+	for _, txIn := range d.Tx().MsgTx().TxIn {
+		op := txIn.PreviousOutPoint
+		if key, ok := s.unconfirmed.spentBlockOutPointKeys[op]; ok {
+			r, err := s.lookupBlockTx(key.BlockTxKey)
+			if err != nil {
+				return nil, err
+			}
+			t := &TxRecord{key.BlockTxKey, r, s}
+			credits = append(credits, Credit{t, key.OutputIndex})
+			continue
+		}
+		r, ok := s.unconfirmed.txs[op.Hash]
+		if !ok || len(r.credits) <= int(op.Index) ||
+			r.credits[op.Index] == nil {
+			continue
+		}
+		t := &TxRecord{BlockTxKey{BlockHeight: -1}, r, s}
+		credits = append(credits, Credit{t, op.Index})
+	}
+	return credits, nil
+}
+
 // OutputAmount returns the total amount of all outputs for a transaction.
 func (t *TxRecord) OutputAmount(ignoreChange bool) btcutil.Amount {
 	t.s.mtx.RLock()
@@ -17,6 +17,9 @@ package txstore_test
 import (
 	"bytes"
 	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -515,6 +518,11 @@ func TestInsertsCreditsDebitsRollbacks(t *testing.T) {
 			t.Errorf("%s: missing expected unspent output(s)", test.name)
 		}
 
+		// Check that the credits and debits are consistent.
+		if problems := s.CheckIntegrity(); len(problems) != 0 {
+			t.Errorf("%s: inconsistent store: %v", test.name, problems)
+		}
+
 		// Check that unmined sent txs match expected.
 		for _, tx := range s.UnminedDebitTxs() {
 			if _, ok := test.unmined[*tx.Sha()]; !ok {
@@ -570,10 +578,17 @@ func TestFindingSpentCredits(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, err = r2.AddDebits()
+	d, err := r2.AddDebits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	spent, err := d.SpentCredits()
 	if err != nil {
 		t.Fatal(err)
 	}
+	if len(spent) != 1 || *spent[0].OutPoint() != *wire.NewOutPoint(TstRecvTx.Sha(), 0) {
+		t.Fatal("spent credits don't match the debited credit")
+	}
 
 	bal, err := s.Balance(1, TstSignedTxBlockDetails.Height)
 	if err != nil {
@@ -594,3 +609,79 @@ func TestFindingSpentCredits(t *testing.T) {
 		t.Fatal("has more than one unspent credit")
 	}
 }
+
+// xorCipher is a Cipher which xors every byte with itself, enough to check that
+// the file of a transaction store is not written in plain text.
+type xorCipher byte
+
+func (c xorCipher) Encrypt(data []byte) ([]byte, error) {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[i] = b ^ byte(c)
+	}
+	return out, nil
+}
+
+func (c xorCipher) Decrypt(data []byte) ([]byte, error) {
+	return c.Encrypt(data)
+}
+
+func TestEncryptedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "txstore_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, Filename)
+
+	s := New(dir)
+	r, err := s.InsertTx(TstRecvTx, TstRecvTxBlockDetails)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.AddCredit(0, false); err != nil {
+		t.Fatal(err)
+	}
+	s.MarkDirty()
+	if err := s.WriteIfDirty(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A plain text file is read with a cipher and encrypted when written
+	// again.
+	cipher := xorCipher(0x5a)
+	s, err = OpenDir(dir, cipher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteIfDirty(); err != nil {
+		t.Fatal(err)
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(contents, []byte("txsenc")) {
+		t.Fatal("transaction store file is not marked as encrypted")
+	}
+	if bytes.Contains(contents, TstRecvSerializedTx) {
+		t.Fatal("transaction store file holds a plain text transaction")
+	}
+
+	if _, err := OpenDir(dir, nil); err != ErrEncrypted {
+		t.Fatalf("OpenDir without cipher: got error %v, want %v", err,
+			ErrEncrypted)
+	}
+	s, err = OpenDir(dir, cipher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unspents, err := s.UnspentOutputs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	op := wire.NewOutPoint(TstRecvTx.Sha(), 0)
+	if len(unspents) != 1 || *unspents[0].OutPoint() != *op {
+		t.Fatalf("wrong unspent outputs after decryption: %v", unspents)
+	}
+}
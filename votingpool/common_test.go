@@ -20,10 +20,12 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+	"sync"
 	"testing"
 
 	"github.com/btcsuite/btclog"
 	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/hdkeychain"
 	"github.com/monetas/btcwallet/waddrmgr"
 )
 
@@ -37,6 +39,59 @@ func init() {
 		os.Exit(1)
 	}
 	UseLogger(logger)
+
+	// Make deriveChild fail for the children registered with
+	// tstInvalidateChild. This is done only once, here, so that tests
+	// running in parallel don't race to replace it.
+	origDeriveChild := deriveChild
+	deriveChild = func(key *hdkeychain.ExtendedKey, index uint32) (*hdkeychain.ExtendedKey, error) {
+		tstInvalidChildren.Lock()
+		invalid := tstInvalidChildren.m[tstChildID{key.String(), index}]
+		tstInvalidChildren.Unlock()
+		if invalid {
+			return nil, hdkeychain.ErrInvalidChild
+		}
+		return origDeriveChild(key, index)
+	}
+}
+
+// tstChildID identifies the child with the given index of an extended key.
+type tstChildID struct {
+	key   string
+	index uint32
+}
+
+// tstInvalidChildren holds the children for which deriveChild fails with
+// hdkeychain.ErrInvalidChild.
+var tstInvalidChildren = struct {
+	sync.Mutex
+	m map[tstChildID]bool
+}{m: make(map[tstChildID]bool)}
+
+// tstInvalidateChild makes deriveChild fail with hdkeychain.ErrInvalidChild
+// for the child with the given index of the given private key and of its
+// public counterpart. Tests must only invalidate children of keys no other test
+// uses (e.g. those returned by createMasterKeys).
+func tstInvalidateChild(t *testing.T, key *hdkeychain.ExtendedKey, index Index) {
+	pubKey, err := key.Neuter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tstInvalidChildren.Lock()
+	tstInvalidChildren.m[tstChildID{key.String(), uint32(index)}] = true
+	tstInvalidChildren.m[tstChildID{pubKey.String(), uint32(index)}] = true
+	tstInvalidChildren.Unlock()
+}
+
+// tstCreateSeriesWithInvalidIndex creates an active, empowered series with
+// fresh keys in the given pool, for which the given index is invalid. It
+// returns the ID of the new series.
+func tstCreateSeriesWithInvalidIndex(t *testing.T, pool *Pool, invalid Index) uint32 {
+	keys := createMasterKeys(t, 3)
+	def := TstCreateSeriesDef(t, pool, 2, keys)
+	TstCreateSeries(t, pool, []TstSeriesDef{def})
+	tstInvalidateChild(t, keys[1], invalid)
+	return def.SeriesID
 }
 
 // TstCheckError ensures the passed error is a votingpool.Error with an error
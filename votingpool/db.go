@@ -19,9 +19,15 @@ package votingpool
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/gob"
 	"fmt"
+	"sort"
 
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
 	"github.com/monetas/btcwallet/snacl"
+	"github.com/monetas/btcwallet/waddrmgr"
 	"github.com/monetas/btcwallet/walletdb"
 )
 
@@ -33,8 +39,12 @@ const (
 	// actual base58 extended key length = (111)
 	// snacl.NonceSize == nonce size used for encryption (24)
 	seriesKeyLength = snacl.Overhead + 111 + snacl.NonceSize
-	// 4 bytes version + 1 byte active + 4 bytes nKeys + 4 bytes reqSigs
+	// 4 bytes version + 1 byte state + 4 bytes nKeys + 4 bytes reqSigs
 	seriesMinSerial = 4 + 1 + 4 + 4
+	// 32 bytes tx hash + 4 bytes output index
+	charterOutpointSerial = 32 + 4
+	// 32 bytes block hash + 4 bytes block height
+	birthdaySerial = 32 + 4
 	// 15 is the max number of keys in a voting pool, 1 each for
 	// pubkey and privkey
 	seriesMaxSerial = seriesMinSerial + 15*seriesKeyLength*2
@@ -43,20 +53,111 @@ const (
 )
 
 var (
-	usedAddrsBucketName = []byte("usedaddrs")
-	seriesBucketName    = []byte("series")
+	usedAddrsBucketName   = []byte("usedaddrs")
+	seriesBucketName      = []byte("series")
+	withdrawalsBucketName = []byte("withdrawals")
+	chartersBucketName    = []byte("charters")
+	// key, inside a pool bucket, of the block stamp recorded when the pool
+	// was created
+	birthdayKeyName = []byte("birthday")
 	// string representing a non-existent private key
 	seriesNullPrivKey = [seriesKeyLength]byte{}
 )
 
 type dbSeriesRow struct {
 	version           uint32
-	active            bool
+	state             SeriesState
 	reqSigs           uint32
 	pubKeysEncrypted  [][]byte
 	privKeysEncrypted [][]byte
 }
 
+// dbWithdrawalRow is the gob-encoded representation of a withdrawal round
+// stored in the database. It holds the parameters the withdrawal was started
+// with as well as its resulting status.
+type dbWithdrawalRow struct {
+	Requests      []dbOutputRequest
+	StartAddress  dbWithdrawalAddress
+	ChangeStart   dbChangeAddress
+	LastSeriesID  uint32
+	DustThreshold btcutil.Amount
+	FeePolicy     FeePolicy
+	Status        dbWithdrawalStatus
+}
+
+// sameParams returns whether the row holds the same withdrawal parameters as
+// the given one, regardless of their statuses. Requests are compared one by
+// one rather than with reflect.DeepEqual, as gob decodes an empty slice as nil.
+func (row *dbWithdrawalRow) sameParams(other *dbWithdrawalRow) bool {
+	if len(row.Requests) != len(other.Requests) {
+		return false
+	}
+	for i := range row.Requests {
+		if row.Requests[i] != other.Requests[i] {
+			return false
+		}
+	}
+	return row.StartAddress == other.StartAddress &&
+		row.ChangeStart == other.ChangeStart &&
+		row.LastSeriesID == other.LastSeriesID &&
+		row.DustThreshold == other.DustThreshold &&
+		row.FeePolicy == other.FeePolicy
+}
+
+type dbWithdrawalAddress struct {
+	SeriesID uint32
+	Branch   Branch
+	Index    Index
+}
+
+type dbChangeAddress struct {
+	SeriesID uint32
+	Index    Index
+}
+
+type dbOutputRequest struct {
+	Addr        string
+	Amount      btcutil.Amount
+	Server      string
+	Transaction uint32
+}
+
+type dbWithdrawalOutput struct {
+	// We store the OutBailmentID here as we need a way to look up the
+	// corresponding OutputRequest in dbWithdrawalRow when deserializing.
+	OutBailmentID OutBailmentID
+	Status        outputStatus
+	Shortfall     shortfallReason
+	Outpoints     []dbOutBailmentOutpoint
+}
+
+type dbOutBailmentOutpoint struct {
+	Ntxid  Ntxid
+	Index  uint32
+	Amount btcutil.Amount
+}
+
+type dbChangeAwareTx struct {
+	SerializedMsgTx []byte
+	ChangeIdx       int32
+	// HasCharter is false for transactions without a charter output,
+	// including those stored before charter outputs were introduced, as
+	// gob decodes their missing CharterIdx as 0, a valid output index.
+	HasCharter bool
+	CharterIdx int32
+}
+
+type dbWithdrawalStatus struct {
+	// NextInputAddr is nil when the withdrawal did not determine the next
+	// input address.
+	NextInputAddr  *dbWithdrawalAddress
+	NextChangeAddr dbChangeAddress
+	Fees           btcutil.Amount
+	Outputs        map[OutBailmentID]dbWithdrawalOutput
+	Sigs           map[Ntxid]TxSigs
+	Transactions   map[Ntxid]dbChangeAwareTx
+}
+
 // getUsedAddrBucketID returns the used addresses bucket ID for the given series
 // and branch. It has the form seriesID:branch.
 func getUsedAddrBucketID(seriesID uint32, branch Branch) []byte {
@@ -67,6 +168,14 @@ func getUsedAddrBucketID(seriesID uint32, branch Branch) []byte {
 	return bucketID[:]
 }
 
+// usedAddrKey returns the key of the given index in a used addresses bucket.
+// Keys are big-endian so buckets are sorted by index.
+func usedAddrKey(index Index) []byte {
+	var key [4]byte
+	binary.BigEndian.PutUint32(key[:], uint32(index))
+	return key[:]
+}
+
 // putUsedAddrHash adds an entry (key==index, value==encryptedHash) to the used
 // addresses bucket of the given pool, series and branch.
 func putUsedAddrHash(tx walletdb.Tx, poolID []byte, seriesID uint32, branch Branch,
@@ -77,7 +186,7 @@ func putUsedAddrHash(tx walletdb.Tx, poolID []byte, seriesID uint32, branch Bran
 	if err != nil {
 		return newError(ErrDatabase, "failed to store used address hash", err)
 	}
-	return bucket.Put(uint32ToBytes(uint32(index)), encryptedHash)
+	return bucket.Put(usedAddrKey(index), encryptedHash)
 }
 
 // getUsedAddrHash returns the addr hash with the given index from the used
@@ -90,7 +199,7 @@ func getUsedAddrHash(tx walletdb.Tx, poolID []byte, seriesID uint32, branch Bran
 	if bucket == nil {
 		return nil
 	}
-	return bucket.Get(uint32ToBytes(uint32(index)))
+	return bucket.Get(usedAddrKey(index))
 }
 
 // getMaxUsedIdx returns the highest used index from the used addresses bucket
@@ -102,30 +211,23 @@ func getMaxUsedIdx(tx walletdb.Tx, poolID []byte, seriesID uint32, branch Branch
 	if bucket == nil {
 		return maxIdx, nil
 	}
-	// FIXME: This is far from optimal and should be optimized either by storing
-	// a separate key in the DB with the highest used idx for every
-	// series/branch or perhaps by doing a large gap linear forward search +
-	// binary backwards search (e.g. check for 1000000, 2000000, ....  until it
-	// doesn't exist, and then use a binary search to find the max using the
-	// discovered bounds).
-	err := bucket.ForEach(
-		func(k, v []byte) error {
-			idx := Index(bytesToUint32(k))
-			if idx > maxIdx {
-				maxIdx = idx
-			}
-			return nil
-		})
-	if err != nil {
-		return Index(0), newError(ErrDatabase, "failed to get highest idx of used addresses", err)
+	// Used address keys are big-endian, so the last one is the highest.
+	k, _ := bucket.Cursor().Last()
+	if k == nil {
+		return maxIdx, nil
+	}
+	if len(k) != 4 {
+		str := fmt.Sprintf("malformed used address key %x", k)
+		return Index(0), newError(ErrDatabase, str, nil)
 	}
-	return maxIdx, nil
+	return Index(binary.BigEndian.Uint32(k)), nil
 }
 
 // putPool stores a voting pool in the database, creating a bucket named
-// after the voting pool id and two other buckets inside it to store series and
-// used addresses for that pool.
-func putPool(tx walletdb.Tx, poolID []byte) error {
+// after the voting pool id and other buckets inside it to store series, used
+// addresses, withdrawals and charter outpoints for that pool. The given
+// birthday is stored in the pool bucket as well.
+func putPool(tx walletdb.Tx, poolID []byte, birthday *waddrmgr.BlockStamp) error {
 	poolBucket, err := tx.RootBucket().CreateBucket(poolID)
 	if err != nil {
 		return newError(ErrDatabase, fmt.Sprintf("cannot create pool %v", poolID), err)
@@ -140,9 +242,34 @@ func putPool(tx walletdb.Tx, poolID []byte) error {
 		return newError(ErrDatabase, fmt.Sprintf("cannot create used addrs bucket for pool %v",
 			poolID), err)
 	}
+	_, err = poolBucket.CreateBucket(withdrawalsBucketName)
+	if err != nil {
+		return newError(ErrDatabase, fmt.Sprintf("cannot create withdrawals bucket for pool %v",
+			poolID), err)
+	}
+	_, err = poolBucket.CreateBucket(chartersBucketName)
+	if err != nil {
+		return newError(ErrDatabase, fmt.Sprintf("cannot create charters bucket for pool %v",
+			poolID), err)
+	}
+	if err = poolBucket.Put(birthdayKeyName, serializeBirthday(birthday)); err != nil {
+		return newError(ErrDatabase, fmt.Sprintf("cannot put birthday of pool %v",
+			poolID), err)
+	}
 	return nil
 }
 
+// getPoolBirthday returns the block stamp stored when the pool with the given
+// ID was created. Pools created before birthdays were recorded have none, in
+// which case the zero block stamp (i.e. the genesis block) is returned.
+func getPoolBirthday(tx walletdb.Tx, poolID []byte) (*waddrmgr.BlockStamp, error) {
+	serialized := tx.RootBucket().Bucket(poolID).Get(birthdayKeyName)
+	if serialized == nil {
+		return &waddrmgr.BlockStamp{}, nil
+	}
+	return deserializeBirthday(serialized)
+}
+
 // loadAllSeries returns a map of all the series stored inside a voting pool
 // bucket, keyed by id.
 func loadAllSeries(tx walletdb.Tx, poolID []byte) (map[uint32]*dbSeriesRow, error) {
@@ -173,10 +300,10 @@ func existsPool(tx walletdb.Tx, poolID []byte) bool {
 
 // putSeries stores the given series inside a voting pool bucket named after
 // poolID. The voting pool bucket does not need to be created beforehand.
-func putSeries(tx walletdb.Tx, poolID []byte, version, ID uint32, active bool, reqSigs uint32, pubKeysEncrypted, privKeysEncrypted [][]byte) error {
+func putSeries(tx walletdb.Tx, poolID []byte, version, ID uint32, state SeriesState, reqSigs uint32, pubKeysEncrypted, privKeysEncrypted [][]byte) error {
 	row := &dbSeriesRow{
 		version:           version,
-		active:            active,
+		state:             state,
 		reqSigs:           reqSigs,
 		pubKeysEncrypted:  pubKeysEncrypted,
 		privKeysEncrypted: privKeysEncrypted,
@@ -209,10 +336,13 @@ func putSeriesRow(tx walletdb.Tx, poolID []byte, ID uint32, row *dbSeriesRow) er
 // deserializeSeriesRow deserializes a series storage into a dbSeriesRow struct.
 func deserializeSeriesRow(serializedSeries []byte) (*dbSeriesRow, error) {
 	// The serialized series format is:
-	// <version><active><reqSigs><nKeys><pubKey1><privKey1>...<pubkeyN><privKeyN>
+	// <version><state><reqSigs><nKeys><pubKey1><privKey1>...<pubkeyN><privKeyN>
 	//
-	// 4 bytes version + 1 byte active + 4 bytes reqSigs + 4 bytes nKeys
+	// 4 bytes version + 1 byte state + 4 bytes reqSigs + 4 bytes nKeys
 	// + seriesKeyLength * 2 * nKeys (1 for priv, 1 for pub)
+	//
+	// The state byte used to be a boolean active flag, which maps to the
+	// SeriesCreated (0x00) and SeriesActive (0x01) states.
 
 	// Given the above, the length of the serialized series should be
 	// at minimum the length of the constants.
@@ -240,7 +370,11 @@ func deserializeSeriesRow(serializedSeries []byte) (*dbSeriesRow, error) {
 	}
 	current += 4
 
-	row.active = serializedSeries[current] == 0x01
+	row.state = SeriesState(serializedSeries[current])
+	if row.state > SeriesRetired {
+		str := fmt.Sprintf("serialized series has unknown state: %d", row.state)
+		return nil, newError(ErrSeriesSerialization, str, nil)
+	}
 	current++
 
 	row.reqSigs = bytesToUint32(serializedSeries[current : current+4])
@@ -280,9 +414,9 @@ func deserializeSeriesRow(serializedSeries []byte) (*dbSeriesRow, error) {
 // serializeSeriesRow serializes a dbSeriesRow struct into storage format.
 func serializeSeriesRow(row *dbSeriesRow) ([]byte, error) {
 	// The serialized series format is:
-	// <version><active><reqSigs><nKeys><pubKey1><privKey1>...<pubkeyN><privKeyN>
+	// <version><state><reqSigs><nKeys><pubKey1><privKey1>...<pubkeyN><privKeyN>
 	//
-	// 4 bytes version + 1 byte active + 4 bytes reqSigs + 4 bytes nKeys
+	// 4 bytes version + 1 byte state + 4 bytes reqSigs + 4 bytes nKeys
 	// + seriesKeyLength * 2 * nKeys (1 for priv, 1 for pub)
 	serializedLen := 4 + 1 + 4 + 4 + (seriesKeyLength * 2 * len(row.pubKeysEncrypted))
 
@@ -299,13 +433,14 @@ func serializeSeriesRow(row *dbSeriesRow) ([]byte, error) {
 		return nil, newError(ErrSeriesVersion, str, nil)
 	}
 
+	if row.state > SeriesRetired {
+		str := fmt.Sprintf("unknown series state: %d", row.state)
+		return nil, newError(ErrSeriesSerialization, str, nil)
+	}
+
 	serialized := make([]byte, 0, serializedLen)
 	serialized = append(serialized, uint32ToBytes(row.version)...)
-	if row.active {
-		serialized = append(serialized, 0x01)
-	} else {
-		serialized = append(serialized, 0x00)
-	}
+	serialized = append(serialized, byte(row.state))
 	serialized = append(serialized, uint32ToBytes(row.reqSigs)...)
 	nKeys := uint32(len(row.pubKeysEncrypted))
 	serialized = append(serialized, uint32ToBytes(nKeys)...)
@@ -339,6 +474,384 @@ func serializeSeriesRow(row *dbSeriesRow) ([]byte, error) {
 	return serialized, nil
 }
 
+// putWithdrawal stores the given serialized withdrawal inside the withdrawals
+// bucket of the voting pool with the given ID, keyed by roundID. The
+// withdrawals bucket is created if necessary, as pools created before
+// withdrawals were stored do not have one.
+func putWithdrawal(tx walletdb.Tx, poolID []byte, roundID uint32, serialized []byte) error {
+	poolBucket := tx.RootBucket().Bucket(poolID)
+	bucket, err := poolBucket.CreateBucketIfNotExists(withdrawalsBucketName)
+	if err != nil {
+		str := fmt.Sprintf("cannot create withdrawals bucket for pool %v", poolID)
+		return newError(ErrDatabase, str, err)
+	}
+	if err := bucket.Put(uint32ToBytes(roundID), serialized); err != nil {
+		str := fmt.Sprintf("cannot put withdrawal %d into bucket %v", roundID, poolID)
+		return newError(ErrDatabase, str, err)
+	}
+	return nil
+}
+
+// getWithdrawal returns the serialized withdrawal with the given roundID from
+// the withdrawals bucket of the voting pool with the given ID, or nil if
+// there is no such withdrawal.
+func getWithdrawal(tx walletdb.Tx, poolID []byte, roundID uint32) []byte {
+	bucket := tx.RootBucket().Bucket(poolID).Bucket(withdrawalsBucketName)
+	if bucket == nil {
+		return nil
+	}
+	return bucket.Get(uint32ToBytes(roundID))
+}
+
+// getWithdrawalRoundIDs returns the roundIDs of all withdrawals stored in the
+// withdrawals bucket of the voting pool with the given ID, in ascending
+// order.
+func getWithdrawalRoundIDs(tx walletdb.Tx, poolID []byte) ([]uint32, error) {
+	bucket := tx.RootBucket().Bucket(poolID).Bucket(withdrawalsBucketName)
+	if bucket == nil {
+		return nil, nil
+	}
+	var roundIDs []uint32
+	err := bucket.ForEach(
+		func(k, v []byte) error {
+			roundIDs = append(roundIDs, bytesToUint32(k))
+			return nil
+		})
+	if err != nil {
+		return nil, newError(ErrDatabase, "failed to iterate over withdrawals", err)
+	}
+	sort.Sort(uint32Slice(roundIDs))
+	return roundIDs, nil
+}
+
+// putCharterOutpoint stores the charter outpoint of the series with the given
+// ID inside the charters bucket of the voting pool with the given ID. The
+// charters bucket is created if necessary, as pools created before charter
+// outpoints were stored do not have one.
+func putCharterOutpoint(tx walletdb.Tx, poolID []byte, seriesID uint32,
+	outpoint *wire.OutPoint) error {
+	poolBucket := tx.RootBucket().Bucket(poolID)
+	bucket, err := poolBucket.CreateBucketIfNotExists(chartersBucketName)
+	if err != nil {
+		str := fmt.Sprintf("cannot create charters bucket for pool %v", poolID)
+		return newError(ErrDatabase, str, err)
+	}
+	if err := bucket.Put(uint32ToBytes(seriesID), serializeOutPoint(outpoint)); err != nil {
+		str := fmt.Sprintf("cannot put charter outpoint of series #%d into bucket %v",
+			seriesID, poolID)
+		return newError(ErrDatabase, str, err)
+	}
+	return nil
+}
+
+// loadCharterOutpoints returns a map of all the charter outpoints stored
+// inside a voting pool bucket, keyed by series id.
+func loadCharterOutpoints(tx walletdb.Tx, poolID []byte) (map[uint32]*wire.OutPoint, error) {
+	charters := make(map[uint32]*wire.OutPoint)
+	bucket := tx.RootBucket().Bucket(poolID).Bucket(chartersBucketName)
+	if bucket == nil {
+		return charters, nil
+	}
+	err := bucket.ForEach(
+		func(k, v []byte) error {
+			outpoint, err := deserializeOutPoint(v)
+			if err != nil {
+				return err
+			}
+			charters[bytesToUint32(k)] = outpoint
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return charters, nil
+}
+
+// serializeOutPoint returns the serialization of the given outpoint: its tx
+// hash followed by its output index in little-endian order.
+func serializeOutPoint(outpoint *wire.OutPoint) []byte {
+	serialized := make([]byte, charterOutpointSerial)
+	copy(serialized, outpoint.Hash[:])
+	binary.LittleEndian.PutUint32(serialized[wire.HashSize:], outpoint.Index)
+	return serialized
+}
+
+// deserializeOutPoint deserializes an outpoint serialized with
+// serializeOutPoint.
+func deserializeOutPoint(serialized []byte) (*wire.OutPoint, error) {
+	if len(serialized) != charterOutpointSerial {
+		str := fmt.Sprintf("serialized outpoint has wrong length: %d", len(serialized))
+		return nil, newError(ErrDatabase, str, nil)
+	}
+	var hash wire.ShaHash
+	copy(hash[:], serialized[:wire.HashSize])
+	index := binary.LittleEndian.Uint32(serialized[wire.HashSize:])
+	return wire.NewOutPoint(&hash, index), nil
+}
+
+// serializeBirthday returns the serialization of the given block stamp: its
+// block hash followed by its height in little-endian order.
+func serializeBirthday(bs *waddrmgr.BlockStamp) []byte {
+	serialized := make([]byte, birthdaySerial)
+	copy(serialized, bs.Hash[:])
+	binary.LittleEndian.PutUint32(serialized[wire.HashSize:], uint32(bs.Height))
+	return serialized
+}
+
+// deserializeBirthday deserializes a block stamp serialized with
+// serializeBirthday.
+func deserializeBirthday(serialized []byte) (*waddrmgr.BlockStamp, error) {
+	if len(serialized) != birthdaySerial {
+		str := fmt.Sprintf("serialized birthday has wrong length: %d", len(serialized))
+		return nil, newError(ErrDatabase, str, nil)
+	}
+	bs := &waddrmgr.BlockStamp{
+		Height: int32(binary.LittleEndian.Uint32(serialized[wire.HashSize:])),
+	}
+	copy(bs.Hash[:], serialized[:wire.HashSize])
+	return bs, nil
+}
+
+// uint32Slice defines the methods needed to satisify sort.Interface to sort a
+// slice of uint32 values in ascending order.
+type uint32Slice []uint32
+
+func (s uint32Slice) Len() int           { return len(s) }
+func (s uint32Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s uint32Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// newDBWithdrawalRow creates a dbWithdrawalRow from the given withdrawal
+// parameters and status.
+func newDBWithdrawalRow(requests []OutputRequest, startAddress WithdrawalAddress,
+	lastSeriesID uint32, changeStart ChangeAddress, dustThreshold btcutil.Amount,
+	feePolicy FeePolicy, status *WithdrawalStatus) (*dbWithdrawalRow, error) {
+
+	row := &dbWithdrawalRow{
+		Requests: make([]dbOutputRequest, len(requests)),
+		StartAddress: dbWithdrawalAddress{
+			SeriesID: startAddress.SeriesID(),
+			Branch:   startAddress.Branch(),
+			Index:    startAddress.Index(),
+		},
+		ChangeStart: dbChangeAddress{
+			SeriesID: changeStart.SeriesID(),
+			Index:    changeStart.Index(),
+		},
+		LastSeriesID:  lastSeriesID,
+		DustThreshold: dustThreshold,
+		FeePolicy:     feePolicy,
+	}
+	for i, request := range requests {
+		row.Requests[i] = dbOutputRequest{
+			Addr:        request.Address.EncodeAddress(),
+			Amount:      request.Amount,
+			Server:      request.Server,
+			Transaction: request.Transaction,
+		}
+	}
+	if status == nil {
+		return row, nil
+	}
+
+	row.Status = dbWithdrawalStatus{
+		NextChangeAddr: dbChangeAddress{
+			SeriesID: status.nextChangeAddr.SeriesID(),
+			Index:    status.nextChangeAddr.Index(),
+		},
+		Fees:         status.fees,
+		Outputs:      make(map[OutBailmentID]dbWithdrawalOutput, len(status.outputs)),
+		Sigs:         status.sigs,
+		Transactions: make(map[Ntxid]dbChangeAwareTx, len(status.transactions)),
+	}
+	if status.nextInputAddr.poolAddress != nil {
+		row.Status.NextInputAddr = &dbWithdrawalAddress{
+			SeriesID: status.nextInputAddr.SeriesID(),
+			Branch:   status.nextInputAddr.Branch(),
+			Index:    status.nextInputAddr.Index(),
+		}
+	}
+	for oid, output := range status.outputs {
+		outpoints := make([]dbOutBailmentOutpoint, len(output.outpoints))
+		for i, outpoint := range output.outpoints {
+			outpoints[i] = dbOutBailmentOutpoint{
+				Ntxid: outpoint.ntxid, Index: outpoint.index, Amount: outpoint.amount}
+		}
+		row.Status.Outputs[oid] = dbWithdrawalOutput{
+			OutBailmentID: oid,
+			Status:        output.status,
+			Shortfall:     output.shortfall,
+			Outpoints:     outpoints,
+		}
+	}
+	for ntxid, tx := range status.transactions {
+		var buf bytes.Buffer
+		buf.Grow(tx.MsgTx.SerializeSize())
+		if err := tx.MsgTx.Serialize(&buf); err != nil {
+			return nil, newError(ErrWithdrawalStorage, "cannot serialize transaction", err)
+		}
+		row.Status.Transactions[ntxid] = dbChangeAwareTx{
+			SerializedMsgTx: buf.Bytes(),
+			ChangeIdx:       tx.changeIdx,
+			HasCharter:      tx.charterIdx >= 0,
+			CharterIdx:      tx.charterIdx,
+		}
+	}
+	return row, nil
+}
+
+// serializeWithdrawal constructs a dbWithdrawalRow and serializes it (using
+// encoding/gob) so that it can be stored in the DB.
+func serializeWithdrawal(requests []OutputRequest, startAddress WithdrawalAddress,
+	lastSeriesID uint32, changeStart ChangeAddress, dustThreshold btcutil.Amount,
+	feePolicy FeePolicy, status *WithdrawalStatus) ([]byte, error) {
+
+	row, err := newDBWithdrawalRow(requests, startAddress, lastSeriesID, changeStart,
+		dustThreshold, feePolicy, status)
+	if err != nil {
+		return nil, err
+	}
+	return encodeWithdrawalRow(row)
+}
+
+// encodeWithdrawalRow serializes the given dbWithdrawalRow using
+// encoding/gob.
+func encodeWithdrawalRow(row *dbWithdrawalRow) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(row); err != nil {
+		return nil, newError(ErrWithdrawalStorage, "cannot encode withdrawal", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeWithdrawalRow deserializes a dbWithdrawalRow serialized with
+// encodeWithdrawalRow.
+func decodeWithdrawalRow(serialized []byte) (*dbWithdrawalRow, error) {
+	var row dbWithdrawalRow
+	if err := gob.NewDecoder(bytes.NewReader(serialized)).Decode(&row); err != nil {
+		return nil, newError(ErrWithdrawalStorage, "cannot decode withdrawal", err)
+	}
+	return &row, nil
+}
+
+// updateWithdrawalSigs replaces the raw signatures stored for the transaction
+// with the given ntxid in the withdrawal with the given roundID.
+func updateWithdrawalSigs(tx walletdb.Tx, poolID []byte, roundID uint32, ntxid Ntxid,
+	sigs TxSigs) error {
+
+	serialized := getWithdrawal(tx, poolID, roundID)
+	if serialized == nil {
+		str := fmt.Sprintf("withdrawal %d not found", roundID)
+		return newError(ErrWithdrawalNotFound, str, nil)
+	}
+	row, err := decodeWithdrawalRow(serialized)
+	if err != nil {
+		return err
+	}
+	if row.Status.Sigs == nil {
+		row.Status.Sigs = make(map[Ntxid]TxSigs)
+	}
+	row.Status.Sigs[ntxid] = sigs
+	serialized, err = encodeWithdrawalRow(row)
+	if err != nil {
+		return err
+	}
+	return putWithdrawal(tx, poolID, roundID, serialized)
+}
+
+// deserializeWithdrawal deserializes the given byte slice into a
+// dbWithdrawalRow and converts its status into a WithdrawalStatus. The
+// addresses of the withdrawal are looked up in the given pool, so this must
+// be called with the pool's manager unlocked.
+func deserializeWithdrawal(p *Pool, serialized []byte) (*dbWithdrawalRow, *WithdrawalStatus, error) {
+	row, err := decodeWithdrawalRow(serialized)
+	if err != nil {
+		return nil, nil, err
+	}
+	chainParams := p.Manager().ChainParams()
+	requests := make(map[OutBailmentID]OutputRequest, len(row.Requests))
+	for _, dbRequest := range row.Requests {
+		addr, err := btcutil.DecodeAddress(dbRequest.Addr, chainParams)
+		if err != nil {
+			return nil, nil, newError(ErrWithdrawalStorage,
+				"cannot deserialize addr for requested output", err)
+		}
+		pkScript, err := txscript.PayToAddrScript(addr)
+		if err != nil {
+			return nil, nil, newError(ErrWithdrawalStorage, "invalid addr for requested output", err)
+		}
+		request := OutputRequest{
+			Address:     addr,
+			Amount:      dbRequest.Amount,
+			PkScript:    pkScript,
+			Server:      dbRequest.Server,
+			Transaction: dbRequest.Transaction,
+		}
+		requests[request.outBailmentID()] = request
+	}
+
+	status := &WithdrawalStatus{
+		fees:         row.Status.Fees,
+		feePolicy:    row.FeePolicy,
+		outputs:      make(map[OutBailmentID]*WithdrawalOutput, len(row.Status.Outputs)),
+		sigs:         row.Status.Sigs,
+		transactions: make(map[Ntxid]changeAwareTx, len(row.Status.Transactions)),
+	}
+	// The series of the stored addresses may have been thawed or retired
+	// since the withdrawal, so their state is not checked.
+	if row.Status.NextInputAddr != nil {
+		a := row.Status.NextInputAddr
+		nextInputAddr, err := p.withdrawalAddress(a.SeriesID, a.Branch, a.Index)
+		if err != nil {
+			return nil, nil, newError(ErrWithdrawalStorage,
+				"cannot deserialize nextInputAddr", err)
+		}
+		status.nextInputAddr = *nextInputAddr
+	}
+	changeSeriesID := row.Status.NextChangeAddr.SeriesID
+	changeSeries := p.Series(changeSeriesID)
+	if changeSeries == nil {
+		str := fmt.Sprintf("series #%d of nextChangeAddr does not exist", changeSeriesID)
+		return nil, nil, newError(ErrWithdrawalStorage, str, nil)
+	}
+	nextChangeAddr, err := p.changeAddress(changeSeriesID, changeSeries,
+		row.Status.NextChangeAddr.Index)
+	if err != nil {
+		return nil, nil, newError(ErrWithdrawalStorage, "cannot deserialize nextChangeAddr", err)
+	}
+	status.nextChangeAddr = *nextChangeAddr
+
+	for oid, dbOutput := range row.Status.Outputs {
+		request, ok := requests[dbOutput.OutBailmentID]
+		if !ok {
+			str := fmt.Sprintf("no output request with outbailment ID %s", oid)
+			return nil, nil, newError(ErrWithdrawalStorage, str, nil)
+		}
+		outpoints := make([]OutBailmentOutpoint, len(dbOutput.Outpoints))
+		for i, o := range dbOutput.Outpoints {
+			outpoints[i] = OutBailmentOutpoint{ntxid: o.Ntxid, index: o.Index, amount: o.Amount}
+		}
+		status.outputs[oid] = &WithdrawalOutput{
+			request:   request,
+			status:    dbOutput.Status,
+			shortfall: dbOutput.Shortfall,
+			outpoints: outpoints,
+		}
+	}
+	for ntxid, tx := range row.Status.Transactions {
+		var msgtx wire.MsgTx
+		if err := msgtx.Deserialize(bytes.NewReader(tx.SerializedMsgTx)); err != nil {
+			return nil, nil, newError(ErrWithdrawalStorage, "cannot deserialize transaction", err)
+		}
+		charterIdx := int32(-1)
+		if tx.HasCharter {
+			charterIdx = tx.CharterIdx
+		}
+		status.transactions[ntxid] = changeAwareTx{
+			MsgTx: &msgtx, changeIdx: tx.ChangeIdx, charterIdx: charterIdx}
+	}
+	return row, status, nil
+}
+
 // uint32ToBytes converts a 32 bit unsigned integer into a 4-byte slice in
 // little-endian order: 1 -> [1 0 0 0].
 func uint32ToBytes(number uint32) []byte {
@@ -352,3 +865,165 @@ func uint32ToBytes(number uint32) []byte {
 func bytesToUint32(encoded []byte) uint32 {
 	return binary.LittleEndian.Uint32(encoded)
 }
+
+// dbSchema describes the versions of the data stored in the voting pool
+// namespace.  Namespaces created before the introduction of the schema have no
+// version record and are at version 0.
+//
+// The version stored in every series row is the version of the series chosen
+// by its creator, not a version of the storage format, so changes to the
+// format of series rows are tracked by the schema as well.
+var dbSchema = &walletdb.Schema{
+	Migrations: []walletdb.Migration{{
+		Version:     1,
+		Description: "store used address indexes in big-endian order",
+		Migrate:     migrateUsedAddrKeys,
+	}, {
+		Version:     2,
+		Description: "store series lifecycle states in place of the active flag",
+		Migrate:     migrateSeriesStates,
+	}},
+}
+
+// isEmptyNamespace returns whether the namespace of the given transaction holds
+// no data at all, not even a version record.
+func isEmptyNamespace(tx walletdb.Tx) bool {
+	k, _ := tx.RootBucket().Cursor().First()
+	return k == nil
+}
+
+// initNamespace records an empty namespace as being at the latest version of
+// dbSchema, so its data is not needlessly migrated once added, and returns
+// whether the namespace was empty.  Nothing is written in dry runs.  The
+// namespace is only written to when it is empty, so the namespaces of
+// read-only databases can be initialized once they hold data.
+func initNamespace(namespace walletdb.Namespace, dryRun bool) (bool, error) {
+	var isNew bool
+	err := namespace.View(func(tx walletdb.Tx) error {
+		isNew = isEmptyNamespace(tx)
+		return nil
+	})
+	if err != nil || !isNew || dryRun {
+		return isNew, err
+	}
+	err = namespace.Update(func(tx walletdb.Tx) error {
+		// Data may have been added since the namespace was checked.
+		isNew = isEmptyNamespace(tx)
+		if !isNew {
+			return nil
+		}
+		return dbSchema.PutLatestVersion(tx)
+	})
+	return isNew, err
+}
+
+// loadPoolIDs returns the IDs of all the pools stored in the namespace of the
+// given transaction.
+func loadPoolIDs(tx walletdb.Tx) ([][]byte, error) {
+	var ids [][]byte
+	err := tx.RootBucket().ForEach(func(k, v []byte) error {
+		// Pools are the only nested buckets of the root bucket.
+		if v == nil {
+			ids = append(ids, k)
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// migrateUsedAddrKeys rewrites the keys of the used addresses buckets of every
+// pool, which were stored in little-endian order, in big-endian order.
+func migrateUsedAddrKeys(tx walletdb.Tx) error {
+	rootBucket := tx.RootBucket()
+	poolIDs, err := loadPoolIDs(tx)
+	if err != nil {
+		return err
+	}
+
+	for _, poolID := range poolIDs {
+		poolBucket := rootBucket.Bucket(poolID)
+		if poolBucket == nil {
+			continue
+		}
+		usedAddrs := poolBucket.Bucket(usedAddrsBucketName)
+		if usedAddrs == nil {
+			continue
+		}
+		var bucketIDs [][]byte
+		err := usedAddrs.ForEach(func(k, v []byte) error {
+			bucketIDs = append(bucketIDs, k)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, bucketID := range bucketIDs {
+			bucket := usedAddrs.Bucket(bucketID)
+			if bucket == nil {
+				continue
+			}
+			if err := swapUsedAddrKeys(bucket); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// migrateSeriesStates rewrites the series rows of every pool in the current
+// format.  The byte following the series version used to be a boolean active
+// flag, written as 0x00 or 0x01, which are the encodings of the SeriesCreated
+// and SeriesActive states, so rows are carried over as they are.  Rewriting
+// them still makes sure every row can be read in the current format, failing
+// the upgrade instead of the loading of the pool otherwise.
+func migrateSeriesStates(tx walletdb.Tx) error {
+	poolIDs, err := loadPoolIDs(tx)
+	if err != nil {
+		return err
+	}
+
+	for _, poolID := range poolIDs {
+		if tx.RootBucket().Bucket(poolID).Bucket(seriesBucketName) == nil {
+			continue
+		}
+		allSeries, err := loadAllSeries(tx, poolID)
+		if err != nil {
+			return err
+		}
+		for seriesID, row := range allSeries {
+			if err := putSeriesRow(tx, poolID, seriesID, row); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// swapUsedAddrKeys replaces the little-endian keys of the given used addresses
+// bucket with big-endian ones.
+func swapUsedAddrKeys(bucket walletdb.Bucket) error {
+	hashes := make(map[Index][]byte)
+	err := bucket.ForEach(func(k, v []byte) error {
+		if len(k) != 4 {
+			str := fmt.Sprintf("malformed used address key %x", k)
+			return newError(ErrDatabase, str, nil)
+		}
+		hashes[Index(bytesToUint32(k))] = append([]byte{}, v...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for index := range hashes {
+		if err := bucket.Delete(uint32ToBytes(uint32(index))); err != nil {
+			return err
+		}
+	}
+	for index, hash := range hashes {
+		if err := bucket.Put(usedAddrKey(index), hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
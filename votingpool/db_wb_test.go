@@ -18,8 +18,10 @@ package votingpool
 
 import (
 	"bytes"
+	"encoding/gob"
 	"testing"
 
+	"github.com/btcsuite/btcutil"
 	"github.com/monetas/btcwallet/walletdb"
 )
 
@@ -80,3 +82,334 @@ func TestGetMaxUsedIdx(t *testing.T) {
 		t.Fatalf("Wrong max idx; got %d, want %d", maxIdx, Index(3001))
 	}
 }
+
+func TestUpgradeNewNamespace(t *testing.T) {
+	t.Parallel()
+
+	db, err := walletdb.Create("memdb")
+	if err != nil {
+		t.Fatalf("Failed to create wallet DB: %v", err)
+	}
+	defer db.Close()
+	namespace, err := db.Namespace([]byte("votingpool"))
+	if err != nil {
+		t.Fatalf("Failed to create VotingPool DB namespace: %v", err)
+	}
+
+	// A dry run has nothing to report and doesn't write the version.
+	pending, err := Upgrade(namespace, &walletdb.MigrateOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Fatalf("Unexpected pending migrations: %v", pending)
+	}
+	err = namespace.View(func(tx walletdb.Tx) error {
+		if !isEmptyNamespace(tx) {
+			t.Fatal("Dry run wrote to the new namespace")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A new namespace is recorded at the latest version without migrating,
+	// so no backup is requested.
+	opts := &walletdb.MigrateOptions{
+		Backup: func() error {
+			t.Fatal("Backup requested for a new namespace")
+			return nil
+		},
+	}
+	applied, err := Upgrade(namespace, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 0 {
+		t.Fatalf("Unexpected migrations applied: %v", applied)
+	}
+	version, err := dbSchema.Version(namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := dbSchema.LatestVersion(); version != want {
+		t.Fatalf("Wrong version of new namespace; got %d, want %d",
+			version, want)
+	}
+}
+
+func TestMigrateUsedAddrKeys(t *testing.T) {
+	tearDown, _, pool := TstCreatePool(t)
+	defer tearDown()
+
+	// Store used addresses the way they were before version 1 of the
+	// schema, with little-endian keys, and revert the namespace to version
+	// 0.
+	indexes := []Index{0, 7, 256, 3001, 41}
+	err := pool.namespace.Update(
+		func(tx walletdb.Tx) error {
+			usedAddrs := tx.RootBucket().Bucket(pool.ID).Bucket(usedAddrsBucketName)
+			bucket, err := usedAddrs.CreateBucket(getUsedAddrBucketID(0, 0))
+			if err != nil {
+				return err
+			}
+			for _, idx := range indexes {
+				dummyHash := bytes.Repeat([]byte{byte(idx)}, 10)
+				err := bucket.Put(uint32ToBytes(uint32(idx)), dummyHash)
+				if err != nil {
+					return err
+				}
+			}
+			// A schema without migrations has version 0.
+			return (&walletdb.Schema{}).PutLatestVersion(tx)
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version, err := Version(pool.namespace); err != nil || version != 0 {
+		t.Fatalf("Wrong version before upgrade; got %d (%v), want 0",
+			version, err)
+	}
+
+	applied, err := Upgrade(pool.namespace, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 2 || applied[0].Version != 1 || applied[1].Version != 2 {
+		t.Fatalf("Unexpected migrations applied: %v", applied)
+	}
+
+	if version, err := Version(pool.namespace); err != nil || version != 1 {
+		t.Fatalf("Wrong version after upgrade; got %d (%v), want 1",
+			version, err)
+	}
+
+	err = pool.namespace.View(
+		func(tx walletdb.Tx) error {
+			for _, idx := range indexes {
+				want := bytes.Repeat([]byte{byte(idx)}, 10)
+				got := getUsedAddrHash(tx, pool.ID, 0, 0, idx)
+				if !bytes.Equal(got, want) {
+					t.Errorf("Wrong hash for idx %d; got %x, want %x",
+						idx, got, want)
+				}
+			}
+			maxIdx, err := getMaxUsedIdx(tx, pool.ID, 0, 0)
+			if err != nil {
+				return err
+			}
+			if maxIdx != Index(3001) {
+				t.Errorf("Wrong max idx; got %d, want %d", maxIdx,
+					Index(3001))
+			}
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestDeserializeWithdrawalCharterIdx checks that the charter output index of
+// withdrawal transactions survives storage, and that transactions stored
+// before charter outputs were introduced are read back without one.
+func TestDeserializeWithdrawalCharterIdx(t *testing.T) {
+	tearDown, pool, store := TstCreatePoolAndTxStore(t)
+	defer tearDown()
+
+	net := pool.Manager().ChainParams()
+	seriesID, eligible := TstCreateCredits(t, pool, []int64{4e6, 1e5}, store)
+	charter := eligible[1]
+	eligible = eligible[:1]
+	requests := []OutputRequest{TstNewOutputRequest(
+		t, 1, "34eVkREKgvvGASZW7hkgE2uNc1yycntMK6", btcutil.Amount(3e6), net)}
+	changeStart := TstNewChangeAddress(t, pool, seriesID, 0)
+	startAddr := TstNewWithdrawalAddress(t, pool, seriesID, 0, 0)
+
+	w := newWithdrawal(0, requests, eligible, *changeStart, DefaultFeePolicy)
+	w.charter = charter
+	if err := w.fulfillRequests(); err != nil {
+		t.Fatal(err)
+	}
+	ntxid := w.transactions[0].ntxid()
+	if w.status.transactions[ntxid].charterIdx != 1 {
+		t.Fatalf("Wrong charter index; got %d, want 1",
+			w.status.transactions[ntxid].charterIdx)
+	}
+
+	row, err := newDBWithdrawalRow(requests, *startAddr, seriesID, *changeStart,
+		0, DefaultFeePolicy, w.status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serialized, err := encodeWithdrawalRow(row)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// This is the row format used before charter outputs were introduced.
+	type oldChangeAwareTx struct {
+		SerializedMsgTx []byte
+		ChangeIdx       int32
+	}
+	type oldWithdrawalStatus struct {
+		NextInputAddr  *dbWithdrawalAddress
+		NextChangeAddr dbChangeAddress
+		Fees           btcutil.Amount
+		Outputs        map[OutBailmentID]dbWithdrawalOutput
+		Sigs           map[Ntxid]TxSigs
+		Transactions   map[Ntxid]oldChangeAwareTx
+	}
+	type oldWithdrawalRow struct {
+		Requests      []dbOutputRequest
+		StartAddress  dbWithdrawalAddress
+		ChangeStart   dbChangeAddress
+		LastSeriesID  uint32
+		DustThreshold btcutil.Amount
+		FeePolicy     FeePolicy
+		Status        oldWithdrawalStatus
+	}
+	oldRow := oldWithdrawalRow{
+		Requests:      row.Requests,
+		StartAddress:  row.StartAddress,
+		ChangeStart:   row.ChangeStart,
+		LastSeriesID:  row.LastSeriesID,
+		DustThreshold: row.DustThreshold,
+		FeePolicy:     row.FeePolicy,
+		Status: oldWithdrawalStatus{
+			NextInputAddr:  row.Status.NextInputAddr,
+			NextChangeAddr: row.Status.NextChangeAddr,
+			Fees:           row.Status.Fees,
+			Outputs:        row.Status.Outputs,
+			Sigs:           row.Status.Sigs,
+			Transactions:   make(map[Ntxid]oldChangeAwareTx),
+		},
+	}
+	for id, tx := range row.Status.Transactions {
+		oldRow.Status.Transactions[id] = oldChangeAwareTx{
+			SerializedMsgTx: tx.SerializedMsgTx,
+			ChangeIdx:       tx.ChangeIdx,
+		}
+	}
+	var oldSerialized bytes.Buffer
+	if err := gob.NewEncoder(&oldSerialized).Encode(&oldRow); err != nil {
+		t.Fatal(err)
+	}
+
+	TstRunWithManagerUnlocked(t, pool.Manager(), func() {
+		_, status, err := deserializeWithdrawal(pool, serialized)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := status.transactions[ntxid].charterIdx; got != 1 {
+			t.Errorf("Wrong charter index; got %d, want 1", got)
+		}
+
+		_, status, err = deserializeWithdrawal(pool, oldSerialized.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := status.transactions[ntxid].charterIdx; got != -1 {
+			t.Errorf("Wrong charter index of old row; got %d, want -1", got)
+		}
+	})
+}
+
+func TestMigrateSeriesStates(t *testing.T) {
+	tearDown, _, pool := TstCreatePool(t)
+	defer tearDown()
+
+	// Store series the way they were before version 2 of the schema, with
+	// an active flag in place of the state, and revert the namespace to
+	// version 1.
+	pubKeys := [][]byte{bytes.Repeat([]byte{0x01}, seriesKeyLength)}
+	activeFlags := map[uint32]byte{1: 0x00, 2: 0x01}
+	err := pool.namespace.Update(
+		func(tx walletdb.Tx) error {
+			for seriesID, active := range activeFlags {
+				err := putSeries(tx, pool.ID, 1, seriesID, SeriesState(active),
+					1, pubKeys, nil)
+				if err != nil {
+					return err
+				}
+			}
+			schema := &walletdb.Schema{Migrations: dbSchema.Migrations[:1]}
+			return schema.PutLatestVersion(tx)
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := Upgrade(pool.namespace, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 1 || applied[0].Version != 2 {
+		t.Fatalf("Unexpected migrations applied: %v", applied)
+	}
+
+	wantStates := map[uint32]SeriesState{1: SeriesCreated, 2: SeriesActive}
+	err = pool.namespace.View(
+		func(tx walletdb.Tx) error {
+			allSeries, err := loadAllSeries(tx, pool.ID)
+			if err != nil {
+				return err
+			}
+			for seriesID, want := range wantStates {
+				row := allSeries[seriesID]
+				if row == nil {
+					t.Errorf("Series #%d is missing", seriesID)
+					continue
+				}
+				if row.state != want {
+					t.Errorf("Wrong state for series #%d; got %v, want %v",
+						seriesID, row.state, want)
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateSeriesStatesInvalidRow(t *testing.T) {
+	tearDown, _, pool := TstCreatePool(t)
+	defer tearDown()
+
+	// Store a series with a byte that is neither a former active flag nor
+	// a state and revert the namespace to version 1.
+	pubKeys := [][]byte{bytes.Repeat([]byte{0x01}, seriesKeyLength)}
+	schema := &walletdb.Schema{Migrations: dbSchema.Migrations[:1]}
+	err := pool.namespace.Update(
+		func(tx walletdb.Tx) error {
+			row := &dbSeriesRow{version: 1, reqSigs: 1, pubKeysEncrypted: pubKeys}
+			serialized, err := serializeSeriesRow(row)
+			if err != nil {
+				return err
+			}
+			serialized[4] = 0x07
+			bucket := tx.RootBucket().Bucket(pool.ID).Bucket(seriesBucketName)
+			if err := bucket.Put(uint32ToBytes(1), serialized); err != nil {
+				return err
+			}
+			return schema.PutLatestVersion(tx)
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Upgrade(pool.namespace, nil); err == nil {
+		t.Fatal("Upgrade succeeded with an unreadable series row")
+	}
+
+	// The failed upgrade must leave the namespace at version 1.
+	version, err := dbSchema.Version(pool.namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 {
+		t.Fatalf("Wrong version after failed upgrade; got %d, want 1", version)
+	}
+}
@@ -0,0 +1,369 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package votingpool
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/btcsuite/btcutil"
+	"github.com/monetas/btcwallet/txstore"
+	"github.com/monetas/btcwallet/waddrmgr"
+	"github.com/monetas/btcwallet/walletdb"
+)
+
+// AddressWatcher is implemented by wallets that can be asked to watch the
+// block chain for transactions paying to the given addresses, rescanning from
+// the given block to find any that have already been mined.
+type AddressWatcher interface {
+	WatchAddresses(addrs []btcutil.Address, bs waddrmgr.BlockStamp) error
+}
+
+// depositAddr identifies the series, branch and index of a deposit address.
+type depositAddr struct {
+	seriesID uint32
+	branch   Branch
+	index    Index
+}
+
+// seriesBranch identifies a branch of a series.
+type seriesBranch struct {
+	seriesID uint32
+	branch   Branch
+}
+
+// DepositWatcher keeps a lookahead of deposit addresses, past the highest used
+// one, for every branch of the series of a Pool that take deposits. The
+// addresses in the lookahead are imported into the address manager and handed
+// to an AddressWatcher, so that deposits made to them are noticed. Whenever
+// one is, the addresses up to the one the deposit was made to are marked as
+// used and the lookahead is moved forward.
+//
+// Deposits are only recorded as they are noticed; the addresses are marked as
+// used and the lookahead is moved forward by a goroutine of the watcher, so
+// credits are handled without touching the database or the AddressWatcher.
+type DepositWatcher struct {
+	pool      *Pool
+	watcher   AddressWatcher
+	lookahead uint32
+
+	// Signals the refresh goroutine that deposits were recorded.  It is
+	// buffered so that deposits noticed while a refresh is under way are
+	// handled by another one.
+	refreshNeeded chan struct{}
+	quit          chan struct{}
+	quitMtx       sync.Mutex
+	wg            sync.WaitGroup
+
+	// Serializes calls to Refresh.  It is not held by CreditReceived, so
+	// credits are recorded while a refresh imports addresses and waits for
+	// the AddressWatcher.
+	refreshMtx sync.Mutex
+
+	// Protects watched and pending.  It is never held while using the
+	// database, the address manager or the AddressWatcher.
+	mtx sync.Mutex
+	// All addresses in the lookahead, keyed by their encoding.
+	watched map[string]depositAddr
+	// The highest index of each branch that has received a deposit which
+	// could not yet be marked as used because the manager was locked.
+	pending map[seriesBranch]Index
+}
+
+// NewDepositWatcher returns a DepositWatcher that keeps the given number of
+// deposit addresses past the highest used one for every branch of the given
+// pool's series, registering them with the given AddressWatcher.
+func NewDepositWatcher(p *Pool, watcher AddressWatcher, lookahead uint32) *DepositWatcher {
+	return &DepositWatcher{
+		pool:          p,
+		watcher:       watcher,
+		lookahead:     lookahead,
+		refreshNeeded: make(chan struct{}, 1),
+		quit:          make(chan struct{}),
+		watched:       make(map[string]depositAddr),
+		pending:       make(map[seriesBranch]Index),
+	}
+}
+
+// Start starts the goroutine which marks the addresses of the deposits passed
+// to CreditReceived as used and extends the lookahead.
+func (w *DepositWatcher) Start() {
+	w.wg.Add(1)
+	go w.refreshHandler()
+}
+
+// Stop signals the refresh goroutine to stop.  Deposits which were not yet
+// handled remain recorded until the next call to Refresh.
+func (w *DepositWatcher) Stop() {
+	w.quitMtx.Lock()
+	defer w.quitMtx.Unlock()
+
+	select {
+	case <-w.quit:
+	default:
+		close(w.quit)
+	}
+}
+
+// WaitForShutdown blocks until the refresh goroutine has finished.
+func (w *DepositWatcher) WaitForShutdown() {
+	w.wg.Wait()
+}
+
+// refreshHandler refreshes the watcher whenever CreditReceived records a
+// deposit, unless the manager is locked, in which case the deposits are left
+// for the next call to Refresh with the manager unlocked.  It must be run as
+// a goroutine.
+func (w *DepositWatcher) refreshHandler() {
+	defer w.wg.Done()
+
+	for {
+		select {
+		case <-w.refreshNeeded:
+		case <-w.quit:
+			return
+		}
+		if w.pool.manager.IsLocked() {
+			log.Debugf("Address manager is locked; deferring update of " +
+				"used voting pool deposit addresses")
+			continue
+		}
+		if err := w.Refresh(); err != nil {
+			log.Errorf("Cannot update voting pool deposit addresses: %v", err)
+		}
+	}
+}
+
+// Refresh marks as used the addresses of any deposits received while the
+// manager was locked and then extends the lookahead of every branch of the
+// series that take deposits, so that it covers the given number of addresses
+// past the highest used one. Addresses not watched before are imported into the
+// address manager and passed to the AddressWatcher, which will rescan from the
+// pool's birthday for deposits made to them. It must be called with the
+// manager unlocked.
+func (w *DepositWatcher) Refresh() error {
+	w.refreshMtx.Lock()
+	defer w.refreshMtx.Unlock()
+
+	// Take the recorded deposits, putting back those that are not marked as
+	// used when this fails.
+	w.mtx.Lock()
+	pending := w.pending
+	w.pending = make(map[seriesBranch]Index)
+	w.mtx.Unlock()
+	for sb, index := range pending {
+		if err := w.pool.EnsureUsedAddr(sb.seriesID, sb.branch, index); err != nil {
+			w.restorePending(pending)
+			return err
+		}
+		delete(pending, sb)
+	}
+
+	lookahead, err := w.lookaheadAddrs()
+	if err != nil {
+		return err
+	}
+
+	// Only the addresses not watched yet are imported and watched.
+	w.mtx.Lock()
+	var unwatched []lookaheadAddr
+	for _, la := range lookahead {
+		if _, ok := w.watched[la.addr.EncodeAddress()]; !ok {
+			unwatched = append(unwatched, la)
+		}
+	}
+	w.mtx.Unlock()
+	if len(unwatched) == 0 {
+		return nil
+	}
+
+	newAddrs := make([]btcutil.Address, 0, len(unwatched))
+	for _, la := range unwatched {
+		if err := w.pool.importScript(la.script); err != nil {
+			return err
+		}
+		newAddrs = append(newAddrs, la.addr)
+	}
+
+	// The addresses are recorded as watched before the AddressWatcher is
+	// called, so deposits found by its rescan are noticed.
+	w.mtx.Lock()
+	for _, la := range unwatched {
+		w.watched[la.addr.EncodeAddress()] = la.deposit
+	}
+	w.mtx.Unlock()
+
+	if w.watcher == nil {
+		return nil
+	}
+	return w.watcher.WatchAddresses(newAddrs, w.pool.birthday)
+}
+
+// restorePending records again the deposits of a failed refresh which were
+// not marked as used, unless later deposits were recorded for their branches
+// in the meantime.
+func (w *DepositWatcher) restorePending(pending map[seriesBranch]Index) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	for sb, index := range pending {
+		if cur, ok := w.pending[sb]; !ok || cur < index {
+			w.pending[sb] = index
+		}
+	}
+}
+
+// lookaheadAddr is an address in the lookahead of a branch, with its deposit
+// script.
+type lookaheadAddr struct {
+	addr    btcutil.Address
+	script  []byte
+	deposit depositAddr
+}
+
+// lookaheadAddrs returns the addresses of the lookahead of every branch of the
+// series that take deposits, which are the given number of addresses past the
+// highest used one.
+func (w *DepositWatcher) lookaheadAddrs() ([]lookaheadAddr, error) {
+	var addrs []lookaheadAddr
+	for _, seriesID := range w.pool.depositSeriesIDs() {
+		series := w.pool.Series(seriesID)
+		for branch := Branch(0); int(branch) <= len(series.publicKeys); branch++ {
+			start, err := w.pool.nextUnusedIndex(seriesID, branch)
+			if err != nil {
+				return nil, err
+			}
+			for index := start; index < start+Index(w.lookahead); index++ {
+				script, err := w.pool.DepositScript(seriesID, branch, index)
+				if vpErr, ok := err.(Error); ok && vpErr.ErrorCode == ErrInvalidIndex {
+					// Invalid indices are skipped by all pool members, so
+					// no deposits can be made to them.
+					continue
+				}
+				if err != nil {
+					return nil, err
+				}
+				addr, err := w.pool.addressFor(script)
+				if err != nil {
+					return nil, err
+				}
+				addrs = append(addrs, lookaheadAddr{
+					addr:    addr,
+					script:  script,
+					deposit: depositAddr{seriesID, branch, index},
+				})
+			}
+		}
+	}
+	return addrs, nil
+}
+
+// CreditReceived must be called with every credit added to the wallet's
+// transaction store. If the credit is a deposit to one of the watched
+// addresses, it is recorded and the refresh goroutine started by Start is
+// signaled to mark that address and all addresses before it in the same branch
+// as used and to extend the lookahead. If the manager is locked this is
+// deferred until the next call to Refresh with the manager unlocked.
+// CreditReceived does not block, so it may be called from the goroutine
+// handling chain server notifications.
+func (w *DepositWatcher) CreditReceived(c txstore.Credit) {
+	_, addrs, _, err := c.Addresses(w.pool.manager.ChainParams())
+	if err != nil {
+		return
+	}
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	found := false
+	for _, addr := range addrs {
+		deposit, ok := w.watched[addr.EncodeAddress()]
+		if !ok {
+			continue
+		}
+		log.Infof("Deposit to series %d, branch %d, index %d of voting pool %x",
+			deposit.seriesID, deposit.branch, deposit.index, w.pool.ID)
+		sb := seriesBranch{deposit.seriesID, deposit.branch}
+		if index, ok := w.pending[sb]; !ok || index < deposit.index {
+			w.pending[sb] = deposit.index
+		}
+		found = true
+	}
+	if !found {
+		return
+	}
+
+	select {
+	case w.refreshNeeded <- struct{}{}:
+	default:
+		// A refresh is already pending and will handle this deposit.
+	}
+}
+
+// Addresses returns all deposit addresses currently being watched.
+func (w *DepositWatcher) Addresses() []btcutil.Address {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	addrs := make([]btcutil.Address, 0, len(w.watched))
+	for encoded := range w.watched {
+		addr, err := btcutil.DecodeAddress(encoded, w.pool.manager.ChainParams())
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// depositSeriesIDs returns, in ascending order, the IDs of all series that
+// take deposits.
+func (p *Pool) depositSeriesIDs() []uint32 {
+	var ids []uint32
+	for id, series := range p.seriesLookup {
+		if series.state == SeriesCreated || series.state == SeriesActive {
+			ids = append(ids, id)
+		}
+	}
+	sort.Sort(uint32Slice(ids))
+	return ids
+}
+
+// nextUnusedIndex returns the index following the highest used address of
+// the given series and branch, or 0 if no address of that branch has been used.
+func (p *Pool) nextUnusedIndex(seriesID uint32, branch Branch) (Index, error) {
+	highest, err := p.highestUsedIndexFor(seriesID, branch)
+	if err != nil {
+		return 0, err
+	}
+	if highest > 0 {
+		return highest + 1, nil
+	}
+	var used bool
+	err = p.namespace.View(
+		func(tx walletdb.Tx) error {
+			used = getUsedAddrHash(tx, p.ID, seriesID, branch, 0) != nil
+			return nil
+		})
+	if err != nil {
+		return 0, newError(ErrDatabase, "failed to lookup used addr", err)
+	}
+	if used {
+		return 1, nil
+	}
+	return 0, nil
+}
@@ -0,0 +1,362 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package votingpool
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcutil"
+	"github.com/monetas/btcwallet/waddrmgr"
+)
+
+// tstAddressWatcher is an AddressWatcher that records the addresses it's
+// asked to watch and, if watched is not nil, signals it after every call.
+type tstAddressWatcher struct {
+	calls   [][]btcutil.Address
+	bs      waddrmgr.BlockStamp
+	watched chan struct{}
+}
+
+func (w *tstAddressWatcher) WatchAddresses(addrs []btcutil.Address, bs waddrmgr.BlockStamp) error {
+	w.calls = append(w.calls, addrs)
+	w.bs = bs
+	if w.watched != nil {
+		w.watched <- struct{}{}
+	}
+	return nil
+}
+
+func TestDepositWatcherRefresh(t *testing.T) {
+	tearDown, mgr, pool := TstCreatePool(t)
+	defer tearDown()
+
+	TstCreateSeries(t, pool, []TstSeriesDef{
+		{ReqSigs: 2, PubKeys: TstPubKeys[1:4], SeriesID: 1},
+		{ReqSigs: 2, PubKeys: TstPubKeys[3:6], SeriesID: 2},
+	})
+	watcher := &tstAddressWatcher{}
+	dw := NewDepositWatcher(pool, watcher, 2)
+
+	TstRunWithManagerUnlocked(t, mgr, func() {
+		if err := dw.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if len(watcher.calls) != 1 {
+		t.Fatalf("Wrong number of WatchAddresses calls; got %d, want 1", len(watcher.calls))
+	}
+	// Each series has 3 pubkeys and thus 4 branches, and we have a lookahead of 2.
+	var want []btcutil.Address
+	for _, seriesID := range []uint32{1, 2} {
+		for branch := Branch(0); branch <= 3; branch++ {
+			for idx := Index(0); idx < 2; idx++ {
+				addr, err := pool.DepositScriptAddress(seriesID, branch, idx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want = append(want, addr)
+			}
+		}
+	}
+	if !reflect.DeepEqual(watcher.calls[0], want) {
+		t.Fatalf("Wrong addresses watched; got %v, want %v", watcher.calls[0], want)
+	}
+	if watcher.bs != pool.Birthday() {
+		t.Fatalf("Wrong rescan start; got %v, want %v", watcher.bs, pool.Birthday())
+	}
+	if len(dw.Addresses()) != len(want) {
+		t.Fatalf("Wrong number of watched addresses; got %d, want %d",
+			len(dw.Addresses()), len(want))
+	}
+	for _, addr := range want {
+		if _, err := mgr.Address(addr); err != nil {
+			t.Fatalf("Address %v not imported into the address manager: %v", addr, err)
+		}
+	}
+
+	// A second refresh must not watch any new addresses.
+	TstRunWithManagerUnlocked(t, mgr, func() {
+		if err := dw.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if len(watcher.calls) != 1 {
+		t.Fatalf("Unexpected WatchAddresses call with %v", watcher.calls[1:])
+	}
+}
+
+func TestDepositWatcherSkipsSeriesNotTakingDeposits(t *testing.T) {
+	tearDown, mgr, pool := TstCreatePool(t)
+	defer tearDown()
+
+	TstCreateSeries(t, pool, []TstSeriesDef{
+		{ReqSigs: 2, PubKeys: TstPubKeys[1:4], SeriesID: 1},
+	})
+	pool.Series(1).state = SeriesThawing
+	watcher := &tstAddressWatcher{}
+	dw := NewDepositWatcher(pool, watcher, 2)
+
+	TstRunWithManagerUnlocked(t, mgr, func() {
+		if err := dw.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if len(watcher.calls) != 0 {
+		t.Fatalf("Unexpected WatchAddresses call with %v", watcher.calls)
+	}
+}
+
+func TestDepositWatcherSkipsInvalidIndex(t *testing.T) {
+	tearDown, mgr, pool := TstCreatePool(t)
+	defer tearDown()
+
+	seriesID := tstCreateSeriesWithInvalidIndex(t, pool, 1)
+	watcher := &tstAddressWatcher{}
+	dw := NewDepositWatcher(pool, watcher, 3)
+
+	TstRunWithManagerUnlocked(t, mgr, func() {
+		if err := dw.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	// Each of the 4 branches has indices 0 and 2 watched, but not the
+	// invalid index 1.
+	if got := len(dw.Addresses()); got != 8 {
+		t.Fatalf("Wrong number of watched addresses; got %d, want 8", got)
+	}
+	for _, index := range []Index{0, 2} {
+		addr, err := pool.DepositScriptAddress(seriesID, 0, index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := dw.watched[addr.EncodeAddress()]; !ok {
+			t.Fatalf("Address with index %d is not watched", index)
+		}
+	}
+}
+
+func TestDepositWatcherCreditReceived(t *testing.T) {
+	tearDown, pool, store := TstCreatePoolAndTxStore(t)
+	defer tearDown()
+
+	mgr := pool.manager
+	TstCreateSeries(t, pool, []TstSeriesDef{
+		{ReqSigs: 2, PubKeys: TstPubKeys[1:4], SeriesID: 1},
+	})
+	watcher := &tstAddressWatcher{}
+	dw := NewDepositWatcher(pool, watcher, 2)
+	TstRunWithManagerUnlocked(t, mgr, func() {
+		if err := dw.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	// The deposit is handled by the refresh goroutine.
+	watcher.watched = make(chan struct{}, 1)
+	dw.Start()
+	defer func() {
+		dw.Stop()
+		dw.WaitForShutdown()
+	}()
+	credits := TstCreateInputs(t, store, tstDepositPkScript(t, pool, 1, 2, 1), []int64{1e6})
+	TstRunWithManagerUnlocked(t, mgr, func() {
+		dw.CreditReceived(credits[0])
+		select {
+		case <-watcher.watched:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Deposit was not handled")
+		}
+	})
+
+	checkNextUnusedIndex(t, pool, 1, 2, 2)
+	// Other branches must not be affected.
+	checkNextUnusedIndex(t, pool, 1, 1, 0)
+
+	// The lookahead of the branch must have been moved past the used address.
+	if len(watcher.calls) != 2 {
+		t.Fatalf("Wrong number of WatchAddresses calls; got %d, want 2", len(watcher.calls))
+	}
+	var want []btcutil.Address
+	for idx := Index(2); idx < 4; idx++ {
+		addr, err := pool.DepositScriptAddress(1, 2, idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, addr)
+	}
+	if !reflect.DeepEqual(watcher.calls[1], want) {
+		t.Fatalf("Wrong addresses watched; got %v, want %v", watcher.calls[1], want)
+	}
+}
+
+// tstBlockingAddressWatcher is an AddressWatcher that signals called and then
+// blocks until release is closed.
+type tstBlockingAddressWatcher struct {
+	called  chan struct{}
+	release chan struct{}
+}
+
+func (w *tstBlockingAddressWatcher) WatchAddresses(addrs []btcutil.Address, bs waddrmgr.BlockStamp) error {
+	w.called <- struct{}{}
+	<-w.release
+	return nil
+}
+
+func TestDepositWatcherCreditReceivedDuringRefresh(t *testing.T) {
+	tearDown, pool, store := TstCreatePoolAndTxStore(t)
+	defer tearDown()
+
+	mgr := pool.manager
+	TstCreateSeries(t, pool, []TstSeriesDef{
+		{ReqSigs: 2, PubKeys: TstPubKeys[1:4], SeriesID: 1},
+	})
+	watcher := &tstBlockingAddressWatcher{
+		called:  make(chan struct{}, 1),
+		release: make(chan struct{}),
+	}
+	dw := NewDepositWatcher(pool, watcher, 2)
+	credits := TstCreateInputs(t, store, tstDepositPkScript(t, pool, 1, 0, 1), []int64{1e6})
+
+	TstRunWithManagerUnlocked(t, mgr, func() {
+		refreshed := make(chan error, 1)
+		go func() { refreshed <- dw.Refresh() }()
+		select {
+		case <-watcher.called:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Addresses were not watched")
+		}
+
+		// The refresh is waiting for the AddressWatcher, which must
+		// not keep credits from being recorded.
+		received := make(chan struct{})
+		go func() {
+			dw.CreditReceived(credits[0])
+			close(received)
+		}()
+		select {
+		case <-received:
+		case <-time.After(5 * time.Second):
+			t.Fatal("CreditReceived blocked on the refresh")
+		}
+
+		close(watcher.release)
+		if err := <-refreshed; err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	dw.mtx.Lock()
+	index, ok := dw.pending[seriesBranch{1, 0}]
+	dw.mtx.Unlock()
+	if !ok || index != 1 {
+		t.Fatalf("Deposit was not recorded; got index %d (recorded %v), want 1",
+			index, ok)
+	}
+}
+
+func TestDepositWatcherCreditReceivedWhileLocked(t *testing.T) {
+	tearDown, pool, store := TstCreatePoolAndTxStore(t)
+	defer tearDown()
+
+	mgr := pool.manager
+	TstCreateSeries(t, pool, []TstSeriesDef{
+		{ReqSigs: 2, PubKeys: TstPubKeys[1:4], SeriesID: 1},
+	})
+	watcher := &tstAddressWatcher{}
+	dw := NewDepositWatcher(pool, watcher, 2)
+	TstRunWithManagerUnlocked(t, mgr, func() {
+		if err := dw.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	credits := TstCreateInputs(t, store, tstDepositPkScript(t, pool, 1, 0, 0), []int64{1e6})
+	dw.Start()
+	dw.CreditReceived(credits[0])
+	dw.Stop()
+	dw.WaitForShutdown()
+
+	// The manager is locked, so the address can't be marked as used yet.
+	checkNextUnusedIndex(t, pool, 1, 0, 0)
+
+	TstRunWithManagerUnlocked(t, mgr, func() {
+		if err := dw.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	checkNextUnusedIndex(t, pool, 1, 0, 1)
+}
+
+func TestDepositWatcherIgnoresOtherCredits(t *testing.T) {
+	tearDown, pool, store := TstCreatePoolAndTxStore(t)
+	defer tearDown()
+
+	mgr := pool.manager
+	TstCreateSeries(t, pool, []TstSeriesDef{
+		{ReqSigs: 2, PubKeys: TstPubKeys[1:4], SeriesID: 1},
+	})
+	watcher := &tstAddressWatcher{}
+	dw := NewDepositWatcher(pool, watcher, 2)
+	TstRunWithManagerUnlocked(t, mgr, func() {
+		if err := dw.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	// This address is beyond the lookahead.
+	credits := TstCreateInputs(t, store, tstDepositPkScript(t, pool, 1, 0, 5), []int64{1e6})
+	TstRunWithManagerUnlocked(t, mgr, func() {
+		dw.CreditReceived(credits[0])
+	})
+
+	checkNextUnusedIndex(t, pool, 1, 0, 0)
+	if len(watcher.calls) != 1 {
+		t.Fatalf("Unexpected WatchAddresses call with %v", watcher.calls[1:])
+	}
+}
+
+// tstDepositPkScript returns a pkScript paying to the deposit address with the
+// given series, branch and index, without marking that address as used.
+func tstDepositPkScript(t *testing.T, p *Pool, seriesID uint32, branch Branch, idx Index) []byte {
+	addr, err := p.DepositScriptAddress(seriesID, branch, idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkScript
+}
+
+func checkNextUnusedIndex(t *testing.T, p *Pool, seriesID uint32, branch Branch, want Index) {
+	got, err := p.nextUnusedIndex(seriesID, branch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("Wrong next unused index for series %d, branch %d; got %d, want %d",
+			seriesID, branch, got, want)
+	}
+}
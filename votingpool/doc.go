@@ -59,6 +59,12 @@ sorted according to the given branch. The procedure to construct multi-sig
 deposit addresses is described in detail at
 http://opentransactions.org/wiki/index.php/Deposit_Address_(voting_pools)
 
+In the extremely unlikely case that one of the series' public keys has no
+valid child at a given index, that index is invalid for the whole series: no
+deposit address exists for it (an error with code ErrInvalidIndex is returned)
+and it is skipped when tracking used addresses and selecting withdrawal inputs
+and change addresses, so all pool members agree on the addresses in use.
+
 Replacing a series
 
 A series can be replaced via the ReplaceSeries method. It accepts
@@ -86,6 +92,8 @@ StartWithdrawal method:
 	lastSeriesID: the ID of the last series where we should take inputs from
 	changeStart: the first change address to use
 	dustThreshold: the minimum amount of satoshis an input needs to be considered eligible
+	feePolicy: the fee rate per kB, minimum fee and maximum fee share per output used
+	  to calculate the network fees of every transaction
 
 StartWithdrawal will then select all eligible inputs in the given address
 range (following the algorithim at <http://opentransactions.org/wiki/index.php/Input_Selection_Algorithm_(voting_pools)>)
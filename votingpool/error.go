@@ -147,6 +147,40 @@ const (
 	// invalid ID.
 	ErrSeriesIDInvalid
 
+	// ErrWithdrawalStorage indicates an error occurred when serializing or
+	// deserializing a withdrawal for storing into the database.
+	ErrWithdrawalStorage
+
+	// ErrWithdrawalNotFound indicates an attempt to load a withdrawal round
+	// that has not been stored.
+	ErrWithdrawalNotFound
+
+	// ErrWithdrawalParamsMismatch indicates an attempt to start a
+	// withdrawal with a roundID that has already been used for a
+	// withdrawal with different parameters.
+	ErrWithdrawalParamsMismatch
+
+	// ErrInvalidRawSig indicates a raw signature that is not a valid
+	// signature for the transaction input and pubkey it was provided for.
+	ErrInvalidRawSig
+
+	// ErrCharterOutputNotFound indicates that the charter outpoint of a
+	// series is not among the unspent outputs locked to that series.
+	ErrCharterOutputNotFound
+
+	// ErrInvalidSeriesState indicates an operation, or a transition to
+	// another state, that is not allowed in the current state of a series.
+	ErrInvalidSeriesState
+
+	// ErrSeriesNotHot indicates an attempt to withdraw from a series that
+	// is neither active nor thawing.
+	ErrSeriesNotHot
+
+	// ErrInvalidIndex indicates an address index at which at least one of
+	// the public keys of a series has no valid child. Such indices are
+	// skipped by all pool members.
+	ErrInvalidIndex
+
 	// lastErr is used for testing, making it possible to iterate over
 	// the error codes in order to check that they all have proper
 	// translations in errorCodeStrings.
@@ -187,6 +221,14 @@ var errorCodeStrings = map[ErrorCode]string{
 	ErrTxSigning:                 "ErrTxSigning",
 	ErrInvalidScriptHash:         "ErrInvalidScriptHash",
 	ErrWithdrawFromUnusedAddr:    "ErrWithdrawFromUnusedAddr",
+	ErrWithdrawalStorage:         "ErrWithdrawalStorage",
+	ErrWithdrawalNotFound:        "ErrWithdrawalNotFound",
+	ErrWithdrawalParamsMismatch:  "ErrWithdrawalParamsMismatch",
+	ErrInvalidRawSig:             "ErrInvalidRawSig",
+	ErrCharterOutputNotFound:     "ErrCharterOutputNotFound",
+	ErrInvalidSeriesState:        "ErrInvalidSeriesState",
+	ErrSeriesNotHot:              "ErrSeriesNotHot",
+	ErrInvalidIndex:              "ErrInvalidIndex",
 }
 
 // String returns the ErrorCode as a human-readable name.
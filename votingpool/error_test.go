@@ -64,6 +64,14 @@ func TestErrorCodeStringer(t *testing.T) {
 		{vp.ErrTxSigning, "ErrTxSigning"},
 		{vp.ErrInvalidScriptHash, "ErrInvalidScriptHash"},
 		{vp.ErrWithdrawFromUnusedAddr, "ErrWithdrawFromUnusedAddr"},
+		{vp.ErrWithdrawalStorage, "ErrWithdrawalStorage"},
+		{vp.ErrWithdrawalNotFound, "ErrWithdrawalNotFound"},
+		{vp.ErrWithdrawalParamsMismatch, "ErrWithdrawalParamsMismatch"},
+		{vp.ErrInvalidRawSig, "ErrInvalidRawSig"},
+		{vp.ErrCharterOutputNotFound, "ErrCharterOutputNotFound"},
+		{vp.ErrInvalidSeriesState, "ErrInvalidSeriesState"},
+		{vp.ErrSeriesNotHot, "ErrSeriesNotHot"},
+		{vp.ErrInvalidIndex, "ErrInvalidIndex"},
 		{0xffff, "Unknown ErrorCode (65535)"},
 	}
 
@@ -231,7 +231,7 @@ func Example_startWithdrawal() {
 	}
 	_, err = pool.StartWithdrawal(
 		roundID, requests, *startAddr, lastSeriesID, *changeStart, txstore, currentBlock,
-		dustThreshold)
+		dustThreshold, votingpool.DefaultFeePolicy)
 	if err != nil {
 		fmt.Println(err)
 	}
@@ -22,7 +22,6 @@ import (
 	"bytes"
 	"io/ioutil"
 	"os"
-	"path/filepath"
 	"sync/atomic"
 	"testing"
 
@@ -34,6 +33,7 @@ import (
 	"github.com/monetas/btcwallet/txstore"
 	"github.com/monetas/btcwallet/waddrmgr"
 	"github.com/monetas/btcwallet/walletdb"
+	_ "github.com/monetas/btcwallet/walletdb/memdb"
 )
 
 var (
@@ -54,7 +54,7 @@ func getUniqueID() uint32 {
 func createWithdrawalTx(t *testing.T, pool *Pool, store *txstore.Store, inputAmounts []int64,
 	outputAmounts []int64) *withdrawalTx {
 	net := pool.Manager().ChainParams()
-	tx := newWithdrawalTx()
+	tx := newWithdrawalTx(DefaultFeePolicy)
 	_, credits := TstCreateCredits(t, pool, inputAmounts, store)
 	for _, c := range credits {
 		tx.addInput(c)
@@ -169,7 +169,9 @@ func TstCreateSeries(t *testing.T, pool *Pool, definitions []TstSeriesDef) {
 				}
 			}
 		})
-		pool.Series(def.SeriesID).active = !def.Inactive
+		if !def.Inactive {
+			pool.Series(def.SeriesID).state = SeriesActive
+		}
 	}
 }
 
@@ -296,12 +298,8 @@ func TstCreatePool(t *testing.T) (tearDownFunc func(), mgr *waddrmgr.Manager, po
 	// t.Parallel() call in each of our tests.
 	t.Parallel()
 
-	// Create a new wallet DB and addr manager.
-	dir, err := ioutil.TempDir("", "pool_test")
-	if err != nil {
-		t.Fatalf("Failed to create db dir: %v", err)
-	}
-	db, err := walletdb.Create("bdb", filepath.Join(dir, "wallet.db"))
+	// Create a new in-memory wallet DB and addr manager.
+	db, err := walletdb.Create("memdb")
 	if err != nil {
 		t.Fatalf("Failed to create wallet DB: %v", err)
 	}
@@ -328,7 +326,6 @@ func TstCreatePool(t *testing.T) (tearDownFunc func(), mgr *waddrmgr.Manager, po
 	tearDownFunc = func() {
 		db.Close()
 		mgr.Close()
-		os.RemoveAll(dir)
 	}
 	return tearDownFunc, mgr, pool
 }
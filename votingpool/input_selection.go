@@ -170,6 +170,66 @@ func (p *Pool) getEligibleInputs(store *txstore.Store, startAddress WithdrawalAd
 	return inputs, nil
 }
 
+// SeriesCredits returns all unspent credits in the given store that are locked
+// to used addresses of the given series, regardless of their eligibility as
+// withdrawal inputs. The series must be hot.
+//
+// This method must be called with the manager unlocked.
+func (p *Pool) SeriesCredits(store *txstore.Store, seriesID uint32) ([]Credit, error) {
+	series := p.Series(seriesID)
+	if series == nil {
+		str := fmt.Sprintf("unknown seriesID: %d", seriesID)
+		return nil, newError(ErrSeriesNotExists, str, nil)
+	}
+	if !series.IsHot() {
+		str := fmt.Sprintf("series #%d is %v", seriesID, series.state)
+		return nil, newError(ErrSeriesNotHot, str, nil)
+	}
+	unspents, err := store.UnspentOutputs()
+	if err != nil {
+		return nil, newError(ErrInputSelection, "failed to get unspent outputs", err)
+	}
+	addrMap, err := groupCreditsByAddr(unspents, p.manager.ChainParams())
+	if err != nil {
+		return nil, err
+	}
+	var credits []Credit
+	address, err := firstAddr(p, seriesID)
+	for address != nil && err == nil {
+		for _, c := range addrMap[address.addr.EncodeAddress()] {
+			credits = append(credits, newCredit(c, *address))
+		}
+		address, err = nextAddr(p, address.seriesID, address.branch, address.index, seriesID+1)
+	}
+	if err != nil {
+		return nil, newError(ErrInputSelection, "failed to get series addresses", err)
+	}
+	sort.Sort(byAddress(credits))
+	return credits, nil
+}
+
+// SeriesBalance returns the total amount of the unspent credits locked to used
+// addresses of the given series which have at least minConf confirmations.
+// The series must be hot.
+//
+// This method must be called with the manager unlocked.
+func (p *Pool) SeriesBalance(store *txstore.Store, seriesID uint32, minConf int,
+	chainHeight int32) (btcutil.Amount, error) {
+	credits, err := p.SeriesCredits(store, seriesID)
+	if err != nil {
+		return 0, err
+	}
+	balance := btcutil.Amount(0)
+	for _, c := range credits {
+		// SeriesCredits() only returns *credit values, so it's safe to get at
+		// the underlying txstore.Credit here.
+		if c.(*credit).Credit.Confirmed(minConf, chainHeight) {
+			balance += c.Amount()
+		}
+	}
+	return balance, nil
+}
+
 // nextAddr returns the next WithdrawalAddress according to the input selection
 // rules: http://opentransactions.org/wiki/index.php/Input_Selection_Algorithm_(voting_pools)
 // It returns nil if the new address' seriesID is >= stopSeriesID.
@@ -200,6 +260,16 @@ func nextAddr(p *Pool, seriesID uint32, branch Branch, index Index, stopSeriesID
 		return nil, nil
 	}
 
+	if series := p.Series(seriesID); series != nil && !series.IsHot() {
+		// Series that are not hot are skipped altogether.
+		log.Debugf("nextAddr(): skipping series #%d as it is %v", seriesID, series.state)
+		highestIdx, err := p.highestUsedSeriesIndex(seriesID)
+		if err != nil {
+			return nil, err
+		}
+		return nextAddr(p, seriesID, Branch(len(series.publicKeys)), highestIdx+1, stopSeriesID)
+	}
+
 	addr, err := p.WithdrawalAddress(seriesID, branch, index)
 	if err != nil && err.(Error).ErrorCode == ErrWithdrawFromUnusedAddr {
 		// The used indices will vary between branches so sometimes we'll try to
@@ -212,6 +282,20 @@ func nextAddr(p *Pool, seriesID uint32, branch Branch, index Index, stopSeriesID
 	return addr, err
 }
 
+// firstAddr returns the first used WithdrawalAddress of the given series,
+// according to the input selection rules, or nil if no address of the series
+// has been used.
+func firstAddr(p *Pool, seriesID uint32) (*WithdrawalAddress, error) {
+	addr, err := p.WithdrawalAddress(seriesID, 0, 0)
+	if err == nil {
+		return addr, nil
+	}
+	if vpErr, ok := err.(Error); !ok || vpErr.ErrorCode != ErrWithdrawFromUnusedAddr {
+		return nil, err
+	}
+	return nextAddr(p, seriesID, 0, 0, seriesID+1)
+}
+
 // highestUsedSeriesIndex returns the highest index among all of this Pool's
 // used addresses for the given seriesID. It returns 0 if there are no used
 // addresses with the given seriesID.
@@ -280,8 +364,138 @@ func (p *Pool) isCreditEligible(c txstore.Credit, minConf int, chainHeight int32
 	return true
 }
 
-// isCharterOutput - TODO: In order to determine this, we need the txid
-// and the output index of the current charter output, which we don't have yet.
+// isCharterOutput returns true if the given credit is the current charter
+// output of any of the pool's series.
 func (p *Pool) isCharterOutput(c txstore.Credit) bool {
-	return false
+	_, ok := p.charterSeriesID(c.OutPoint())
+	return ok
+}
+
+// charterSeriesID returns the ID of the series whose current charter outpoint
+// is the given one. The second return value is false if the outpoint is not
+// the charter of any series.
+func (p *Pool) charterSeriesID(outpoint *wire.OutPoint) (uint32, bool) {
+	for seriesID, charter := range p.charters {
+		if *charter == *outpoint {
+			return seriesID, true
+		}
+	}
+	return 0, false
+}
+
+// AdvanceCharters advances the charter of every series that has one and
+// stores the new charter outpoints; see advanceCharter. It is called by
+// StartWithdrawal, so that recreated charter outputs which have been mined
+// are not used as inputs, and may be called whenever new blocks are connected
+// to keep the stored charters current.
+func (p *Pool) AdvanceCharters(store *txstore.Store) error {
+	for seriesID := range p.charters {
+		if err := p.advanceCharter(store, seriesID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// advanceCharter follows the charter of the given series through the mined
+// transactions of the given store: while the charter output is spent by a
+// mined transaction, the output of that transaction recreating it, with the
+// same pkScript and amount, becomes the series' charter. This way the charter
+// is kept up to date whichever pool member broadcast the withdrawal
+// transaction spending it, and unmined transactions, which may never be
+// broadcast or may be double spent, are ignored.
+func (p *Pool) advanceCharter(store *txstore.Store, seriesID uint32) error {
+	charter := p.CharterOutpoint(seriesID)
+	if charter == nil {
+		return nil
+	}
+	records := store.Records()
+	charterOut := findTxOut(records, charter)
+	if charterOut == nil {
+		// charterCredit reports charters missing from the store.
+		return nil
+	}
+	next := *charter
+	for {
+		recreated, ok := findRecreatedCharter(records, &next, charterOut)
+		if !ok {
+			break
+		}
+		next = recreated
+	}
+	if next == *charter {
+		return nil
+	}
+	log.Infof("Charter of series #%d of voting pool %x advanced to %v",
+		seriesID, p.ID, next)
+	return p.SetCharterOutpoint(seriesID, next)
+}
+
+// findTxOut returns the output with the given outpoint of one of the given
+// transaction records, or nil if there is none.
+func findTxOut(records []*txstore.TxRecord, outpoint *wire.OutPoint) *wire.TxOut {
+	for _, r := range records {
+		if !r.Tx().Sha().IsEqual(&outpoint.Hash) {
+			continue
+		}
+		txOuts := r.Tx().MsgTx().TxOut
+		if int(outpoint.Index) < len(txOuts) {
+			return txOuts[outpoint.Index]
+		}
+	}
+	return nil
+}
+
+// findRecreatedCharter looks, among the mined transactions of the given
+// records, for the one spending the given charter and returns the outpoint of
+// its output recreating the charter output charterOut. The second return
+// value is false if there is no such transaction or output.
+func findRecreatedCharter(records []*txstore.TxRecord, charter *wire.OutPoint,
+	charterOut *wire.TxOut) (wire.OutPoint, bool) {
+	for _, r := range records {
+		if r.BlockHeight == -1 {
+			continue
+		}
+		msgtx := r.Tx().MsgTx()
+		spends := false
+		for _, txIn := range msgtx.TxIn {
+			if txIn.PreviousOutPoint == *charter {
+				spends = true
+				break
+			}
+		}
+		if !spends {
+			continue
+		}
+		for i, txOut := range msgtx.TxOut {
+			if txOut.Value == charterOut.Value &&
+				bytes.Equal(txOut.PkScript, charterOut.PkScript) {
+				return wire.OutPoint{Hash: *r.Tx().Sha(), Index: uint32(i)}, true
+			}
+		}
+	}
+	return wire.OutPoint{}, false
+}
+
+// charterCredit returns the credit of the given series' current charter
+// output, or nil if the series has no charter outpoint registered.
+//
+// This method must be called with the manager unlocked.
+func (p *Pool) charterCredit(store *txstore.Store, seriesID uint32) (Credit, error) {
+	charter := p.CharterOutpoint(seriesID)
+	if charter == nil {
+		return nil, nil
+	}
+	credits, err := p.SeriesCredits(store, seriesID)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range credits {
+		if *c.OutPoint() == *charter {
+			return c, nil
+		}
+	}
+	str := fmt.Sprintf("charter outpoint %v of series #%d is not an unspent output of that series",
+		charter, seriesID)
+	return nil, newError(ErrCharterOutputNotFound, str, nil)
 }
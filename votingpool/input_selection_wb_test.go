@@ -84,6 +84,71 @@ func TestGetEligibleInputs(t *testing.T) {
 	checkUniqueness(t, eligibles)
 }
 
+func TestSeriesCreditsAndBalance(t *testing.T) {
+	tearDown, pool, store := TstCreatePoolAndTxStore(t)
+	defer tearDown()
+
+	series := []TstSeriesDef{
+		{ReqSigs: 2, PubKeys: TstPubKeys[1:4], SeriesID: 1},
+		{ReqSigs: 2, PubKeys: TstPubKeys[3:6], SeriesID: 2},
+	}
+	TstCreateSeries(t, pool, series)
+	scripts := getPKScriptsForAddressRange(t, pool, 1, 0, 2, 0, 1)
+	for i, script := range scripts {
+		TstCreateInputsOnBlock(t, store, i+1, script, []int64{1e6, 2e6})
+	}
+	// Credits on series 2 must not count towards the credits of series 1.
+	TstCreateInputsOnBlock(t, store, len(scripts)+1, TstCreatePkScript(t, pool, 2, 0, 0),
+		[]int64{5e6})
+
+	var credits []Credit
+	var confirmed, unconfirmed btcutil.Amount
+	var err error
+	TstRunWithManagerUnlocked(t, pool.Manager(), func() {
+		credits, err = pool.SeriesCredits(store, 1)
+		if err != nil {
+			return
+		}
+		confirmed, err = pool.SeriesBalance(store, 1, 1, TstInputsBlock)
+		if err != nil {
+			return
+		}
+		unconfirmed, err = pool.SeriesBalance(store, 1, 2, TstInputsBlock)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(credits) != 2*len(scripts) {
+		t.Fatalf("Wrong number of series credits; got %d, want %d", len(credits),
+			2*len(scripts))
+	}
+	if !sort.IsSorted(byAddress(credits)) {
+		t.Fatal("Series credits are not sorted.")
+	}
+	for _, c := range credits {
+		if c.Address().SeriesID() != 1 {
+			t.Fatalf("Credit %v is not on series 1", c)
+		}
+	}
+	wantBalance := btcutil.Amount(3e6 * len(scripts))
+	if confirmed != wantBalance {
+		t.Fatalf("Wrong series balance; got %v, want %v", confirmed, wantBalance)
+	}
+	if unconfirmed != 0 {
+		t.Fatalf("Wrong series balance with 2 confirmations; got %v, want 0", unconfirmed)
+	}
+}
+
+func TestSeriesCreditsNonExistentSeries(t *testing.T) {
+	tearDown, pool, store := TstCreatePoolAndTxStore(t)
+	defer tearDown()
+
+	_, err := pool.SeriesCredits(store, 1)
+
+	TstCheckError(t, "", err, ErrSeriesNotExists)
+}
+
 func TestNextAddrWithVaryingHighestIndices(t *testing.T) {
 	tearDown, mgr, pool := TstCreatePool(t)
 	defer tearDown()
@@ -212,6 +277,57 @@ func TestNextAddr(t *testing.T) {
 	}
 }
 
+func TestNextAddrSkipsSeriesNotHot(t *testing.T) {
+	tearDown, mgr, pool := TstCreatePool(t)
+	defer tearDown()
+
+	series := []TstSeriesDef{
+		{ReqSigs: 2, PubKeys: TstPubKeys[1:4], SeriesID: 1},
+		{ReqSigs: 2, PubKeys: TstPubKeys[3:6], SeriesID: 2, Inactive: true},
+		{ReqSigs: 2, PubKeys: TstPubKeys[5:8], SeriesID: 3},
+	}
+	TstCreateSeries(t, pool, series)
+	TstEnsureUsedAddr(t, pool, 1, Branch(0), 0)
+	TstEnsureUsedAddr(t, pool, 2, Branch(0), 0)
+	TstEnsureUsedAddr(t, pool, 3, Branch(0), 0)
+
+	var addr *WithdrawalAddress
+	var err error
+	TstRunWithManagerUnlocked(t, mgr, func() {
+		addr, err = nextAddr(pool, 1, 0, 0, 4)
+	})
+	if err != nil {
+		t.Fatalf("Failed to get next address: %v", err)
+	}
+	// Series 2 is not hot, so we must have skipped it.
+	checkWithdrawalAddressMatches(t, addr, 3, 0, 0)
+}
+
+func TestNextAddrSkipsInvalidIndex(t *testing.T) {
+	tearDown, mgr, pool := TstCreatePool(t)
+	defer tearDown()
+
+	seriesID := tstCreateSeriesWithInvalidIndex(t, pool, 1)
+	for _, branch := range []Branch{0, 1, 2, 3} {
+		TstRunWithManagerUnlocked(t, mgr, func() {
+			if err := pool.EnsureUsedAddr(seriesID, branch, 2); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	var addr *WithdrawalAddress
+	var err error
+	TstRunWithManagerUnlocked(t, mgr, func() {
+		addr, err = nextAddr(pool, seriesID, 3, 0, seriesID+1)
+	})
+	if err != nil {
+		t.Fatalf("Failed to get next address: %v", err)
+	}
+	// Index 1 is invalid, so we must have moved from index 0 to index 2.
+	checkWithdrawalAddressMatches(t, addr, seriesID, 0, 2)
+}
+
 func TestEligibleInputsAreEligible(t *testing.T) {
 	tearDown, pool, store := TstCreatePoolAndTxStore(t)
 	defer tearDown()
@@ -271,6 +387,148 @@ func TestNonEligibleInputsAreNotEligible(t *testing.T) {
 	}
 }
 
+func TestCharterOutputIsNotEligible(t *testing.T) {
+	tearDown, pool, store := TstCreatePoolAndTxStore(t)
+	defer tearDown()
+
+	series := []TstSeriesDef{{ReqSigs: 2, PubKeys: TstPubKeys[1:4], SeriesID: 1}}
+	TstCreateSeries(t, pool, series)
+	pkScript := TstCreatePkScript(t, pool, 1, 0, 0)
+	credits := TstCreateInputs(t, store, pkScript, []int64{int64(dustThreshold), int64(dustThreshold)})
+	if err := pool.SetCharterOutpoint(1, *credits[0].OutPoint()); err != nil {
+		t.Fatal(err)
+	}
+
+	chainHeight := int32(TstInputsBlock + eligibleInputMinConfirmations)
+	if pool.isCreditEligible(credits[0], eligibleInputMinConfirmations, chainHeight,
+		dustThreshold) {
+		t.Errorf("Charter output is eligible and it should not be.")
+	}
+	if !pool.isCreditEligible(credits[1], eligibleInputMinConfirmations, chainHeight,
+		dustThreshold) {
+		t.Errorf("Input is not eligible and it should be.")
+	}
+
+	var charter Credit
+	var err error
+	TstRunWithManagerUnlocked(t, pool.Manager(), func() {
+		charter, err = pool.charterCredit(store, 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *charter.OutPoint() != *credits[0].OutPoint() {
+		t.Fatalf("Wrong charter credit; got %v, want %v", charter.OutPoint(),
+			credits[0].OutPoint())
+	}
+}
+
+func TestCharterCreditNotFound(t *testing.T) {
+	tearDown, pool, store := TstCreatePoolAndTxStore(t)
+	defer tearDown()
+
+	series := []TstSeriesDef{{ReqSigs: 2, PubKeys: TstPubKeys[1:4], SeriesID: 1}}
+	TstCreateSeries(t, pool, series)
+	if err := pool.SetCharterOutpoint(1, wire.OutPoint{Hash: wire.ShaHash{1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var err error
+	TstRunWithManagerUnlocked(t, pool.Manager(), func() {
+		_, err = pool.charterCredit(store, 1)
+	})
+
+	TstCheckError(t, "", err, ErrCharterOutputNotFound)
+}
+
+func TestCharterAdvancesOnceMined(t *testing.T) {
+	tearDown, pool, store := TstCreatePoolAndTxStore(t)
+	defer tearDown()
+
+	series := []TstSeriesDef{{ReqSigs: 2, PubKeys: TstPubKeys[1:4], SeriesID: 1}}
+	TstCreateSeries(t, pool, series)
+	pkScript := TstCreatePkScript(t, pool, 1, 0, 0)
+	credits := TstCreateInputs(t, store, pkScript, []int64{int64(dustThreshold)})
+	if err := pool.SetCharterOutpoint(1, *credits[0].OutPoint()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Create an unmined withdrawal transaction spending the charter and
+	// recreating it in its second output, which has the charter's amount.
+	msgtx := createMsgTx(pkScript, []int64{1e6, int64(dustThreshold)})
+	msgtx.TxIn[0].PreviousOutPoint = *credits[0].OutPoint()
+	tx := btcutil.NewTx(msgtx)
+	r, err := store.InsertTx(tx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.AddCredit(0, false); err != nil {
+		t.Fatal(err)
+	}
+	recreated, err := r.AddCredit(1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkCharter := func(want *wire.OutPoint) {
+		var charter Credit
+		var err error
+		if err := pool.AdvanceCharters(store); err != nil {
+			t.Fatal(err)
+		}
+		TstRunWithManagerUnlocked(t, pool.Manager(), func() {
+			charter, err = pool.charterCredit(store, 1)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *charter.OutPoint() != *want {
+			t.Fatalf("Wrong charter credit; got %v, want %v", charter.OutPoint(), want)
+		}
+		if got := pool.CharterOutpoint(1); *got != *want {
+			t.Fatalf("Wrong charter outpoint; got %v, want %v", got, want)
+		}
+	}
+
+	// Until the transaction is mined the old charter is kept.
+	checkCharter(credits[0].OutPoint())
+
+	tx.SetIndex(2)
+	if _, err := store.InsertTx(tx, &txstore.Block{Height: TstInputsBlock + 1}); err != nil {
+		t.Fatal(err)
+	}
+	// Input selection must not advance the charter by itself.
+	startAddr := TstNewWithdrawalAddress(t, pool, 1, 0, 0)
+	TstRunWithManagerUnlocked(t, pool.Manager(), func() {
+		_, err = pool.getEligibleInputs(store, *startAddr, 1, dustThreshold,
+			TstInputsBlock+eligibleInputMinConfirmations, 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := pool.CharterOutpoint(1); *got != *credits[0].OutPoint() {
+		t.Fatalf("Charter advanced by input selection; got %v, want %v", got,
+			credits[0].OutPoint())
+	}
+	checkCharter(recreated.OutPoint())
+	if !pool.isCharterOutput(recreated) {
+		t.Fatalf("Recreated charter output is not considered a charter output")
+	}
+
+	// The new charter must survive a reload of the pool.
+	var pool2 *Pool
+	TstRunWithManagerUnlocked(t, pool.Manager(), func() {
+		pool2, err = Load(pool.namespace, pool.Manager(), pool.ID)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := pool2.CharterOutpoint(1); got == nil || *got != *recreated.OutPoint() {
+		t.Fatalf("Wrong charter after reload; got %v, want %v", got,
+			recreated.OutPoint())
+	}
+}
+
 func TestCreditSortingByAddress(t *testing.T) {
 	teardown, _, pool := TstCreatePool(t)
 	defer teardown()
@@ -21,6 +21,7 @@ import (
 	"sort"
 
 	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
 	"github.com/btcsuite/btcutil/hdkeychain"
 	"github.com/monetas/btcwallet/internal/zero"
@@ -40,12 +41,48 @@ type Branch uint32
 // Index is the type used to represent an index number in a series.
 type Index uint32
 
+// SeriesState represents the stage of its lifecycle a series is in. A series
+// moves from SeriesCreated to SeriesActive, SeriesThawing and finally
+// SeriesRetired, and it never goes back to a previous state.
+type SeriesState byte
+
+const (
+	// SeriesCreated is the state of newly created series. Deposit addresses
+	// can be generated (e.g. so that members can check them), but the series
+	// is not used for change nor as a source of withdrawal inputs.
+	SeriesCreated SeriesState = iota
+
+	// SeriesActive is the state of series that take deposits and change and
+	// whose credits are used as withdrawal inputs.
+	SeriesActive
+
+	// SeriesThawing is the state of series that no longer take deposits nor
+	// change but are still hot, i.e. their credits are used as withdrawal
+	// inputs until they're all spent.
+	SeriesThawing
+
+	// SeriesRetired is the state of series that are no longer used at all.
+	SeriesRetired
+)
+
+func (s SeriesState) String() string {
+	strings := map[SeriesState]string{
+		SeriesCreated: "created",
+		SeriesActive:  "active",
+		SeriesThawing: "thawing",
+		SeriesRetired: "retired",
+	}
+	if str, ok := strings[s]; ok {
+		return str
+	}
+	return fmt.Sprintf("unknown (%d)", byte(s))
+}
+
 // SeriesData represents a Series for a given Pool.
 type SeriesData struct {
 	version uint32
-	// Whether or not a series is active. This is serialized/deserialized but
-	// for now there's no way to deactivate a series.
-	active bool
+	// The stage of its lifecycle this series is in.
+	state SeriesState
 	// A.k.a. "m" in "m of n signatures needed".
 	reqSigs     uint32
 	publicKeys  []*hdkeychain.ExtendedKey
@@ -61,6 +98,11 @@ type Pool struct {
 	seriesLookup map[uint32]*SeriesData
 	manager      *waddrmgr.Manager
 	namespace    walletdb.Namespace
+	// The current charter outpoint of each series, keyed by series ID.
+	charters map[uint32]*wire.OutPoint
+	// The block the address manager was synced to when the pool was
+	// created. Deposit scripts are imported with this as their birthday.
+	birthday waddrmgr.BlockStamp
 }
 
 // PoolAddress represents a voting pool P2SH address, generated by
@@ -93,23 +135,72 @@ type WithdrawalAddress struct {
 	*poolAddress
 }
 
+// Upgrade brings the data stored in the voting pool namespace up to date,
+// applying any pending migrations as specified by opts, and returns the
+// migrations applied.  With a dry run, the migrations that would be applied
+// are returned but the namespace is left untouched.  Create and Load upgrade
+// the namespace automatically, so this only needs to be called to take a
+// backup or perform a dry run first.
+//
+// A new namespace, which holds no data yet, is recorded as being at the latest
+// version instead of being migrated, so no backup is taken for it.
+func Upgrade(namespace walletdb.Namespace, opts *walletdb.MigrateOptions) ([]walletdb.Migration, error) {
+	dryRun := opts != nil && opts.DryRun
+	isNew, err := initNamespace(namespace, dryRun)
+	if err != nil {
+		str := "failed to initialize the voting pool namespace"
+		return nil, newError(ErrDatabase, str, err)
+	}
+	if isNew {
+		return nil, nil
+	}
+	applied, err := dbSchema.Migrate(namespace, opts)
+	if err != nil {
+		str := "failed to upgrade the voting pool namespace"
+		return nil, newError(ErrDatabase, str, err)
+	}
+	return applied, nil
+}
+
+// Version returns the version of the data stored in the voting pool namespace.
+// Namespaces which have never been upgraded are at version 0.
+func Version(namespace walletdb.Namespace) (uint32, error) {
+	version, err := dbSchema.Version(namespace)
+	if err != nil {
+		str := "failed to fetch the voting pool namespace version"
+		return 0, newError(ErrDatabase, str, err)
+	}
+	return version, nil
+}
+
 // Create creates a new entry in the database with the given ID
-// and returns the Pool representing it.
+// and returns the Pool representing it. The block the address manager is
+// synced to is recorded as the pool's birthday, as no deposits can be made
+// to the pool before it exists.
 func Create(namespace walletdb.Namespace, m *waddrmgr.Manager, poolID []byte) (*Pool, error) {
+	if _, err := Upgrade(namespace, nil); err != nil {
+		return nil, err
+	}
+	birthday := m.SyncedTo()
 	err := namespace.Update(
 		func(tx walletdb.Tx) error {
-			return putPool(tx, poolID)
+			return putPool(tx, poolID, &birthday)
 		})
 	if err != nil {
 		str := fmt.Sprintf("unable to add voting pool %v to db", poolID)
 		return nil, newError(ErrPoolAlreadyExists, str, err)
 	}
-	return newPool(namespace, m, poolID), nil
+	p := newPool(namespace, m, poolID)
+	p.birthday = birthday
+	return p, nil
 }
 
 // Load fetches the entry in the database with the given ID and returns the Pool
 // representing it.
 func Load(namespace walletdb.Namespace, m *waddrmgr.Manager, poolID []byte) (*Pool, error) {
+	if _, err := Upgrade(namespace, nil); err != nil {
+		return nil, err
+	}
 	err := namespace.View(
 		func(tx walletdb.Tx) error {
 			if exists := existsPool(tx, poolID); !exists {
@@ -125,6 +216,23 @@ func Load(namespace walletdb.Namespace, m *waddrmgr.Manager, poolID []byte) (*Po
 	if err = p.LoadAllSeries(); err != nil {
 		return nil, err
 	}
+	err = namespace.View(
+		func(tx walletdb.Tx) error {
+			var err error
+			p.charters, err = loadCharterOutpoints(tx, poolID)
+			if err != nil {
+				return err
+			}
+			birthday, err := getPoolBirthday(tx, poolID)
+			if err != nil {
+				return err
+			}
+			p.birthday = *birthday
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
 	return p, nil
 }
 
@@ -135,6 +243,7 @@ func newPool(namespace walletdb.Namespace, m *waddrmgr.Manager, poolID []byte) *
 		seriesLookup: make(map[uint32]*SeriesData),
 		manager:      m,
 		namespace:    namespace,
+		charters:     make(map[uint32]*wire.OutPoint),
 	}
 }
 
@@ -243,7 +352,7 @@ func (p *Pool) saveSeriesToDisk(seriesID uint32, data *SeriesData) error {
 	}
 
 	err = p.namespace.Update(func(tx walletdb.Tx) error {
-		return putSeries(tx, p.ID, data.version, seriesID, data.active,
+		return putSeries(tx, p.ID, data.version, seriesID, data.state,
 			data.reqSigs, encryptedPubKeys, encryptedPrivKeys)
 	})
 	if err != nil {
@@ -319,7 +428,7 @@ func (p *Pool) putSeries(version, seriesID, reqSigs uint32, inRawPubKeys []strin
 
 	data := &SeriesData{
 		version:     version,
-		active:      false,
+		state:       SeriesCreated,
 		reqSigs:     reqSigs,
 		publicKeys:  keys,
 		privateKeys: make([]*hdkeychain.ExtendedKey, len(keys)),
@@ -359,22 +468,79 @@ func (p *Pool) CreateSeries(version, seriesID, reqSigs uint32, rawPubKeys []stri
 	return p.putSeries(version, seriesID, reqSigs, rawPubKeys)
 }
 
-// ActivateSeries marks the series with the given ID as active.
+// ActivateSeries marks the series with the given ID as active. Only newly
+// created (or already active) series can be activated.
 func (p *Pool) ActivateSeries(seriesID uint32) error {
+	return p.setSeriesState(seriesID, SeriesCreated, SeriesActive)
+}
+
+// ThawSeries moves the active series with the given ID to the thawing state,
+// so that it no longer takes deposits or change but its credits are still
+// used as withdrawal inputs.
+func (p *Pool) ThawSeries(seriesID uint32) error {
+	return p.setSeriesState(seriesID, SeriesActive, SeriesThawing)
+}
+
+// RetireSeries moves the thawing series with the given ID to the retired
+// state, after which it is not used at all.
+func (p *Pool) RetireSeries(seriesID uint32) error {
+	return p.setSeriesState(seriesID, SeriesThawing, SeriesRetired)
+}
+
+// setSeriesState moves the series with the given ID from the from state to
+// the to state and saves it to disk. It's a no-op if the series is already
+// in the to state.
+func (p *Pool) setSeriesState(seriesID uint32, from, to SeriesState) error {
 	series := p.Series(seriesID)
 	if series == nil {
-		str := fmt.Sprintf("series #%d does not exist, cannot activate it", seriesID)
+		str := fmt.Sprintf("series #%d does not exist, cannot make it %v", seriesID, to)
+		return newError(ErrSeriesNotExists, str, nil)
+	}
+	if series.state == to {
+		return nil
+	}
+	if series.state != from {
+		str := fmt.Sprintf("series #%d is %v; only %v series can be made %v", seriesID,
+			series.state, from, to)
+		return newError(ErrInvalidSeriesState, str, nil)
+	}
+	series.state = to
+	if err := p.saveSeriesToDisk(seriesID, series); err != nil {
+		series.state = from
+		return err
+	}
+	return nil
+}
+
+// SetCharterOutpoint registers the given outpoint as the charter output of the
+// series with the given ID, replacing the previous one, if any. Charter
+// outputs are never used as withdrawal inputs; instead, the first transaction
+// of every withdrawal spends the charter output of the withdrawal's last
+// series and creates a new one with the same amount and pkScript, which
+// becomes the series' charter once that transaction is mined; see
+// advanceCharter.
+func (p *Pool) SetCharterOutpoint(seriesID uint32, outpoint wire.OutPoint) error {
+	if p.Series(seriesID) == nil {
+		str := fmt.Sprintf("series #%d does not exist, cannot set its charter", seriesID)
 		return newError(ErrSeriesNotExists, str, nil)
 	}
-	series.active = true
-	err := p.saveSeriesToDisk(seriesID, series)
+	err := p.namespace.Update(
+		func(tx walletdb.Tx) error {
+			return putCharterOutpoint(tx, p.ID, seriesID, &outpoint)
+		})
 	if err != nil {
 		return err
 	}
-	p.seriesLookup[seriesID] = series
+	p.charters[seriesID] = &outpoint
 	return nil
 }
 
+// CharterOutpoint returns the charter outpoint of the series with the given
+// ID, or nil if none has been registered.
+func (p *Pool) CharterOutpoint(seriesID uint32) *wire.OutPoint {
+	return p.charters[seriesID]
+}
+
 // ReplaceSeries will replace an already existing series.
 //
 // - rawPubKeys has to contain three or more public keys
@@ -391,6 +557,11 @@ func (p *Pool) ReplaceSeries(version, seriesID, reqSigs uint32, rawPubKeys []str
 		return newError(ErrSeriesAlreadyEmpowered, str, nil)
 	}
 
+	if series.state != SeriesCreated {
+		str := fmt.Sprintf("series #%d is %v and cannot be replaced", seriesID, series.state)
+		return newError(ErrInvalidSeriesState, str, nil)
+	}
+
 	return p.putSeries(version, seriesID, reqSigs, rawPubKeys)
 }
 
@@ -488,6 +659,8 @@ func (p *Pool) LoadAllSeries() error {
 			return err
 		}
 		p.seriesLookup[id] = &SeriesData{
+			version:     series.version,
+			state:       series.state,
 			publicKeys:  pubKeys,
 			privateKeys: privKeys,
 			reqSigs:     series.reqSigs,
@@ -552,15 +725,61 @@ func (p *Pool) addressFor(script []byte) (btcutil.Address, error) {
 	return btcutil.NewAddressScriptHashFromHash(scriptHash, p.manager.ChainParams())
 }
 
+// deriveChild returns the child of the given extended key with the given index.
+// It is a variable so that tests can simulate invalid children.
+var deriveChild = func(key *hdkeychain.ExtendedKey, index uint32) (*hdkeychain.ExtendedKey, error) {
+	return key.Child(index)
+}
+
+// isValidIndex returns whether every public key of the series with the given
+// ID has a valid child at the given index. When one of them doesn't (i.e.
+// hdkeychain.ErrInvalidChild, which happens with a probability lower than 1 in
+// 2^127), the index is invalid for all branches of the series and is skipped
+// by all pool members, so the index of an address always matches the index of
+// the children its deposit script is derived from.
+func (p *Pool) isValidIndex(seriesID uint32, index Index) (bool, error) {
+	series := p.Series(seriesID)
+	if series == nil {
+		str := fmt.Sprintf("series #%d does not exist", seriesID)
+		return false, newError(ErrSeriesNotExists, str, nil)
+	}
+	for i, key := range series.publicKeys {
+		_, err := deriveChild(key, uint32(index))
+		if err == hdkeychain.ErrInvalidChild {
+			return false, nil
+		}
+		if err != nil {
+			str := fmt.Sprintf("child #%d for this pubkey %d does not exist", index, i)
+			return false, newError(ErrKeyChain, str, err)
+		}
+	}
+	return true, nil
+}
+
 // DepositScript constructs and returns a multi-signature redemption script where
 // a certain number (Series.reqSigs) of the public keys belonging to the series
 // with the given ID are required to sign the transaction for it to be successful.
+// An error with code ErrInvalidIndex is returned if one of the series' public
+// keys has no valid child at the given index, in which case the index must be
+// skipped (see isValidIndex).
 func (p *Pool) DepositScript(seriesID uint32, branch Branch, index Index) ([]byte, error) {
 	series := p.Series(seriesID)
 	if series == nil {
 		str := fmt.Sprintf("series #%d does not exist", seriesID)
 		return nil, newError(ErrSeriesNotExists, str, nil)
 	}
+	if series.state != SeriesCreated && series.state != SeriesActive {
+		str := fmt.Sprintf("series #%d is %v and takes no deposits", seriesID, series.state)
+		return nil, newError(ErrInvalidSeriesState, str, nil)
+	}
+	return p.redeemScript(seriesID, series, branch, index)
+}
+
+// redeemScript constructs the multi-signature redemption script of the given
+// series, branch and index like DepositScript, whatever the state of the
+// series.
+func (p *Pool) redeemScript(seriesID uint32, series *SeriesData, branch Branch,
+	index Index) ([]byte, error) {
 
 	pubKeys, err := branchOrder(series.publicKeys, branch)
 	if err != nil {
@@ -569,9 +788,12 @@ func (p *Pool) DepositScript(seriesID uint32, branch Branch, index Index) ([]byt
 
 	pks := make([]*btcutil.AddressPubKey, len(pubKeys))
 	for i, key := range pubKeys {
-		child, err := key.Child(uint32(index))
-		// TODO: implement getting the next index until we find a valid one,
-		// in case there is a hdkeychain.ErrInvalidChild.
+		child, err := deriveChild(key, uint32(index))
+		if err == hdkeychain.ErrInvalidChild {
+			str := fmt.Sprintf("index %d is invalid for series #%d as pubkey %d has no "+
+				"child with it", index, seriesID, i)
+			return nil, newError(ErrInvalidIndex, str, err)
+		}
 		if err != nil {
 			str := fmt.Sprintf("child #%d for this pubkey %d does not exist", index, i)
 			return nil, newError(ErrKeyChain, str, err)
@@ -609,12 +831,20 @@ func (p *Pool) ChangeAddress(seriesID uint32, index Index) (*ChangeAddress, erro
 		return nil, newError(ErrSeriesNotExists,
 			fmt.Sprintf("series %d does not exist", seriesID), nil)
 	}
-	if !series.active {
+	if series.state != SeriesActive {
 		str := fmt.Sprintf("ChangeAddress must be on active series; series #%d is not", seriesID)
 		return nil, newError(ErrSeriesNotActive, str, nil)
 	}
+	return p.changeAddress(seriesID, series, index)
+}
+
+// changeAddress returns the change address for the given series and index
+// like ChangeAddress, whatever the state of the series.  It is used to rebuild
+// stored addresses of series which may have been thawed or retired since.
+func (p *Pool) changeAddress(seriesID uint32, series *SeriesData, index Index) (
+	*ChangeAddress, error) {
 
-	script, err := p.DepositScript(seriesID, Branch(0), index)
+	script, err := p.redeemScript(seriesID, series, Branch(0), index)
 	if err != nil {
 		return nil, err
 	}
@@ -631,15 +861,34 @@ func (p *Pool) ChangeAddress(seriesID uint32, index Index) (*ChangeAddress, erro
 // should only withdraw from previously used addresses but also because when
 // processing withdrawals we may iterate over a huge number of addresses and
 // it'd be too expensive to re-generate the redeem script for all of them.
+// The series with the given ID must be hot.
 // This method must be called with the manager unlocked.
 func (p *Pool) WithdrawalAddress(seriesID uint32, branch Branch, index Index) (
 	*WithdrawalAddress, error) {
-	// TODO: Ensure the given series is hot.
+	series := p.Series(seriesID)
+	if series == nil {
+		str := fmt.Sprintf("series #%d does not exist", seriesID)
+		return nil, newError(ErrSeriesNotExists, str, nil)
+	}
+	if !series.IsHot() {
+		str := fmt.Sprintf("cannot withdraw from series #%d as it is %v", seriesID, series.state)
+		return nil, newError(ErrSeriesNotHot, str, nil)
+	}
+	return p.withdrawalAddress(seriesID, branch, index)
+}
+
+// withdrawalAddress returns the used address for the given series, branch and
+// index like WithdrawalAddress, whatever the state of the series.  It is used
+// to rebuild stored addresses of series which may have been retired since.
+func (p *Pool) withdrawalAddress(seriesID uint32, branch Branch, index Index) (
+	*WithdrawalAddress, error) {
+
 	addr, err := p.getUsedAddr(seriesID, branch, index)
 	if err != nil {
 		return nil, err
 	}
 	if addr == nil {
+		// Invalid indices are never marked as used, so they end up here too.
 		str := fmt.Sprintf("cannot withdraw from unused addr (series: %d, branch: %d, index: %d)",
 			seriesID, branch, index)
 		return nil, newError(ErrWithdrawFromUnusedAddr, str, nil)
@@ -728,8 +977,8 @@ func (p *Pool) EmpowerSeries(seriesID uint32, rawPrivKey string) error {
 }
 
 // EnsureUsedAddr ensures we have entries in our used addresses DB for the given
-// seriesID, branch and all indices up to the given one. It must be called with
-// the manager unlocked.
+// seriesID, branch and all valid indices up to the given one. Invalid indices
+// (see isValidIndex) are skipped. It must be called with the manager unlocked.
 func (p *Pool) EnsureUsedAddr(seriesID uint32, branch Branch, index Index) error {
 	lastIdx, err := p.highestUsedIndexFor(seriesID, branch)
 	if err != nil {
@@ -739,13 +988,13 @@ func (p *Pool) EnsureUsedAddr(seriesID uint32, branch Branch, index Index) error
 		// highestUsedIndexFor() returns 0 when there are no used addresses for a
 		// given seriesID/branch, so we do this to ensure there is an entry with
 		// index==0.
-		if err := p.addUsedAddr(seriesID, branch, lastIdx); err != nil {
+		if err := p.addUsedAddrIfValid(seriesID, branch, lastIdx); err != nil {
 			return err
 		}
 	}
 	lastIdx++
 	for lastIdx <= index {
-		if err := p.addUsedAddr(seriesID, branch, lastIdx); err != nil {
+		if err := p.addUsedAddrIfValid(seriesID, branch, lastIdx); err != nil {
 			return err
 		}
 		lastIdx++
@@ -753,6 +1002,20 @@ func (p *Pool) EnsureUsedAddr(seriesID uint32, branch Branch, index Index) error
 	return nil
 }
 
+// addUsedAddrIfValid calls addUsedAddr unless the given index is invalid for
+// the given series. It must be called with the manager unlocked.
+func (p *Pool) addUsedAddrIfValid(seriesID uint32, branch Branch, index Index) error {
+	valid, err := p.isValidIndex(seriesID, index)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		log.Infof("Skipping invalid index %d of series #%d", index, seriesID)
+		return nil
+	}
+	return p.addUsedAddr(seriesID, branch, index)
+}
+
 // addUsedAddr creates a deposit script for the given seriesID/branch/index,
 // ensures it is imported into the address manager and finaly adds the script
 // hash to our used addresses DB. It must be called with the manager unlocked.
@@ -764,10 +1027,7 @@ func (p *Pool) addUsedAddr(seriesID uint32, branch Branch, index Index) error {
 
 	// First ensure the address manager has our script. That way there's no way
 	// to have it in the used addresses DB but not in the address manager.
-	// TODO: Decide how far back we want the addr manager to rescan and set the
-	// BlockStamp height according to that.
-	_, err = p.manager.ImportScript(script, &waddrmgr.BlockStamp{})
-	if err != nil && err.(waddrmgr.ManagerError).ErrorCode != waddrmgr.ErrDuplicateAddress {
+	if err := p.importScript(script); err != nil {
 		return err
 	}
 
@@ -786,6 +1046,26 @@ func (p *Pool) addUsedAddr(seriesID uint32, branch Branch, index Index) error {
 	return nil
 }
 
+// importScript imports the given deposit script into the address manager,
+// using the pool's birthday as the script's birthday so that rescans for it
+// start no earlier than the block the pool was created at. Scripts that have
+// already been imported are ignored. It must be called with the manager
+// unlocked.
+func (p *Pool) importScript(script []byte) error {
+	birthday := p.birthday
+	_, err := p.manager.ImportScript(script, &birthday)
+	if err != nil && err.(waddrmgr.ManagerError).ErrorCode != waddrmgr.ErrDuplicateAddress {
+		return err
+	}
+	return nil
+}
+
+// Birthday returns the block the address manager was synced to when the pool
+// was created.
+func (p *Pool) Birthday() waddrmgr.BlockStamp {
+	return p.birthday
+}
+
 // getUsedAddr gets the script hash for the given series, branch and index from
 // the used addresses DB and uses that to look up the ManagedScriptAddress
 // from the address manager. It must be called with the manager unlocked.
@@ -864,6 +1144,17 @@ func (a *poolAddress) Index() Index {
 	return a.index
 }
 
+// State returns the stage of its lifecycle this series is in.
+func (s *SeriesData) State() SeriesState {
+	return s.state
+}
+
+// IsHot returns true if this series' credits can be used as withdrawal
+// inputs, which is the case for active and thawing series.
+func (s *SeriesData) IsHot() bool {
+	return s.state == SeriesActive || s.state == SeriesThawing
+}
+
 // IsEmpowered returns true if this series is empowered (i.e. if it has
 // at least one private key loaded).
 func (s *SeriesData) IsEmpowered() bool {
@@ -23,6 +23,7 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil/hdkeychain"
 	vp "github.com/monetas/btcwallet/votingpool"
 	"github.com/monetas/btcwallet/waddrmgr"
@@ -196,6 +197,10 @@ func TestLoadPool(t *testing.T) {
 	if !bytes.Equal(pool2.ID, pool.ID) {
 		t.Errorf("Voting pool obtained from DB does not match the created one")
 	}
+	if pool2.Birthday() != mgr.SyncedTo() {
+		t.Errorf("Wrong birthday for voting pool obtained from DB; got %v, want %v",
+			pool2.Birthday(), mgr.SyncedTo())
+	}
 }
 
 func TestCreatePool(t *testing.T) {
@@ -929,6 +934,156 @@ func TestPoolWithdrawalAddress(t *testing.T) {
 	vp.TstCheckError(t, "", err, vp.ErrWithdrawFromUnusedAddr)
 }
 
+func TestSetCharterOutpoint(t *testing.T) {
+	tearDown, mgr, pool := vp.TstCreatePool(t)
+	defer tearDown()
+
+	vp.TstCreateSeries(t, pool, []vp.TstSeriesDef{
+		{ReqSigs: 2, PubKeys: vp.TstPubKeys[1:4], SeriesID: 1},
+		{ReqSigs: 2, PubKeys: vp.TstPubKeys[3:6], SeriesID: 2},
+	})
+	if charter := pool.CharterOutpoint(1); charter != nil {
+		t.Fatalf("Unexpected charter outpoint for series 1: %v", charter)
+	}
+
+	charter1 := wire.OutPoint{Hash: wire.ShaHash{1}, Index: 2}
+	charter2 := wire.OutPoint{Hash: wire.ShaHash{3}, Index: 4}
+	if err := pool.SetCharterOutpoint(1, wire.OutPoint{Hash: wire.ShaHash{9}}); err != nil {
+		t.Fatal(err)
+	}
+	// Setting a new charter outpoint replaces the previous one.
+	if err := pool.SetCharterOutpoint(1, charter1); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.SetCharterOutpoint(2, charter2); err != nil {
+		t.Fatal(err)
+	}
+
+	// Charter outpoints must be persisted, so check them on a freshly loaded
+	// pool.
+	var pool2 *vp.Pool
+	var err error
+	vp.TstRunWithManagerUnlocked(t, mgr, func() {
+		pool2, err = vp.Load(pool.TstNamespace(), mgr, pool.ID)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for seriesID, want := range map[uint32]wire.OutPoint{1: charter1, 2: charter2} {
+		got := pool2.CharterOutpoint(seriesID)
+		if got == nil || *got != want {
+			t.Fatalf("Wrong charter outpoint for series %d; got %v, want %v", seriesID,
+				got, want)
+		}
+	}
+}
+
+func TestSetCharterOutpointNonExistentSeries(t *testing.T) {
+	tearDown, _, pool := vp.TstCreatePool(t)
+	defer tearDown()
+
+	err := pool.SetCharterOutpoint(1, wire.OutPoint{})
+
+	vp.TstCheckError(t, "", err, vp.ErrSeriesNotExists)
+}
+
+func TestSeriesLifecycle(t *testing.T) {
+	tearDown, mgr, pool := vp.TstCreatePool(t)
+	defer tearDown()
+
+	if err := pool.CreateSeries(vp.CurrentVersion, 1, 2, vp.TstPubKeys[1:4]); err != nil {
+		t.Fatal(err)
+	}
+	checkSeriesState(t, pool, 1, vp.SeriesCreated)
+
+	// Series can't skip states nor go back to a previous one.
+	vp.TstCheckError(t, "thaw created", pool.ThawSeries(1), vp.ErrInvalidSeriesState)
+	vp.TstCheckError(t, "retire created", pool.RetireSeries(1), vp.ErrInvalidSeriesState)
+
+	if err := pool.ActivateSeries(1); err != nil {
+		t.Fatal(err)
+	}
+	checkSeriesState(t, pool, 1, vp.SeriesActive)
+	// Activating an active series is a no-op.
+	if err := pool.ActivateSeries(1); err != nil {
+		t.Fatal(err)
+	}
+	err := pool.ReplaceSeries(vp.CurrentVersion, 1, 2, vp.TstPubKeys[3:6])
+	vp.TstCheckError(t, "replace active", err, vp.ErrInvalidSeriesState)
+
+	if err := pool.ThawSeries(1); err != nil {
+		t.Fatal(err)
+	}
+	checkSeriesState(t, pool, 1, vp.SeriesThawing)
+	vp.TstCheckError(t, "activate thawing", pool.ActivateSeries(1), vp.ErrInvalidSeriesState)
+
+	// The state must be persisted, so check it on a freshly loaded pool.
+	var pool2 *vp.Pool
+	vp.TstRunWithManagerUnlocked(t, mgr, func() {
+		pool2, err = vp.Load(pool.TstNamespace(), mgr, pool.ID)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSeriesState(t, pool2, 1, vp.SeriesThawing)
+
+	if err := pool.RetireSeries(1); err != nil {
+		t.Fatal(err)
+	}
+	checkSeriesState(t, pool, 1, vp.SeriesRetired)
+
+	vp.TstCheckError(t, "activate unknown", pool.ActivateSeries(2), vp.ErrSeriesNotExists)
+}
+
+func TestSeriesStateRestrictions(t *testing.T) {
+	tearDown, _, pool := vp.TstCreatePool(t)
+	defer tearDown()
+
+	vp.TstCreateSeries(t, pool, []vp.TstSeriesDef{
+		{ReqSigs: 2, PubKeys: vp.TstPubKeys[1:4], SeriesID: 1},
+		{ReqSigs: 2, PubKeys: vp.TstPubKeys[3:6], SeriesID: 2, Inactive: true},
+	})
+	vp.TstEnsureUsedAddr(t, pool, 1, 0, 0)
+	vp.TstEnsureUsedAddr(t, pool, 2, 0, 0)
+
+	// Created series take deposits but are not hot.
+	if _, err := pool.DepositScriptAddress(2, 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	_, err := pool.WithdrawalAddress(2, 0, 0)
+	vp.TstCheckError(t, "withdraw from created", err, vp.ErrSeriesNotHot)
+
+	// Thawing series are hot but take no deposits or change.
+	if err := pool.ThawSeries(1); err != nil {
+		t.Fatal(err)
+	}
+	_, err = pool.DepositScriptAddress(1, 0, 1)
+	vp.TstCheckError(t, "deposit to thawing", err, vp.ErrInvalidSeriesState)
+	_, err = pool.ChangeAddress(1, 0)
+	vp.TstCheckError(t, "change to thawing", err, vp.ErrSeriesNotActive)
+	vp.TstRunWithManagerUnlocked(t, pool.Manager(), func() {
+		_, err = pool.WithdrawalAddress(1, 0, 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Retired series are not used at all.
+	if err := pool.RetireSeries(1); err != nil {
+		t.Fatal(err)
+	}
+	_, err = pool.WithdrawalAddress(1, 0, 0)
+	vp.TstCheckError(t, "withdraw from retired", err, vp.ErrSeriesNotHot)
+	_, err = pool.DepositScriptAddress(1, 0, 1)
+	vp.TstCheckError(t, "deposit to retired", err, vp.ErrInvalidSeriesState)
+}
+
+func checkSeriesState(t *testing.T, pool *vp.Pool, seriesID uint32, want vp.SeriesState) {
+	if got := pool.Series(seriesID).State(); got != want {
+		t.Fatalf("Wrong state for series %d; got %v, want %v", seriesID, got, want)
+	}
+}
+
 func checkPoolAddress(t *testing.T, addr vp.PoolAddress, seriesID uint32, branch vp.Branch,
 	index vp.Index) {
 
@@ -122,6 +122,57 @@ func TestPoolGetUsedAddr(t *testing.T) {
 	}
 }
 
+func TestDepositScriptInvalidIndex(t *testing.T) {
+	tearDown, _, pool := TstCreatePool(t)
+	defer tearDown()
+
+	seriesID := tstCreateSeriesWithInvalidIndex(t, pool, 1)
+
+	// The index is invalid for every branch, as they all use the same keys.
+	for _, branch := range []Branch{0, 1, 2, 3} {
+		_, err := pool.DepositScript(seriesID, branch, 1)
+		TstCheckError(t, fmt.Sprintf("branch %d", branch), err, ErrInvalidIndex)
+	}
+	if _, err := pool.DepositScript(seriesID, 0, 2); err != nil {
+		t.Fatalf("Unexpected error for index following the invalid one: %v", err)
+	}
+	if _, err := pool.ChangeAddress(seriesID, 1); err == nil {
+		t.Fatal("Expected an error getting a change address with an invalid index")
+	}
+}
+
+func TestPoolEnsureUsedAddrSkipsInvalidIndex(t *testing.T) {
+	tearDown, mgr, pool := TstCreatePool(t)
+	defer tearDown()
+
+	seriesID := tstCreateSeriesWithInvalidIndex(t, pool, 1)
+
+	var err error
+	TstRunWithManagerUnlocked(t, mgr, func() {
+		err = pool.EnsureUsedAddr(seriesID, 0, 3)
+	})
+	if err != nil {
+		t.Fatalf("Failed to ensure used addresses: %v", err)
+	}
+	for _, i := range []Index{0, 1, 2, 3} {
+		addr, err := pool.getUsedAddr(seriesID, 0, i)
+		if err != nil {
+			t.Fatalf("Failed to get addr from used addresses set: %v", err)
+		}
+		if i == 1 && addr != nil {
+			t.Fatalf("Invalid index %d found in used addresses DB", i)
+		}
+		if i != 1 && addr == nil {
+			t.Fatalf("Index %d not found in used addresses DB", i)
+		}
+	}
+
+	TstRunWithManagerUnlocked(t, mgr, func() {
+		_, err = pool.WithdrawalAddress(seriesID, 0, 1)
+	})
+	TstCheckError(t, "", err, ErrWithdrawFromUnusedAddr)
+}
+
 func TestSerializationErrors(t *testing.T) {
 	tearDown, mgr, _ := TstCreatePool(t)
 	defer tearDown()
@@ -157,7 +208,6 @@ func TestSerializationErrors(t *testing.T) {
 		},
 	}
 
-	active := true
 	for testNum, test := range tests {
 		encryptedPubs, err := encryptKeys(test.pubKeys, mgr, waddrmgr.CKTPublic)
 		if err != nil {
@@ -173,7 +223,7 @@ func TestSerializationErrors(t *testing.T) {
 
 		row := &dbSeriesRow{
 			version:           test.version,
-			active:            active,
+			state:             SeriesActive,
 			reqSigs:           test.reqSigs,
 			pubKeysEncrypted:  encryptedPubs,
 			privKeysEncrypted: encryptedPrivs}
@@ -189,30 +239,32 @@ func TestSerialization(t *testing.T) {
 
 	tests := []struct {
 		version  uint32
-		active   bool
+		state    SeriesState
 		pubKeys  []string
 		privKeys []string
 		reqSigs  uint32
 	}{
 		{
 			version: 1,
-			active:  true,
+			state:   SeriesActive,
 			pubKeys: TstPubKeys[0:1],
 			reqSigs: 1,
 		},
 		{
 			version:  0,
-			active:   false,
+			state:    SeriesCreated,
 			pubKeys:  TstPubKeys[0:1],
 			privKeys: TstPrivKeys[0:1],
 			reqSigs:  1,
 		},
 		{
+			state:    SeriesThawing,
 			pubKeys:  TstPubKeys[0:3],
 			privKeys: []string{TstPrivKeys[0], "", ""},
 			reqSigs:  2,
 		},
 		{
+			state:   SeriesRetired,
 			pubKeys: TstPubKeys[0:5],
 			reqSigs: 3,
 		},
@@ -238,7 +290,7 @@ func TestSerialization(t *testing.T) {
 
 		row := &dbSeriesRow{
 			version:           test.version,
-			active:            test.active,
+			state:             test.state,
 			reqSigs:           test.reqSigs,
 			pubKeysEncrypted:  encryptedPubs,
 			privKeysEncrypted: encryptedPrivs,
@@ -258,9 +310,9 @@ func TestSerialization(t *testing.T) {
 				testNum, row.version, test.version)
 		}
 
-		if row.active != test.active {
-			t.Errorf("Serialization #%d - active mismatch: got %v want %v",
-				testNum, row.active, test.active)
+		if row.state != test.state {
+			t.Errorf("Serialization #%d - state mismatch: got %v want %v",
+				testNum, row.state, test.state)
 		}
 
 		if row.reqSigs != test.reqSigs {
@@ -319,7 +371,7 @@ func TestDeserializationErrors(t *testing.T) {
 		{
 			serialized: []byte{
 				1, 0, 0, 0, // 4 bytes (version)
-				0,          // 1 byte (active)
+				0,          // 1 byte (state)
 				2, 0, 0, 0, // 4 bytes (reqSigs)
 				3, 0, 0, 0, // 4 bytes (nKeys)
 			},
@@ -331,6 +383,11 @@ func TestDeserializationErrors(t *testing.T) {
 			// Unsupported version.
 			err: ErrSeriesVersion,
 		},
+		{
+			serialized: []byte{1, 0, 0, 0, 4, 0, 0, 0, 0, 0, 0, 0, 0},
+			// Unknown state.
+			err: ErrSeriesSerialization,
+		},
 	}
 
 	for testNum, test := range tests {
@@ -0,0 +1,215 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package votingpool
+
+import (
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/monetas/btcwallet/txstore"
+	"github.com/monetas/btcwallet/walletdb"
+)
+
+// TxSigsStatus describes the raw signatures collected so far for one of the
+// transactions of a withdrawal.
+type TxSigsStatus struct {
+	// Sigs contains, for every input, one raw signature for every pubkey
+	// in the input's redeem script. An empty RawSig is used for pubkeys
+	// whose signature is still missing.
+	Sigs TxSigs
+
+	// InputsSigned reports, for every input, whether at least the number
+	// of signatures required by its series has been collected.
+	InputsSigned []bool
+
+	// SignedTx is the fully signed transaction. It is nil unless every
+	// input has enough signatures.
+	SignedTx *wire.MsgTx
+}
+
+// MergeTxSigs merges the raw signatures of several voting pool members for
+// the transaction with the given ntxid, generated as part of the withdrawal
+// with the given roundID. Every non-empty RawSig is checked against the
+// pubkey it is supposed to belong to; pubkeys are taken from the input's
+// redeem script, which lists the series pubkeys in branch order. The merged
+// signatures replace the ones stored with the withdrawal, so signatures can be
+// added as they arrive from each member.
+//
+// Once every input has the number of signatures required by its series, the
+// returned status includes the fully signed transaction.
+//
+// This method must be called with the manager unlocked.
+func (p *Pool) MergeTxSigs(roundID uint32, ntxid Ntxid, memberSigs []TxSigs,
+	store *txstore.Store) (*TxSigsStatus, error) {
+
+	status, err := p.Withdrawal(roundID)
+	if err != nil {
+		return nil, err
+	}
+	tx, ok := status.transactions[ntxid]
+	if !ok {
+		str := fmt.Sprintf("withdrawal %d has no transaction with ntxid %s", roundID, ntxid)
+		return nil, newError(ErrInvalidValue, str, nil)
+	}
+	msgtx := tx.MsgTx
+
+	credits, err := store.FindPreviousCredits(btcutil.NewTx(msgtx))
+	if err != nil {
+		return nil, newError(ErrTxSigning, "cannot find credits spent by transaction", err)
+	}
+	if len(credits) != len(msgtx.TxIn) {
+		str := fmt.Sprintf("found %d credits spent by transaction with %d inputs",
+			len(credits), len(msgtx.TxIn))
+		return nil, newError(ErrTxSigning, str, nil)
+	}
+
+	chainParams := p.manager.ChainParams()
+	merged := make(TxSigs, len(msgtx.TxIn))
+	pkScripts := make([][]byte, len(msgtx.TxIn))
+	inputsSigned := make([]bool, len(msgtx.TxIn))
+	allSigned := true
+	for i := range msgtx.TxIn {
+		pkScripts[i] = credits[i].TxOut().PkScript
+		redeemScript, err := redeemScriptFor(p, pkScripts[i])
+		if err != nil {
+			return nil, err
+		}
+		_, pubKeyAddrs, nRequired, err := txscript.ExtractPkScriptAddrs(redeemScript,
+			chainParams)
+		if err != nil {
+			return nil, newError(ErrTxSigning, "unparseable redeem script", err)
+		}
+
+		inputSigs := make([]RawSig, len(pubKeyAddrs))
+		if stored := status.sigs[ntxid]; i < len(stored) {
+			copy(inputSigs, stored[i])
+		}
+		for member, txSigs := range memberSigs {
+			if len(txSigs) != len(msgtx.TxIn) {
+				str := fmt.Sprintf("signatures of member %d are for %d inputs; want %d",
+					member, len(txSigs), len(msgtx.TxIn))
+				return nil, newError(ErrInvalidValue, str, nil)
+			}
+			if len(txSigs[i]) != len(pubKeyAddrs) {
+				str := fmt.Sprintf("member %d has %d signatures for input %d; want %d",
+					member, len(txSigs[i]), i, len(pubKeyAddrs))
+				return nil, newError(ErrInvalidValue, str, nil)
+			}
+			for j, sig := range txSigs[i] {
+				if len(sig) == 0 {
+					continue
+				}
+				pubKey := pubKeyAddrs[j].(*btcutil.AddressPubKey).PubKey()
+				if !verifyRawSig(msgtx, i, redeemScript, pubKey, sig) {
+					str := fmt.Sprintf("invalid signature from member %d for pubkey "+
+						"%d of input %d", member, j, i)
+					return nil, newError(ErrInvalidRawSig, str, nil)
+				}
+				if len(inputSigs[j]) == 0 {
+					inputSigs[j] = sig
+				}
+			}
+		}
+		merged[i] = inputSigs
+
+		inputsSigned[i] = len(compactSigs(inputSigs)) >= nRequired
+		if !inputsSigned[i] {
+			allSigned = false
+		}
+	}
+
+	err = p.namespace.Update(
+		func(tx walletdb.Tx) error {
+			return updateWithdrawalSigs(tx, p.ID, roundID, ntxid, merged)
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	sigsStatus := &TxSigsStatus{Sigs: merged, InputsSigned: inputsSigned}
+	if !allSigned {
+		return sigsStatus, nil
+	}
+	signedTx := msgtx.Copy()
+	for i := range signedTx.TxIn {
+		err := signMultiSigUTXO(p.manager, signedTx, i, pkScripts[i], compactSigs(merged[i]))
+		if err != nil {
+			return nil, err
+		}
+	}
+	// The charter output created by the signed transaction, if any, only
+	// becomes the new charter of its series once the transaction is mined;
+	// see advanceCharter.
+	sigsStatus.SignedTx = signedTx
+	return sigsStatus, nil
+}
+
+// redeemScriptFor returns the redeem script of the P2SH pkScript, looked up
+// on the pool's address manager. It must be called with the manager
+// unlocked.
+func redeemScriptFor(p *Pool, pkScript []byte) ([]byte, error) {
+	class, addresses, _, err := txscript.ExtractPkScriptAddrs(pkScript, p.manager.ChainParams())
+	if err != nil {
+		return nil, newError(ErrTxSigning, "unparseable pkScript", err)
+	}
+	if class != txscript.ScriptHashTy {
+		return nil, newError(ErrTxSigning, fmt.Sprintf("pkScript is not P2SH: %s", class), nil)
+	}
+	redeemScript, err := getRedeemScript(p.manager, addresses[0].(*btcutil.AddressScriptHash))
+	if err != nil {
+		return nil, newError(ErrTxSigning, "unable to retrieve redeem script", err)
+	}
+	return redeemScript, nil
+}
+
+// compactSigs returns the non-empty signatures of the given list, keeping
+// their order, as expected by signMultiSigUTXO.
+func compactSigs(sigs []RawSig) []RawSig {
+	compacted := make([]RawSig, 0, len(sigs))
+	for _, sig := range sigs {
+		if len(sig) != 0 {
+			compacted = append(compacted, sig)
+		}
+	}
+	return compacted
+}
+
+// verifyRawSig returns whether the given raw signature (a DER signature
+// followed by its hash type, as generated by getRawSigs) is a valid
+// SIGHASH_ALL signature by pubKey for the input with the given index,
+// which redeems a P2SH output with the given redeem script.
+func verifyRawSig(msgtx *wire.MsgTx, idx int, redeemScript []byte, pubKey *btcec.PublicKey,
+	sig RawSig) bool {
+
+	if len(sig) < 2 || txscript.SigHashType(sig[len(sig)-1]) != txscript.SigHashAll {
+		return false
+	}
+	ecSig, err := btcec.ParseDERSignature(sig[:len(sig)-1], btcec.S256())
+	if err != nil {
+		return false
+	}
+	// The redeem scripts of voting pool addresses have no OP_CODESEPARATOR,
+	// so the whole redeem script is used as the subscript.
+	hash, err := txscript.CalcSignatureHash(redeemScript, txscript.SigHashAll, msgtx, idx)
+	if err != nil {
+		return false
+	}
+	return ecSig.Verify(hash, pubKey)
+}
@@ -28,6 +28,7 @@ import (
 	"github.com/btcsuite/btcutil"
 	"github.com/monetas/btcwallet/txstore"
 	"github.com/monetas/btcwallet/waddrmgr"
+	"github.com/monetas/btcwallet/walletdb"
 	"github.com/btcsuite/fastsha256"
 )
 
@@ -35,9 +36,51 @@ import (
 // MAX_STANDARD_TX_SIZE.
 const txMaxSize = 100000
 
-// feeIncrement is the minimum transation fee (0.00001 BTC, measured in satoshis)
-// added to transactions requiring a fee.
-const feeIncrement = 1e3
+// FeePolicy describes how the transactions of a withdrawal pay network fees.
+// It is one of the inputs of StartWithdrawal and must be agreed upon by all
+// members of the pool, as different policies produce different transactions.
+type FeePolicy struct {
+	// RatePerKB is the fee paid for every kilobyte (or fraction thereof) of a
+	// transaction's estimated size.
+	RatePerKB btcutil.Amount
+
+	// MinFee is the minimum fee paid by any transaction.
+	MinFee btcutil.Amount
+
+	// MaxFeeShare is the maximum fee a transaction may pay for each of the
+	// requested outputs it contains. Fees above that are capped, but never
+	// below MinFee. A zero value means there's no limit.
+	MaxFeeShare btcutil.Amount
+}
+
+// DefaultFeePolicy is a fee policy of 0.00001 BTC per started kilobyte, with
+// no cap.
+var DefaultFeePolicy = FeePolicy{RatePerKB: 1e3, MinFee: 1e3}
+
+// fee returns the fee a transaction of the given size (in bytes) and with the
+// given number of requested outputs must pay according to this policy.
+func (fp FeePolicy) fee(txSize, numOutputs int) btcutil.Amount {
+	fee := btcutil.Amount(1+txSize/1000) * fp.RatePerKB
+	if fp.MaxFeeShare > 0 && numOutputs > 0 {
+		if max := fp.MaxFeeShare * btcutil.Amount(numOutputs); fee > max {
+			fee = max
+		}
+	}
+	if fee < fp.MinFee {
+		fee = fp.MinFee
+	}
+	return fee
+}
+
+// validate returns an error with code ErrInvalidValue if any of the amounts
+// in this policy is negative.
+func (fp FeePolicy) validate() error {
+	if fp.RatePerKB < 0 || fp.MinFee < 0 || fp.MaxFeeShare < 0 {
+		str := fmt.Sprintf("invalid fee policy %+v: amounts cannot be negative", fp)
+		return newError(ErrInvalidValue, str, nil)
+	}
+	return nil
+}
 
 type outputStatus byte
 
@@ -47,6 +90,25 @@ const (
 	statusSplit
 )
 
+// shortfallReason describes why an output was not completely fulfilled.
+type shortfallReason byte
+
+const (
+	// shortfallNone is used for outputs that were completely fulfilled.
+	shortfallNone shortfallReason = iota
+	// shortfallInsufficientFunds is used when the confirmed credits on the
+	// series withdrawn from, charter outputs aside, don't add up to the total
+	// amount requested.
+	shortfallInsufficientFunds
+	// shortfallIneligibleCredits is used when the series withdrawn from hold
+	// enough funds but not enough of them are eligible inputs (e.g. they're
+	// below the dust threshold or precede startAddress).
+	shortfallIneligibleCredits
+	// shortfallNetworkFees is used when there were enough eligible inputs for
+	// the total amount requested but not for the network fees as well.
+	shortfallNetworkFees
+)
+
 // OutBailmentID is the unique ID of a user's outbailment, comprising the
 // name of the server the user connected to, and the transaction number,
 // internal to that server.
@@ -76,8 +138,9 @@ type OutputRequest struct {
 
 // WithdrawalOutput represents a possibly fulfilled OutputRequest.
 type WithdrawalOutput struct {
-	request OutputRequest
-	status  outputStatus
+	request   OutputRequest
+	status    outputStatus
+	shortfall shortfallReason
 	// The outpoints that fulfill the OutputRequest. There will be more than one in case we
 	// need to split the request across multiple transactions.
 	outpoints []OutBailmentOutpoint
@@ -91,10 +154,11 @@ type OutBailmentOutpoint struct {
 }
 
 // changeAwareTx is just a wrapper around wire.MsgTx that knows about its change
-// output, if any.
+// and charter outputs, if any.
 type changeAwareTx struct {
 	*wire.MsgTx
-	changeIdx int32 // -1 if there's no change output.
+	changeIdx  int32 // -1 if there's no change output.
+	charterIdx int32 // -1 if there's no charter output.
 }
 
 // WithdrawalStatus contains the details of a processed withdrawal, including
@@ -104,6 +168,7 @@ type WithdrawalStatus struct {
 	nextInputAddr  WithdrawalAddress
 	nextChangeAddr ChangeAddress
 	fees           btcutil.Amount
+	feePolicy      FeePolicy
 	outputs        map[OutBailmentID]*WithdrawalOutput
 	sigs           map[Ntxid]TxSigs
 	transactions   map[Ntxid]changeAwareTx
@@ -146,6 +211,16 @@ func (s outputStatus) String() string {
 	return strings[s]
 }
 
+func (r shortfallReason) String() string {
+	strings := map[shortfallReason]string{
+		shortfallNone:              "",
+		shortfallInsufficientFunds: "insufficient-funds",
+		shortfallIneligibleCredits: "ineligible-credits",
+		shortfallNetworkFees:       "network-fees",
+	}
+	return strings[r]
+}
+
 // Outputs returns a map of outbailment IDs to WithdrawalOutputs for all outputs
 // requested in this withdrawal.
 func (s *WithdrawalStatus) Outputs() map[OutBailmentID]*WithdrawalOutput {
@@ -164,6 +239,12 @@ func (s *WithdrawalStatus) Fees() btcutil.Amount {
 	return s.fees
 }
 
+// FeePolicy returns the fee policy used to construct the transactions of a
+// withdrawal.
+func (s *WithdrawalStatus) FeePolicy() FeePolicy {
+	return s.feePolicy
+}
+
 // NextInputAddr returns the votingpool address that should be used as the
 // startAddress of subsequent withdrawals.
 func (s *WithdrawalStatus) NextInputAddr() WithdrawalAddress {
@@ -213,6 +294,22 @@ func (o *WithdrawalOutput) Status() string {
 	return o.status.String()
 }
 
+// Fulfilled returns the total amount sent to this WithdrawalOutput's address
+// across all of its outpoints.
+func (o *WithdrawalOutput) Fulfilled() btcutil.Amount {
+	amount := btcutil.Amount(0)
+	for _, outpoint := range o.outpoints {
+		amount += outpoint.amount
+	}
+	return amount
+}
+
+// ShortfallReason returns why this WithdrawalOutput was not completely
+// fulfilled, or an empty string if it was.
+func (o *WithdrawalOutput) ShortfallReason() string {
+	return o.shortfall.String()
+}
+
 // Address returns the string representation of this WithdrawalOutput's address.
 func (o *WithdrawalOutput) Address() string {
 	return o.request.Address.String()
@@ -224,11 +321,28 @@ func (o *WithdrawalOutput) Outpoints() []OutBailmentOutpoint {
 	return o.outpoints
 }
 
+// Request returns the OutputRequest this WithdrawalOutput was created for.
+func (o *WithdrawalOutput) Request() OutputRequest {
+	return o.request
+}
+
 // Amount returns the amount (in satoshis) in this OutBailmentOutpoint.
 func (o OutBailmentOutpoint) Amount() btcutil.Amount {
 	return o.amount
 }
 
+// Ntxid returns the ntxid of the transaction containing this
+// OutBailmentOutpoint.
+func (o OutBailmentOutpoint) Ntxid() Ntxid {
+	return o.ntxid
+}
+
+// Index returns the index of this OutBailmentOutpoint in its transaction's
+// outputs.
+func (o OutBailmentOutpoint) Index() uint32 {
+	return o.index
+}
+
 // withdrawal holds all the state needed for Pool.Withdrawal() to do its job.
 type withdrawal struct {
 	roundID         uint32
@@ -237,6 +351,10 @@ type withdrawal struct {
 	pendingRequests []OutputRequest
 	eligibleInputs  []Credit
 	current         *withdrawalTx
+	feePolicy       FeePolicy
+	// charter is the credit of the charter output to be spent (and
+	// recreated) by the first transaction of this withdrawal, if any.
+	charter Credit
 }
 
 // withdrawalTxOut wraps an OutputRequest and provides a separate amount field.
@@ -269,10 +387,21 @@ type withdrawalTx struct {
 
 	// changeOutput holds information about the change for this transaction.
 	changeOutput *wire.TxOut
+
+	// charter, when not nil, is the pool's charter output spent by this
+	// transaction. It is always the last input, and a new charter output
+	// with the same amount and pkScript is added right before the change
+	// output. As both have the same amount, they're not included in
+	// inputTotal() and outputTotal().
+	charter Credit
+
+	// feePolicy is used to calculate the network fees paid by this
+	// transaction.
+	feePolicy FeePolicy
 }
 
-func newWithdrawalTx() *withdrawalTx {
-	return &withdrawalTx{}
+func newWithdrawalTx(feePolicy FeePolicy) *withdrawalTx {
+	return &withdrawalTx{feePolicy: feePolicy}
 }
 
 // ntxid returns the unique ID for this transaction.
@@ -302,6 +431,24 @@ func (tx *withdrawalTx) outputTotal() (total btcutil.Amount) {
 	return total
 }
 
+// txInputs returns all the credits spent by this transaction, in the same
+// order as they appear in the MsgTx generated by toMsgTx(). That is, the
+// inputs followed by the charter output, if any.
+func (tx *withdrawalTx) txInputs() []Credit {
+	if tx.charter == nil {
+		return tx.inputs
+	}
+	inputs := make([]Credit, len(tx.inputs), len(tx.inputs)+1)
+	copy(inputs, tx.inputs)
+	return append(inputs, tx.charter)
+}
+
+// hasCharter returns true if this transaction spends and recreates the
+// pool's charter output.
+func (tx *withdrawalTx) hasCharter() bool {
+	return tx.charter != nil
+}
+
 // hasChange returns true if this transaction has a change output.
 func (tx *withdrawalTx) hasChange() bool {
 	return tx.changeOutput != nil
@@ -314,11 +461,15 @@ func (tx *withdrawalTx) toMsgTx() *wire.MsgTx {
 		msgtx.AddTxOut(wire.NewTxOut(int64(o.amount), o.pkScript()))
 	}
 
+	if tx.hasCharter() {
+		msgtx.AddTxOut(wire.NewTxOut(int64(tx.charter.Amount()), tx.charter.TxOut().PkScript))
+	}
+
 	if tx.hasChange() {
 		msgtx.AddTxOut(tx.changeOutput)
 	}
 
-	for _, i := range tx.inputs {
+	for _, i := range tx.txInputs() {
 		msgtx.AddTxIn(wire.NewTxIn(i.OutPoint(), []byte{}))
 	}
 	return msgtx
@@ -400,7 +551,7 @@ func (tx *withdrawalTx) rollBackLastOutput() ([]Credit, *withdrawalTxOut, error)
 }
 
 func newWithdrawal(roundID uint32, requests []OutputRequest, inputs []Credit,
-	changeStart ChangeAddress) *withdrawal {
+	changeStart ChangeAddress, feePolicy FeePolicy) *withdrawal {
 	outputs := make(map[OutBailmentID]*WithdrawalOutput, len(requests))
 	for _, request := range requests {
 		outputs[request.outBailmentID()] = &WithdrawalOutput{request: request}
@@ -408,10 +559,12 @@ func newWithdrawal(roundID uint32, requests []OutputRequest, inputs []Credit,
 	status := &WithdrawalStatus{
 		outputs:        outputs,
 		nextChangeAddr: changeStart,
+		feePolicy:      feePolicy,
 	}
 	return &withdrawal{
 		roundID:         roundID,
-		current:         newWithdrawalTx(),
+		current:         newWithdrawalTx(feePolicy),
+		feePolicy:       feePolicy,
 		pendingRequests: requests,
 		eligibleInputs:  inputs,
 		status:          status,
@@ -425,29 +578,194 @@ func newWithdrawal(roundID uint32, requests []OutputRequest, inputs []Credit,
 // signature lists (one for every private key available to this wallet) for each
 // of those transaction's inputs. More details about the actual algorithm can be
 // found at http://opentransactions.org/wiki/index.php/Startwithdrawal
+//
+// The resulting status is stored in the database, keyed by roundID, and
+// subsequent calls with the same roundID and parameters return the stored
+// status instead of processing the withdrawal again. An error with code
+// ErrWithdrawalParamsMismatch is returned if the roundID was already used
+// with different parameters.
+//
+// The given fee policy determines the network fees paid by every transaction
+// and is stored along with the other parameters, so all members of the pool
+// must use the same one in order to construct identical transactions.
+//
+// This method must be called with the manager unlocked.
 func (p *Pool) StartWithdrawal(roundID uint32, requests []OutputRequest,
 	startAddress WithdrawalAddress, lastSeriesID uint32, changeStart ChangeAddress,
-	txStore *txstore.Store, chainHeight int32, dustThreshold btcutil.Amount) (
-	*WithdrawalStatus, error) {
+	txStore *txstore.Store, chainHeight int32, dustThreshold btcutil.Amount,
+	feePolicy FeePolicy) (*WithdrawalStatus, error) {
 
+	if err := feePolicy.validate(); err != nil {
+		return nil, err
+	}
+	status, err := p.storedWithdrawalStatus(roundID, requests, startAddress, lastSeriesID,
+		changeStart, dustThreshold, feePolicy)
+	if err != nil {
+		return nil, err
+	}
+	if status != nil {
+		return status, nil
+	}
+
+	// Recreated charter outputs which have been mined must be known as
+	// charters so they are not used as inputs.
+	if err := p.AdvanceCharters(txStore); err != nil {
+		return nil, err
+	}
 	eligible, err := p.getEligibleInputs(txStore, startAddress, lastSeriesID, dustThreshold,
 		chainHeight, eligibleInputMinConfirmations)
 	if err != nil {
 		return nil, err
 	}
 
-	w := newWithdrawal(roundID, requests, eligible, changeStart)
+	eligibleTotal := btcutil.Amount(0)
+	for _, input := range eligible {
+		eligibleTotal += input.Amount()
+	}
+	seriesBalance, err := p.withdrawableBalance(txStore, lastSeriesID, chainHeight,
+		eligibleInputMinConfirmations)
+	if err != nil {
+		return nil, err
+	}
+
+	w := newWithdrawal(roundID, requests, eligible, changeStart, feePolicy)
+	w.charter, err = p.charterCredit(txStore, lastSeriesID)
+	if err != nil {
+		return nil, err
+	}
 	if err := w.fulfillRequests(); err != nil {
 		return nil, err
 	}
+	w.status.updateShortfallReasons(seriesBalance, eligibleTotal)
+	w.status.nextInputAddr, err = w.nextInputAddr(startAddress, lastSeriesID)
+	if err != nil {
+		return nil, err
+	}
 	w.status.sigs, err = getRawSigs(w.transactions)
 	if err != nil {
 		return nil, err
 	}
 
+	serialized, err := serializeWithdrawal(requests, startAddress, lastSeriesID, changeStart,
+		dustThreshold, feePolicy, w.status)
+	if err != nil {
+		return nil, err
+	}
+	err = p.namespace.Update(
+		func(tx walletdb.Tx) error {
+			return putWithdrawal(tx, p.ID, roundID, serialized)
+		})
+	if err != nil {
+		return nil, err
+	}
+
 	return w.status, nil
 }
 
+// withdrawableBalance returns the total amount of the unspent credits of the
+// hot series up to lastSeriesID which could fund output requests: those with
+// at least minConf confirmations which are not charter outputs. Unlike
+// getEligibleInputs, it doesn't take the dust threshold or the start address
+// into account, so that shortfalls caused by those are told apart from
+// shortfalls caused by a lack of funds.
+//
+// This method must be called with the manager unlocked.
+func (p *Pool) withdrawableBalance(store *txstore.Store, lastSeriesID uint32,
+	chainHeight int32, minConf int) (btcutil.Amount, error) {
+
+	balance := btcutil.Amount(0)
+	for seriesID := uint32(1); seriesID <= lastSeriesID; seriesID++ {
+		if series := p.Series(seriesID); series == nil || !series.IsHot() {
+			continue
+		}
+		credits, err := p.SeriesCredits(store, seriesID)
+		if err != nil {
+			return 0, err
+		}
+		for _, c := range credits {
+			// SeriesCredits() only returns *credit values, so it's safe to
+			// get at the underlying txstore.Credit here.
+			txCredit := c.(*credit).Credit
+			if txCredit.Confirmed(minConf, chainHeight) && !p.isCharterOutput(txCredit) {
+				balance += c.Amount()
+			}
+		}
+	}
+	return balance, nil
+}
+
+// storedWithdrawalStatus returns the stored status of the withdrawal with the
+// given roundID, or nil if there is none. If the withdrawal was stored with
+// parameters other than the given ones, an error is returned.
+func (p *Pool) storedWithdrawalStatus(roundID uint32, requests []OutputRequest,
+	startAddress WithdrawalAddress, lastSeriesID uint32, changeStart ChangeAddress,
+	dustThreshold btcutil.Amount, feePolicy FeePolicy) (*WithdrawalStatus, error) {
+
+	var serialized []byte
+	err := p.namespace.View(
+		func(tx walletdb.Tx) error {
+			serialized = getWithdrawal(tx, p.ID, roundID)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	if serialized == nil {
+		return nil, nil
+	}
+
+	row, status, err := deserializeWithdrawal(p, serialized)
+	if err != nil {
+		return nil, err
+	}
+	params, err := newDBWithdrawalRow(requests, startAddress, lastSeriesID, changeStart,
+		dustThreshold, feePolicy, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !row.sameParams(params) {
+		str := fmt.Sprintf("withdrawal %d was started with different parameters", roundID)
+		return nil, newError(ErrWithdrawalParamsMismatch, str, nil)
+	}
+	return status, nil
+}
+
+// Withdrawal returns the stored status of the withdrawal with the given
+// roundID. An error with code ErrWithdrawalNotFound is returned if no such
+// withdrawal has been started.
+//
+// This method must be called with the manager unlocked.
+func (p *Pool) Withdrawal(roundID uint32) (*WithdrawalStatus, error) {
+	var serialized []byte
+	err := p.namespace.View(
+		func(tx walletdb.Tx) error {
+			serialized = getWithdrawal(tx, p.ID, roundID)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	if serialized == nil {
+		str := fmt.Sprintf("withdrawal %d not found", roundID)
+		return nil, newError(ErrWithdrawalNotFound, str, nil)
+	}
+	_, status, err := deserializeWithdrawal(p, serialized)
+	return status, err
+}
+
+// WithdrawalRoundIDs returns the roundIDs of all stored withdrawals, in
+// ascending order.
+func (p *Pool) WithdrawalRoundIDs() ([]uint32, error) {
+	var roundIDs []uint32
+	err := p.namespace.View(
+		func(tx walletdb.Tx) error {
+			var err error
+			roundIDs, err = getWithdrawalRoundIDs(tx, p.ID)
+			return err
+		})
+	return roundIDs, err
+}
+
 // popRequest removes and returns the first request from the stack of pending
 // requests.
 func (w *withdrawal) popRequest() OutputRequest {
@@ -574,10 +892,7 @@ func (w *withdrawal) finalizeCurrentTx() error {
 		// original one.
 		outputStatus := w.status.outputs[txOut.request.outBailmentID()]
 		origRequest := outputStatus.request
-		amtFulfilled := btcutil.Amount(0)
-		for _, outpoint := range outputStatus.outpoints {
-			amtFulfilled += outpoint.amount
-		}
+		amtFulfilled := outputStatus.Fulfilled()
 		if outputStatus.status == statusSuccess && amtFulfilled != origRequest.Amount {
 			msg := fmt.Sprintf("%s was not completely fulfilled; only %v fulfilled", origRequest,
 				amtFulfilled)
@@ -586,7 +901,7 @@ func (w *withdrawal) finalizeCurrentTx() error {
 	}
 
 	w.transactions = append(w.transactions, tx)
-	w.current = newWithdrawalTx()
+	w.current = newWithdrawalTx(w.feePolicy)
 	return nil
 }
 
@@ -622,6 +937,10 @@ func (w *withdrawal) fulfillRequests() error {
 	// Sort outputs by outBailmentID (hash(server ID, tx #))
 	sort.Sort(byOutBailmentID(w.pendingRequests))
 
+	// The first transaction of the withdrawal carries the charter
+	// input/output pair.
+	w.current.charter = w.charter
+
 	for len(w.pendingRequests) > 0 {
 		if err := w.fulfillNextRequest(); err != nil {
 			return err
@@ -638,12 +957,13 @@ func (w *withdrawal) fulfillRequests() error {
 		return err
 	}
 
-	// TODO: Update w.status.nextInputAddr. Not yet implemented as in some
-	// conditions we need to know about un-thawed series.
+	// Notice that w.status.nextInputAddr is not updated here as that requires
+	// the start address and last series of the withdrawal; StartWithdrawal()
+	// does it using w.nextInputAddr().
 
+	w.status.updateStatuses()
 	w.status.transactions = make(map[Ntxid]changeAwareTx, len(w.transactions))
 	for _, tx := range w.transactions {
-		w.status.updateStatusFor(tx)
 		w.status.fees += tx.fee
 		msgtx := tx.toMsgTx()
 		changeIdx := -1
@@ -652,14 +972,78 @@ func (w *withdrawal) fulfillRequests() error {
 			// in the generated MsgTx.
 			changeIdx = len(msgtx.TxOut) - 1
 		}
+		charterIdx := -1
+		if tx.hasCharter() {
+			// The charter output always follows the requested outputs.
+			charterIdx = len(tx.outputs)
+		}
 		w.status.transactions[tx.ntxid()] = changeAwareTx{
-			MsgTx:     msgtx,
-			changeIdx: int32(changeIdx),
+			MsgTx:      msgtx,
+			changeIdx:  int32(changeIdx),
+			charterIdx: int32(charterIdx),
 		}
 	}
 	return nil
 }
 
+// nextInputAddr returns the address from which input selection should start
+// in the withdrawal following this one, according to the following rules:
+//
+//   - If any eligible inputs were left unused, it's the address of the first
+//     of them, so they're considered again in the next withdrawal.
+//   - Otherwise it's the address following the last input used, as returned
+//     by nextAddr(), as long as it's not on a series after lastSeriesID.
+//   - If there is no such address, it's the first used address of the series
+//     after lastSeriesID, provided that series is hot (active or thawing).
+//   - If the series after lastSeriesID is not hot, input selection wraps
+//     around to the first used address of the lowest hot series.
+//   - If none of the above exists, startAddress is used again.
+//
+// This method must be called with the manager unlocked.
+func (w *withdrawal) nextInputAddr(startAddress WithdrawalAddress, lastSeriesID uint32) (
+	WithdrawalAddress, error) {
+	if len(w.eligibleInputs) > 0 {
+		return w.eligibleInputs[0].Address(), nil
+	}
+
+	p := startAddress.pool
+	if n := len(w.transactions); n > 0 && len(w.transactions[n-1].inputs) > 0 {
+		// Inputs are always taken in order from the list of eligible inputs,
+		// so the last input of the last transaction is the last one used.
+		inputs := w.transactions[n-1].inputs
+		last := inputs[len(inputs)-1].Address()
+		addr, err := nextAddr(p, last.seriesID, last.branch, last.index, lastSeriesID+1)
+		if err != nil {
+			return WithdrawalAddress{}, newError(ErrWithdrawalProcessing,
+				"failed to get next input address", err)
+		}
+		if addr != nil {
+			return *addr, nil
+		}
+	}
+
+	candidates := []uint32{lastSeriesID + 1}
+	for seriesID := uint32(1); seriesID <= lastSeriesID; seriesID++ {
+		candidates = append(candidates, seriesID)
+	}
+	for _, seriesID := range candidates {
+		series := p.Series(seriesID)
+		if series == nil || !series.IsHot() {
+			continue
+		}
+		addr, err := firstAddr(p, seriesID)
+		if err != nil {
+			return WithdrawalAddress{}, newError(ErrWithdrawalProcessing,
+				"failed to get next input address", err)
+		}
+		if addr != nil {
+			log.Debugf("Next input address wraps to series %d", seriesID)
+			return *addr, nil
+		}
+	}
+	return startAddress, nil
+}
+
 func (w *withdrawal) splitLastOutput() error {
 	if len(w.current.outputs) == 0 {
 		return newError(ErrPreconditionNotMet,
@@ -694,14 +1078,42 @@ func (w *withdrawal) splitLastOutput() error {
 	return nil
 }
 
-func (s *WithdrawalStatus) updateStatusFor(tx *withdrawalTx) {
+// updateStatuses sets the final status of every output, as described in
+// http://opentransactions.org/wiki/index.php/Update_Status. Outputs whose
+// outpoints don't add up to the requested amount are partial-, regardless of
+// whether or not they were split across multiple transactions.
+func (s *WithdrawalStatus) updateStatuses() {
 	for _, output := range s.outputs {
-		if len(output.outpoints) > 1 {
+		switch {
+		case output.Fulfilled() < output.request.Amount:
+			output.status = statusPartial
+		case len(output.outpoints) > 1:
 			output.status = statusSplit
+		default:
+			output.status = statusSuccess
+		}
+	}
+}
+
+// updateShortfallReasons records why every partial- output was not completely
+// fulfilled, given the total balance of the series withdrawn from and the
+// total amount of eligible inputs.
+func (s *WithdrawalStatus) updateShortfallReasons(seriesBalance, eligibleTotal btcutil.Amount) {
+	requested := btcutil.Amount(0)
+	for _, output := range s.outputs {
+		requested += output.request.Amount
+	}
+	for _, output := range s.outputs {
+		switch {
+		case output.status != statusPartial:
+			output.shortfall = shortfallNone
+		case seriesBalance < requested:
+			output.shortfall = shortfallInsufficientFunds
+		case eligibleTotal < requested:
+			output.shortfall = shortfallIneligibleCredits
+		default:
+			output.shortfall = shortfallNetworkFees
 		}
-		// TODO: Update outputs with status=='partial-'. For this we need an API
-		// that gives us the amount of credits in a given series.
-		// http://opentransactions.org/wiki/index.php/Update_Status
 	}
 }
 
@@ -711,10 +1123,11 @@ func (s *WithdrawalStatus) updateStatusFor(tx *withdrawalTx) {
 func getRawSigs(transactions []*withdrawalTx) (map[Ntxid]TxSigs, error) {
 	sigs := make(map[Ntxid]TxSigs)
 	for _, tx := range transactions {
-		txSigs := make(TxSigs, len(tx.inputs))
+		inputs := tx.txInputs()
+		txSigs := make(TxSigs, len(inputs))
 		msgtx := tx.toMsgTx()
 		ntxid := tx.ntxid()
-		for inputIdx, input := range tx.inputs {
+		for inputIdx, input := range inputs {
 			creditAddr := input.Address()
 			redeemScript := creditAddr.redeemScript()
 			series := creditAddr.series()
@@ -734,7 +1147,7 @@ func getRawSigs(transactions []*withdrawalTx) (map[Ntxid]TxSigs, error) {
 					return nil, err
 				}
 				if privKey != nil {
-					childKey, err := privKey.Child(uint32(creditAddr.Index()))
+					childKey, err := deriveChild(privKey, uint32(creditAddr.Index()))
 					if err != nil {
 						return nil, newError(ErrKeyChain, "failed to derive private key", err)
 					}
@@ -855,10 +1268,11 @@ func validateSigScript(msgtx *wire.MsgTx, idx int, pkScript []byte) error {
 	return nil
 }
 
-// calculateTxFee calculates the expected network fees for a given tx. We use
-// a variable instead of a function so that it can be replaced in tests.
+// calculateTxFee calculates the expected network fees for a given tx,
+// according to its fee policy. We use a variable instead of a function so that
+// it can be replaced in tests.
 var calculateTxFee = func(tx *withdrawalTx) btcutil.Amount {
-	return btcutil.Amount(1+calculateTxSize(tx)/1000) * feeIncrement
+	return tx.feePolicy.fee(calculateTxSize(tx), len(tx.outputs))
 }
 
 // isTxTooBig returns true if the size (in bytes) of the given tx is greater
@@ -886,6 +1300,7 @@ var calculateTxSize = func(tx *withdrawalTx) int {
 	// Craft a SignatureScript with dummy signatures for every input in this tx
 	// so that we can use msgtx.SerializeSize() to get its size and don't need
 	// to rely on estimations.
+	inputs := tx.txInputs()
 	for i, txin := range msgtx.TxIn {
 		// 1 byte for the OP_FALSE opcode, then 73+1 bytes for each signature
 		// with their OP_DATA opcode and finally the redeem script + 1 byte
@@ -893,7 +1308,7 @@ var calculateTxSize = func(tx *withdrawalTx) int {
 		// Notice that we use 73 as the signature length as that's the maximum
 		// length they may have:
 		// https://en.bitcoin.it/wiki/Elliptic_Curve_Digital_Signature_Algorithm
-		addr := tx.inputs[i].Address()
+		addr := inputs[i].Address()
 		redeemScriptLen := len(addr.redeemScript())
 		n := wire.VarIntSerializeSize(uint64(redeemScriptLen))
 		sigScriptLen := 1 + (74 * int(addr.series().reqSigs)) + redeemScriptLen + 1 + n
@@ -902,15 +1317,25 @@ var calculateTxSize = func(tx *withdrawalTx) int {
 	return msgtx.SerializeSize()
 }
 
+// nextChangeAddress returns the change address following the given one,
+// skipping indices that are invalid for its series.
 func nextChangeAddress(a ChangeAddress) (ChangeAddress, error) {
 	index := a.index
 	seriesID := a.seriesID
-	if index == math.MaxUint32 {
-		index = 0
-		seriesID++
-	} else {
-		index++
+	for {
+		if index == math.MaxUint32 {
+			index = 0
+			seriesID++
+		} else {
+			index++
+		}
+		addr, err := a.pool.ChangeAddress(seriesID, index)
+		if err == nil {
+			return *addr, nil
+		}
+		if vpErr, ok := err.(Error); !ok || vpErr.ErrorCode != ErrInvalidIndex {
+			return ChangeAddress{}, err
+		}
+		log.Debugf("Skipping invalid change address index %d of series #%d", index, seriesID)
 	}
-	addr, err := a.pool.ChangeAddress(seriesID, index)
-	return *addr, err
 }
@@ -54,7 +54,7 @@ func TestStartWithdrawal(t *testing.T) {
 	var err error
 	vp.TstRunWithManagerUnlocked(t, mgr, func() {
 		status, err = pool.StartWithdrawal(0, requests, *startAddr, lastSeriesID, *changeStart,
-			store, currentBlock, dustThreshold)
+			store, currentBlock, dustThreshold, vp.DefaultFeePolicy)
 	})
 	if err != nil {
 		t.Fatal(err)
@@ -98,6 +98,211 @@ func TestStartWithdrawal(t *testing.T) {
 	})
 }
 
+func TestStartWithdrawalStoresStatus(t *testing.T) {
+	tearDown, pool, store := vp.TstCreatePoolAndTxStore(t)
+	defer tearDown()
+	mgr := pool.Manager()
+
+	masters := []*hdkeychain.ExtendedKey{
+		vp.TstCreateMasterKey(t, bytes.Repeat([]byte{0x00, 0x01}, 16)),
+		vp.TstCreateMasterKey(t, bytes.Repeat([]byte{0x02, 0x01}, 16)),
+		vp.TstCreateMasterKey(t, bytes.Repeat([]byte{0x03, 0x01}, 16))}
+	def := vp.TstCreateSeriesDef(t, pool, 2, masters)
+	vp.TstCreateSeries(t, pool, []vp.TstSeriesDef{def})
+	vp.TstCreateCreditsOnSeries(t, pool, def.SeriesID, []int64{5e6, 4e6}, store)
+	address1 := "34eVkREKgvvGASZW7hkgE2uNc1yycntMK6"
+	address2 := "3PbExiaztsSYgh6zeMswC49hLUwhTQ86XG"
+	requests := []vp.OutputRequest{
+		vp.TstNewOutputRequest(t, 1, address1, 4e6, mgr.ChainParams()),
+		vp.TstNewOutputRequest(t, 2, address2, 1e6, mgr.ChainParams()),
+	}
+	changeStart := vp.TstNewChangeAddress(t, pool, def.SeriesID, 0)
+	startAddr := vp.TstNewWithdrawalAddress(t, pool, def.SeriesID, 0, 0)
+	dustThreshold := btcutil.Amount(1e4)
+	currentBlock := int32(vp.TstInputsBlock + vp.TstEligibleInputMinConfirmations + 1)
+	roundID := uint32(3)
+
+	vp.TstRunWithManagerUnlocked(t, mgr, func() {
+		status, err := pool.StartWithdrawal(roundID, requests, *startAddr, def.SeriesID,
+			*changeStart, store, currentBlock, dustThreshold, vp.DefaultFeePolicy)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Starting the same round again must return the stored status,
+		// even if the state of the txstore has changed in the meantime
+		// (here simulated by passing a chain height at which no inputs
+		// are eligible).
+		again, err := pool.StartWithdrawal(roundID, requests, *startAddr, def.SeriesID,
+			*changeStart, store, vp.TstInputsBlock, dustThreshold, vp.DefaultFeePolicy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkWithdrawalStatusesEqual(t, again, status)
+
+		loaded, err := pool.Withdrawal(roundID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkWithdrawalStatusesEqual(t, loaded, status)
+
+		// Reusing the roundID with different parameters is an error.
+		_, err = pool.StartWithdrawal(roundID, requests[:1], *startAddr, def.SeriesID,
+			*changeStart, store, currentBlock, dustThreshold, vp.DefaultFeePolicy)
+		vp.TstCheckError(t, "", err, vp.ErrWithdrawalParamsMismatch)
+
+		// And so is reusing it with a different fee policy.
+		feePolicy := vp.DefaultFeePolicy
+		feePolicy.RatePerKB *= 2
+		_, err = pool.StartWithdrawal(roundID, requests, *startAddr, def.SeriesID,
+			*changeStart, store, currentBlock, dustThreshold, feePolicy)
+		vp.TstCheckError(t, "", err, vp.ErrWithdrawalParamsMismatch)
+
+		_, err = pool.Withdrawal(roundID + 1)
+		vp.TstCheckError(t, "", err, vp.ErrWithdrawalNotFound)
+	})
+
+	roundIDs, err := pool.WithdrawalRoundIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roundIDs) != 1 || roundIDs[0] != roundID {
+		t.Fatalf("Wrong withdrawal roundIDs; got %v, want [%d]", roundIDs, roundID)
+	}
+}
+
+func TestStoredWithdrawalAfterSeriesRetired(t *testing.T) {
+	tearDown, pool, store := vp.TstCreatePoolAndTxStore(t)
+	defer tearDown()
+	mgr := pool.Manager()
+
+	masters := []*hdkeychain.ExtendedKey{
+		vp.TstCreateMasterKey(t, bytes.Repeat([]byte{0x00, 0x01}, 16)),
+		vp.TstCreateMasterKey(t, bytes.Repeat([]byte{0x02, 0x01}, 16)),
+		vp.TstCreateMasterKey(t, bytes.Repeat([]byte{0x03, 0x01}, 16))}
+	def := vp.TstCreateSeriesDef(t, pool, 2, masters)
+	vp.TstCreateSeries(t, pool, []vp.TstSeriesDef{def})
+	vp.TstCreateCreditsOnSeries(t, pool, def.SeriesID, []int64{5e6, 4e6}, store)
+	requests := []vp.OutputRequest{
+		vp.TstNewOutputRequest(t, 1, "34eVkREKgvvGASZW7hkgE2uNc1yycntMK6", 4e6,
+			mgr.ChainParams()),
+	}
+	changeStart := vp.TstNewChangeAddress(t, pool, def.SeriesID, 0)
+	startAddr := vp.TstNewWithdrawalAddress(t, pool, def.SeriesID, 0, 0)
+	dustThreshold := btcutil.Amount(1e4)
+	currentBlock := int32(vp.TstInputsBlock + vp.TstEligibleInputMinConfirmations + 1)
+	roundID := uint32(1)
+
+	vp.TstRunWithManagerUnlocked(t, mgr, func() {
+		status, err := pool.StartWithdrawal(roundID, requests, *startAddr, def.SeriesID,
+			*changeStart, store, currentBlock, dustThreshold, vp.DefaultFeePolicy)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The stored round names addresses of the series, which must still
+		// be loaded once the series is no longer active, nor hot.
+		changeState := []struct {
+			name   string
+			change func(uint32) error
+		}{
+			{"thawed", pool.ThawSeries},
+			{"retired", pool.RetireSeries},
+		}
+		for _, test := range changeState {
+			if err := test.change(def.SeriesID); err != nil {
+				t.Fatal(err)
+			}
+			loaded, err := pool.Withdrawal(roundID)
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			checkWithdrawalStatusesEqual(t, loaded, status)
+
+			again, err := pool.StartWithdrawal(roundID, requests, *startAddr,
+				def.SeriesID, *changeStart, store, currentBlock, dustThreshold,
+				vp.DefaultFeePolicy)
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			checkWithdrawalStatusesEqual(t, again, status)
+		}
+	})
+}
+
+func checkWithdrawalStatusesEqual(t *testing.T, got, want *vp.WithdrawalStatus) {
+	if got.Fees() != want.Fees() {
+		t.Fatalf("Wrong fees; got %v, want %v", got.Fees(), want.Fees())
+	}
+	if got.FeePolicy() != want.FeePolicy() {
+		t.Fatalf("Wrong fee policy; got %+v, want %+v", got.FeePolicy(), want.FeePolicy())
+	}
+	gotChange, wantChange := got.NextChangeAddr(), want.NextChangeAddr()
+	if gotChange.SeriesID() != wantChange.SeriesID() || gotChange.Index() != wantChange.Index() {
+		t.Fatalf("Wrong next change address; got %v, want %v", gotChange, wantChange)
+	}
+
+	if len(got.Outputs()) != len(want.Outputs()) {
+		t.Fatalf("Wrong number of outputs; got %d, want %d", len(got.Outputs()),
+			len(want.Outputs()))
+	}
+	for id, wantOutput := range want.Outputs() {
+		gotOutput, ok := got.Outputs()[id]
+		if !ok {
+			t.Fatalf("Missing output %s", id)
+		}
+		if gotOutput.Status() != wantOutput.Status() {
+			t.Fatalf("Wrong status for output %s; got %s, want %s", id,
+				gotOutput.Status(), wantOutput.Status())
+		}
+		if gotOutput.ShortfallReason() != wantOutput.ShortfallReason() {
+			t.Fatalf("Wrong shortfall reason for output %s; got %q, want %q", id,
+				gotOutput.ShortfallReason(), wantOutput.ShortfallReason())
+		}
+		if gotOutput.Address() != wantOutput.Address() {
+			t.Fatalf("Wrong address for output %s; got %s, want %s", id,
+				gotOutput.Address(), wantOutput.Address())
+		}
+		if len(gotOutput.Outpoints()) != len(wantOutput.Outpoints()) {
+			t.Fatalf("Wrong number of outpoints for output %s; got %d, want %d", id,
+				len(gotOutput.Outpoints()), len(wantOutput.Outpoints()))
+		}
+		for i, outpoint := range wantOutput.Outpoints() {
+			if gotOutput.Outpoints()[i].Amount() != outpoint.Amount() {
+				t.Fatalf("Wrong amount for outpoint %d of output %s; got %v, want %v",
+					i, id, gotOutput.Outpoints()[i].Amount(), outpoint.Amount())
+			}
+		}
+	}
+
+	if len(got.Sigs()) != len(want.Sigs()) {
+		t.Fatalf("Wrong number of signed transactions; got %d, want %d", len(got.Sigs()),
+			len(want.Sigs()))
+	}
+	for ntxid, wantTxSigs := range want.Sigs() {
+		gotTxSigs := got.Sigs()[ntxid]
+		if len(gotTxSigs) != len(wantTxSigs) {
+			t.Fatalf("Wrong number of inputs for %s; got %d, want %d", ntxid,
+				len(gotTxSigs), len(wantTxSigs))
+		}
+		for i, inputSigs := range wantTxSigs {
+			if len(gotTxSigs[i]) != len(inputSigs) {
+				t.Fatalf("Wrong number of sigs for input %d of %s; got %d, want %d",
+					i, ntxid, len(gotTxSigs[i]), len(inputSigs))
+			}
+			for j, sig := range inputSigs {
+				if !bytes.Equal(gotTxSigs[i][j], sig) {
+					t.Fatalf("Wrong sig %d for input %d of %s; got %x, want %x",
+						j, i, ntxid, gotTxSigs[i][j], sig)
+				}
+			}
+		}
+		if got.TstGetMsgTx(ntxid).TxSha() != want.TstGetMsgTx(ntxid).TxSha() {
+			t.Fatalf("Wrong transaction for %s", ntxid)
+		}
+	}
+}
+
 func checkWithdrawalOutputs(
 	t *testing.T, wStatus *vp.WithdrawalStatus, amounts map[string]btcutil.Amount) {
 	fulfilled := wStatus.Outputs()
@@ -131,3 +336,123 @@ func checkWithdrawalOutputs(
 		}
 	}
 }
+
+func TestMergeTxSigs(t *testing.T) {
+	tearDown, pool, store := vp.TstCreatePoolAndTxStore(t)
+	defer tearDown()
+	mgr := pool.Manager()
+
+	masters := []*hdkeychain.ExtendedKey{
+		vp.TstCreateMasterKey(t, bytes.Repeat([]byte{0x00, 0x01}, 16)),
+		vp.TstCreateMasterKey(t, bytes.Repeat([]byte{0x02, 0x01}, 16)),
+		vp.TstCreateMasterKey(t, bytes.Repeat([]byte{0x03, 0x01}, 16))}
+	def := vp.TstCreateSeriesDef(t, pool, 2, masters)
+	// This pool member holds only the first private key of the series.
+	otherPrivKey := def.PrivKeys[1]
+	def.PrivKeys = def.PrivKeys[:1]
+	vp.TstCreateSeries(t, pool, []vp.TstSeriesDef{def})
+	vp.TstCreateCreditsOnSeries(t, pool, def.SeriesID, []int64{5e6, 4e6}, store)
+	requests := []vp.OutputRequest{
+		vp.TstNewOutputRequest(t, 1, "34eVkREKgvvGASZW7hkgE2uNc1yycntMK6", 4e6,
+			mgr.ChainParams()),
+	}
+	changeStart := vp.TstNewChangeAddress(t, pool, def.SeriesID, 0)
+	startAddr := vp.TstNewWithdrawalAddress(t, pool, def.SeriesID, 0, 0)
+	dustThreshold := btcutil.Amount(1e4)
+	currentBlock := int32(vp.TstInputsBlock + vp.TstEligibleInputMinConfirmations + 1)
+
+	vp.TstRunWithManagerUnlocked(t, mgr, func() {
+		status, err := pool.StartWithdrawal(0, requests, *startAddr, def.SeriesID,
+			*changeStart, store, currentBlock, dustThreshold, vp.DefaultFeePolicy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(status.Sigs()) != 1 {
+			t.Fatalf("Wrong number of transactions; got %d, want 1", len(status.Sigs()))
+		}
+		var ntxid vp.Ntxid
+		for ntxid = range status.Sigs() {
+		}
+
+		// Only one of the two required signatures is available.
+		sigsStatus, err := pool.MergeTxSigs(0, ntxid, nil, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkInputsSigned(t, sigsStatus, false)
+
+		// Obtain the signatures of another member by empowering the series
+		// with a second key and processing the same withdrawal in another
+		// round. Only the signatures we don't have yet are kept.
+		if err := pool.EmpowerSeries(def.SeriesID, otherPrivKey); err != nil {
+			t.Fatal(err)
+		}
+		other, err := pool.StartWithdrawal(1, requests, *startAddr, def.SeriesID,
+			*changeStart, store, currentBlock, dustThreshold, vp.DefaultFeePolicy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		otherSigs := other.Sigs()[ntxid]
+		if len(otherSigs) != len(sigsStatus.Sigs) {
+			t.Fatalf("Wrong number of inputs signed by other member; got %d, want %d",
+				len(otherSigs), len(sigsStatus.Sigs))
+		}
+		for i, inputSigs := range otherSigs {
+			for j := range inputSigs {
+				if len(sigsStatus.Sigs[i][j]) != 0 {
+					inputSigs[j] = vp.RawSig{}
+				}
+			}
+		}
+
+		// A corrupted signature must be rejected.
+		corrupted := make(vp.TxSigs, len(otherSigs))
+		for i, inputSigs := range otherSigs {
+			corrupted[i] = make([]vp.RawSig, len(inputSigs))
+			for j, sig := range inputSigs {
+				if len(sig) != 0 {
+					sig = append(vp.RawSig{}, sig...)
+					sig[len(sig)/2] ^= 0xff
+				}
+				corrupted[i][j] = sig
+			}
+		}
+		_, err = pool.MergeTxSigs(0, ntxid, []vp.TxSigs{corrupted}, store)
+		vp.TstCheckError(t, "", err, vp.ErrInvalidRawSig)
+
+		sigsStatus, err = pool.MergeTxSigs(0, ntxid, []vp.TxSigs{otherSigs}, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkInputsSigned(t, sigsStatus, true)
+		if sigsStatus.SignedTx == nil {
+			t.Fatal("No signed transaction with all required signatures")
+		}
+
+		// The merged signatures are stored with the withdrawal.
+		stored, err := pool.Withdrawal(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sigsStatus, err = pool.MergeTxSigs(0, ntxid, nil, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkInputsSigned(t, sigsStatus, true)
+		if len(stored.Sigs()[ntxid]) != len(sigsStatus.Sigs) {
+			t.Fatalf("Wrong number of stored input sigs; got %d, want %d",
+				len(stored.Sigs()[ntxid]), len(sigsStatus.Sigs))
+		}
+	})
+}
+
+func checkInputsSigned(t *testing.T, status *vp.TxSigsStatus, want bool) {
+	for i, signed := range status.InputsSigned {
+		if signed != want {
+			t.Fatalf("Wrong signed state for input %d; got %v, want %v", i, signed, want)
+		}
+	}
+	if !want && status.SignedTx != nil {
+		t.Fatal("Unexpected signed transaction without all required signatures")
+	}
+}
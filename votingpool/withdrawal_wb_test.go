@@ -29,6 +29,7 @@ import (
 	"github.com/btcsuite/btcutil/hdkeychain"
 	"github.com/monetas/btcwallet/txstore"
 	"github.com/monetas/btcwallet/waddrmgr"
+	"github.com/monetas/btcwallet/walletdb"
 )
 
 // TestOutputSplittingNotEnoughInputs checks that an output will get split if we
@@ -48,7 +49,8 @@ func TestOutputSplittingNotEnoughInputs(t *testing.T) {
 		TstNewOutputRequest(t, 2, "34eVkREKgvvGASZW7hkgE2uNc1yycntMK6", output2Amount, net),
 	}
 	seriesID, eligible := TstCreateCredits(t, pool, []int64{7}, store)
-	w := newWithdrawal(0, requests, eligible, *TstNewChangeAddress(t, pool, seriesID, 0))
+	w := newWithdrawal(0, requests, eligible, *TstNewChangeAddress(t, pool, seriesID, 0),
+		DefaultFeePolicy)
 
 	// Trigger an output split because of lack of inputs by forcing a high fee.
 	// If we just started with not enough inputs for the requested outputs,
@@ -91,7 +93,7 @@ func TestOutputSplittingOversizeTx(t *testing.T) {
 		t, 1, "34eVkREKgvvGASZW7hkgE2uNc1yycntMK6", requestAmount, pool.Manager().ChainParams())
 	seriesID, eligible := TstCreateCredits(t, pool, []int64{bigInput, smallInput}, store)
 	changeStart := TstNewChangeAddress(t, pool, seriesID, 0)
-	w := newWithdrawal(0, []OutputRequest{request}, eligible, *changeStart)
+	w := newWithdrawal(0, []OutputRequest{request}, eligible, *changeStart, DefaultFeePolicy)
 	restoreCalculateTxFee := replaceCalculateTxFee(TstConstantFee(0))
 	defer restoreCalculateTxFee()
 	restoreIsTxTooBig := replaceIsTxTooBig(func(tx *withdrawalTx) bool {
@@ -139,7 +141,7 @@ func TestSplitLastOutputNoOutputs(t *testing.T) {
 	tearDown, pool, store := TstCreatePoolAndTxStore(t)
 	defer tearDown()
 
-	w := newWithdrawal(0, []OutputRequest{}, []Credit{}, ChangeAddress{})
+	w := newWithdrawal(0, []OutputRequest{}, []Credit{}, ChangeAddress{}, DefaultFeePolicy)
 	w.current = createWithdrawalTx(t, pool, store, []int64{}, []int64{})
 
 	err := w.splitLastOutput()
@@ -162,7 +164,7 @@ func TestWithdrawalTxOutputs(t *testing.T) {
 	}
 	changeStart := TstNewChangeAddress(t, pool, seriesID, 0)
 
-	w := newWithdrawal(0, outputs, eligible, *changeStart)
+	w := newWithdrawal(0, outputs, eligible, *changeStart, DefaultFeePolicy)
 	if err := w.fulfillRequests(); err != nil {
 		t.Fatal(err)
 	}
@@ -193,7 +195,7 @@ func TestFulfillRequestsNoSatisfiableOutputs(t *testing.T) {
 		t, 1, "3Qt1EaKRD9g9FeL2DGkLLswhK1AKmmXFSe", btcutil.Amount(3e6), pool.Manager().ChainParams())
 	changeStart := TstNewChangeAddress(t, pool, seriesID, 0)
 
-	w := newWithdrawal(0, []OutputRequest{request}, eligible, *changeStart)
+	w := newWithdrawal(0, []OutputRequest{request}, eligible, *changeStart, DefaultFeePolicy)
 	if err := w.fulfillRequests(); err != nil {
 		t.Fatal(err)
 	}
@@ -214,6 +216,52 @@ func TestFulfillRequestsNoSatisfiableOutputs(t *testing.T) {
 	}
 }
 
+// TestStoredWithdrawalStatusEmptyRequests checks that a withdrawal stored with
+// no requests matches the same parameters when started again, even though gob
+// decodes its empty list of requests as nil.
+func TestStoredWithdrawalStatusEmptyRequests(t *testing.T) {
+	tearDown, pool, store := TstCreatePoolAndTxStore(t)
+	defer tearDown()
+
+	seriesID, eligible := TstCreateCredits(t, pool, []int64{1e6}, store)
+	changeStart := TstNewChangeAddress(t, pool, seriesID, 0)
+	startAddr := TstNewWithdrawalAddress(t, pool, seriesID, 0, 0)
+	requests := []OutputRequest{}
+	dustThreshold := btcutil.Amount(1e4)
+
+	w := newWithdrawal(0, requests, eligible, *changeStart, DefaultFeePolicy)
+	if err := w.fulfillRequests(); err != nil {
+		t.Fatal(err)
+	}
+	serialized, err := serializeWithdrawal(requests, *startAddr, seriesID, *changeStart,
+		dustThreshold, DefaultFeePolicy, w.status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pool.namespace.Update(
+		func(tx walletdb.Tx) error {
+			return putWithdrawal(tx, pool.ID, 0, serialized)
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	TstRunWithManagerUnlocked(t, pool.Manager(), func() {
+		status, err := pool.storedWithdrawalStatus(0, requests, *startAddr, seriesID,
+			*changeStart, dustThreshold, DefaultFeePolicy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status == nil {
+			t.Fatal("Stored withdrawal not found")
+		}
+
+		_, err = pool.storedWithdrawalStatus(0, requests, *startAddr, seriesID,
+			*changeStart, dustThreshold+1, DefaultFeePolicy)
+		TstCheckError(t, "", err, ErrWithdrawalParamsMismatch)
+	})
+}
+
 // Check that some requested outputs are not fulfilled when we don't have credits for all
 // of them.
 func TestFulfillRequestsNotEnoughCreditsForAllRequests(t *testing.T) {
@@ -232,7 +280,7 @@ func TestFulfillRequestsNotEnoughCreditsForAllRequests(t *testing.T) {
 	outputs := []OutputRequest{out1, out2, out3}
 	changeStart := TstNewChangeAddress(t, pool, seriesID, 0)
 
-	w := newWithdrawal(0, outputs, eligible, *changeStart)
+	w := newWithdrawal(0, outputs, eligible, *changeStart, DefaultFeePolicy)
 	if err := w.fulfillRequests(); err != nil {
 		t.Fatal(err)
 	}
@@ -267,6 +315,267 @@ func TestFulfillRequestsNotEnoughCreditsForAllRequests(t *testing.T) {
 
 // TestRollbackLastOutput tests the case where we rollback one output
 // and one input, such that sum(in) >= sum(out) + fee.
+func TestUpdateStatuses(t *testing.T) {
+	net := &chaincfg.MainNetParams
+	req1 := TstNewOutputRequest(t, 1, "34eVkREKgvvGASZW7hkgE2uNc1yycntMK6", 3e6, net)
+	req2 := TstNewOutputRequest(t, 2, "3PbExiaztsSYgh6zeMswC49hLUwhTQ86XG", 2e6, net)
+	req3 := TstNewOutputRequest(t, 3, "3Qt1EaKRD9g9FeL2DGkLLswhK1AKmmXFSe", 5e6, net)
+	req4 := TstNewOutputRequest(t, 4, "3Qt1EaKRD9g9FeL2DGkLLswhK1AKmmXFSe", 4e6, net)
+	status := &WithdrawalStatus{outputs: map[OutBailmentID]*WithdrawalOutput{
+		// Completely fulfilled by a single outpoint.
+		req1.outBailmentID(): TstNewWithdrawalOutput(req1, statusPartial,
+			[]OutBailmentOutpoint{{amount: 3e6}}),
+		// Completely fulfilled across two transactions.
+		req2.outBailmentID(): TstNewWithdrawalOutput(req2, statusPartial,
+			[]OutBailmentOutpoint{{amount: 1e6}, {amount: 1e6}}),
+		// Split across two transactions but still not completely fulfilled.
+		req3.outBailmentID(): TstNewWithdrawalOutput(req3, statusSuccess,
+			[]OutBailmentOutpoint{{amount: 1e6}, {amount: 1e6}}),
+		// Not fulfilled at all.
+		req4.outBailmentID(): TstNewWithdrawalOutput(req4, statusSuccess, nil),
+	}}
+
+	status.updateStatuses()
+
+	wantStatuses := map[OutBailmentID]outputStatus{
+		req1.outBailmentID(): statusSuccess,
+		req2.outBailmentID(): statusSplit,
+		req3.outBailmentID(): statusPartial,
+		req4.outBailmentID(): statusPartial,
+	}
+	for id, want := range wantStatuses {
+		if got := status.outputs[id].status; got != want {
+			t.Fatalf("Wrong status for %s; got '%s', want '%s'", id, got, want)
+		}
+	}
+	if got := status.outputs[req3.outBailmentID()].Fulfilled(); got != 2e6 {
+		t.Fatalf("Wrong fulfilled amount; got %v, want %v", got, btcutil.Amount(2e6))
+	}
+}
+
+func TestUpdateShortfallReasons(t *testing.T) {
+	net := &chaincfg.MainNetParams
+	req1 := TstNewOutputRequest(t, 1, "34eVkREKgvvGASZW7hkgE2uNc1yycntMK6", 3e6, net)
+	req2 := TstNewOutputRequest(t, 2, "3PbExiaztsSYgh6zeMswC49hLUwhTQ86XG", 2e6, net)
+	status := &WithdrawalStatus{outputs: map[OutBailmentID]*WithdrawalOutput{
+		req1.outBailmentID(): TstNewWithdrawalOutput(req1, statusSuccess, nil),
+		req2.outBailmentID(): TstNewWithdrawalOutput(req2, statusPartial, nil),
+	}}
+
+	tests := []struct {
+		seriesBalance, eligibleTotal btcutil.Amount
+		want                         shortfallReason
+	}{
+		{seriesBalance: 4e6, eligibleTotal: 4e6, want: shortfallInsufficientFunds},
+		{seriesBalance: 5e6, eligibleTotal: 4e6, want: shortfallIneligibleCredits},
+		{seriesBalance: 5e6, eligibleTotal: 5e6, want: shortfallNetworkFees},
+	}
+	for i, test := range tests {
+		status.updateShortfallReasons(test.seriesBalance, test.eligibleTotal)
+		if got := status.outputs[req1.outBailmentID()].shortfall; got != shortfallNone {
+			t.Fatalf("Test #%d: unexpected shortfall reason for successful output: %q",
+				i, got)
+		}
+		got := status.outputs[req2.outBailmentID()].ShortfallReason()
+		if got != test.want.String() {
+			t.Fatalf("Test #%d: wrong shortfall reason; got %q, want %q", i, got, test.want)
+		}
+	}
+}
+
+func TestStartWithdrawalCharterIsNotSeriesBalance(t *testing.T) {
+	tearDown, pool, store := TstCreatePoolAndTxStore(t)
+	defer tearDown()
+	mgr := pool.Manager()
+
+	// The only funds left on the series are its charter output, which
+	// can't be used to fulfill requests.
+	seriesID, credits := TstCreateCredits(t, pool, []int64{4e6}, store)
+	if err := pool.SetCharterOutpoint(seriesID, *credits[0].OutPoint()); err != nil {
+		t.Fatal(err)
+	}
+	request := TstNewOutputRequest(
+		t, 1, "34eVkREKgvvGASZW7hkgE2uNc1yycntMK6", btcutil.Amount(1e6), mgr.ChainParams())
+	changeStart := TstNewChangeAddress(t, pool, seriesID, 0)
+	startAddr := TstNewWithdrawalAddress(t, pool, seriesID, 0, 0)
+	currentBlock := int32(TstInputsBlock + eligibleInputMinConfirmations + 1)
+
+	var status *WithdrawalStatus
+	var err error
+	TstRunWithManagerUnlocked(t, mgr, func() {
+		status, err = pool.StartWithdrawal(0, []OutputRequest{request}, *startAddr,
+			seriesID, *changeStart, store, currentBlock, dustThreshold, DefaultFeePolicy)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := status.outputs[request.outBailmentID()]
+	if output.status != statusPartial {
+		t.Fatalf("Wrong output status; got '%s', want '%s'", output.status, statusPartial)
+	}
+	if output.shortfall != shortfallInsufficientFunds {
+		t.Fatalf("Wrong shortfall reason; got %q, want %q", output.shortfall,
+			shortfallInsufficientFunds)
+	}
+}
+
+func TestFulfillRequestsIncludesCharter(t *testing.T) {
+	tearDown, pool, store := TstCreatePoolAndTxStore(t)
+	defer tearDown()
+
+	net := pool.Manager().ChainParams()
+	seriesID, eligible := TstCreateCredits(t, pool, []int64{4e6, 1e5}, store)
+	// Use the last credit as the pool's charter output.
+	charter := eligible[1]
+	eligible = eligible[:1]
+	request := TstNewOutputRequest(
+		t, 1, "34eVkREKgvvGASZW7hkgE2uNc1yycntMK6", btcutil.Amount(3e6), net)
+	changeStart := TstNewChangeAddress(t, pool, seriesID, 0)
+
+	w := newWithdrawal(0, []OutputRequest{request}, eligible, *changeStart, DefaultFeePolicy)
+	w.charter = charter
+	if err := w.fulfillRequests(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(w.transactions) != 1 {
+		t.Fatalf("Wrong number of transactions; got %d, want 1", len(w.transactions))
+	}
+	tx := w.transactions[0]
+	msgtx := tx.toMsgTx()
+
+	// The charter must be the last input and its output must follow the
+	// requested output, with change at the end.
+	if len(msgtx.TxIn) != 2 {
+		t.Fatalf("Wrong number of inputs; got %d, want 2", len(msgtx.TxIn))
+	}
+	if msgtx.TxIn[1].PreviousOutPoint != *charter.OutPoint() {
+		t.Fatalf("Wrong charter input; got %v, want %v", msgtx.TxIn[1].PreviousOutPoint,
+			*charter.OutPoint())
+	}
+	if len(msgtx.TxOut) != 3 {
+		t.Fatalf("Wrong number of outputs; got %d, want 3", len(msgtx.TxOut))
+	}
+	charterOut := msgtx.TxOut[1]
+	if charterOut.Value != int64(charter.Amount()) ||
+		!bytes.Equal(charterOut.PkScript, charter.TxOut().PkScript) {
+		t.Fatalf("Wrong charter output; got %v, want value %v and pkScript %x",
+			charterOut, charter.Amount(), charter.TxOut().PkScript)
+	}
+
+	// The charter amount must not be used to pay for outputs or fees.
+	wantChange := eligible[0].Amount() - request.Amount - tx.fee
+	if tx.changeOutput.Value != int64(wantChange) {
+		t.Fatalf("Wrong change amount; got %v, want %v", tx.changeOutput.Value, wantChange)
+	}
+
+	stored := w.status.transactions[tx.ntxid()]
+	if stored.charterIdx != 1 || stored.changeIdx != 2 {
+		t.Fatalf("Wrong charter/change indices; got %d/%d, want 1/2", stored.charterIdx,
+			stored.changeIdx)
+	}
+}
+
+func TestNextInputAddr(t *testing.T) {
+	tearDown, mgr, pool := TstCreatePool(t)
+	defer tearDown()
+
+	TstCreateSeries(t, pool, []TstSeriesDef{
+		{ReqSigs: 2, PubKeys: TstPubKeys[1:4], SeriesID: 1},
+		{ReqSigs: 2, PubKeys: TstPubKeys[3:6], SeriesID: 2},
+		{ReqSigs: 2, PubKeys: TstPubKeys[5:8], SeriesID: 3, Inactive: true},
+	})
+	TstEnsureUsedAddr(t, pool, 1, Branch(1), 1)
+	TstEnsureUsedAddr(t, pool, 2, Branch(0), 0)
+	TstEnsureUsedAddr(t, pool, 3, Branch(0), 0)
+	startAddr := TstNewWithdrawalAddress(t, pool, 1, 1, 0)
+
+	// withdrawalUsing returns a withdrawal whose last transaction spends a
+	// credit on the given address, with the given eligible inputs left.
+	withdrawalUsing := func(used *WithdrawalAddress, left ...*WithdrawalAddress) *withdrawal {
+		w := newWithdrawal(0, []OutputRequest{}, []Credit{}, ChangeAddress{}, DefaultFeePolicy)
+		if used != nil {
+			tx := newWithdrawalTx(DefaultFeePolicy)
+			tx.inputs = []Credit{newCredit(txstore.Credit{}, *used)}
+			w.transactions = []*withdrawalTx{tx}
+		}
+		for _, addr := range left {
+			w.eligibleInputs = append(w.eligibleInputs, newCredit(txstore.Credit{}, *addr))
+		}
+		return w
+	}
+
+	tests := []struct {
+		name         string
+		w            *withdrawal
+		lastSeriesID uint32
+		seriesID     uint32
+		branch       Branch
+		index        Index
+	}{
+		{
+			name: "first unused eligible input",
+			w: withdrawalUsing(TstNewWithdrawalAddress(t, pool, 1, 1, 0),
+				TstNewWithdrawalAddress(t, pool, 1, 1, 1)),
+			lastSeriesID: 1,
+			seriesID:     1, branch: 1, index: 1,
+		},
+		{
+			name:         "address after last used input",
+			w:            withdrawalUsing(TstNewWithdrawalAddress(t, pool, 1, 1, 0)),
+			lastSeriesID: 1,
+			seriesID:     1, branch: 1, index: 1,
+		},
+		{
+			name:         "first address of next hot series",
+			w:            withdrawalUsing(TstNewWithdrawalAddress(t, pool, 1, 1, 1)),
+			lastSeriesID: 1,
+			seriesID:     2, branch: 0, index: 0,
+		},
+		{
+			name:         "wrap around as next series is not hot",
+			w:            withdrawalUsing(TstNewWithdrawalAddress(t, pool, 2, 0, 0)),
+			lastSeriesID: 2,
+			seriesID:     1, branch: 1, index: 0,
+		},
+		{
+			name:         "no inputs",
+			w:            withdrawalUsing(nil),
+			lastSeriesID: 3,
+			seriesID:     1, branch: 1, index: 0,
+		},
+	}
+
+	for _, test := range tests {
+		var addr WithdrawalAddress
+		var err error
+		TstRunWithManagerUnlocked(t, mgr, func() {
+			addr, err = test.w.nextInputAddr(*startAddr, test.lastSeriesID)
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		checkWithdrawalAddressMatches(t, &addr, test.seriesID, test.branch, test.index)
+	}
+}
+
+func TestNextChangeAddressSkipsInvalidIndex(t *testing.T) {
+	tearDown, _, pool := TstCreatePool(t)
+	defer tearDown()
+
+	seriesID := tstCreateSeriesWithInvalidIndex(t, pool, 1)
+
+	next, err := nextChangeAddress(*TstNewChangeAddress(t, pool, seriesID, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.seriesID != seriesID || next.index != 2 {
+		t.Fatalf("Wrong next change address; got series %d, index %d, want series %d, index 2",
+			next.seriesID, next.index, seriesID)
+	}
+}
+
 func TestRollbackLastOutput(t *testing.T) {
 	tearDown, pool, store := TstCreatePoolAndTxStore(t)
 	defer tearDown()
@@ -371,7 +680,7 @@ func TestRollbackLastOutputNoInputsRolledBack(t *testing.T) {
 // rollBackLastOutput returns an error if there are less than two
 // outputs in the transaction.
 func TestRollBackLastOutputInsufficientOutputs(t *testing.T) {
-	tx := newWithdrawalTx()
+	tx := newWithdrawalTx(DefaultFeePolicy)
 	_, _, err := tx.rollBackLastOutput()
 	TstCheckError(t, "", err, ErrPreconditionNotMet)
 
@@ -397,7 +706,7 @@ func TestRollbackLastOutputWhenNewOutputAdded(t *testing.T) {
 	}
 	changeStart := TstNewChangeAddress(t, pool, series, 0)
 
-	w := newWithdrawal(0, requests, eligible, *changeStart)
+	w := newWithdrawal(0, requests, eligible, *changeStart, DefaultFeePolicy)
 	restoreCalculateTxFee := replaceCalculateTxFee(TstConstantFee(0))
 	defer restoreCalculateTxFee()
 	restoreIsTxTooBig := replaceIsTxTooBig(func(tx *withdrawalTx) bool {
@@ -447,7 +756,7 @@ func TestRollbackLastOutputWhenNewInputAdded(t *testing.T) {
 	}
 	changeStart := TstNewChangeAddress(t, pool, series, 0)
 
-	w := newWithdrawal(0, requests, eligible, *changeStart)
+	w := newWithdrawal(0, requests, eligible, *changeStart, DefaultFeePolicy)
 	restoreCalculateTxFee := replaceCalculateTxFee(TstConstantFee(0))
 	defer restoreCalculateTxFee()
 	restoreIsTxTooBig := replaceIsTxTooBig(func(tx *withdrawalTx) bool {
@@ -944,7 +1253,7 @@ func TestTxSizeCalculation(t *testing.T) {
 }
 
 func TestTxFeeEstimationForSmallTx(t *testing.T) {
-	tx := newWithdrawalTx()
+	tx := newWithdrawalTx(DefaultFeePolicy)
 
 	// A tx that is smaller than 1000 bytes in size should have a fee of 10000
 	// satoshis.
@@ -959,7 +1268,7 @@ func TestTxFeeEstimationForSmallTx(t *testing.T) {
 }
 
 func TestTxFeeEstimationForLargeTx(t *testing.T) {
-	tx := newWithdrawalTx()
+	tx := newWithdrawalTx(DefaultFeePolicy)
 
 	// A tx that is larger than 1000 bytes in size should have a fee of 1e3
 	// satoshis plus 1e3 for every 1000 bytes.
@@ -973,6 +1282,60 @@ func TestTxFeeEstimationForLargeTx(t *testing.T) {
 	}
 }
 
+func TestTxFeeEstimationWithFeePolicy(t *testing.T) {
+	tests := []struct {
+		policy     FeePolicy
+		size       int
+		numOutputs int
+		want       btcutil.Amount
+	}{
+		{FeePolicy{RatePerKB: 2e3}, 999, 1, 2e3},
+		{FeePolicy{RatePerKB: 2e3}, 2500, 1, 6e3},
+		// The minimum fee applies when the rate gives a smaller one.
+		{FeePolicy{RatePerKB: 1e3, MinFee: 5e3}, 2500, 1, 5e3},
+		// The fee is capped at MaxFeeShare per requested output...
+		{FeePolicy{RatePerKB: 1e3, MaxFeeShare: 1e3}, 2500, 2, 2e3},
+		{FeePolicy{RatePerKB: 1e3, MaxFeeShare: 1e3}, 2500, 3, 3e3},
+		// ...but never below the minimum fee.
+		{FeePolicy{RatePerKB: 1e3, MinFee: 2500, MaxFeeShare: 1e3}, 2500, 2, 2500},
+		// Transactions with no outputs are not capped.
+		{FeePolicy{RatePerKB: 1e3, MaxFeeShare: 1e3}, 2500, 0, 3e3},
+	}
+
+	for i, test := range tests {
+		fee := test.policy.fee(test.size, test.numOutputs)
+		if fee != test.want {
+			t.Errorf("Test #%d: unexpected tx fee; got %v, want %v", i, fee, test.want)
+		}
+	}
+}
+
+func TestTxFeeEstimationUsesTxFeePolicy(t *testing.T) {
+	tx := newWithdrawalTx(FeePolicy{RatePerKB: 3e3, MaxFeeShare: 5e3})
+	tx.addOutput(OutputRequest{Amount: 1})
+	restoreCalcTxSize := replaceCalculateTxSize(func(tx *withdrawalTx) int { return 3000 })
+	defer restoreCalcTxSize()
+
+	wantFee := btcutil.Amount(5e3)
+	if fee := calculateTxFee(tx); fee != wantFee {
+		t.Fatalf("Unexpected tx fee; got %v, want %v", fee, wantFee)
+	}
+}
+
+func TestFeePolicyValidate(t *testing.T) {
+	if err := DefaultFeePolicy.validate(); err != nil {
+		t.Fatalf("Unexpected error validating the default fee policy: %v", err)
+	}
+	invalid := []FeePolicy{
+		{RatePerKB: -1},
+		{RatePerKB: 1e3, MinFee: -1},
+		{RatePerKB: 1e3, MaxFeeShare: -1},
+	}
+	for _, policy := range invalid {
+		TstCheckError(t, "FeePolicy.validate", policy.validate(), ErrInvalidValue)
+	}
+}
+
 // lookupStoredTx returns the TxRecord from the given store whose SHA matches the
 // given ShaHash.
 func lookupStoredTx(store *txstore.Store, sha *wire.ShaHash) *txstore.TxRecord {
@@ -0,0 +1,451 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package waddrmgr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/monetas/btcwallet/walletdb"
+)
+
+// IntegrityProblem describes an inconsistency in the data of an address
+// manager found by CheckIntegrity.
+type IntegrityProblem struct {
+	// Description describes the problem.
+	Description string
+
+	// Repairable is true when the problem can be repaired from the
+	// remaining data of the manager, such as an index which disagrees
+	// with the rows it indexes.  Other problems, such as rows which can't
+	// be deserialized, can only be fixed by restoring a backup.
+	Repairable bool
+}
+
+// integrityChecker records the problems found in the manager namespace as
+// well as the changes repairing them, which can't be made while iterating
+// over the buckets being checked.
+type integrityChecker struct {
+	tx       walletdb.Tx
+	problems []IntegrityProblem
+	repairs  []func() error
+}
+
+// problem records a problem described by the passed format and arguments.
+// The problem is repairable when repair is not nil.
+func (c *integrityChecker) problem(repair func() error, format string, a ...interface{}) {
+	c.problems = append(c.problems, IntegrityProblem{
+		Description: fmt.Sprintf(format, a...),
+		Repairable:  repair != nil,
+	})
+	if repair != nil {
+		c.repairs = append(c.repairs, repair)
+	}
+}
+
+// deserialize calls the passed deserialization func, converting the panics of
+// deserializers reading past the end of malformed data into errors.
+func deserialize(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed data: %v", r)
+		}
+	}()
+	return fn()
+}
+
+// CheckIntegrity verifies the data of the address manager in the provided
+// namespace without opening the manager, so no passphrase is needed.  The
+// following invariants are checked:
+//
+//   - All buckets of the manager exist
+//   - All account and address rows can be deserialized
+//   - The account name and ID indexes agree with the account rows
+//   - The last account counter is not below the number of any account
+//   - The next external and internal indexes of accounts are above the
+//     indexes of all their chained addresses
+//   - The address account index agrees with the address rows, and the used
+//     address and birthday entries belong to existing addresses
+//
+// All problems found are returned.  When repair is true, the repairable ones
+// are also fixed in the same database transaction, otherwise the namespace is
+// not modified.  ErrNoExist is returned when the namespace holds no manager.
+func CheckIntegrity(namespace walletdb.Namespace, repair bool) ([]IntegrityProblem, error) {
+	exists, err := managerExists(namespace)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		str := "the specified address manager does not exist"
+		return nil, managerError(ErrNoExist, str, nil)
+	}
+
+	var problems []IntegrityProblem
+	check := func(tx walletdb.Tx) error {
+		c := &integrityChecker{tx: tx}
+		c.check()
+		problems = c.problems
+		if !repair {
+			return nil
+		}
+		for _, fn := range c.repairs {
+			if err := fn(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if repair {
+		err = namespace.Update(check)
+	} else {
+		err = namespace.View(check)
+	}
+	if err != nil {
+		return nil, maybeConvertDbError(err)
+	}
+	return problems, nil
+}
+
+// check runs all checks of the manager namespace.
+func (c *integrityChecker) check() {
+	if !c.checkBuckets() {
+		return
+	}
+	accounts := c.checkAccounts()
+	c.checkAccountIndexes(accounts)
+	c.checkAddresses(accounts)
+}
+
+// checkBuckets checks that all buckets of the manager exist.  Missing index
+// buckets are created when repairing, and are treated as empty buckets by the
+// following checks so the entries to restore are found.  It returns false when
+// buckets holding data which can't be rebuilt are missing, as no further checks
+// are possible then.
+func (c *integrityChecker) checkBuckets() bool {
+	root := c.tx.RootBucket()
+	ok := true
+	for _, name := range [][]byte{mainBucketName, syncBucketName,
+		acctBucketName, addrBucketName} {
+
+		if root.Bucket(name) == nil {
+			c.problem(nil, "bucket '%s' is missing", name)
+			ok = false
+		}
+	}
+	for _, name := range [][]byte{acctNameIdxBucketName,
+		acctIDIdxBucketName, addrAcctIdxBucketName, metaBucketName,
+		usedAddrBucketName, addrBirthdayBucketName} {
+
+		if root.Bucket(name) != nil {
+			continue
+		}
+		name := name
+		c.problem(func() error {
+			_, err := root.CreateBucket(name)
+			return err
+		}, "bucket '%s' is missing", name)
+	}
+	return ok
+}
+
+// get returns the value for the key in the named bucket of the manager, or nil
+// if either the bucket or the key does not exist.
+func (c *integrityChecker) get(bucketName, key []byte) []byte {
+	bucket := c.tx.RootBucket().Bucket(bucketName)
+	if bucket == nil {
+		return nil
+	}
+	return bucket.Get(key)
+}
+
+// put returns a repair func storing the key/value pair in the named bucket.
+func (c *integrityChecker) put(bucketName, key, value []byte) func() error {
+	return func() error {
+		return c.tx.RootBucket().Bucket(bucketName).Put(key, value)
+	}
+}
+
+// del returns a repair func deleting the key from the named bucket.
+func (c *integrityChecker) del(bucketName, key []byte) func() error {
+	return func() error {
+		return c.tx.RootBucket().Bucket(bucketName).Delete(key)
+	}
+}
+
+// forEach calls fn for each key/value pair in the passed bucket, skipping
+// nested buckets.  Missing buckets are treated as empty.  The passed keys and
+// values are copied so they remain valid for repairs.
+func (c *integrityChecker) forEach(bucket walletdb.Bucket, fn func(k, v []byte)) {
+	if bucket == nil {
+		return
+	}
+	bucket.ForEach(func(k, v []byte) error {
+		if v != nil {
+			fn(copyBytes(k), copyBytes(v))
+		}
+		return nil
+	})
+}
+
+// copyBytes returns a copy of the passed byte slice.
+func copyBytes(b []byte) []byte {
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
+
+// checkAccounts checks that all account rows can be deserialized and that the
+// last account counter is not below any account number, returning the rows of
+// all valid accounts keyed by their number.
+func (c *integrityChecker) checkAccounts() map[uint32]*dbBIP0044AccountRow {
+	accounts := make(map[uint32]*dbBIP0044AccountRow)
+	var lastAccount uint32
+	c.forEach(c.tx.RootBucket().Bucket(acctBucketName), func(k, v []byte) {
+		if len(k) != 4 {
+			c.problem(nil, "account key %x is malformed", k)
+			return
+		}
+		account := binary.LittleEndian.Uint32(k)
+		var row *dbBIP0044AccountRow
+		err := deserialize(func() error {
+			r, err := deserializeAccountRow(k, v)
+			if err != nil {
+				return err
+			}
+			if r.acctType != actBIP0044 {
+				return fmt.Errorf("unsupported account type '%d'",
+					r.acctType)
+			}
+			row, err = deserializeBIP0044AccountRow(k, r)
+			return err
+		})
+		if err != nil {
+			c.problem(nil, "account %d is malformed: %v", account, err)
+			return
+		}
+		accounts[account] = row
+		if account != ImportedAddrAccount && account > lastAccount {
+			lastAccount = account
+		}
+	})
+
+	val := c.get(metaBucketName, lastAccountName)
+	switch {
+	case len(val) != 4:
+		c.problem(c.put(metaBucketName, lastAccountName,
+			uint32ToBytes(lastAccount)),
+			"last account counter is missing or malformed")
+	case binary.LittleEndian.Uint32(val) < lastAccount:
+		c.problem(c.put(metaBucketName, lastAccountName,
+			uint32ToBytes(lastAccount)),
+			"last account counter %d is below account %d",
+			binary.LittleEndian.Uint32(val), lastAccount)
+	}
+
+	return accounts
+}
+
+// checkAccountIndexes checks that the account name and ID indexes map the
+// names and numbers of all accounts to each other, and that they don't hold
+// entries for accounts which do not exist.
+func (c *integrityChecker) checkAccountIndexes(accounts map[uint32]*dbBIP0044AccountRow) {
+	for account, row := range accounts {
+		id := uint32ToBytes(account)
+		name := stringToBytes(row.name)
+		if !bytes.Equal(c.get(acctIDIdxBucketName, id), name) {
+			c.problem(c.put(acctIDIdxBucketName, id, name),
+				"account ID index entry of account %d is missing "+
+					"or does not match its name '%s'", account,
+				row.name)
+		}
+		if !bytes.Equal(c.get(acctNameIdxBucketName, name), id) {
+			c.problem(c.put(acctNameIdxBucketName, name, id),
+				"account name index entry for '%s' is missing "+
+					"or does not match account %d", row.name,
+				account)
+		}
+	}
+
+	// The name index may hold aliases, such as the empty name of the
+	// default account, so only entries for unknown accounts are stale.
+	root := c.tx.RootBucket()
+	c.forEach(root.Bucket(acctNameIdxBucketName), func(k, v []byte) {
+		if len(v) != 4 || accounts[binary.LittleEndian.Uint32(v)] == nil {
+			c.problem(c.del(acctNameIdxBucketName, k),
+				"account name index entry %x refers to an "+
+					"unknown account", k)
+		}
+	})
+	c.forEach(root.Bucket(acctIDIdxBucketName), func(k, v []byte) {
+		if len(k) != 4 || accounts[binary.LittleEndian.Uint32(k)] == nil {
+			c.problem(c.del(acctIDIdxBucketName, k),
+				"account ID index entry %x refers to an "+
+					"unknown account", k)
+		}
+	})
+}
+
+// checkAddresses checks that all address rows can be deserialized, belong to
+// existing accounts, and are indexed by the address account index, as well as
+// that the next indexes of accounts are above those of their chained
+// addresses.  Entries of the address account index, the used addresses and the
+// address birthdays which refer to unknown addresses are stale.
+func (c *integrityChecker) checkAddresses(accounts map[uint32]*dbBIP0044AccountRow) {
+	root := c.tx.RootBucket()
+
+	// The next indexes needed by the chained addresses of each account.
+	nextIndexes := make(map[uint32]*[2]uint32)
+
+	// The accounts of all valid addresses keyed by the address hash, and
+	// the hashes of all addresses including malformed ones, whose index
+	// entries are not stale.
+	addrAccounts := make(map[string]uint32)
+	known := make(map[string]bool)
+	c.forEach(root.Bucket(addrBucketName), func(k, v []byte) {
+		known[string(k)] = true
+		var row *dbAddressRow
+		var chained *dbChainAddressRow
+		err := deserialize(func() error {
+			var err error
+			row, err = deserializeAddressRow(v)
+			if err != nil {
+				return err
+			}
+			switch row.addrType {
+			case adtChain:
+				chained, err = deserializeChainedAddress(row)
+			case adtImport:
+				_, err = deserializeImportedAddress(row)
+			case adtScript:
+				_, err = deserializeScriptAddress(row)
+			default:
+				err = fmt.Errorf("unsupported address type '%d'",
+					row.addrType)
+			}
+			return err
+		})
+		if err != nil {
+			c.problem(nil, "address %x is malformed: %v", k, err)
+			return
+		}
+		addrAccounts[string(k)] = row.account
+
+		if accounts[row.account] == nil {
+			c.problem(nil, "address %x belongs to unknown account %d",
+				k, row.account)
+			return
+		}
+
+		account := uint32ToBytes(row.account)
+		if !bytes.Equal(c.get(addrAcctIdxBucketName, k), account) {
+			c.problem(c.put(addrAcctIdxBucketName, k, account),
+				"address account index entry of address %x is "+
+					"missing or does not match account %d", k,
+				row.account)
+		}
+		acctIdx := root.Bucket(addrAcctIdxBucketName)
+		if acctIdx != nil {
+			acctIdx = acctIdx.Bucket(account)
+		}
+		if acctIdx == nil || acctIdx.Get(k) == nil {
+			c.problem(func() error {
+				return putAddrAccountIndex(c.tx, row.account, k)
+			}, "address %x is missing from the address index of "+
+				"account %d", k, row.account)
+		}
+
+		if chained == nil || chained.branch > internalBranch {
+			return
+		}
+		next := nextIndexes[row.account]
+		if next == nil {
+			next = new([2]uint32)
+			nextIndexes[row.account] = next
+		}
+		if chained.index >= next[chained.branch] {
+			next[chained.branch] = chained.index + 1
+		}
+	})
+
+	for account, next := range nextIndexes {
+		row := accounts[account]
+		if row.nextExternalIndex >= next[externalBranch] &&
+			row.nextInternalIndex >= next[internalBranch] {
+			continue
+		}
+		account, updated := account, *row
+		if updated.nextExternalIndex < next[externalBranch] {
+			updated.nextExternalIndex = next[externalBranch]
+		}
+		if updated.nextInternalIndex < next[internalBranch] {
+			updated.nextInternalIndex = next[internalBranch]
+		}
+		c.problem(func() error {
+			return putAccountInfo(c.tx, account,
+				updated.pubKeyEncrypted, updated.privKeyEncrypted,
+				updated.nextExternalIndex,
+				updated.nextInternalIndex, updated.name)
+		}, "next indexes of account %d are below those of its "+
+			"addresses", account)
+	}
+
+	acctIdx := root.Bucket(addrAcctIdxBucketName)
+	c.forEach(acctIdx, func(k, v []byte) {
+		if !known[string(k)] {
+			c.problem(c.del(addrAcctIdxBucketName, k),
+				"address account index entry %x refers to an "+
+					"unknown address", k)
+		}
+	})
+	if acctIdx != nil {
+		acctIdx.ForEach(func(k, v []byte) error {
+			nested := acctIdx.Bucket(k)
+			if v != nil || nested == nil {
+				return nil
+			}
+			account := copyBytes(k)
+			c.forEach(nested, func(k, v []byte) {
+				acct, ok := addrAccounts[string(k)]
+				switch {
+				case ok && bytes.Equal(uint32ToBytes(acct), account):
+					return
+				case !ok && known[string(k)]:
+					// Malformed address.
+					return
+				}
+				c.problem(func() error {
+					return c.tx.RootBucket().
+						Bucket(addrAcctIdxBucketName).
+						Bucket(account).Delete(k)
+				}, "address index of account %x holds unknown "+
+					"or foreign address %x", account, k)
+			})
+			return nil
+		})
+	}
+
+	for _, name := range [][]byte{usedAddrBucketName, addrBirthdayBucketName} {
+		name := name
+		c.forEach(root.Bucket(name), func(k, v []byte) {
+			if !known[string(k)] {
+				c.problem(c.del(name, k), "entry %x of bucket "+
+					"'%s' refers to an unknown address", k, name)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package waddrmgr_test
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/monetas/btcwallet/waddrmgr"
+	"github.com/monetas/btcwallet/walletdb"
+)
+
+// countRepairable returns the number of repairable problems.
+func countRepairable(problems []waddrmgr.IntegrityProblem) int {
+	n := 0
+	for _, p := range problems {
+		if p.Repairable {
+			n++
+		}
+	}
+	return n
+}
+
+func TestCheckIntegrity(t *testing.T) {
+	db, err := walletdb.Create("memdb")
+	if err != nil {
+		t.Fatalf("Failed to create db: %v", err)
+	}
+	defer db.Close()
+	namespace, err := db.Namespace(waddrmgrNamespaceKey)
+	if err != nil {
+		t.Fatalf("Namespace: unexpected error: %v", err)
+	}
+
+	_, err = waddrmgr.CheckIntegrity(namespace, false)
+	if !checkManagerError(t, "CheckIntegrity", err, waddrmgr.ErrNoExist) {
+		return
+	}
+
+	mgr, err := waddrmgr.Create(namespace, seed, pubPassphrase,
+		privPassphrase, &chaincfg.MainNetParams, fastScrypt)
+	if err != nil {
+		t.Fatalf("Failed to create Manager: %v", err)
+	}
+	if err := mgr.Unlock(privPassphrase); err != nil {
+		t.Fatalf("Unlock: unexpected error: %v", err)
+	}
+	account, err := mgr.NewAccount("acct1")
+	if err != nil {
+		t.Fatalf("NewAccount: unexpected error: %v", err)
+	}
+	_, err = mgr.NextExternalAddresses(waddrmgr.DefaultAccountNum, 2)
+	if err != nil {
+		t.Fatalf("NextExternalAddresses: unexpected error: %v", err)
+	}
+	_, err = mgr.NextInternalAddresses(account, 1)
+	if err != nil {
+		t.Fatalf("NextInternalAddresses: unexpected error: %v", err)
+	}
+	mgr.Close()
+
+	problems, err := waddrmgr.CheckIntegrity(namespace, false)
+	if err != nil {
+		t.Fatalf("CheckIntegrity: unexpected error: %v", err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("CheckIntegrity: unexpected problems: %v", problems)
+	}
+
+	if err := waddrmgr.TstCorruptManager(namespace); err != nil {
+		t.Fatalf("TstCorruptManager: unexpected error: %v", err)
+	}
+
+	// One problem for the account ID index entry, the last account
+	// counter, the next indexes, the used address entry and the malformed
+	// address each, and one for each of the three addresses missing from
+	// the address account index.
+	const wantProblems, wantRepairable = 8, 7
+	for i, repair := range []bool{false, false, true} {
+		problems, err := waddrmgr.CheckIntegrity(namespace, repair)
+		if err != nil {
+			t.Fatalf("CheckIntegrity #%d: unexpected error: %v", i, err)
+		}
+		if len(problems) != wantProblems ||
+			countRepairable(problems) != wantRepairable {
+			t.Fatalf("CheckIntegrity #%d: got %d problems of which "+
+				"%d are repairable, want %d and %d: %v", i,
+				len(problems), countRepairable(problems),
+				wantProblems, wantRepairable, problems)
+		}
+	}
+
+	// Only the malformed address remains after the repair.
+	problems, err = waddrmgr.CheckIntegrity(namespace, false)
+	if err != nil {
+		t.Fatalf("CheckIntegrity: unexpected error: %v", err)
+	}
+	if len(problems) != 1 || problems[0].Repairable {
+		t.Fatalf("CheckIntegrity: unexpected problems after repair: %v",
+			problems)
+	}
+
+	// The repaired manager can be used again.
+	mgr, err = waddrmgr.Open(namespace, pubPassphrase,
+		&chaincfg.MainNetParams, fastScrypt)
+	if err != nil {
+		t.Fatalf("Open: unexpected error: %v", err)
+	}
+	defer mgr.Close()
+	addrs, err := mgr.NextExternalAddresses(waddrmgr.DefaultAccountNum, 1)
+	if err != nil {
+		t.Fatalf("NextExternalAddresses: unexpected error: %v", err)
+	}
+	details, err := mgr.AddressDetails(addrs[0].Address())
+	if err != nil {
+		t.Fatalf("AddressDetails: unexpected error: %v", err)
+	}
+	if details.Index != 2 {
+		t.Errorf("NextExternalAddresses: got index %d, want 2",
+			details.Index)
+	}
+}
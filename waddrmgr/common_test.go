@@ -18,15 +18,13 @@ package waddrmgr_test
 
 import (
 	"encoding/hex"
-	"io/ioutil"
-	"os"
-	"path/filepath"
 	"testing"
 
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/monetas/btcwallet/waddrmgr"
 	"github.com/monetas/btcwallet/walletdb"
 	_ "github.com/monetas/btcwallet/walletdb/bdb"
+	_ "github.com/monetas/btcwallet/walletdb/memdb"
 )
 
 var (
@@ -122,28 +120,25 @@ func openDbNamespace(dbPath string) (walletdb.DB, walletdb.Namespace, error) {
 func setupManager(t *testing.T) (tearDownFunc func(), mgr *waddrmgr.Manager) {
 	t.Parallel()
 
-	// Create a new manager in a temp directory.
-	dirName, err := ioutil.TempDir("", "mgrtest")
+	// Create a new manager in an in-memory database.
+	db, err := walletdb.Create("memdb")
 	if err != nil {
-		t.Fatalf("Failed to create db temp dir: %v", err)
+		t.Fatalf("Failed to create db: %v", err)
 	}
-	dbPath := filepath.Join(dirName, "mgrtest.db")
-	db, namespace, err := createDbNamespace(dbPath)
+	namespace, err := db.Namespace(waddrmgrNamespaceKey)
 	if err != nil {
-		_ = os.RemoveAll(dirName)
-		t.Fatalf("createDbNamespace: unexpected error: %v", err)
+		db.Close()
+		t.Fatalf("Namespace: unexpected error: %v", err)
 	}
 	mgr, err = waddrmgr.Create(namespace, seed, pubPassphrase,
 		privPassphrase, &chaincfg.MainNetParams, fastScrypt)
 	if err != nil {
 		db.Close()
-		_ = os.RemoveAll(dirName)
 		t.Fatalf("Failed to create Manager: %v", err)
 	}
 	tearDownFunc = func() {
 		mgr.Close()
 		db.Close()
-		_ = os.RemoveAll(dirName)
 	}
 	return tearDownFunc, mgr
 }
@@ -30,7 +30,7 @@ import (
 
 const (
 	// LatestMgrVersion is the most recent manager version.
-	LatestMgrVersion = 3
+	LatestMgrVersion = 4
 )
 
 var (
@@ -209,6 +209,11 @@ var (
 
 	// Used addresses (used bucket)
 	usedAddrBucketName = []byte("usedaddrs")
+
+	// addrBirthdayBucketName is used to store the birthday block stamp of
+	// imported addresses, keyed by the address hash.  Addresses without an
+	// entry use the start block of the manager.
+	addrBirthdayBucketName = []byte("addrbirthday")
 )
 
 // uint32ToBytes converts a 32 bit unsigned integer into a 4-byte slice in
@@ -584,15 +589,15 @@ func fetchAllAccounts(tx walletdb.Tx) ([]uint32, error) {
 	bucket := tx.RootBucket().Bucket(acctBucketName)
 
 	var accounts []uint32
-	err := bucket.ForEach(func(k, v []byte) error {
+	c := bucket.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
 		// Skip buckets.
 		if v == nil {
-			return nil
+			continue
 		}
 		accounts = append(accounts, binary.LittleEndian.Uint32(k))
-		return nil
-	})
-	return accounts, err
+	}
+	return accounts, nil
 }
 
 // fetchLastAccount retreives the last account from the database.
@@ -1043,6 +1048,55 @@ func markAddressUsed(tx walletdb.Tx, addressID []byte) error {
 	return nil
 }
 
+// fetchAddressBirthday returns the birthday block stamp stored for the provided
+// address id, or nil when no birthday was recorded for the address.
+func fetchAddressBirthday(tx walletdb.Tx, addressID []byte) (*BlockStamp, error) {
+	bucket := tx.RootBucket().Bucket(addrBirthdayBucketName)
+
+	// The serialized birthday format is:
+	//   <blockheight><blockhash>
+	//
+	// 4 bytes block height + 32 bytes hash length
+	addrHash := fastsha256.Sum256(addressID)
+	buf := bucket.Get(addrHash[:])
+	if buf == nil {
+		return nil, nil
+	}
+	if len(buf) != 36 {
+		str := fmt.Sprintf("malformed birthday stored for address %x",
+			addressID)
+		return nil, managerError(ErrDatabase, str, nil)
+	}
+
+	var bs BlockStamp
+	bs.Height = int32(binary.LittleEndian.Uint32(buf[0:4]))
+	copy(bs.Hash[:], buf[4:36])
+	return &bs, nil
+}
+
+// putAddressBirthday stores the provided birthday block stamp for the address
+// id to the database.
+func putAddressBirthday(tx walletdb.Tx, addressID []byte, bs *BlockStamp) error {
+	bucket := tx.RootBucket().Bucket(addrBirthdayBucketName)
+
+	// The serialized birthday format is:
+	//   <blockheight><blockhash>
+	//
+	// 4 bytes block height + 32 bytes hash length
+	buf := make([]byte, 36)
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(bs.Height))
+	copy(buf[4:36], bs.Hash[0:32])
+
+	addrHash := fastsha256.Sum256(addressID)
+	err := bucket.Put(addrHash[:], buf)
+	if err != nil {
+		str := fmt.Sprintf("failed to store birthday for address %x",
+			addressID)
+		return managerError(ErrDatabase, str, err)
+	}
+	return nil
+}
+
 // fetchAddress loads address information for the provided address id from the
 // database.  The returned value is one of the address rows for the specific
 // address type.  The caller should use type assertions to ascertain the type.
@@ -1199,10 +1253,11 @@ func fetchAccountAddresses(tx walletdb.Tx, account uint32) ([]interface{}, error
 	}
 
 	var addrs []interface{}
-	err := bucket.ForEach(func(k, v []byte) error {
+	c := bucket.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
 		// Skip buckets.
 		if v == nil {
-			return nil
+			continue
 		}
 		addrRow, err := fetchAddressByHash(tx, k)
 		if err != nil {
@@ -1210,16 +1265,12 @@ func fetchAccountAddresses(tx walletdb.Tx, account uint32) ([]interface{}, error
 				desc := fmt.Sprintf("failed to fetch address hash '%s': %v",
 					k, merr.Description)
 				merr.Description = desc
-				return merr
+				return nil, merr
 			}
-			return err
+			return nil, maybeConvertDbError(err)
 		}
 
 		addrs = append(addrs, addrRow)
-		return nil
-	})
-	if err != nil {
-		return nil, maybeConvertDbError(err)
 	}
 
 	return addrs, nil
@@ -1233,10 +1284,11 @@ func fetchAllAddresses(tx walletdb.Tx) ([]interface{}, error) {
 	bucket := tx.RootBucket().Bucket(addrBucketName)
 
 	var addrs []interface{}
-	err := bucket.ForEach(func(k, v []byte) error {
+	c := bucket.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
 		// Skip buckets.
 		if v == nil {
-			return nil
+			continue
 		}
 
 		// Deserialize the address row first to determine the field
@@ -1246,17 +1298,13 @@ func fetchAllAddresses(tx walletdb.Tx) ([]interface{}, error) {
 			desc := fmt.Sprintf("failed to fetch address hash '%s': %v",
 				k, merr.Description)
 			merr.Description = desc
-			return merr
+			return nil, merr
 		}
 		if err != nil {
-			return err
+			return nil, maybeConvertDbError(err)
 		}
 
 		addrs = append(addrs, addrRow)
-		return nil
-	})
-	if err != nil {
-		return nil, maybeConvertDbError(err)
 	}
 
 	return addrs, nil
@@ -1606,6 +1654,13 @@ func createManagerNS(namespace walletdb.Namespace) error {
 			return managerError(ErrDatabase, str, err)
 		}
 
+		// addrBirthdayBucketName bucket was added in manager version 4
+		_, err = rootBucket.CreateBucket(addrBirthdayBucketName)
+		if err != nil {
+			str := "failed to create address birthday bucket"
+			return managerError(ErrDatabase, str, err)
+		}
+
 		if err := putLastAccount(tx, DefaultAccountNum); err != nil {
 			return err
 		}
@@ -1636,87 +1691,32 @@ func createManagerNS(namespace walletdb.Namespace) error {
 // upgradeToVersion2 upgrades the database from version 1 to version 2
 // 'usedAddrBucketName' a bucket for storing addrs flagged as marked is
 // initialized and it will be updated on the next rescan.
-func upgradeToVersion2(namespace walletdb.Namespace) error {
-	err := namespace.Update(func(tx walletdb.Tx) error {
-		currentMgrVersion := uint32(2)
-		rootBucket := tx.RootBucket()
-
-		_, err := rootBucket.CreateBucket(usedAddrBucketName)
-		if err != nil {
-			str := "failed to create used addresses bucket"
-			return managerError(ErrDatabase, str, err)
-		}
-
-		if err := putManagerVersion(tx, currentMgrVersion); err != nil {
-			return err
-		}
-
-		return nil
-	})
+func upgradeToVersion2(tx walletdb.Tx) error {
+	_, err := tx.RootBucket().CreateBucket(usedAddrBucketName)
 	if err != nil {
-		return maybeConvertDbError(err)
+		str := "failed to create used addresses bucket"
+		return managerError(ErrDatabase, str, err)
 	}
 	return nil
 }
 
-// upgradeManager upgrades the data in the provided manager namespace to newer
-// versions as neeeded.
-func upgradeManager(namespace walletdb.Namespace, pubPassPhrase []byte, config *Options) error {
-	var version uint32
-	err := namespace.View(func(tx walletdb.Tx) error {
-		var err error
-		version, err = fetchManagerVersion(tx)
-		return err
-	})
-	if err != nil {
-		str := "failed to fetch version for update"
-		return managerError(ErrDatabase, str, err)
-	}
-
-	// NOTE: There are currently no upgrades, but this is provided here as a
-	// template for how to properly do upgrades.  Each function to upgrade
-	// to the next version must include serializing the new version as a
-	// part of the same transaction so any failures in upgrades to later
-	// versions won't leave the database in an inconsistent state.  The
-	// putManagerVersion function provides a convenient mechanism for that
-	// purpose.
-	//
-	// Upgrade one version at a time so it is possible to upgrade across
-	// an aribtary number of versions without needing to write a bunch of
-	// additional code to go directly from version X to Y.
-	// if version < 2 {
-	// 	// Upgrade from version 1 to 2.
-	//	if err := upgradeToVersion2(namespace); err != nil {
-	//		return err
-	//	}
-	//
-	//	// The manager is now at version 2.
-	//	version = 2
-	// }
-	// if version < 3 {
-	// 	// Upgrade from version 2 to 3.
-	//	if err := upgradeToVersion3(namespace); err != nil {
-	//		return err
-	//	}
-	//
-	//	// The manager is now at version 3.
-	//	version = 3
-	// }
-
-	if version < 2 {
-		// Upgrade from version 1 to 2.
-		if err := upgradeToVersion2(namespace); err != nil {
-			return err
-		}
-
-		// The manager is now at version 2.
-		version = 2
-	}
-
-	if version < 3 {
-		if config.ObtainSeed == nil || config.ObtainPrivatePass == nil {
+// managerSchema returns the schema of the data in the provided manager
+// namespace.  The upgrade to version 3 requires the seed and the private
+// passphrase, which are only requested through the config when that upgrade is
+// applied.
+//
+// Each upgrade is applied in the same transaction as the update of the manager
+// version, so any failures in upgrades to later versions won't leave the
+// database in an inconsistent state.  Upgrades are applied one version at a
+// time so it is possible to upgrade across an aribtary number of versions
+// without needing to write a bunch of additional code to go directly from
+// version X to Y.
+func managerSchema(namespace walletdb.Namespace, pubPassPhrase []byte, config *Options) *walletdb.Schema {
+	migrateToVersion3 := func(tx walletdb.Tx) error {
+		if config == nil || config.ObtainSeed == nil ||
+			config.ObtainPrivatePass == nil {
 			str := "failed to obtain seed and private passphrase required for upgrade"
-			return managerError(ErrDatabase, str, err)
+			return managerError(ErrDatabase, str, nil)
 		}
 
 		seed, err := config.ObtainSeed()
@@ -1727,19 +1727,62 @@ func upgradeManager(namespace walletdb.Namespace, pubPassPhrase []byte, config *
 		if err != nil {
 			return err
 		}
-		// Upgrade from version 2 to 3.
-		if err := upgradeToVersion3(namespace, seed, privPassPhrase, pubPassPhrase); err != nil {
-			return err
+		return upgradeToVersion3(tx, namespace, seed, privPassPhrase,
+			pubPassPhrase)
+	}
+
+	return &walletdb.Schema{
+		Migrations: []walletdb.Migration{{
+			Version:     2,
+			Description: "add the used addresses bucket",
+			Migrate:     upgradeToVersion2,
+		}, {
+			Version:     3,
+			Description: "add the cointype keys and account names",
+			Migrate:     migrateToVersion3,
+		}, {
+			Version:     4,
+			Description: "add the address birthday bucket",
+			Migrate:     upgradeToVersion4,
+		}},
+		FetchVersion: fetchManagerVersion,
+		PutVersion:   putManagerVersion,
+	}
+}
+
+// upgradeManager upgrades the data in the provided manager namespace to newer
+// versions as neeeded.  The Migration field of the config, when set, controls
+// whether a backup is taken first or the upgrades are only tried.
+func upgradeManager(namespace walletdb.Namespace, pubPassPhrase []byte, config *Options) error {
+	var opts *walletdb.MigrateOptions
+	if config != nil {
+		opts = config.Migration
+	}
+
+	schema := managerSchema(namespace, pubPassPhrase, config)
+	applied, err := schema.Migrate(namespace, opts)
+	if err != nil {
+		if err == walletdb.ErrNewerVersion {
+			str := fmt.Sprintf("the manager version is newer than "+
+				"the latest supported version %d",
+				schema.LatestVersion())
+			return managerError(ErrDatabase, str, err)
 		}
+		return maybeConvertDbError(err)
+	}
 
-		// The manager is now at version 3.
-		version = 3
+	// A dry run leaves the manager at its old version, which can't be
+	// loaded.
+	if opts != nil && opts.DryRun && len(applied) != 0 {
+		str := fmt.Sprintf("dry run of %d upgrades to version %d "+
+			"succeeded", len(applied), schema.LatestVersion())
+		return managerError(ErrUpgrade, str, nil)
 	}
 
 	// Ensure the manager is upraded to the latest version.  This check is
 	// to intentionally cause a failure if the manager version is updated
 	// without writing code to handle the upgrade.
-	if version < latestMgrVersion {
+	if version := schema.LatestVersion(); version < latestMgrVersion {
 		str := fmt.Sprintf("the latest manager version is %d, but the "+
 			"current version after upgrades is only %d",
 			latestMgrVersion, version)
@@ -1754,109 +1797,110 @@ func upgradeManager(namespace walletdb.Namespace, pubPassPhrase []byte, config *
 // * acctNameIdxBucketName
 // * acctIDIdxBucketName
 // * metaBucketName
-func upgradeToVersion3(namespace walletdb.Namespace, seed, privPassPhrase, pubPassPhrase []byte) error {
-	err := namespace.Update(func(tx walletdb.Tx) error {
-		currentMgrVersion := uint32(3)
-		rootBucket := tx.RootBucket()
+func upgradeToVersion3(tx walletdb.Tx, namespace walletdb.Namespace, seed, privPassPhrase, pubPassPhrase []byte) error {
+	rootBucket := tx.RootBucket()
 
-		woMgr, err := loadManager(namespace, pubPassPhrase, &chaincfg.SimNetParams, nil)
-		if err != nil {
-			return err
-		}
-		defer woMgr.Close()
+	woMgr, err := loadManager(namespace, pubPassPhrase, &chaincfg.SimNetParams, nil)
+	if err != nil {
+		return err
+	}
+	defer woMgr.Close()
 
-		err = woMgr.Unlock(privPassPhrase)
-		if err != nil {
-			return err
-		}
+	err = woMgr.Unlock(privPassPhrase)
+	if err != nil {
+		return err
+	}
 
-		// Derive the master extended key from the seed.
-		root, err := hdkeychain.NewMaster(seed)
-		if err != nil {
-			str := "failed to derive master extended key"
-			return managerError(ErrKeyChain, str, err)
-		}
+	// Derive the master extended key from the seed.
+	root, err := hdkeychain.NewMaster(seed)
+	if err != nil {
+		str := "failed to derive master extended key"
+		return managerError(ErrKeyChain, str, err)
+	}
 
-		// Derive the cointype key according to BIP0044.
-		coinTypeKeyPriv, err := deriveCoinTypeKey(root, chaincfg.SimNetParams.HDCoinType)
-		if err != nil {
-			str := "failed to derive cointype extended key"
-			return managerError(ErrKeyChain, str, err)
-		}
+	// Derive the cointype key according to BIP0044.
+	coinTypeKeyPriv, err := deriveCoinTypeKey(root, chaincfg.SimNetParams.HDCoinType)
+	if err != nil {
+		str := "failed to derive cointype extended key"
+		return managerError(ErrKeyChain, str, err)
+	}
 
-		cryptoKeyPub := woMgr.cryptoKeyPub
-		cryptoKeyPriv := woMgr.cryptoKeyPriv
-		// Encrypt the cointype keys with the associated crypto keys.
-		coinTypeKeyPub, err := coinTypeKeyPriv.Neuter()
-		if err != nil {
-			str := "failed to convert cointype private key"
-			return managerError(ErrKeyChain, str, err)
-		}
-		coinTypePubEnc, err := cryptoKeyPub.Encrypt([]byte(coinTypeKeyPub.String()))
-		if err != nil {
-			str := "failed to encrypt cointype public key"
-			return managerError(ErrCrypto, str, err)
-		}
-		coinTypePrivEnc, err := cryptoKeyPriv.Encrypt([]byte(coinTypeKeyPriv.String()))
-		if err != nil {
-			str := "failed to encrypt cointype private key"
-			return managerError(ErrCrypto, str, err)
-		}
+	cryptoKeyPub := woMgr.cryptoKeyPub
+	cryptoKeyPriv := woMgr.cryptoKeyPriv
+	// Encrypt the cointype keys with the associated crypto keys.
+	coinTypeKeyPub, err := coinTypeKeyPriv.Neuter()
+	if err != nil {
+		str := "failed to convert cointype private key"
+		return managerError(ErrKeyChain, str, err)
+	}
+	coinTypePubEnc, err := cryptoKeyPub.Encrypt([]byte(coinTypeKeyPub.String()))
+	if err != nil {
+		str := "failed to encrypt cointype public key"
+		return managerError(ErrCrypto, str, err)
+	}
+	coinTypePrivEnc, err := cryptoKeyPriv.Encrypt([]byte(coinTypeKeyPriv.String()))
+	if err != nil {
+		str := "failed to encrypt cointype private key"
+		return managerError(ErrCrypto, str, err)
+	}
 
-		// Save the encrypted cointype keys to the database.
-		err = putCoinTypeKeys(tx, coinTypePubEnc, coinTypePrivEnc)
-		if err != nil {
-			return err
-		}
+	// Save the encrypted cointype keys to the database.
+	err = putCoinTypeKeys(tx, coinTypePubEnc, coinTypePrivEnc)
+	if err != nil {
+		return err
+	}
 
-		_, err = rootBucket.CreateBucket(acctNameIdxBucketName)
-		if err != nil {
-			str := "failed to create an account name index bucket"
-			return managerError(ErrDatabase, str, err)
-		}
+	_, err = rootBucket.CreateBucket(acctNameIdxBucketName)
+	if err != nil {
+		str := "failed to create an account name index bucket"
+		return managerError(ErrDatabase, str, err)
+	}
 
-		_, err = rootBucket.CreateBucket(acctIDIdxBucketName)
-		if err != nil {
-			str := "failed to create an account id index bucket"
-			return managerError(ErrDatabase, str, err)
-		}
+	_, err = rootBucket.CreateBucket(acctIDIdxBucketName)
+	if err != nil {
+		str := "failed to create an account id index bucket"
+		return managerError(ErrDatabase, str, err)
+	}
 
-		_, err = rootBucket.CreateBucket(metaBucketName)
-		if err != nil {
-			str := "failed to create a meta bucket"
-			return managerError(ErrDatabase, str, err)
-		}
+	_, err = rootBucket.CreateBucket(metaBucketName)
+	if err != nil {
+		str := "failed to create a meta bucket"
+		return managerError(ErrDatabase, str, err)
+	}
 
-		// Initialize metadata for all keys
-		if err := putLastAccount(tx, DefaultAccountNum); err != nil {
-			return err
-		}
+	// Initialize metadata for all keys
+	if err := putLastAccount(tx, DefaultAccountNum); err != nil {
+		return err
+	}
 
-		// Update default account indexes
-		if err := putAccountIDIndex(tx, DefaultAccountNum, DefaultAccountName); err != nil {
-			return err
-		}
-		if err := putAccountNameIndex(tx, DefaultAccountNum, DefaultAccountName); err != nil {
-			return err
-		}
-		// Update imported account indexes
-		if err := putAccountIDIndex(tx, ImportedAddrAccount, ImportedAddrAccountName); err != nil {
-			return err
-		}
-		if err := putAccountNameIndex(tx, ImportedAddrAccount, ImportedAddrAccountName); err != nil {
-			return err
-		}
+	// Update default account indexes
+	if err := putAccountIDIndex(tx, DefaultAccountNum, DefaultAccountName); err != nil {
+		return err
+	}
+	if err := putAccountNameIndex(tx, DefaultAccountNum, DefaultAccountName); err != nil {
+		return err
+	}
+	// Update imported account indexes
+	if err := putAccountIDIndex(tx, ImportedAddrAccount, ImportedAddrAccountName); err != nil {
+		return err
+	}
+	if err := putAccountNameIndex(tx, ImportedAddrAccount, ImportedAddrAccountName); err != nil {
+		return err
+	}
 
-		// Write current manager version
-		if err := putManagerVersion(tx, currentMgrVersion); err != nil {
-			return err
-		}
+	// Save "" alias for default account name for backward compat
+	return putAccountNameIndex(tx, DefaultAccountNum, "")
+}
 
-		// Save "" alias for default account name for backward compat
-		return putAccountNameIndex(tx, DefaultAccountNum, "")
-	})
+// upgradeToVersion4 upgrades the database from version 3 to version 4
+// 'addrBirthdayBucketName' a bucket for storing the birthday of imported
+// addresses is initialized.  Addresses imported before the upgrade have no
+// recorded birthday and use the start block of the manager instead.
+func upgradeToVersion4(tx walletdb.Tx) error {
+	_, err := tx.RootBucket().CreateBucket(addrBirthdayBucketName)
 	if err != nil {
-		return maybeConvertDbError(err)
+		str := "failed to create address birthday bucket"
+		return managerError(ErrDatabase, str, err)
 	}
 	return nil
 }
@@ -27,6 +27,7 @@ import (
 	"errors"
 
 	"github.com/monetas/btcwallet/snacl"
+	"github.com/monetas/btcwallet/walletdb"
 )
 
 // TstMaxRecentHashes makes the unexported maxRecentHashes constant available
@@ -37,6 +38,18 @@ var TstMaxRecentHashes = maxRecentHashes
 // for change when the tests are run.
 var TstLatestMgrVersion = &latestMgrVersion
 
+// TstDowngradeToVersion3 reverts the upgrade of the manager in the provided
+// namespace from version 3 to version 4 so it can be tested.
+func TstDowngradeToVersion3(namespace walletdb.Namespace) error {
+	return namespace.Update(func(tx walletdb.Tx) error {
+		err := tx.RootBucket().DeleteBucket(addrBirthdayBucketName)
+		if err != nil {
+			return err
+		}
+		return putManagerVersion(tx, 3)
+	})
+}
+
 // Replace the Manager.newSecretKey function with the given one and calls
 // the callback function. Afterwards the original newSecretKey
 // function will be restored.
@@ -91,3 +104,52 @@ func TstRunWithFailingCryptoKeyPriv(m *Manager, callback func()) {
 	m.cryptoKeyPriv = &failingCryptoKey{}
 	callback()
 }
+
+// TstCorruptManager breaks the invariants of the manager in the provided
+// namespace checked by CheckIntegrity.  The ID index entry of the default
+// account and the address account index entries of all addresses are deleted,
+// the last account counter and the next indexes of the default account are
+// reset, and a used address entry for an unknown address as well as a
+// malformed address are added.  Only the malformed address can't be repaired.
+func TstCorruptManager(namespace walletdb.Namespace) error {
+	return namespace.Update(func(tx walletdb.Tx) error {
+		if err := deleteAccountIDIndex(tx, DefaultAccountNum); err != nil {
+			return err
+		}
+		if err := putLastAccount(tx, DefaultAccountNum); err != nil {
+			return err
+		}
+		row, err := fetchAccountInfo(tx, DefaultAccountNum)
+		if err != nil {
+			return err
+		}
+		acct := row.(*dbBIP0044AccountRow)
+		err = putAccountInfo(tx, DefaultAccountNum, acct.pubKeyEncrypted,
+			acct.privKeyEncrypted, 0, 0, acct.name)
+		if err != nil {
+			return err
+		}
+
+		root := tx.RootBucket()
+		var hashes [][]byte
+		err = root.Bucket(addrBucketName).ForEach(func(k, v []byte) error {
+			hashes = append(hashes, append([]byte(nil), k...))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, hash := range hashes {
+			err := root.Bucket(addrAcctIdxBucketName).Delete(hash)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = root.Bucket(usedAddrBucketName).Put([]byte("unknown"), nullVal)
+		if err != nil {
+			return err
+		}
+		return root.Bucket(addrBucketName).Put([]byte("malformed"), nullVal)
+	})
+}
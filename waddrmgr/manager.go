@@ -21,6 +21,7 @@ import (
 	"crypto/sha512"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/btcsuite/btcd/btcec"
 	"github.com/btcsuite/btcd/chaincfg"
@@ -112,6 +113,11 @@ type Options struct {
 	// private passphrase from the user (or any other mechanism the caller
 	// deems fit).
 	ObtainPrivatePass ObtainUserInputFunc
+	// Migration controls how upgrades of the manager namespace are
+	// applied when opening the manager.  When nil, they are applied
+	// without a backup.  When it requests a dry run, Open fails with
+	// ErrUpgrade after checking the pending upgrades succeed.
+	Migration *walletdb.MigrateOptions
 }
 
 // defaultConfig is an instance of the Options struct initialized with default
@@ -684,6 +690,90 @@ func (m *Manager) Address(address btcutil.Address) (ManagedAddress, error) {
 	return m.loadAndCacheAddress(address)
 }
 
+// AddressBirthday returns the block stamp of the earliest block which may
+// contain transactions for the given address.  Imported keys and scripts use
+// the block stamp they were imported with.  All other addresses, and addresses
+// imported before birthdays were recorded, use the start block of the manager.
+//
+// This function will return an error if the address is not known to the
+// manager.
+func (m *Manager) AddressBirthday(address btcutil.Address) (BlockStamp, error) {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	addressID := address.ScriptAddress()
+	var bs *BlockStamp
+	err := m.namespace.View(func(tx walletdb.Tx) error {
+		if !existsAddress(tx, addressID) {
+			str := fmt.Sprintf("address %s not found",
+				address.EncodeAddress())
+			return managerError(ErrAddressNotFound, str, nil)
+		}
+
+		var err error
+		bs, err = fetchAddressBirthday(tx, addressID)
+		return err
+	})
+	if err != nil {
+		return BlockStamp{}, maybeConvertDbError(err)
+	}
+	if bs == nil {
+		return m.syncState.startBlock, nil
+	}
+	return *bs, nil
+}
+
+// AddressDetails describes how an address was added to the manager.
+type AddressDetails struct {
+	// Derived is true for addresses derived from the root key of the
+	// manager, whose BIP0044 derivation path is
+	// m/44'/<coin type>'/<Account>'/<Branch>/<Index>.  It is false for
+	// imported keys and scripts.
+	Derived bool
+	Account uint32
+	Branch  uint32
+	Index   uint32
+}
+
+// AddressDetails returns the details of the given address.
+//
+// This function will return an error if the address is not known to the
+// manager.
+func (m *Manager) AddressDetails(address btcutil.Address) (*AddressDetails, error) {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	var rowInterface interface{}
+	err := m.namespace.View(func(tx walletdb.Tx) error {
+		var err error
+		rowInterface, err = fetchAddress(tx, address.ScriptAddress())
+		return err
+	})
+	if err != nil {
+		return nil, maybeConvertDbError(err)
+	}
+
+	var details AddressDetails
+	switch row := rowInterface.(type) {
+	case *dbChainAddressRow:
+		details.Derived = true
+		details.Account = row.account
+		details.Branch = row.branch
+		details.Index = row.index
+
+	case *dbImportedAddressRow:
+		details.Account = row.account
+
+	case *dbScriptAddressRow:
+		details.Account = row.account
+
+	default:
+		str := fmt.Sprintf("unsupported address type %T", rowInterface)
+		return nil, managerError(ErrDatabase, str, nil)
+	}
+	return &details, nil
+}
+
 // AddrAccount returns the account to which the given address belongs.
 func (m *Manager) AddrAccount(address btcutil.Address) (uint32, error) {
 	var account uint32
@@ -1034,8 +1124,8 @@ func (m *Manager) ImportPrivateKey(wif *btcutil.WIF, bs *BlockStamp) (ManagedPub
 	// is before the current one.
 	updateStartBlock := bs.Height < m.syncState.startBlock.Height
 
-	// Save the new imported address to the db and update start block (if
-	// needed) in a single transaction.
+	// Save the new imported address and its birthday to the db and update
+	// start block (if needed) in a single transaction.
 	err = m.namespace.Update(func(tx walletdb.Tx) error {
 		err := putImportedAddress(tx, pubKeyHash, ImportedAddrAccount,
 			ssNone, encryptedPubKey, encryptedPrivKey)
@@ -1043,6 +1133,11 @@ func (m *Manager) ImportPrivateKey(wif *btcutil.WIF, bs *BlockStamp) (ManagedPub
 			return err
 		}
 
+		err = putAddressBirthday(tx, pubKeyHash, bs)
+		if err != nil {
+			return err
+		}
+
 		if updateStartBlock {
 			return putStartBlock(tx, bs)
 		}
@@ -1141,8 +1236,8 @@ func (m *Manager) ImportScript(script []byte, bs *BlockStamp) (ManagedScriptAddr
 		updateStartBlock = true
 	}
 
-	// Save the new imported address to the db and update start block (if
-	// needed) in a single transaction.
+	// Save the new imported address and its birthday to the db and update
+	// start block (if needed) in a single transaction.
 	err = m.namespace.Update(func(tx walletdb.Tx) error {
 		err := putScriptAddress(tx, scriptHash, ImportedAddrAccount,
 			ssNone, encryptedHash, encryptedScript)
@@ -1150,6 +1245,11 @@ func (m *Manager) ImportScript(script []byte, bs *BlockStamp) (ManagedScriptAddr
 			return err
 		}
 
+		err = putAddressBirthday(tx, scriptHash, bs)
+		if err != nil {
+			return err
+		}
+
 		if updateStartBlock {
 			return putStartBlock(tx, bs)
 		}
@@ -1382,6 +1482,37 @@ func (m *Manager) MarkUsed(address btcutil.Address) error {
 	return nil
 }
 
+// UpdateBatch returns a new batch of updates to the database namespace of the
+// manager, which commits the queued updates once maxSize of them are queued or
+// maxDelay has passed since the first of them was queued.  See walletdb.Batch
+// for details.
+func (m *Manager) UpdateBatch(maxSize int, maxDelay time.Duration) *walletdb.Batch {
+	return walletdb.NewBatch(m.namespace, maxSize, maxDelay)
+}
+
+// MarkUsedBatched is like MarkUsed, but queues the update in the passed batch
+// returned by UpdateBatch instead of committing it immediately.  The done func,
+// when not nil, is called with the result once the batch is committed.
+func (m *Manager) MarkUsedBatched(batch *walletdb.Batch, address btcutil.Address, done func(error)) {
+	addressID := address.ScriptAddress()
+	batch.Update(func(tx walletdb.Tx) error {
+		return markAddressUsed(tx, addressID)
+	}, func(err error) {
+		if err != nil {
+			err = maybeConvertDbError(err)
+		} else {
+			// Clear caches which might have stale entries for
+			// used addresses.
+			m.mtx.Lock()
+			delete(m.addrs, addrKey(addressID))
+			m.mtx.Unlock()
+		}
+		if done != nil {
+			done(err)
+		}
+	})
+}
+
 // ChainParams returns the chain parameters for this address manager.
 func (m *Manager) ChainParams() *chaincfg.Params {
 	// NOTE: No need for mutex here since the net field does not change
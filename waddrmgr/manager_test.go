@@ -414,6 +414,23 @@ func testExternalAddresses(tc *testContext) bool {
 			if !testAddress(tc, prefix, addr, &expectedAddrs[i]) {
 				return false
 			}
+
+			// Ensure the derivation path of the address is the
+			// expected one.
+			details, err := tc.manager.AddressDetails(utilAddr)
+			if err != nil {
+				tc.t.Errorf("%s AddressDetails: unexpected "+
+					"error: %v", prefix, err)
+				return false
+			}
+			if !details.Derived || details.Account != tc.account ||
+				details.Branch != 0 || details.Index != uint32(i) {
+				tc.t.Errorf("%s AddressDetails: unexpected "+
+					"details - got %+v, want account %d, "+
+					"branch 0, index %d", prefix, details,
+					tc.account, i)
+				return false
+			}
 		}
 
 		return true
@@ -745,6 +762,10 @@ func testImportPrivateKey(tc *testContext) bool {
 		{
 			name: "wif for compressed pubkey address",
 			in:   "KwdMAjGmerYanjeui5SHS7JkmpZvVipYvB2LJGU1ZxJwYvP98617",
+			blockstamp: waddrmgr.BlockStamp{
+				Height: 100,
+				Hash:   wire.ShaHash{0x01},
+			},
 			expected: expectedAddr{
 				address:     "1LoVGDgRs9hTfTNJNuXKSpywcbdvwRXpmK",
 				addressHash: hexToBytes("d9351dcbad5b8f3b8bfa2f2cdc85c28118ca9326"),
@@ -827,6 +848,23 @@ func testImportPrivateKey(tc *testContext) bool {
 				failed = true
 				continue
 			}
+
+			// Ensure the birthday the key was imported with is
+			// returned for the address.
+			bs, err := tc.manager.AddressBirthday(utilAddr)
+			if err != nil {
+				tc.t.Errorf("%s AddressBirthday: unexpected "+
+					"error: %v", taPrefix, err)
+				failed = true
+				continue
+			}
+			if bs != test.blockstamp {
+				tc.t.Errorf("%s AddressBirthday: mismatched "+
+					"birthday - got %v, want %v", taPrefix,
+					bs, test.blockstamp)
+				failed = true
+				continue
+			}
 		}
 
 		return !failed
@@ -1063,6 +1101,61 @@ func testMarkUsed(tc *testContext) bool {
 	return true
 }
 
+// testMarkUsedBatched ensures addresses marked used in a batch are flagged as
+// such once the batch is committed.
+func testMarkUsedBatched(tc *testContext) bool {
+	prefix := "MarkUsedBatched"
+	maddr, err := tc.manager.LastInternalAddress(tc.account)
+	if err != nil {
+		tc.t.Errorf("%s: unexpected error: %v", prefix, err)
+		return false
+	}
+	if tc.create {
+		// Test that initially the address is not flagged as used
+		used, err := maddr.Used()
+		if err != nil {
+			tc.t.Errorf("%s: unexpected error: %v", prefix, err)
+			return false
+		}
+		if used {
+			tc.t.Errorf("%s: address flagged used before the batch "+
+				"is committed", prefix)
+			return false
+		}
+	}
+
+	batch := tc.manager.UpdateBatch(10, 0)
+	called := false
+	tc.manager.MarkUsedBatched(batch, maddr.Address(), func(err error) {
+		called = true
+		if err != nil {
+			tc.t.Errorf("%s: unexpected error: %v", prefix, err)
+		}
+	})
+	if called {
+		tc.t.Errorf("%s: batch committed before it was flushed", prefix)
+		return false
+	}
+	if err := batch.Flush(); err != nil {
+		tc.t.Errorf("%s: unexpected error: %v", prefix, err)
+		return false
+	}
+	if !called {
+		tc.t.Errorf("%s: done func not called by Flush", prefix)
+		return false
+	}
+	used, err := maddr.Used()
+	if err != nil {
+		tc.t.Errorf("%s: unexpected error: %v", prefix, err)
+		return false
+	}
+	if !used {
+		tc.t.Errorf("%s: address not flagged used", prefix)
+		return false
+	}
+	return true
+}
+
 // testChangePassphrase ensures changes both the public and privte passphrases
 // works as intended.
 func testChangePassphrase(tc *testContext) bool {
@@ -1392,6 +1485,7 @@ func testManagerAPI(tc *testContext) {
 	testImportPrivateKey(tc)
 	testImportScript(tc)
 	testMarkUsed(tc)
+	testMarkUsedBatched(tc)
 	testChangePassphrase(tc)
 
 	// Reset default account
@@ -1822,6 +1916,71 @@ func TestManager(t *testing.T) {
 	}
 }
 
+// TestUpgradeOptions ensures that upgrades of the manager are only tried when a
+// dry run is requested and that a backup is taken before they are applied.
+//
+// NOTE: This test is not run in parallel since TestManager temporarily changes
+// the latest manager version.
+func TestUpgradeOptions(t *testing.T) {
+	db, err := walletdb.Create("memdb")
+	if err != nil {
+		t.Fatalf("Failed to create db: %v", err)
+	}
+	defer db.Close()
+	namespace, err := db.Namespace(waddrmgrNamespaceKey)
+	if err != nil {
+		t.Fatalf("Namespace: unexpected error: %v", err)
+	}
+	mgr, err := waddrmgr.Create(namespace, seed, pubPassphrase,
+		privPassphrase, &chaincfg.MainNetParams, fastScrypt)
+	if err != nil {
+		t.Fatalf("Create: unexpected error: %v", err)
+	}
+	mgr.Close()
+
+	// Revert the last upgrade so there is one to apply.
+	if err := waddrmgr.TstDowngradeToVersion3(namespace); err != nil {
+		t.Fatalf("TstDowngradeToVersion3: unexpected error: %v", err)
+	}
+
+	backups := 0
+	config := *fastScrypt
+	config.Migration = &walletdb.MigrateOptions{
+		DryRun: true,
+		Backup: func() error {
+			backups++
+			return nil
+		},
+	}
+
+	// A dry run must leave the manager at the old version, which can't be
+	// opened, and not take a backup.
+	for i := 0; i < 2; i++ {
+		_, err = waddrmgr.Open(namespace, pubPassphrase,
+			&chaincfg.MainNetParams, &config)
+		if !checkManagerError(t, "Dry run", err, waddrmgr.ErrUpgrade) {
+			return
+		}
+	}
+	if backups != 0 {
+		t.Fatalf("Open: backup taken during dry run")
+	}
+
+	// Applying the upgrade must take a backup first, and only once.
+	config.Migration.DryRun = false
+	for i := 0; i < 2; i++ {
+		mgr, err = waddrmgr.Open(namespace, pubPassphrase,
+			&chaincfg.MainNetParams, &config)
+		if err != nil {
+			t.Fatalf("Open: unexpected error: %v", err)
+		}
+		mgr.Close()
+	}
+	if backups != 1 {
+		t.Fatalf("Open: got %d backups, want 1", backups)
+	}
+}
+
 // TestEncryptDecryptErrors ensures that errors which occur while encrypting and
 // decrypting data return the expected errors.
 func TestEncryptDecryptErrors(t *testing.T) {
@@ -0,0 +1,90 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package waddrmgr
+
+import (
+	"github.com/monetas/btcwallet/walletdb"
+)
+
+// ManagerStats summarizes the contents of an address manager.
+type ManagerStats struct {
+	// Version is the version of the data stored in the manager namespace.
+	Version uint32
+
+	// Accounts is the number of accounts created in the manager, not
+	// counting the account of imported addresses.
+	Accounts int
+
+	// DerivedAddresses, ImportedAddresses and ScriptAddresses are the
+	// numbers of addresses of each type stored in the manager.
+	DerivedAddresses  int
+	ImportedAddresses int
+	ScriptAddresses   int
+
+	// StartBlock is the birthday of the manager, the earliest block which
+	// may contain transactions relevant to its addresses.
+	StartBlock BlockStamp
+
+	// SyncedTo is the block the manager is synced through.
+	SyncedTo BlockStamp
+}
+
+// Stats returns a summary of the contents of the manager.  The addresses are
+// counted from the database rows, so this does not require the manager to be
+// unlocked.
+func (m *Manager) Stats() (*ManagerStats, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	stats := &ManagerStats{
+		StartBlock: m.syncState.startBlock,
+		SyncedTo:   m.syncState.syncedTo,
+	}
+	err := m.namespace.View(func(tx walletdb.Tx) error {
+		var err error
+		stats.Version, err = fetchManagerVersion(tx)
+		if err != nil {
+			return err
+		}
+
+		accounts, err := fetchAllAccounts(tx)
+		if err != nil {
+			return err
+		}
+		stats.Accounts = len(accounts)
+
+		rows, err := fetchAllAddresses(tx)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			switch row.(type) {
+			case *dbChainAddressRow:
+				stats.DerivedAddresses++
+			case *dbImportedAddressRow:
+				stats.ImportedAddresses++
+			case *dbScriptAddressRow:
+				stats.ScriptAddresses++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, maybeConvertDbError(err)
+	}
+	return stats, nil
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package waddrmgr_test
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/monetas/btcwallet/waddrmgr"
+	"github.com/monetas/btcwallet/walletdb"
+)
+
+func TestStats(t *testing.T) {
+	db, err := walletdb.Create("memdb")
+	if err != nil {
+		t.Fatalf("Failed to create db: %v", err)
+	}
+	defer db.Close()
+	namespace, err := db.Namespace(waddrmgrNamespaceKey)
+	if err != nil {
+		t.Fatalf("Namespace: unexpected error: %v", err)
+	}
+	mgr, err := waddrmgr.Create(namespace, seed, pubPassphrase,
+		privPassphrase, &chaincfg.MainNetParams, fastScrypt)
+	if err != nil {
+		t.Fatalf("Failed to create Manager: %v", err)
+	}
+	defer mgr.Close()
+
+	before, err := mgr.Stats()
+	if err != nil {
+		t.Fatalf("Stats: unexpected error: %v", err)
+	}
+	if before.Version != waddrmgr.LatestMgrVersion {
+		t.Errorf("Stats: version %d, want %d", before.Version,
+			waddrmgr.LatestMgrVersion)
+	}
+	if before.SyncedTo != mgr.SyncedTo() {
+		t.Errorf("Stats: synced to %v, want %v", before.SyncedTo,
+			mgr.SyncedTo())
+	}
+	if before.StartBlock.Height > before.SyncedTo.Height {
+		t.Errorf("Stats: start block %v after synced block %v",
+			before.StartBlock, before.SyncedTo)
+	}
+
+	if err := mgr.Unlock(privPassphrase); err != nil {
+		t.Fatalf("Unlock: unexpected error: %v", err)
+	}
+	account, err := mgr.NewAccount("acct1")
+	if err != nil {
+		t.Fatalf("NewAccount: unexpected error: %v", err)
+	}
+	_, err = mgr.NextExternalAddresses(waddrmgr.DefaultAccountNum, 2)
+	if err != nil {
+		t.Fatalf("NextExternalAddresses: unexpected error: %v", err)
+	}
+	_, err = mgr.NextInternalAddresses(account, 1)
+	if err != nil {
+		t.Fatalf("NextInternalAddresses: unexpected error: %v", err)
+	}
+
+	after, err := mgr.Stats()
+	if err != nil {
+		t.Fatalf("Stats: unexpected error: %v", err)
+	}
+	if after.Accounts != before.Accounts+1 {
+		t.Errorf("Stats: %d accounts, want %d", after.Accounts,
+			before.Accounts+1)
+	}
+	if after.DerivedAddresses != before.DerivedAddresses+3 {
+		t.Errorf("Stats: %d derived addresses, want %d",
+			after.DerivedAddresses, before.DerivedAddresses+3)
+	}
+	if after.ImportedAddresses != before.ImportedAddresses ||
+		after.ScriptAddresses != before.ScriptAddresses {
+		t.Errorf("Stats: %d imported and %d script addresses, want "+
+			"%d and %d", after.ImportedAddresses,
+			after.ScriptAddresses, before.ImportedAddresses,
+			before.ScriptAddresses)
+	}
+}
@@ -0,0 +1,388 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package wallet
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/monetas/btcwallet/rename"
+	"github.com/monetas/btcwallet/txstore"
+	"github.com/monetas/btcwallet/walletdb"
+	"github.com/monetas/btcwallet/walletdb/encdb"
+)
+
+const (
+	// backupVersion is the version of the backup archive format.
+	backupVersion = 1
+
+	// backupManifestName, backupDbName and backupTxStoreName are the
+	// names of the files of a backup archive.  The manifest is always the
+	// first file of the archive.
+	backupManifestName = "manifest.json"
+	backupDbName       = "wallet.db"
+	backupTxStoreName  = txstore.Filename
+)
+
+// ErrInvalidBackup describes an error where a backup archive is malformed or
+// its contents don't match its manifest.
+var ErrInvalidBackup = errors.New("invalid wallet backup")
+
+// BackupManifest describes the contents of a wallet backup archive.
+type BackupManifest struct {
+	Version int                `json:"version"`
+	Network string             `json:"network"`
+	Created time.Time          `json:"created"`
+	Files   []BackupFileDigest `json:"files"`
+}
+
+// BackupFileDigest describes a file of a wallet backup archive.
+type BackupFileDigest struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// newBackupFileDigest returns the digest of the file with the passed name and
+// contents.
+func newBackupFileDigest(name string, contents []byte) BackupFileDigest {
+	sum := sha256.Sum256(contents)
+	return BackupFileDigest{
+		Name:   name,
+		Size:   int64(len(contents)),
+		SHA256: hex.EncodeToString(sum[:]),
+	}
+}
+
+// Backup writes an archive of the wallet database and transaction store to a
+// new file at the passed path.  The wallet keeps running while the backup is
+// taken: the database is copied while the transaction store can't be
+// modified, so the archive holds a consistent state of both.
+func (w *Wallet) Backup(path string) error {
+	var dbBuf, txBuf bytes.Buffer
+	_, err := w.TxStore.Snapshot(&txBuf, func() error {
+		return w.db.Copy(&dbBuf)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Write the archive to a temporary file next to the destination so
+	// that it can be atomically moved into place once complete.
+	fi, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	fiPath := fi.Name()
+	err = writeBackup(fi, w.chainParams, time.Now(), dbBuf.Bytes(),
+		txBuf.Bytes())
+	if err == nil {
+		err = fi.Sync()
+	}
+	fi.Close()
+	if err == nil {
+		err = rename.Atomic(fiPath, path)
+	}
+	if err != nil {
+		_ = os.Remove(fiPath)
+		return err
+	}
+
+	log.Infof("Wrote wallet backup to %s", path)
+	return nil
+}
+
+// writeBackup writes a backup archive of the passed serialized database and
+// transaction store to w.
+func writeBackup(w io.Writer, params *chaincfg.Params, created time.Time,
+	db, txs []byte) error {
+
+	manifest := BackupManifest{
+		Version: backupVersion,
+		Network: params.Name,
+		Created: created.UTC(),
+		Files: []BackupFileDigest{
+			newBackupFileDigest(backupDbName, db),
+			newBackupFileDigest(backupTxStoreName, txs),
+		},
+	}
+	manifestBytes, err := json.MarshalIndent(&manifest, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	files := []struct {
+		name     string
+		contents []byte
+	}{
+		{backupManifestName, manifestBytes},
+		{backupDbName, db},
+		{backupTxStoreName, txs},
+	}
+	for _, f := range files {
+		hdr := &tar.Header{
+			Name:    f.name,
+			Mode:    0600,
+			Size:    int64(len(f.contents)),
+			ModTime: manifest.Created,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(f.contents); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// readBackup reads a backup archive from r and returns its manifest and files,
+// keyed by name.  ErrInvalidBackup is returned when the archive is malformed,
+// holds files not described by the manifest, or misses some of them.
+func readBackup(r io.Reader) (*BackupManifest, map[string][]byte, error) {
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%v: %s", ErrInvalidBackup,
+			fmt.Sprintf(format, args...))
+	}
+
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != backupManifestName {
+		return nil, nil, invalid("missing manifest")
+	}
+	var manifest BackupManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, nil, invalid("malformed manifest: %v", err)
+	}
+	if manifest.Version != backupVersion {
+		return nil, nil, invalid("unsupported version %d",
+			manifest.Version)
+	}
+	digests := make(map[string]BackupFileDigest, len(manifest.Files))
+	for _, d := range manifest.Files {
+		digests[d.Name] = d
+	}
+	for _, name := range []string{backupDbName, backupTxStoreName} {
+		if _, ok := digests[name]; !ok {
+			return nil, nil, invalid("manifest does not list %s",
+				name)
+		}
+	}
+
+	files := make(map[string][]byte, len(digests))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, invalid("%v", err)
+		}
+		digest, ok := digests[hdr.Name]
+		if !ok {
+			return nil, nil, invalid("unexpected file %s", hdr.Name)
+		}
+		if _, ok := files[hdr.Name]; ok {
+			return nil, nil, invalid("duplicate file %s", hdr.Name)
+		}
+		contents, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, nil, invalid("%v", err)
+		}
+		if newBackupFileDigest(hdr.Name, contents) != digest {
+			return nil, nil, invalid("checksum mismatch for %s",
+				hdr.Name)
+		}
+		files[hdr.Name] = contents
+	}
+	for name := range digests {
+		if _, ok := files[name]; !ok {
+			return nil, nil, invalid("missing file %s", name)
+		}
+	}
+	return &manifest, files, nil
+}
+
+// RestoreBackup replaces the wallet database at dbPath and the transaction
+// store in the same directory with the contents of the backup archive at
+// archivePath.  The archive is fully validated before any of the live files
+// are replaced: its files must match the checksums of its manifest, it must
+// be for the network described by params, and both the database and the
+// transaction store must be readable.  Encrypted databases and transaction
+// stores are decrypted with pubPass to check them.  The wallet must not be
+// running.
+func RestoreBackup(archivePath, dbPath string, pubPass []byte, params *chaincfg.Params) error {
+	fi, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	manifest, files, err := readBackup(fi)
+	fi.Close()
+	if err != nil {
+		return err
+	}
+	if manifest.Network != params.Name {
+		return fmt.Errorf("%v: backup is for the %s network, not %s",
+			ErrInvalidBackup, manifest.Network, params.Name)
+	}
+
+	// Write both files next to the live ones and make sure they can be
+	// read before moving anything into place.
+	dir := filepath.Dir(dbPath)
+	tmpDbPath, err := writeTempFile(dir, filepath.Base(dbPath),
+		files[backupDbName])
+	if err != nil {
+		return err
+	}
+	tmpTxsPath, err := writeTempFile(dir, backupTxStoreName,
+		files[backupTxStoreName])
+	if err != nil {
+		_ = os.Remove(tmpDbPath)
+		return err
+	}
+	err = checkBackupFiles(tmpDbPath, files[backupTxStoreName], pubPass)
+	if err != nil {
+		_ = os.Remove(tmpDbPath)
+		_ = os.Remove(tmpTxsPath)
+		return err
+	}
+	err = moveRestoredFiles(tmpDbPath, dbPath, tmpTxsPath,
+		filepath.Join(dir, backupTxStoreName))
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Restored wallet backup created %v from %s",
+		manifest.Created, archivePath)
+	return nil
+}
+
+// moveRestoredFiles moves the restored database and transaction store at
+// tmpDbPath and tmpTxsPath into place at dbPath and txsPath.  The transaction
+// store is replaced first: a newer store next to an older database only holds
+// transactions which are found again when rescanning, while the reverse would
+// miss transactions.  The live transaction store is moved aside beforehand and
+// put back if the database can't be replaced, so that a failure leaves the
+// live files as they were.  The temporary files are removed on failure.
+func moveRestoredFiles(tmpDbPath, dbPath, tmpTxsPath, txsPath string) error {
+	oldTxsPath := fmt.Sprintf("%s.%d.old", txsPath, time.Now().Unix())
+	hadTxs := false
+	_, err := os.Stat(txsPath)
+	switch {
+	case err == nil:
+		hadTxs = true
+		err = rename.Atomic(txsPath, oldTxsPath)
+	case os.IsNotExist(err):
+		err = nil
+	}
+	if err != nil {
+		_ = os.Remove(tmpDbPath)
+		_ = os.Remove(tmpTxsPath)
+		return err
+	}
+
+	// putBack restores the live transaction store once the restored one
+	// has been removed.
+	putBack := func(err error) error {
+		if !hadTxs {
+			return err
+		}
+		if rerr := rename.Atomic(oldTxsPath, txsPath); rerr != nil {
+			return fmt.Errorf("%v (the previous transaction store "+
+				"could not be moved back from %s: %v)", err,
+				oldTxsPath, rerr)
+		}
+		return err
+	}
+
+	if err := rename.Atomic(tmpTxsPath, txsPath); err != nil {
+		_ = os.Remove(tmpDbPath)
+		_ = os.Remove(tmpTxsPath)
+		return putBack(err)
+	}
+	if err := rename.Atomic(tmpDbPath, dbPath); err != nil {
+		_ = os.Remove(tmpDbPath)
+		_ = os.Remove(txsPath)
+		return putBack(err)
+	}
+	if hadTxs {
+		_ = os.Remove(oldTxsPath)
+	}
+	return nil
+}
+
+// checkBackupFiles makes sure the database at dbPath and the serialized
+// transaction store of a backup can be read.  When the database is encrypted,
+// it is opened with pubPass and its cipher decrypts the transaction store.
+func checkBackupFiles(dbPath string, txs []byte, pubPass []byte) error {
+	db, err := walletdb.Open("bdb", dbPath)
+	if err != nil {
+		return fmt.Errorf("%v: unreadable database: %v",
+			ErrInvalidBackup, err)
+	}
+	encrypted, err := encdb.IsEncrypted(db)
+	if err == nil && encrypted {
+		var encDb walletdb.DB
+		encDb, err = walletdb.Open("encdb", db, pubPass)
+		if err == nil {
+			db = encDb
+		}
+	}
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("%v: unreadable database: %v",
+			ErrInvalidBackup, err)
+	}
+	_, err = txstore.Deserialize(txs, TxStoreCipher(db))
+	db.Close()
+	if err != nil {
+		return fmt.Errorf("%v: unreadable transaction store: %v",
+			ErrInvalidBackup, err)
+	}
+	return nil
+}
+
+// writeTempFile writes the passed contents to a new temporary file in dir and
+// returns its path.
+func writeTempFile(dir, prefix string, contents []byte) (string, error) {
+	fi, err := ioutil.TempFile(dir, prefix)
+	if err != nil {
+		return "", err
+	}
+	_, err = fi.Write(contents)
+	if err == nil {
+		err = fi.Sync()
+	}
+	fi.Close()
+	if err != nil {
+		_ = os.Remove(fi.Name())
+		return "", err
+	}
+	return fi.Name(), nil
+}
@@ -0,0 +1,232 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package wallet
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/monetas/btcwallet/txstore"
+	"github.com/monetas/btcwallet/walletdb"
+	_ "github.com/monetas/btcwallet/walletdb/bdb"
+)
+
+func TestBackupRoundTrip(t *testing.T) {
+	db, txs := []byte("database"), []byte("transactions")
+	created := time.Unix(1425000000, 0).UTC()
+
+	var buf bytes.Buffer
+	err := writeBackup(&buf, &chaincfg.TestNet3Params, created, db, txs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, files, err := readBackup(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if manifest.Network != chaincfg.TestNet3Params.Name {
+		t.Errorf("Wrong network; got %v, want %v", manifest.Network,
+			chaincfg.TestNet3Params.Name)
+	}
+	if !manifest.Created.Equal(created) {
+		t.Errorf("Wrong creation time; got %v, want %v",
+			manifest.Created, created)
+	}
+	want := map[string][]byte{backupDbName: db, backupTxStoreName: txs}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("Wrong files; got %q, want %q", files, want)
+	}
+}
+
+func TestReadBackupInvalid(t *testing.T) {
+	var valid bytes.Buffer
+	err := writeBackup(&valid, &chaincfg.MainNetParams, time.Now(),
+		[]byte("database"), []byte("transactions"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rewrite copies the valid archive, passing each file through fn,
+	// which may change its contents or drop it by returning nil.
+	rewrite := func(fn func(name string, contents []byte) []byte) *bytes.Buffer {
+		var buf bytes.Buffer
+		tr := tar.NewReader(bytes.NewReader(valid.Bytes()))
+		tw := tar.NewWriter(&buf)
+		for {
+			hdr, err := tr.Next()
+			if err != nil {
+				break
+			}
+			var contents bytes.Buffer
+			contents.ReadFrom(tr)
+			newContents := fn(hdr.Name, contents.Bytes())
+			if newContents == nil {
+				continue
+			}
+			hdr.Size = int64(len(newContents))
+			tw.WriteHeader(hdr)
+			tw.Write(newContents)
+		}
+		tw.Close()
+		return &buf
+	}
+
+	tests := []struct {
+		name    string
+		archive *bytes.Buffer
+	}{
+		{
+			name: "corrupted database",
+			archive: rewrite(func(name string, c []byte) []byte {
+				if name == backupDbName {
+					return []byte("datab4se")
+				}
+				return c
+			}),
+		},
+		{
+			name: "missing transaction store",
+			archive: rewrite(func(name string, c []byte) []byte {
+				if name == backupTxStoreName {
+					return nil
+				}
+				return c
+			}),
+		},
+		{
+			name: "missing manifest",
+			archive: rewrite(func(name string, c []byte) []byte {
+				if name == backupManifestName {
+					return nil
+				}
+				return c
+			}),
+		},
+		{
+			name:    "truncated archive",
+			archive: bytes.NewBuffer(valid.Bytes()[:valid.Len()/2]),
+		},
+	}
+	for _, test := range tests {
+		if _, _, err := readBackup(test.archive); err == nil {
+			t.Errorf("%s: no error reading invalid backup", test.name)
+		}
+	}
+}
+
+// writeTestArchive writes a backup archive holding an empty database and
+// transaction store to a new file in dir and returns its path along with the
+// serialized transaction store.
+func writeTestArchive(t *testing.T, dir string) (string, []byte) {
+	srcDbPath := filepath.Join(dir, "src.db")
+	db, err := walletdb.Create("bdb", srcDbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	dbBytes, err := ioutil.ReadFile(srcDbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var txs bytes.Buffer
+	if _, err := txstore.New(dir).WriteTo(&txs); err != nil {
+		t.Fatal(err)
+	}
+
+	archivePath := filepath.Join(dir, "backup.tar")
+	fi, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fi.Close()
+	err = writeBackup(fi, &chaincfg.TestNet3Params, time.Now(), dbBytes,
+		txs.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return archivePath, txs.Bytes()
+}
+
+func TestRestoreBackupFailureKeepsTxStore(t *testing.T) {
+	archiveDir, err := ioutil.TempDir("", "backup_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(archiveDir)
+	archivePath, restoredTxs := writeTestArchive(t, archiveDir)
+
+	netDir, err := ioutil.TempDir("", "backup_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(netDir)
+	dbPath := filepath.Join(netDir, backupDbName)
+	txsPath := filepath.Join(netDir, backupTxStoreName)
+	liveTxs := []byte("live transactions")
+	if err := ioutil.WriteFile(txsPath, liveTxs, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A directory in place of the database makes moving the restored
+	// database into place fail after the transaction store was replaced.
+	if err := os.MkdirAll(filepath.Join(dbPath, "keep"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	err = RestoreBackup(archivePath, dbPath, nil, &chaincfg.TestNet3Params)
+	if err == nil {
+		t.Fatal("RestoreBackup succeeded with the database path taken")
+	}
+	checkDir := func(wantTxs []byte) {
+		if got, err := ioutil.ReadFile(txsPath); err != nil ||
+			!bytes.Equal(got, wantTxs) {
+			t.Fatalf("Wrong transaction store; got %q (error %v), "+
+				"want %q", got, err, wantTxs)
+		}
+		fis, err := ioutil.ReadDir(netDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, fi := range fis {
+			names = append(names, fi.Name())
+		}
+		want := []string{backupTxStoreName, backupDbName}
+		if !reflect.DeepEqual(names, want) {
+			t.Fatalf("Wrong files left behind; got %v, want %v",
+				names, want)
+		}
+	}
+	checkDir(liveTxs)
+
+	// Once the database path is free, both files are replaced.
+	if err := os.RemoveAll(dbPath); err != nil {
+		t.Fatal(err)
+	}
+	err = RestoreBackup(archivePath, dbPath, nil, &chaincfg.TestNet3Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDir(restoredTxs)
+}
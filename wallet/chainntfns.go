@@ -17,14 +17,38 @@
 package wallet
 
 import (
+	"time"
+
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcutil"
 	"github.com/monetas/btcwallet/chain"
 	"github.com/monetas/btcwallet/txstore"
 	"github.com/monetas/btcwallet/waddrmgr"
+	"github.com/monetas/btcwallet/walletdb"
+)
+
+const (
+	// usedAddrBatchSize is the maximum number of addresses marked used by
+	// chain notifications which are committed together.
+	usedAddrBatchSize = 1000
+
+	// usedAddrBatchDelay is the maximum time addresses marked used by
+	// chain notifications wait to be committed.
+	usedAddrBatchDelay = time.Second
 )
 
 func (w *Wallet) handleChainNotifications() {
+	// Addresses are marked used in batches, as the results of rescans
+	// may include thousands of transactions.  The batch is flushed before
+	// passing on rescan progress, so addresses are marked used before
+	// rescans are checkpointed, and before changes of the chain tip.
+	usedAddrs := w.Manager.UpdateBatch(usedAddrBatchSize,
+		usedAddrBatchDelay)
+	flush := func() {
+		// Errors are logged per address by markAddrsUsed.
+		usedAddrs.Flush()
+	}
+
 	sync := func(w *Wallet) {
 		// At the moment there is no recourse if the rescan fails for
 		// some reason, however, the wallet will not be marked synced
@@ -42,17 +66,20 @@ func (w *Wallet) handleChainNotifications() {
 		case chain.ClientConnected:
 			go sync(w)
 		case chain.BlockConnected:
+			flush()
 			w.connectBlock(waddrmgr.BlockStamp(n))
 		case chain.BlockDisconnected:
+			flush()
 			err = w.disconnectBlock(waddrmgr.BlockStamp(n))
 		case chain.RecvTx:
-			err = w.addReceivedTx(n.Tx, n.Block)
+			err = w.addReceivedTx(n.Tx, n.Block, usedAddrs)
 		case chain.RedeemingTx:
-			err = w.addRedeemingTx(n.Tx, n.Block)
+			err = w.addRedeemingTx(n.Tx, n.Block, usedAddrs)
 
 		// The following are handled by the wallet's rescan
 		// goroutines, so just pass them there.
 		case *chain.RescanProgress, *chain.RescanFinished:
+			flush()
 			w.rescanNotifications <- n
 		}
 		if err != nil {
@@ -60,6 +87,7 @@ func (w *Wallet) handleChainNotifications() {
 				"notification: %v", err)
 		}
 	}
+	flush()
 	w.wg.Done()
 }
 
@@ -79,6 +107,7 @@ func (w *Wallet) connectBlock(bs waddrmgr.BlockStamp) {
 	w.notifyConnectedBlock(bs)
 
 	w.notifyBalances(bs.Height)
+	w.checkConfirmations(bs)
 }
 
 // disconnectBlock handles a chain server reorganize by rolling back all
@@ -90,11 +119,13 @@ func (w *Wallet) disconnectBlock(bs waddrmgr.BlockStamp) error {
 	}
 
 	// Disconnect the last seen block from the manager if it matches the
-	// removed block.
+	// removed block.  The new chain tip is the previous block, if known.
+	tip := waddrmgr.BlockStamp{Height: bs.Height - 1}
 	iter := w.Manager.NewIterateRecentBlocks()
 	if iter != nil && iter.BlockStamp().Hash == bs.Hash {
 		if iter.Prev() {
 			prev := iter.BlockStamp()
+			tip = prev
 			w.Manager.SetSyncedTo(&prev)
 			err := w.TxStore.Rollback(prev.Height)
 			if err != nil {
@@ -112,11 +143,15 @@ func (w *Wallet) disconnectBlock(bs waddrmgr.BlockStamp) error {
 	w.notifyDisconnectedBlock(bs)
 
 	w.notifyBalances(bs.Height - 1)
+	w.checkConfirmations(tip)
 
 	return nil
 }
 
-func (w *Wallet) addReceivedTx(tx *btcutil.Tx, block *txstore.Block) error {
+// addReceivedTx inserts the notified transaction paying to wallet addresses
+// as credits, and queues marking the credited addresses used in the passed
+// batch.
+func (w *Wallet) addReceivedTx(tx *btcutil.Tx, block *txstore.Block, usedAddrs *walletdb.Batch) error {
 	// For every output, if it pays to a wallet address, insert the
 	// transaction into the store (possibly moving it from unconfirmed to
 	// confirmed), and add a credit record if one does not already exist.
@@ -150,13 +185,17 @@ func (w *Wallet) addReceivedTx(tx *btcutil.Tx, block *txstore.Block) error {
 			if txr.HasCredit(txOutIdx) {
 				continue
 			}
-			_, err := txr.AddCredit(uint32(txOutIdx), false)
+			credit, err := txr.AddCredit(uint32(txOutIdx), false)
 			if err != nil {
 				return err
 			}
 			w.TxStore.MarkDirty()
+			w.notifyCreditHandlers(credit)
 		}
 	}
+	if txInserted {
+		w.markAddrsUsed(txr, usedAddrs)
+	}
 
 	bs, err := w.chainSvr.BlockStamp()
 	if err == nil {
@@ -167,8 +206,10 @@ func (w *Wallet) addReceivedTx(tx *btcutil.Tx, block *txstore.Block) error {
 }
 
 // addRedeemingTx inserts the notified spending transaction as a debit and
-// schedules the transaction store for a future file write.
-func (w *Wallet) addRedeemingTx(tx *btcutil.Tx, block *txstore.Block) error {
+// schedules the transaction store for a future file write.  The addresses
+// credited by the transaction are queued to be marked used in the passed
+// batch.
+func (w *Wallet) addRedeemingTx(tx *btcutil.Tx, block *txstore.Block, usedAddrs *walletdb.Batch) error {
 	txr, err := w.TxStore.InsertTx(tx, block)
 	if err != nil {
 		return err
@@ -176,9 +217,7 @@ func (w *Wallet) addRedeemingTx(tx *btcutil.Tx, block *txstore.Block) error {
 	if _, err := txr.AddDebits(); err != nil {
 		return err
 	}
-	if err := w.markAddrsUsed(txr); err != nil {
-		return err
-	}
+	w.markAddrsUsed(txr, usedAddrs)
 
 	bs, err := w.chainSvr.BlockStamp()
 	if err == nil {
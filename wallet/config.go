@@ -24,10 +24,15 @@ import (
 )
 
 // Config is a structure used to initialize a Wallet
-// All values are required for successfully opening a Wallet
+// All values but ReadOnly are required for successfully opening a Wallet
 type Config struct {
 	ChainParams *chaincfg.Params
 	Db          *walletdb.DB
 	TxStore     *txstore.Store
 	Waddrmgr    *waddrmgr.Manager
+
+	// ReadOnly opens the wallet without modifying it.  The database must
+	// only allow read-only transactions, and the wallet does not write the
+	// transaction store or sync with the chain server once started.
+	ReadOnly bool
 }
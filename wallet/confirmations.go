@@ -0,0 +1,492 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/monetas/btcwallet/txstore"
+	"github.com/monetas/btcwallet/waddrmgr"
+	"github.com/monetas/btcwallet/walletdb"
+)
+
+var (
+	// ErrConfWatchNotFound describes an error where a confirmation watch
+	// could not be found by its ID.
+	ErrConfWatchNotFound = errors.New("confirmation watch not found")
+
+	// ErrInvalidConfTarget describes an error where a confirmation watch
+	// is registered with a target of less than one confirmation.
+	ErrInvalidConfTarget = errors.New("confirmation target must be " +
+		"positive")
+)
+
+// ConfirmationWatch describes interest in the confirmation depth of wallet
+// transactions.  Exactly one of TxHash and Address is set.  When TxHash is
+// set, only the transaction with that hash is watched.  When Address is set,
+// every wallet transaction with an output paying to the address is watched.
+type ConfirmationWatch struct {
+	ID      uint64
+	TxHash  *wire.ShaHash
+	Address btcutil.Address
+	Target  int32
+}
+
+// ConfirmationNotification is sent whenever a watched transaction reaches the
+// confirmation target of a watch (Reached is true), or when a reorganize
+// drops a transaction which previously reached the target below it (Reached
+// is false).
+type ConfirmationNotification struct {
+	WatchID       uint64
+	TxHash        wire.ShaHash
+	Confirmations int32
+	Target        int32
+	Reached       bool
+	Block         waddrmgr.BlockStamp
+}
+
+// confWatchPruneDepth is the number of blocks past the target of a watch after
+// which transactions are no longer tracked by the watch.  Reorganizations
+// deeper than this are not expected, so such transactions are not notified
+// again.
+const confWatchPruneDepth = 100
+
+// confWatch is a registered confirmation watch and the hashes of all matching
+// transactions which have already been notified as reaching the target.
+//
+// Transactions mined at or below the settled height have more than
+// confWatchPruneDepth confirmations past the target and are no longer tracked,
+// which keeps the set of reached transactions from growing with the history of
+// watched addresses.  Transactions found later in such blocks, by a rescan for
+// example, are not notified either.
+//
+// heights maps the hashes of the matching transactions which are not settled,
+// whether or not they reached the target, to the height they were last seen
+// at, or -1 if they were unmined.  It lets the watch be evaluated without
+// reading the whole transaction store.  It is not saved, and is rebuilt from
+// the store by trackConfRecords.
+type confWatch struct {
+	ConfirmationWatch
+	settled int32
+	reached map[wire.ShaHash]struct{}
+	heights map[wire.ShaHash]int32
+}
+
+// settledHeight returns the height at or below which transactions are settled
+// for the watch with the chain ending at the given height.
+func (cw *confWatch) settledHeight(chainHeight int32) int32 {
+	return chainHeight - (cw.Target - 1) - confWatchPruneDepth
+}
+
+// confRecords indexes the transaction records matching any of the
+// confirmation watches by transaction hash and by encoded address.
+type confRecords struct {
+	byHash map[wire.ShaHash]*txstore.TxRecord
+	byAddr map[string][]*txstore.TxRecord
+}
+
+// indexConfRecords indexes the passed records which match any of the passed
+// watches.  The records are read only once for all watches.
+func (w *Wallet) indexConfRecords(watches []*confWatch,
+	records []*txstore.TxRecord) *confRecords {
+
+	idx := &confRecords{
+		byHash: make(map[wire.ShaHash]*txstore.TxRecord),
+		byAddr: make(map[string][]*txstore.TxRecord),
+	}
+	hashes := make(map[wire.ShaHash]struct{})
+	addrs := make(map[string]struct{})
+	for _, cw := range watches {
+		if cw.TxHash != nil {
+			hashes[*cw.TxHash] = struct{}{}
+		} else {
+			addrs[cw.Address.EncodeAddress()] = struct{}{}
+		}
+	}
+
+	for _, r := range records {
+		hash := *r.Tx().Sha()
+		if _, ok := hashes[hash]; ok {
+			idx.byHash[hash] = r
+		}
+		if len(addrs) == 0 {
+			continue
+		}
+		matched := make(map[string]struct{})
+		for _, c := range r.Credits() {
+			_, creditAddrs, _, _ := c.Addresses(w.chainParams)
+			for _, addr := range creditAddrs {
+				encAddr := addr.EncodeAddress()
+				if _, ok := addrs[encAddr]; !ok {
+					continue
+				}
+				if _, ok := matched[encAddr]; ok {
+					continue
+				}
+				matched[encAddr] = struct{}{}
+				idx.byAddr[encAddr] = append(idx.byAddr[encAddr], r)
+			}
+		}
+	}
+	return idx
+}
+
+// records returns the indexed records matching a watch.
+func (idx *confRecords) records(cw *confWatch) []*txstore.TxRecord {
+	if cw.TxHash != nil {
+		if r, ok := idx.byHash[*cw.TxHash]; ok {
+			return []*txstore.TxRecord{r}
+		}
+		return nil
+	}
+	return idx.byAddr[cw.Address.EncodeAddress()]
+}
+
+// trackConfRecords rebuilds the tracked transactions of the passed watches
+// from the whole transaction store.  It is called when watches are loaded or
+// registered, and after rescans since they insert transactions in past
+// blocks.  Otherwise, only the transactions of the block the main chain ends
+// at are matched against the watches when they are evaluated.  It must be
+// called with the confirmation watch mutex held.
+func (w *Wallet) trackConfRecords(watches []*confWatch) {
+	idx := w.indexConfRecords(watches, w.TxStore.Records())
+	for _, cw := range watches {
+		cw.heights = make(map[wire.ShaHash]int32)
+		for _, r := range idx.records(cw) {
+			if r.BlockHeight != -1 && r.BlockHeight <= cw.settled {
+				continue
+			}
+			cw.heights[*r.Tx().Sha()] = r.BlockHeight
+		}
+	}
+}
+
+// retrackConfWatches rebuilds the tracked transactions of every registered
+// watch; see trackConfRecords.
+func (w *Wallet) retrackConfWatches() {
+	w.confWatchMtx.Lock()
+	defer w.confWatchMtx.Unlock()
+
+	watches := make([]*confWatch, 0, len(w.confWatches))
+	for _, cw := range w.confWatches {
+		watches = append(watches, cw)
+	}
+	if len(watches) != 0 {
+		w.trackConfRecords(watches)
+	}
+}
+
+// watchedRecords returns the records of the transactions tracked by a watch or
+// which reached its target, along with the records of the transactions of the
+// new block indexed by idx which match the watch.  Records are sorted by
+// block, and by index within blocks, with unmined transactions last.
+func (w *Wallet) watchedRecords(cw *confWatch, idx *confRecords) []*txstore.TxRecord {
+	byHash := make(map[wire.ShaHash]*txstore.TxRecord)
+	for _, r := range idx.records(cw) {
+		byHash[*r.Tx().Sha()] = r
+	}
+	lookup := func(hash wire.ShaHash, height int32) {
+		if _, ok := byHash[hash]; ok {
+			return
+		}
+		if r := w.TxStore.LookupTx(&hash, height); r != nil {
+			byHash[hash] = r
+		}
+	}
+	for hash, height := range cw.heights {
+		lookup(hash, height)
+	}
+	for hash := range cw.reached {
+		if _, ok := cw.heights[hash]; !ok {
+			lookup(hash, -1)
+		}
+	}
+
+	records := make([]*txstore.TxRecord, 0, len(byHash))
+	for _, r := range byHash {
+		records = append(records, r)
+	}
+	sort.Sort(recordsByBlock(records))
+	return records
+}
+
+// recordsByBlock implements sort.Interface to sort transaction records by
+// block height and index, with unmined transactions last, sorted by hash.
+type recordsByBlock []*txstore.TxRecord
+
+func (s recordsByBlock) Len() int      { return len(s) }
+func (s recordsByBlock) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s recordsByBlock) Less(i, j int) bool {
+	hi, hj := s[i].BlockHeight, s[j].BlockHeight
+	switch {
+	case hi == hj && hi == -1:
+		return bytes.Compare(s[i].Tx().Sha()[:], s[j].Tx().Sha()[:]) < 0
+	case hi == hj:
+		return s[i].BlockIndex < s[j].BlockIndex
+	case hi == -1:
+		return false
+	case hj == -1:
+		return true
+	}
+	return hi < hj
+}
+
+// sortedReached returns the hashes of all transactions which reached the
+// target, sorted bytewise so the serialization is deterministic.
+func (cw *confWatch) sortedReached() []wire.ShaHash {
+	hashes := make([]wire.ShaHash, 0, len(cw.reached))
+	for hash := range cw.reached {
+		hashes = append(hashes, hash)
+	}
+	sort.Sort(shaHashes(hashes))
+	return hashes
+}
+
+// shaHashes implements sort.Interface to sort hashes bytewise.
+type shaHashes []wire.ShaHash
+
+func (h shaHashes) Len() int           { return len(h) }
+func (h shaHashes) Less(i, j int) bool { return bytes.Compare(h[i][:], h[j][:]) < 0 }
+func (h shaHashes) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+// loadConfWatches reads all registered confirmation watches from the wallet
+// namespace.
+func (w *Wallet) loadConfWatches() error {
+	var watches []*confWatch
+	err := w.namespace.View(func(tx walletdb.Tx) error {
+		var err error
+		watches, err = fetchConfWatches(tx, w.chainParams)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	w.confWatchMtx.Lock()
+	for _, cw := range watches {
+		w.confWatches[cw.ID] = cw
+	}
+	if len(watches) != 0 {
+		w.trackConfRecords(watches)
+	}
+	w.confWatchMtx.Unlock()
+	return nil
+}
+
+// WatchConfirmations registers interest in a transaction hash or address
+// reaching a confirmation target.  Exactly one of txHash and addr must be
+// non-nil.  The watch is evaluated whenever a block is connected or
+// disconnected, and a notification is sent through the channel returned by
+// ListenConfirmations each time a watched transaction reaches the target or
+// is reorganized back below it.  Watches persist until removed with
+// UnwatchConfirmations.  The new watch ID is returned.  Read-only wallets
+// can't register watches and return ErrReadOnly.
+func (w *Wallet) WatchConfirmations(txHash *wire.ShaHash, addr btcutil.Address,
+	target int32) (uint64, error) {
+
+	if (txHash == nil) == (addr == nil) {
+		return 0, errors.New("exactly one of a transaction hash or " +
+			"address must be watched")
+	}
+	if target < 1 {
+		return 0, ErrInvalidConfTarget
+	}
+	if w.readOnly {
+		return 0, ErrReadOnly
+	}
+
+	cw := &confWatch{
+		ConfirmationWatch: ConfirmationWatch{
+			TxHash:  txHash,
+			Address: addr,
+			Target:  target,
+		},
+		reached: make(map[wire.ShaHash]struct{}),
+	}
+
+	w.confWatchMtx.Lock()
+	defer w.confWatchMtx.Unlock()
+
+	err := w.namespace.Update(func(tx walletdb.Tx) error {
+		id, err := nextConfWatchID(tx)
+		if err != nil {
+			return err
+		}
+		cw.ID = id
+		return putConfWatch(tx, cw)
+	})
+	if err != nil {
+		return 0, err
+	}
+	w.trackConfRecords([]*confWatch{cw})
+	w.confWatches[cw.ID] = cw
+	return cw.ID, nil
+}
+
+// UnwatchConfirmations removes the confirmation watch with the given ID.
+// ErrConfWatchNotFound is returned if there is no such watch, and ErrReadOnly
+// if the wallet is read-only.
+func (w *Wallet) UnwatchConfirmations(id uint64) error {
+	if w.readOnly {
+		return ErrReadOnly
+	}
+	w.confWatchMtx.Lock()
+	defer w.confWatchMtx.Unlock()
+
+	if _, ok := w.confWatches[id]; !ok {
+		return ErrConfWatchNotFound
+	}
+	err := w.namespace.Update(func(tx walletdb.Tx) error {
+		return deleteConfWatch(tx, id)
+	})
+	if err != nil {
+		return err
+	}
+	delete(w.confWatches, id)
+	return nil
+}
+
+// ConfirmationWatches returns all registered confirmation watches, ordered by
+// ID.
+func (w *Wallet) ConfirmationWatches() []ConfirmationWatch {
+	w.confWatchMtx.Lock()
+	defer w.confWatchMtx.Unlock()
+
+	return w.sortedConfWatches()
+}
+
+// confWatchesByID implements sort.Interface to sort watches by ID.
+type confWatchesByID []ConfirmationWatch
+
+func (s confWatchesByID) Len() int           { return len(s) }
+func (s confWatchesByID) Less(i, j int) bool { return s[i].ID < s[j].ID }
+func (s confWatchesByID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// checkConfirmations evaluates every confirmation watch after the main chain
+// has been changed to end at the passed block, and notifies all transactions
+// which reached or dropped below their watch's target.  Only the transactions
+// tracked by the watches and those of the passed block are read from the
+// transaction store.
+func (w *Wallet) checkConfirmations(bs waddrmgr.BlockStamp) {
+	w.confWatchMtx.Lock()
+	if len(w.confWatches) == 0 {
+		w.confWatchMtx.Unlock()
+		return
+	}
+
+	watches := make([]*confWatch, 0, len(w.confWatches))
+	for _, watch := range w.sortedConfWatches() {
+		watches = append(watches, w.confWatches[watch.ID])
+	}
+	idx := w.indexConfRecords(watches, w.TxStore.BlockRecords(bs.Height))
+
+	var ntfns []ConfirmationNotification
+	for _, cw := range watches {
+		changed := false
+		seen := make(map[wire.ShaHash]int32)
+		for _, r := range w.watchedRecords(cw, idx) {
+			if r.BlockHeight != -1 && r.BlockHeight <= cw.settled {
+				continue
+			}
+			hash := *r.Tx().Sha()
+			seen[hash] = r.BlockHeight
+			confs := r.Confirmations(bs.Height)
+			_, reached := cw.reached[hash]
+			switch {
+			case confs >= cw.Target && !reached:
+				cw.reached[hash] = struct{}{}
+			case confs < cw.Target && reached:
+				delete(cw.reached, hash)
+			default:
+				continue
+			}
+			changed = true
+			ntfns = append(ntfns, ConfirmationNotification{
+				WatchID:       cw.ID,
+				TxHash:        hash,
+				Confirmations: confs,
+				Target:        cw.Target,
+				Reached:       !reached,
+				Block:         bs,
+			})
+		}
+
+		// Transactions which previously reached the target but were
+		// removed from the store entirely have no confirmations.
+		for hash := range cw.reached {
+			if _, ok := seen[hash]; ok {
+				continue
+			}
+			delete(cw.reached, hash)
+			changed = true
+			ntfns = append(ntfns, ConfirmationNotification{
+				WatchID: cw.ID,
+				TxHash:  hash,
+				Target:  cw.Target,
+				Block:   bs,
+			})
+		}
+
+		// Stop tracking transactions which are deep enough to be
+		// settled.  Every other transaction mined up to the new
+		// settled height has already been skipped or notified above.
+		if settled := cw.settledHeight(bs.Height); settled > cw.settled {
+			for hash, height := range seen {
+				if height == -1 || height > settled {
+					continue
+				}
+				delete(seen, hash)
+				if _, ok := cw.reached[hash]; ok {
+					delete(cw.reached, hash)
+					cw.settled = settled
+					changed = true
+				}
+			}
+		}
+		cw.heights = seen
+
+		if changed {
+			err := w.namespace.Update(func(tx walletdb.Tx) error {
+				return putConfWatch(tx, cw)
+			})
+			if err != nil {
+				log.Errorf("Cannot update confirmation watch "+
+					"%d: %v", cw.ID, err)
+			}
+		}
+	}
+	w.confWatchMtx.Unlock()
+
+	for _, n := range ntfns {
+		w.notifyConfirmations(n)
+	}
+}
+
+// sortedConfWatches returns all registered confirmation watches, ordered by
+// ID.  It must be called with the confirmation watch mutex held.
+func (w *Wallet) sortedConfWatches() []ConfirmationWatch {
+	watches := make([]ConfirmationWatch, 0, len(w.confWatches))
+	for _, cw := range w.confWatches {
+		watches = append(watches, cw.ConfirmationWatch)
+	}
+	sort.Sort(confWatchesByID(watches))
+	return watches
+}
@@ -0,0 +1,257 @@
+/*
+ * Copyright (c) 2013, 2014 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package wallet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/monetas/btcwallet/txstore"
+	"github.com/monetas/btcwallet/waddrmgr"
+	"github.com/monetas/btcwallet/walletdb"
+)
+
+// confTest is a wallet, without an address manager, used to test
+// confirmation watches.
+type confTest struct {
+	t     *testing.T
+	w     *Wallet
+	store *txstore.Store
+	ntfns <-chan ConfirmationNotification
+}
+
+// newConfTest opens a wallet for confirmation watch tests and returns a
+// teardown function to close its database.
+func newConfTest(t *testing.T) (*confTest, func()) {
+	db, err := walletdb.Create("memdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := txstore.New("")
+	w, err := Open(&Config{
+		ChainParams: &chaincfg.TestNet3Params,
+		Db:          &db,
+		TxStore:     store,
+	})
+	if err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	ntfns, err := w.ListenConfirmations()
+	if err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	return &confTest{t, w, store, ntfns}, func() { db.Close() }
+}
+
+// insertTx inserts a transaction with a single output paying to pkScript into
+// the store, mined at the given height and index within the block.  The nonce
+// makes the transaction unique.
+func (ct *confTest) insertTx(nonce uint32, pkScript []byte, height int32,
+	index int) wire.ShaHash {
+
+	msgtx := wire.NewMsgTx()
+	msgtx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: nonce}, nil))
+	msgtx.AddTxOut(wire.NewTxOut(1e8, pkScript))
+	tx := btcutil.NewTx(msgtx)
+	tx.SetIndex(index)
+	r, err := ct.store.InsertTx(tx, &txstore.Block{
+		Hash:   wire.ShaHash{byte(height)},
+		Height: height,
+	})
+	if err != nil {
+		ct.t.Fatal(err)
+	}
+	if _, err := r.AddCredit(0, false); err != nil {
+		ct.t.Fatal(err)
+	}
+	return *tx.Sha()
+}
+
+// check evaluates the watches with the chain ending at the given height and
+// returns the notifications sent.
+func (ct *confTest) check(height int32) []ConfirmationNotification {
+	done := make(chan struct{})
+	go func() {
+		ct.w.checkConfirmations(waddrmgr.BlockStamp{Height: height})
+		close(done)
+	}()
+	var ntfns []ConfirmationNotification
+	for {
+		select {
+		case n := <-ct.ntfns:
+			ntfns = append(ntfns, n)
+		case <-done:
+			return ntfns
+		case <-time.After(5 * time.Second):
+			ct.t.Fatal("checkConfirmations did not return")
+		}
+	}
+}
+
+// expect fails the test unless exactly the passed notifications, compared by
+// watch, transaction, confirmations and whether the target is reached, were
+// sent.
+func (ct *confTest) expect(what string, got []ConfirmationNotification,
+	want ...ConfirmationNotification) {
+
+	if len(got) != len(want) {
+		ct.t.Fatalf("%s: wrong notifications; got %+v, want %+v", what,
+			got, want)
+	}
+	for i := range want {
+		if got[i].WatchID != want[i].WatchID ||
+			got[i].TxHash != want[i].TxHash ||
+			got[i].Confirmations != want[i].Confirmations ||
+			got[i].Reached != want[i].Reached {
+
+			ct.t.Fatalf("%s: wrong notification %d; got %+v, want "+
+				"%+v", what, i, got[i], want[i])
+		}
+	}
+}
+
+func TestCheckConfirmations(t *testing.T) {
+	ct, teardown := newConfTest(t)
+	defer teardown()
+
+	// The transaction is the second one of block 100.  The coinbase of
+	// block 102 is removed from the store whenever the block is
+	// disconnected.
+	txHash := ct.insertTx(1, []byte{0x51}, 100, 1)
+	coinbaseHash := ct.insertTx(2, []byte{0x51}, 102, 0)
+	txWatch, err := ct.w.WatchConfirmations(&txHash, nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbaseWatch, err := ct.w.WatchConfirmations(&coinbaseHash, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ct.expect("block 100", ct.check(100))
+	ct.expect("block 101", ct.check(101), ConfirmationNotification{
+		WatchID: txWatch, TxHash: txHash, Confirmations: 2, Reached: true,
+	})
+
+	// Disconnecting block 101, along with block 102, drops the
+	// transaction below the target.
+	if err := ct.store.Rollback(101); err != nil {
+		t.Fatal(err)
+	}
+	ct.expect("disconnect 101", ct.check(100), ConfirmationNotification{
+		WatchID: txWatch, TxHash: txHash, Confirmations: 1,
+	})
+
+	ct.expect("reconnect 101", ct.check(101), ConfirmationNotification{
+		WatchID: txWatch, TxHash: txHash, Confirmations: 2, Reached: true,
+	})
+	// The coinbase is no longer tracked, and is only found again as a
+	// transaction of the new block.
+	ct.insertTx(2, []byte{0x51}, 102, 0)
+	ct.expect("block 102", ct.check(102), ConfirmationNotification{
+		WatchID: coinbaseWatch, TxHash: coinbaseHash, Confirmations: 1,
+		Reached: true,
+	})
+
+	// Disconnecting block 102 removes its coinbase from the store.
+	if err := ct.store.Rollback(102); err != nil {
+		t.Fatal(err)
+	}
+	ct.expect("disconnect 102", ct.check(101), ConfirmationNotification{
+		WatchID: coinbaseWatch, TxHash: coinbaseHash,
+	})
+}
+
+func TestConfirmationWatchPruning(t *testing.T) {
+	ct, teardown := newConfTest(t)
+	defer teardown()
+
+	addr, err := btcutil.DecodeAddress("mjqnv9JoxdYyQK7NMZGCKLxNWHfA6XFVC7",
+		&chaincfg.TestNet3Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txHash := ct.insertTx(1, pkScript, 10, 1)
+	ct.insertTx(2, []byte{0x51}, 10, 2)
+	watch, err := ct.w.WatchConfirmations(nil, addr, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the transaction paying to the address is notified.
+	ct.expect("block 10", ct.check(10), ConfirmationNotification{
+		WatchID: watch, TxHash: txHash, Confirmations: 1, Reached: true,
+	})
+
+	// Once the transaction is deep enough it is no longer tracked, nor
+	// notified again.
+	settled := int32(10 + confWatchPruneDepth)
+	ct.expect("not yet settled", ct.check(settled-1))
+	ct.expect("settled", ct.check(settled))
+	ct.expect("after settled", ct.check(settled+1))
+
+	var cws []*confWatch
+	err = ct.w.namespace.View(func(tx walletdb.Tx) error {
+		var err error
+		cws, err = fetchConfWatches(tx, ct.w.chainParams)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cws) != 1 || len(cws[0].reached) != 0 || cws[0].settled != 10 {
+		t.Fatalf("Wrong stored watches: %+v", cws)
+	}
+}
+
+func TestConfirmationWatchRetrack(t *testing.T) {
+	ct, teardown := newConfTest(t)
+	defer teardown()
+
+	addr, err := btcutil.DecodeAddress("mjqnv9JoxdYyQK7NMZGCKLxNWHfA6XFVC7",
+		&chaincfg.TestNet3Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	watch, err := ct.w.WatchConfirmations(nil, addr, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Transactions of past blocks, such as those found by a rescan, are
+	// not read from the store until the watches are tracked again.
+	txHash := ct.insertTx(1, pkScript, 10, 1)
+	ct.expect("block 20", ct.check(20))
+	ct.w.retrackConfWatches()
+	ct.expect("block 21", ct.check(21), ConfirmationNotification{
+		WatchID: watch, TxHash: txHash, Confirmations: 12, Reached: true,
+	})
+}
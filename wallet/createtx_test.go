@@ -2,8 +2,6 @@ package wallet
 
 import (
 	"encoding/hex"
-	"os"
-	"path/filepath"
 	"reflect"
 	"sort"
 	"testing"
@@ -16,7 +14,7 @@ import (
 	"github.com/monetas/btcwallet/txstore"
 	"github.com/monetas/btcwallet/waddrmgr"
 	"github.com/monetas/btcwallet/walletdb"
-	_ "github.com/monetas/btcwallet/walletdb/bdb"
+	_ "github.com/monetas/btcwallet/walletdb/memdb"
 )
 
 // This is a tx that transfers funds (0.371 BTC) to addresses of known privKeys.
@@ -166,9 +164,7 @@ func checkOutputsMatch(t *testing.T, msgtx *wire.MsgTx, expected map[string]btcu
 
 // newManager creates a new waddrmgr and imports the given privKey into it.
 func newManager(t *testing.T, privKeys []string, bs *waddrmgr.BlockStamp) *waddrmgr.Manager {
-	dbPath := filepath.Join(os.TempDir(), "wallet.bin")
-	os.Remove(dbPath)
-	db, err := walletdb.Create("bdb", dbPath)
+	db, err := walletdb.Create("memdb")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,530 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package wallet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/monetas/btcwallet/waddrmgr"
+	"github.com/monetas/btcwallet/walletdb"
+)
+
+var (
+	// walletNamespaceKey is the namespace key for the data owned directly
+	// by the wallet package (as opposed to the address manager).
+	walletNamespaceKey = []byte("wallet")
+
+	// rescanBucketName is the bucket holding one checkpoint for every
+	// rescan which has been started or queued but has not yet finished.
+	// Keys are the 8 byte big-endian rescan IDs so the bucket iterates in
+	// submission order.
+	rescanBucketName = []byte("rescan")
+
+	// rescanProgressBucketName is the bucket holding the most recent
+	// progress of the rescans in the rescan bucket, keyed the same way.
+	// Progress is stored apart from the checkpoints so that saving it does
+	// not rewrite all the addresses and outpoints of a rescan.
+	rescanProgressBucketName = []byte("rescanprogress")
+
+	// lastRescanIDName is the key (root bucket) of the most recently
+	// assigned rescan ID.
+	lastRescanIDName = []byte("lastrescanid")
+
+	// confWatchBucketName is the bucket holding all registered
+	// confirmation watches.  Keys are the 8 byte big-endian watch IDs.
+	confWatchBucketName = []byte("confwatch")
+
+	// lastConfWatchIDName is the key (root bucket) of the most recently
+	// assigned confirmation watch ID.
+	lastConfWatchIDName = []byte("lastconfwatchid")
+)
+
+// NamespaceVersion is the version of the data in the wallet namespace.  The
+// rescan checkpoints and confirmation watches stored in it carry the version
+// of their own formats, and this must be increased when either changes.
+const NamespaceVersion = 1
+
+// rescanCheckpointVersion is the version of the serialized rescan checkpoint
+// format.
+const rescanCheckpointVersion = 1
+
+// errMalformedCheckpoint describes a rescan checkpoint that could not be
+// deserialized.
+var errMalformedCheckpoint = errors.New("malformed rescan checkpoint")
+
+// rescanCheckpoint is the database representation of a rescan batch.  The
+// progress block stamp is the most recent block the rescan is known to have
+// completed, and is the block a rescan is resumed from after a restart.  The
+// end block stamp is nil for rescans which continue through the best block.
+type rescanCheckpoint struct {
+	id          uint64
+	initialSync bool
+	addrs       []btcutil.Address
+	outpoints   []*wire.OutPoint
+	start       waddrmgr.BlockStamp
+	progress    waddrmgr.BlockStamp
+	end         *waddrmgr.BlockStamp
+}
+
+// createWalletNS creates the buckets used by the wallet package if they do not
+// already exist.
+func createWalletNS(namespace walletdb.Namespace) error {
+	return namespace.Update(func(tx walletdb.Tx) error {
+		_, err := tx.RootBucket().CreateBucketIfNotExists(rescanBucketName)
+		if err != nil {
+			return fmt.Errorf("cannot create rescan bucket: %v", err)
+		}
+		_, err = tx.RootBucket().CreateBucketIfNotExists(rescanProgressBucketName)
+		if err != nil {
+			return fmt.Errorf("cannot create rescan progress bucket: %v",
+				err)
+		}
+		_, err = tx.RootBucket().CreateBucketIfNotExists(confWatchBucketName)
+		if err != nil {
+			return fmt.Errorf("cannot create confirmation watch "+
+				"bucket: %v", err)
+		}
+		return nil
+	})
+}
+
+// rescanIDToBytes returns the database key for a rescan ID.
+func rescanIDToBytes(id uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], id)
+	return buf[:]
+}
+
+// nextID increments and returns the ID stored in the root bucket with the
+// given key.
+func nextID(tx walletdb.Tx, key []byte) (uint64, error) {
+	var id uint64
+	if buf := tx.RootBucket().Get(key); len(buf) == 8 {
+		id = binary.BigEndian.Uint64(buf)
+	}
+	id++
+	if err := tx.RootBucket().Put(key, rescanIDToBytes(id)); err != nil {
+		return 0, fmt.Errorf("cannot store last %s: %v", key, err)
+	}
+	return id, nil
+}
+
+// nextRescanID increments and returns the last assigned rescan ID.
+func nextRescanID(tx walletdb.Tx) (uint64, error) {
+	return nextID(tx, lastRescanIDName)
+}
+
+// serializeRescanCheckpoint returns the serialization of a rescan checkpoint.
+func serializeRescanCheckpoint(cp *rescanCheckpoint) []byte {
+	// The serialized checkpoint format is:
+	//   <version><initialsync><start><progress><hasend><end><numaddrs>
+	//   <addrs><numoutpoints><outpoints>
+	//
+	// 1 byte version + 1 byte initial sync flag + 36 bytes start block
+	// stamp + 36 bytes progress block stamp + 1 byte end block flag + 36
+	// bytes end block stamp + 4 bytes number of addresses + (2 bytes
+	// length + encoded address) per address + 4 bytes number of outpoints
+	// + (32 bytes hash + 4 bytes index) per outpoint
+	//
+	// The end block stamp is all zeros when the end block flag is unset.
+	encAddrs := make([]string, len(cp.addrs))
+	size := 1 + 1 + 36 + 36 + 1 + 36 + 4 + 4 + 36*len(cp.outpoints)
+	for i, addr := range cp.addrs {
+		encAddrs[i] = addr.EncodeAddress()
+		size += 2 + len(encAddrs[i])
+	}
+
+	buf := make([]byte, size)
+	buf[0] = rescanCheckpointVersion
+	if cp.initialSync {
+		buf[1] = 1
+	}
+	offset := 2
+	offset += putBlockStamp(buf[offset:], &cp.start)
+	offset += putBlockStamp(buf[offset:], &cp.progress)
+	if cp.end != nil {
+		buf[offset] = 1
+		putBlockStamp(buf[offset+1:], cp.end)
+	}
+	offset += 1 + 36
+	binary.LittleEndian.PutUint32(buf[offset:], uint32(len(encAddrs)))
+	offset += 4
+	for _, addr := range encAddrs {
+		binary.LittleEndian.PutUint16(buf[offset:], uint16(len(addr)))
+		offset += 2
+		offset += copy(buf[offset:], addr)
+	}
+	binary.LittleEndian.PutUint32(buf[offset:], uint32(len(cp.outpoints)))
+	offset += 4
+	for _, op := range cp.outpoints {
+		offset += copy(buf[offset:], op.Hash[:])
+		binary.LittleEndian.PutUint32(buf[offset:], op.Index)
+		offset += 4
+	}
+	return buf
+}
+
+// deserializeRescanCheckpoint deserializes the checkpoint with the given ID.
+// Addresses are decoded using the passed chain parameters.
+func deserializeRescanCheckpoint(id uint64, buf []byte,
+	chainParams *chaincfg.Params) (*rescanCheckpoint, error) {
+
+	if len(buf) < 1 {
+		return nil, errMalformedCheckpoint
+	}
+	if buf[0] != rescanCheckpointVersion {
+		return nil, fmt.Errorf("unsupported rescan checkpoint version %d",
+			buf[0])
+	}
+	if len(buf) < 1+1+36+36+1+36+4+4 {
+		return nil, errMalformedCheckpoint
+	}
+
+	cp := &rescanCheckpoint{id: id, initialSync: buf[1] == 1}
+	offset := 2
+	offset += getBlockStamp(buf[offset:], &cp.start)
+	offset += getBlockStamp(buf[offset:], &cp.progress)
+	if buf[offset] == 1 {
+		cp.end = new(waddrmgr.BlockStamp)
+		getBlockStamp(buf[offset+1:], cp.end)
+	}
+	offset += 1 + 36
+	numAddrs := int(binary.LittleEndian.Uint32(buf[offset:]))
+	offset += 4
+	cp.addrs = make([]btcutil.Address, 0, numAddrs)
+	for i := 0; i < numAddrs; i++ {
+		if len(buf) < offset+2 {
+			return nil, errMalformedCheckpoint
+		}
+		addrLen := int(binary.LittleEndian.Uint16(buf[offset:]))
+		offset += 2
+		if len(buf) < offset+addrLen {
+			return nil, errMalformedCheckpoint
+		}
+		addr, err := btcutil.DecodeAddress(string(buf[offset:offset+addrLen]),
+			chainParams)
+		if err != nil {
+			return nil, err
+		}
+		offset += addrLen
+		cp.addrs = append(cp.addrs, addr)
+	}
+	if len(buf) < offset+4 {
+		return nil, errMalformedCheckpoint
+	}
+	numOutPoints := int(binary.LittleEndian.Uint32(buf[offset:]))
+	offset += 4
+	if len(buf) != offset+36*numOutPoints {
+		return nil, errMalformedCheckpoint
+	}
+	cp.outpoints = make([]*wire.OutPoint, numOutPoints)
+	for i := range cp.outpoints {
+		op := new(wire.OutPoint)
+		offset += copy(op.Hash[:], buf[offset:offset+32])
+		op.Index = binary.LittleEndian.Uint32(buf[offset:])
+		offset += 4
+		cp.outpoints[i] = op
+	}
+	return cp, nil
+}
+
+// putBlockStamp writes the 36 byte serialization of a block stamp (4 bytes
+// height + 32 bytes hash) to buf and returns the number of bytes written.
+func putBlockStamp(buf []byte, bs *waddrmgr.BlockStamp) int {
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(bs.Height))
+	copy(buf[4:36], bs.Hash[:])
+	return 36
+}
+
+// getBlockStamp reads a block stamp serialized by putBlockStamp and returns
+// the number of bytes read.
+func getBlockStamp(buf []byte, bs *waddrmgr.BlockStamp) int {
+	bs.Height = int32(binary.LittleEndian.Uint32(buf[0:4]))
+	copy(bs.Hash[:], buf[4:36])
+	return 36
+}
+
+// putRescanCheckpoint stores a rescan checkpoint, replacing any previous
+// checkpoint with the same ID.  The progress saved separately for the rescan,
+// if any, is removed as the checkpoint holds the most recent progress.
+func putRescanCheckpoint(tx walletdb.Tx, cp *rescanCheckpoint) error {
+	bucket := tx.RootBucket().Bucket(rescanBucketName)
+	err := bucket.Put(rescanIDToBytes(cp.id), serializeRescanCheckpoint(cp))
+	if err != nil {
+		return fmt.Errorf("cannot store checkpoint for rescan %d: %v",
+			cp.id, err)
+	}
+	return deleteRescanProgress(tx, cp.id)
+}
+
+// putRescanProgress stores the progress of the rescan with the given ID, which
+// replaces the progress of its checkpoint when it is fetched.
+func putRescanProgress(tx walletdb.Tx, id uint64, progress *waddrmgr.BlockStamp) error {
+	var buf [36]byte
+	putBlockStamp(buf[:], progress)
+	bucket := tx.RootBucket().Bucket(rescanProgressBucketName)
+	if err := bucket.Put(rescanIDToBytes(id), buf[:]); err != nil {
+		return fmt.Errorf("cannot store progress for rescan %d: %v",
+			id, err)
+	}
+	return nil
+}
+
+// deleteRescanProgress removes the progress saved separately for a rescan ID.
+// It is not an error if no progress was saved.
+func deleteRescanProgress(tx walletdb.Tx, id uint64) error {
+	bucket := tx.RootBucket().Bucket(rescanProgressBucketName)
+	if err := bucket.Delete(rescanIDToBytes(id)); err != nil {
+		return fmt.Errorf("cannot remove progress for rescan %d: %v",
+			id, err)
+	}
+	return nil
+}
+
+// deleteRescanCheckpoint removes the checkpoint and progress for a rescan ID.
+// It is not an error if the checkpoint does not exist.
+func deleteRescanCheckpoint(tx walletdb.Tx, id uint64) error {
+	bucket := tx.RootBucket().Bucket(rescanBucketName)
+	if err := bucket.Delete(rescanIDToBytes(id)); err != nil {
+		return fmt.Errorf("cannot remove checkpoint for rescan %d: %v",
+			id, err)
+	}
+	return deleteRescanProgress(tx, id)
+}
+
+// deleteRescanCheckpoints removes the saved checkpoints of several rescans.
+func deleteRescanCheckpoints(tx walletdb.Tx, ids []uint64) error {
+	for _, id := range ids {
+		if err := deleteRescanCheckpoint(tx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// existsRescanCheckpoint returns whether a checkpoint is saved for a rescan
+// ID.
+func existsRescanCheckpoint(tx walletdb.Tx, id uint64) bool {
+	bucket := tx.RootBucket().Bucket(rescanBucketName)
+	return bucket.Get(rescanIDToBytes(id)) != nil
+}
+
+// fetchRescanCheckpoints returns all saved rescan checkpoints ordered by ID,
+// with the progress saved separately for them, if any.
+func fetchRescanCheckpoints(tx walletdb.Tx,
+	chainParams *chaincfg.Params) ([]*rescanCheckpoint, error) {
+
+	var cps []*rescanCheckpoint
+	bucket := tx.RootBucket().Bucket(rescanBucketName)
+	// The progress bucket is missing from wallets created before it was
+	// introduced which are opened read-only.
+	progressBucket := tx.RootBucket().Bucket(rescanProgressBucketName)
+	err := bucket.ForEach(func(k, v []byte) error {
+		if len(k) != 8 {
+			return errMalformedCheckpoint
+		}
+		cp, err := deserializeRescanCheckpoint(binary.BigEndian.Uint64(k),
+			v, chainParams)
+		if err != nil {
+			return err
+		}
+		if progressBucket != nil {
+			if buf := progressBucket.Get(k); buf != nil {
+				if len(buf) != 36 {
+					return errMalformedCheckpoint
+				}
+				getBlockStamp(buf, &cp.progress)
+			}
+		}
+		cps = append(cps, cp)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cps, nil
+}
+
+// confWatchVersion is the version of the serialized confirmation watch
+// format.
+const confWatchVersion = 1
+
+// errMalformedConfWatch describes a confirmation watch that could not be
+// deserialized.
+var errMalformedConfWatch = errors.New("malformed confirmation watch")
+
+// nextConfWatchID increments and returns the last assigned confirmation watch
+// ID.
+func nextConfWatchID(tx walletdb.Tx) (uint64, error) {
+	return nextID(tx, lastConfWatchIDName)
+}
+
+// serializeConfWatch returns the serialization of a confirmation watch.
+func serializeConfWatch(cw *confWatch) []byte {
+	// The serialized confirmation watch format is:
+	//   <version><target><settled><txhash or address><numreached><reached>
+	//
+	// 1 byte version + 4 bytes target + 4 bytes settled height + either
+	// (1 byte zero + 32 bytes transaction hash) or (1 byte one + 2 bytes
+	// length + encoded address) + 4 bytes number of reached transactions
+	// + 32 bytes hash per reached transaction
+	var encAddr string
+	size := 1 + 4 + 4 + 1 + 4 + 32*len(cw.reached)
+	if cw.Address != nil {
+		encAddr = cw.Address.EncodeAddress()
+		size += 2 + len(encAddr)
+	} else {
+		size += 32
+	}
+
+	buf := make([]byte, size)
+	buf[0] = confWatchVersion
+	binary.LittleEndian.PutUint32(buf[1:5], uint32(cw.Target))
+	binary.LittleEndian.PutUint32(buf[5:9], uint32(cw.settled))
+	offset := 9
+	if cw.Address != nil {
+		buf[offset] = 1
+		binary.LittleEndian.PutUint16(buf[offset+1:], uint16(len(encAddr)))
+		offset += 3
+		offset += copy(buf[offset:], encAddr)
+	} else {
+		offset++
+		offset += copy(buf[offset:], cw.TxHash[:])
+	}
+	binary.LittleEndian.PutUint32(buf[offset:], uint32(len(cw.reached)))
+	offset += 4
+	for _, hash := range cw.sortedReached() {
+		offset += copy(buf[offset:], hash[:])
+	}
+	return buf
+}
+
+// deserializeConfWatch deserializes the confirmation watch with the given ID.
+// Addresses are decoded using the passed chain parameters.
+func deserializeConfWatch(id uint64, buf []byte,
+	chainParams *chaincfg.Params) (*confWatch, error) {
+
+	if len(buf) < 1 {
+		return nil, errMalformedConfWatch
+	}
+	if buf[0] != confWatchVersion {
+		return nil, fmt.Errorf("unsupported confirmation watch "+
+			"version %d", buf[0])
+	}
+	if len(buf) < 1+4+4+1 {
+		return nil, errMalformedConfWatch
+	}
+
+	cw := &confWatch{
+		ConfirmationWatch: ConfirmationWatch{
+			ID:     id,
+			Target: int32(binary.LittleEndian.Uint32(buf[1:5])),
+		},
+		reached: make(map[wire.ShaHash]struct{}),
+		settled: int32(binary.LittleEndian.Uint32(buf[5:9])),
+	}
+	offset := 9
+	if buf[offset] == 1 {
+		if len(buf) < offset+3 {
+			return nil, errMalformedConfWatch
+		}
+		addrLen := int(binary.LittleEndian.Uint16(buf[offset+1:]))
+		offset += 3
+		if len(buf) < offset+addrLen {
+			return nil, errMalformedConfWatch
+		}
+		addr, err := btcutil.DecodeAddress(string(buf[offset:offset+addrLen]),
+			chainParams)
+		if err != nil {
+			return nil, err
+		}
+		cw.Address = addr
+		offset += addrLen
+	} else {
+		offset++
+		if len(buf) < offset+32 {
+			return nil, errMalformedConfWatch
+		}
+		cw.TxHash = new(wire.ShaHash)
+		offset += copy(cw.TxHash[:], buf[offset:offset+32])
+	}
+	if len(buf) < offset+4 {
+		return nil, errMalformedConfWatch
+	}
+	numReached := int(binary.LittleEndian.Uint32(buf[offset:]))
+	offset += 4
+	if len(buf) != offset+32*numReached {
+		return nil, errMalformedConfWatch
+	}
+	for i := 0; i < numReached; i++ {
+		var hash wire.ShaHash
+		offset += copy(hash[:], buf[offset:offset+32])
+		cw.reached[hash] = struct{}{}
+	}
+	return cw, nil
+}
+
+// putConfWatch stores a confirmation watch, replacing any previous watch with
+// the same ID.
+func putConfWatch(tx walletdb.Tx, cw *confWatch) error {
+	bucket := tx.RootBucket().Bucket(confWatchBucketName)
+	err := bucket.Put(rescanIDToBytes(cw.ID), serializeConfWatch(cw))
+	if err != nil {
+		return fmt.Errorf("cannot store confirmation watch %d: %v",
+			cw.ID, err)
+	}
+	return nil
+}
+
+// deleteConfWatch removes the confirmation watch with the given ID.
+func deleteConfWatch(tx walletdb.Tx, id uint64) error {
+	bucket := tx.RootBucket().Bucket(confWatchBucketName)
+	if err := bucket.Delete(rescanIDToBytes(id)); err != nil {
+		return fmt.Errorf("cannot remove confirmation watch %d: %v",
+			id, err)
+	}
+	return nil
+}
+
+// fetchConfWatches returns all confirmation watches.
+func fetchConfWatches(tx walletdb.Tx,
+	chainParams *chaincfg.Params) ([]*confWatch, error) {
+
+	var watches []*confWatch
+	bucket := tx.RootBucket().Bucket(confWatchBucketName)
+	err := bucket.ForEach(func(k, v []byte) error {
+		if len(k) != 8 {
+			return errMalformedConfWatch
+		}
+		cw, err := deserializeConfWatch(binary.BigEndian.Uint64(k), v,
+			chainParams)
+		if err != nil {
+			return err
+		}
+		watches = append(watches, cw)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return watches, nil
+}
@@ -0,0 +1,266 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package wallet
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/monetas/btcwallet/waddrmgr"
+	"github.com/monetas/btcwallet/walletdb"
+	_ "github.com/monetas/btcwallet/walletdb/memdb"
+)
+
+// setupWalletNamespace creates a new database with the wallet namespace and
+// returns a teardown function to remove it.
+func setupWalletNamespace(t *testing.T) (walletdb.Namespace, func()) {
+	db, err := walletdb.Create("memdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	teardown := func() {
+		db.Close()
+	}
+	namespace, err := db.Namespace(walletNamespaceKey)
+	if err != nil {
+		teardown()
+		t.Fatal(err)
+	}
+	if err := createWalletNS(namespace); err != nil {
+		teardown()
+		t.Fatal(err)
+	}
+	return namespace, teardown
+}
+
+func TestRescanCheckpointSerialization(t *testing.T) {
+	addr, err := btcutil.DecodeAddress("mjqnv9JoxdYyQK7NMZGCKLxNWHfA6XFVC7",
+		&chaincfg.TestNet3Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp := &rescanCheckpoint{
+		id:          7,
+		initialSync: true,
+		addrs:       []btcutil.Address{addr},
+		outpoints:   []*wire.OutPoint{{Hash: wire.ShaHash{1}, Index: 2}},
+		start:       waddrmgr.BlockStamp{Height: 100, Hash: wire.ShaHash{3}},
+		progress:    waddrmgr.BlockStamp{Height: 150, Hash: wire.ShaHash{4}},
+		end:         &waddrmgr.BlockStamp{Height: 200, Hash: wire.ShaHash{5}},
+	}
+
+	got, err := deserializeRescanCheckpoint(cp.id,
+		serializeRescanCheckpoint(cp), &chaincfg.TestNet3Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, cp) {
+		t.Fatalf("Wrong checkpoint; got %+v, want %+v", got, cp)
+	}
+
+	// Truncated checkpoints must be rejected.
+	serialized := serializeRescanCheckpoint(cp)
+	_, err = deserializeRescanCheckpoint(cp.id, serialized[:len(serialized)-1],
+		&chaincfg.TestNet3Params)
+	if err != errMalformedCheckpoint {
+		t.Fatalf("Wrong error; got %v, want %v", err, errMalformedCheckpoint)
+	}
+
+	// Checkpoints without an end block rescan through the best block.
+	cp.end = nil
+	got, err = deserializeRescanCheckpoint(cp.id,
+		serializeRescanCheckpoint(cp), &chaincfg.TestNet3Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.end != nil {
+		t.Fatalf("Unexpected end block %v", got.end)
+	}
+}
+
+func TestRescanCheckpoints(t *testing.T) {
+	namespace, teardown := setupWalletNamespace(t)
+	defer teardown()
+
+	err := namespace.Update(func(tx walletdb.Tx) error {
+		for i := 0; i < 3; i++ {
+			id, err := nextRescanID(tx)
+			if err != nil {
+				return err
+			}
+			if id != uint64(i+1) {
+				t.Fatalf("Wrong rescan ID; got %d, want %d", id, i+1)
+			}
+			cp := &rescanCheckpoint{
+				id:       id,
+				progress: waddrmgr.BlockStamp{Height: int32(id)},
+			}
+			if err := putRescanCheckpoint(tx, cp); err != nil {
+				return err
+			}
+		}
+		return deleteRescanCheckpoint(tx, 2)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = namespace.View(func(tx walletdb.Tx) error {
+		if existsRescanCheckpoint(tx, 2) {
+			t.Fatal("Deleted checkpoint still exists")
+		}
+		cps, err := fetchRescanCheckpoints(tx, &chaincfg.TestNet3Params)
+		if err != nil {
+			return err
+		}
+		if len(cps) != 2 || cps[0].id != 1 || cps[1].id != 3 {
+			t.Fatalf("Wrong checkpoints: %+v", cps)
+		}
+		if cps[1].progress.Height != 3 {
+			t.Fatalf("Wrong progress height; got %d, want 3",
+				cps[1].progress.Height)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRescanProgress(t *testing.T) {
+	namespace, teardown := setupWalletNamespace(t)
+	defer teardown()
+
+	fetchProgress := func() int32 {
+		var height int32
+		err := namespace.View(func(tx walletdb.Tx) error {
+			cps, err := fetchRescanCheckpoints(tx, &chaincfg.TestNet3Params)
+			if err != nil {
+				return err
+			}
+			if len(cps) != 1 {
+				t.Fatalf("Wrong checkpoints: %+v", cps)
+			}
+			height = cps[0].progress.Height
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return height
+	}
+	update := func(fn func(tx walletdb.Tx) error) {
+		if err := namespace.Update(fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cp := &rescanCheckpoint{id: 1, progress: waddrmgr.BlockStamp{Height: 10}}
+	update(func(tx walletdb.Tx) error {
+		return putRescanCheckpoint(tx, cp)
+	})
+
+	// Saved progress replaces the progress of the checkpoint.
+	update(func(tx walletdb.Tx) error {
+		return putRescanProgress(tx, cp.id, &waddrmgr.BlockStamp{Height: 20})
+	})
+	if height := fetchProgress(); height != 20 {
+		t.Fatalf("Wrong progress height; got %d, want 20", height)
+	}
+
+	// Storing the checkpoint again discards the saved progress.
+	cp.progress.Height = 15
+	update(func(tx walletdb.Tx) error {
+		return putRescanCheckpoint(tx, cp)
+	})
+	if height := fetchProgress(); height != 15 {
+		t.Fatalf("Wrong progress height; got %d, want 15", height)
+	}
+
+	// Deleting the checkpoint deletes its progress too.
+	update(func(tx walletdb.Tx) error {
+		if err := putRescanProgress(tx, cp.id,
+			&waddrmgr.BlockStamp{Height: 30}); err != nil {
+
+			return err
+		}
+		return deleteRescanCheckpoint(tx, cp.id)
+	})
+	err := namespace.View(func(tx walletdb.Tx) error {
+		bucket := tx.RootBucket().Bucket(rescanProgressBucketName)
+		if bucket.Get(rescanIDToBytes(cp.id)) != nil {
+			t.Fatal("Progress of deleted checkpoint still exists")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfWatchSerialization(t *testing.T) {
+	addr, err := btcutil.DecodeAddress("mjqnv9JoxdYyQK7NMZGCKLxNWHfA6XFVC7",
+		&chaincfg.TestNet3Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	watches := []*confWatch{
+		{
+			ConfirmationWatch: ConfirmationWatch{
+				ID:     1,
+				TxHash: &wire.ShaHash{1},
+				Target: 6,
+			},
+			reached: map[wire.ShaHash]struct{}{},
+		},
+		{
+			ConfirmationWatch: ConfirmationWatch{
+				ID:      2,
+				Address: addr,
+				Target:  1,
+			},
+			reached: map[wire.ShaHash]struct{}{
+				{2}: {},
+				{3}: {},
+			},
+			settled: 400,
+		},
+	}
+
+	for _, cw := range watches {
+		serialized := serializeConfWatch(cw)
+		got, err := deserializeConfWatch(cw.ID, serialized,
+			&chaincfg.TestNet3Params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, cw) {
+			t.Fatalf("Wrong watch; got %+v, want %+v", got, cw)
+		}
+
+		// Truncated watches must be rejected.
+		_, err = deserializeConfWatch(cw.ID, serialized[:len(serialized)-1],
+			&chaincfg.TestNet3Params)
+		if err != errMalformedConfWatch {
+			t.Fatalf("Wrong error; got %v, want %v", err,
+				errMalformedConfWatch)
+		}
+	}
+}
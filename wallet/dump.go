@@ -0,0 +1,415 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package wallet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/monetas/btcwallet/waddrmgr"
+)
+
+// dumpTimeFormat is the ISO 8601 format of the times in wallet dumps.
+const dumpTimeFormat = "2006-01-02T15:04:05Z"
+
+// dumpTimestampWindow is how long before the creation time of a key a
+// transaction may still appear in a block, as block timestamps are allowed to
+// differ from the actual time.  It matches the window used by bitcoind.
+const dumpTimestampWindow = 2 * time.Hour
+
+// dumpEntry is a private key or script in a wallet dump.
+type dumpEntry struct {
+	wif       *btcutil.WIF // nil for scripts
+	script    []byte
+	created   time.Time // zero when unknown
+	label     string
+	change    bool
+	addr      string
+	hdKeyPath string
+}
+
+// encodeDumpString percent-encodes the characters of s which can't appear in
+// a field of a wallet dump, as bitcoind does.
+func encodeDumpString(s string) string {
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x80 || c == '%' {
+			fmt.Fprintf(&b, "%%%02x", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// decodeDumpString reverses encodeDumpString.  Malformed escapes are kept as
+// is.
+func decodeDumpString(s string) string {
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) {
+			if c, err := hex.DecodeString(s[i+1 : i+3]); err == nil {
+				b.WriteByte(c[0])
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// formatDumpTime formats a creation time of a wallet dump.  Unknown times are
+// written as the Unix epoch, which causes importers to rescan the entire block
+// chain.
+func formatDumpTime(t time.Time) string {
+	if t.IsZero() {
+		t = time.Unix(0, 0)
+	}
+	return t.UTC().Format(dumpTimeFormat)
+}
+
+// writeDump writes a wallet dump of the passed entries in the text format of
+// bitcoind's dumpwallet.
+func writeDump(w io.Writer, created time.Time, synced waddrmgr.BlockStamp,
+	entries []dumpEntry) error {
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# Wallet dump created by btcwallet\n")
+	fmt.Fprintf(bw, "# * Created on %s\n", formatDumpTime(created))
+	fmt.Fprintf(bw, "# * Best block at time of backup was %d (%v)\n\n",
+		synced.Height, synced.Hash)
+	for _, e := range entries {
+		if e.wif == nil {
+			fmt.Fprintf(bw, "%s %s script=1 # addr=%s\n",
+				hex.EncodeToString(e.script),
+				formatDumpTime(e.created), e.addr)
+			continue
+		}
+
+		kind := "label=" + encodeDumpString(e.label)
+		if e.change {
+			kind = "change=1"
+		}
+		fmt.Fprintf(bw, "%s %s %s # addr=%s", e.wif.String(),
+			formatDumpTime(e.created), kind, e.addr)
+		if e.hdKeyPath != "" {
+			fmt.Fprintf(bw, " hdkeypath=%s", e.hdKeyPath)
+		}
+		fmt.Fprintln(bw)
+	}
+	fmt.Fprintf(bw, "\n# End of dump\n")
+	return bw.Flush()
+}
+
+// parseDump parses a wallet dump in the text format of bitcoind's dumpwallet.
+// Keys for networks other than the passed one are rejected.  As with bitcoind,
+// creation times which can't be parsed are considered unknown.
+func parseDump(r io.Reader, params *chaincfg.Params) ([]dumpEntry, error) {
+	var entries []dumpEntry
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: missing creation time",
+				lineNum)
+		}
+
+		var e dumpEntry
+		if wif, err := btcutil.DecodeWIF(fields[0]); err == nil {
+			if !wif.IsForNet(params) {
+				return nil, fmt.Errorf("line %d: key is not for "+
+					"the %s network", lineNum, params.Name)
+			}
+			e.wif = wif
+		} else if script, err := hex.DecodeString(fields[0]); err == nil {
+			e.script = script
+		} else {
+			return nil, fmt.Errorf("line %d: invalid key or script",
+				lineNum)
+		}
+		if t, err := time.Parse(dumpTimeFormat, fields[1]); err == nil &&
+			t.Unix() > 0 {
+			e.created = t
+		}
+		for _, field := range fields[2:] {
+			if field[0] == '#' {
+				break
+			}
+			switch {
+			case field == "change=1":
+				e.change = true
+			case strings.HasPrefix(field, "label="):
+				e.label = decodeDumpString(field[len("label="):])
+			}
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// DumpWallet writes the private keys and scripts of the wallet to a new file at
+// the passed path, in the text format of bitcoind's dumpwallet, so they can be
+// imported by either bitcoind or btcwallet.  The label of each key is the name
+// of its account, and the BIP0044 derivation path of derived keys is included.
+// The creation time of each key or script is the time of the block of its
+// birthday, or unknown when the wallet is not connected to a chain server.
+//
+// The manager must be unlocked.
+func (w *Wallet) DumpWallet(path string) error {
+	addrs, err := w.Manager.AllActiveAddresses()
+	if err != nil {
+		return err
+	}
+
+	blockTimes := make(map[wire.ShaHash]time.Time)
+	entries := make([]dumpEntry, 0, len(addrs))
+	for _, addr := range addrs {
+		ma, err := w.Manager.Address(addr)
+		if err != nil {
+			return err
+		}
+		e := dumpEntry{addr: addr.EncodeAddress()}
+		switch ma := ma.(type) {
+		case waddrmgr.ManagedPubKeyAddress:
+			e.wif, err = ma.ExportPrivKey()
+		case waddrmgr.ManagedScriptAddress:
+			e.script, err = ma.Script()
+		}
+		if err != nil {
+			return err
+		}
+
+		details, err := w.Manager.AddressDetails(addr)
+		if err != nil {
+			return err
+		}
+		e.label, err = w.Manager.AccountName(details.Account)
+		if err != nil {
+			return err
+		}
+		e.change = ma.Internal()
+		if details.Derived {
+			e.hdKeyPath = fmt.Sprintf("m/44'/%d'/%d'/%d/%d",
+				w.chainParams.HDCoinType, details.Account,
+				details.Branch, details.Index)
+		}
+
+		birthday, err := w.Manager.AddressBirthday(addr)
+		if err != nil {
+			return err
+		}
+		e.created, err = w.blockTime(birthday.Hash, blockTimes)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, e)
+	}
+
+	fi, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	err = writeDump(fi, time.Now(), w.Manager.SyncedTo(), entries)
+	if err == nil {
+		err = fi.Sync()
+	}
+	fi.Close()
+	if err != nil {
+		_ = os.Remove(path)
+		return err
+	}
+
+	log.Infof("Dumped %d keys and scripts to %s", len(entries), path)
+	return nil
+}
+
+// blockTime returns the timestamp of the block with the passed hash, or the
+// zero time when the wallet is not connected to a chain server.  Timestamps
+// are cached in the passed map.
+func (w *Wallet) blockTime(hash wire.ShaHash, cache map[wire.ShaHash]time.Time) (time.Time, error) {
+	if t, ok := cache[hash]; ok {
+		return t, nil
+	}
+
+	w.chainSvrLock.Lock()
+	chainSvr := w.chainSvr
+	w.chainSvrLock.Unlock()
+	if chainSvr == nil {
+		return time.Time{}, nil
+	}
+
+	block, err := chainSvr.GetBlock(&hash)
+	if err != nil {
+		return time.Time{}, err
+	}
+	t := block.MsgBlock().Header.Timestamp
+	cache[hash] = t
+	return t, nil
+}
+
+// ImportWallet imports the private keys and scripts of a wallet dump in the
+// text format of bitcoind's dumpwallet.  Keys and scripts already known to the
+// manager are skipped.  The birthday of each imported key or script is the
+// last block mined before its creation time, or the genesis block when the
+// time is unknown or the wallet is not connected to a chain server.  A single
+// rescan, starting at the earliest birthday, is then started for all the
+// imported addresses.  Labels are not imported, as all imported keys and
+// scripts belong to the imported account.
+//
+// The manager must be unlocked to import private keys.  When an entry can not
+// be imported, the rescan is still started for the entries imported before
+// it.
+func (w *Wallet) ImportWallet(r io.Reader) error {
+	entries, err := parseDump(r, w.chainParams)
+	if err != nil {
+		return err
+	}
+
+	addrs, rescanFrom, err := w.importDumpEntries(entries)
+	log.Infof("Imported %d of %d keys and scripts", len(addrs),
+		len(entries))
+	if len(addrs) != 0 {
+		if werr := w.WatchAddresses(addrs, rescanFrom); err == nil {
+			err = werr
+		}
+	}
+	return err
+}
+
+// importDumpEntries imports the keys and scripts of parsed dump entries, and
+// returns the imported addresses and their earliest birthday.  These are
+// returned for the entries imported before any error, too.
+func (w *Wallet) importDumpEntries(entries []dumpEntry) ([]btcutil.Address,
+	waddrmgr.BlockStamp, error) {
+
+	birthdays := make(map[time.Time]waddrmgr.BlockStamp)
+	heightTimes := make(map[int32]time.Time)
+	var addrs []btcutil.Address
+	var rescanFrom waddrmgr.BlockStamp
+	for _, e := range entries {
+		bs, ok := birthdays[e.created]
+		if !ok {
+			var err error
+			bs, err = w.blockStampBefore(e.created, heightTimes)
+			if err != nil {
+				return addrs, rescanFrom, err
+			}
+			birthdays[e.created] = bs
+		}
+
+		var ma waddrmgr.ManagedAddress
+		var err error
+		if e.wif != nil {
+			ma, err = w.Manager.ImportPrivateKey(e.wif, &bs)
+		} else {
+			ma, err = w.Manager.ImportScript(e.script, &bs)
+		}
+		if merr, ok := err.(waddrmgr.ManagerError); ok &&
+			merr.ErrorCode == waddrmgr.ErrDuplicateAddress {
+			continue
+		}
+		if err != nil {
+			return addrs, rescanFrom, err
+		}
+
+		if len(addrs) == 0 || bs.Height < rescanFrom.Height {
+			rescanFrom = bs
+		}
+		addrs = append(addrs, ma.Address())
+	}
+	return addrs, rescanFrom, nil
+}
+
+// blockStampBefore returns the block stamp of the last main chain block with a
+// timestamp before the passed time, less dumpTimestampWindow, or the genesis
+// block if there is none, the time is zero, or the wallet is not connected to
+// a chain server.  Block timestamps are looked up by binary search, and are
+// cached by height in the passed map.
+func (w *Wallet) blockStampBefore(t time.Time, cache map[int32]time.Time) (waddrmgr.BlockStamp, error) {
+	genesis := waddrmgr.BlockStamp{Hash: *w.chainParams.GenesisHash}
+
+	w.chainSvrLock.Lock()
+	chainSvr := w.chainSvr
+	w.chainSvrLock.Unlock()
+	if chainSvr == nil || t.IsZero() {
+		return genesis, nil
+	}
+	best, err := chainSvr.BlockStamp()
+	if err != nil {
+		return waddrmgr.BlockStamp{}, err
+	}
+
+	blockTime := func(height int32) (time.Time, error) {
+		if t, ok := cache[height]; ok {
+			return t, nil
+		}
+		hash, err := chainSvr.GetBlockHash(int64(height))
+		if err != nil {
+			return time.Time{}, err
+		}
+		block, err := chainSvr.GetBlock(hash)
+		if err != nil {
+			return time.Time{}, err
+		}
+		t := block.MsgBlock().Header.Timestamp
+		cache[height] = t
+		return t, nil
+	}
+
+	// Search for the first block at or after the time, the block before it
+	// being the last one before the time.
+	t = t.Add(-dumpTimestampWindow)
+	lo, hi := int32(0), best.Height+1
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		midTime, err := blockTime(mid)
+		if err != nil {
+			return waddrmgr.BlockStamp{}, err
+		}
+		if midTime.Before(t) {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo == 0 {
+		return genesis, nil
+	}
+	hash, err := chainSvr.GetBlockHash(int64(lo - 1))
+	if err != nil {
+		return waddrmgr.BlockStamp{}, err
+	}
+	return waddrmgr.BlockStamp{Height: lo - 1, Hash: *hash}, nil
+}
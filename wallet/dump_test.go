@@ -0,0 +1,236 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package wallet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil"
+	"github.com/monetas/btcwallet/txstore"
+	"github.com/monetas/btcwallet/waddrmgr"
+	"github.com/monetas/btcwallet/walletdb"
+)
+
+func TestDumpStringEncoding(t *testing.T) {
+	tests := []struct {
+		decoded string
+		encoded string
+	}{
+		{"savings", "savings"},
+		{"my savings", "my%20savings"},
+		{"100%", "100%25"},
+		{"café", "caf%c3%a9"},
+	}
+	for _, test := range tests {
+		if got := encodeDumpString(test.decoded); got != test.encoded {
+			t.Errorf("encodeDumpString(%q): got %q, want %q",
+				test.decoded, got, test.encoded)
+		}
+		if got := decodeDumpString(test.encoded); got != test.decoded {
+			t.Errorf("decodeDumpString(%q): got %q, want %q",
+				test.encoded, got, test.decoded)
+		}
+	}
+}
+
+func TestDumpRoundTrip(t *testing.T) {
+	wif, err := btcutil.DecodeWIF("L3jmpy54Pc7MLXTN2mL8Xas7BJziwKaUGmgnXXzgGbVRdiAniXZk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := hexToBytes("5121033a2ba8a4e4c6ab55e33ed0b2e1e1d1c0cf14c4b6ea3bf5d81dbdb6b3b7f5b4bf51ae")
+	created := time.Unix(1425000000, 0).UTC()
+	entries := []dumpEntry{
+		{wif: wif, created: created, label: "my savings",
+			hdKeyPath: "m/44'/0'/1'/0/5"},
+		{wif: wif, change: true},
+		{script: script, created: created},
+	}
+
+	var buf bytes.Buffer
+	err = writeDump(&buf, created, waddrmgr.BlockStamp{Height: 340000},
+		entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), " label=my%20savings ") ||
+		!strings.Contains(buf.String(), " hdkeypath=m/44'/0'/1'/0/5") {
+		t.Errorf("Unexpected dump:\n%s", buf.String())
+	}
+
+	parsed, err := parseDump(&buf, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != len(entries) {
+		t.Fatalf("Wrong number of entries; got %d, want %d",
+			len(parsed), len(entries))
+	}
+	for i, e := range parsed {
+		want := entries[i]
+		if (e.wif == nil) != (want.wif == nil) ||
+			(e.wif != nil && e.wif.String() != want.wif.String()) ||
+			!bytes.Equal(e.script, want.script) ||
+			!e.created.Equal(want.created) ||
+			e.label != want.label || e.change != want.change {
+			t.Errorf("Entry %d: got %+v, want %+v", i, e, want)
+		}
+	}
+}
+
+func TestParseDump(t *testing.T) {
+	// A dump as written by bitcoind.
+	dump := `# Wallet dump created by Bitcoin v0.10.0.0-g047a898 (Mon, 16 Feb 2015 09:38:27 +0100)
+# * Created on 2015-03-01T12:00:00Z
+# * Best block at time of backup was 345000 (000000000000000013f4a1e6e7be1a5ae8b35a0a7ca4c4a94a5c58e4c1c5ef5c),
+#   mined on 2015-03-01T11:52:17Z
+
+Kx4DNid19W8sjNFN3uPqQE7UYnCqyEp7unCvdkf2LrVUFpnDtwpB 2015-02-20T10:00:00Z label=cold%20storage # addr=1N3D8jy2aQuUsKBsDgZ6ZPTVR9VhHgJYpE
+L45fWF6Yd736fDohuB97vwRRLdQQJr3ZGvbokk9ubiT7aNrg7tTn 1970-01-01T00:00:01Z reserve=1 # addr=1VTfwD4iHre2bMrR9qGiJMwoiZGQZ8e6s
+L4R8XyxYQyPSpTwj8w96tM86a6j3QA9jbRPj3RA7DVTVWk71ndeP 0 change=1 # addr=13TdEj4ehUuYFiSaB47eLVBwM2XhAhrK2J
+
+# End of dump
+`
+	entries, err := parseDump(strings.NewReader(dump),
+		&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Wrong number of entries; got %d, want 3", len(entries))
+	}
+	wantCreated := time.Date(2015, 2, 20, 10, 0, 0, 0, time.UTC)
+	if !entries[0].created.Equal(wantCreated) ||
+		entries[0].label != "cold storage" {
+		t.Errorf("Entry 0: got %+v", entries[0])
+	}
+	if !entries[1].created.Equal(time.Unix(1, 0)) {
+		t.Errorf("Entry 1: got creation time %v", entries[1].created)
+	}
+	if !entries[2].created.IsZero() || !entries[2].change {
+		t.Errorf("Entry 2: got %+v", entries[2])
+	}
+
+	// Keys for other networks are rejected.
+	_, err = parseDump(strings.NewReader(dump), &chaincfg.TestNet3Params)
+	if err == nil {
+		t.Error("Keys for another network were not rejected")
+	}
+
+	// So are lines which are neither keys nor scripts.
+	_, err = parseDump(strings.NewReader("notakey 0\n"),
+		&chaincfg.MainNetParams)
+	if err == nil {
+		t.Error("Invalid line was not rejected")
+	}
+}
+
+func hexToBytes(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// TestImportDumpPartialRescan ensures the addresses imported before an entry
+// of a dump fails are returned for the rescan, and that the rescan is saved
+// as a checkpoint when the wallet is not started.
+func TestImportDumpPartialRescan(t *testing.T) {
+	db, err := walletdb.Create("memdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	namespace, err := db.Namespace(waddrmgrNamespaceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := &chaincfg.TestNet3Params
+	seed := bytes.Repeat([]byte{0x2a}, 32)
+	mgr, err := waddrmgr.Create(namespace, seed, []byte("pub"),
+		[]byte("priv"), params, fastScrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgr.Close()
+	if err := mgr.Unlock([]byte("priv")); err != nil {
+		t.Fatal(err)
+	}
+	w, err := Open(&Config{
+		ChainParams: params,
+		Db:          &db,
+		TxStore:     txstore.New(""),
+		Waddrmgr:    mgr,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newWIF := func(net *chaincfg.Params) *btcutil.WIF {
+		privKey, err := btcec.NewPrivateKey(btcec.S256())
+		if err != nil {
+			t.Fatal(err)
+		}
+		wif, err := btcutil.NewWIF(privKey, net, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return wif
+	}
+
+	// The key for the wrong network can't be imported.
+	entries := []dumpEntry{
+		{wif: newWIF(params)},
+		{wif: newWIF(&chaincfg.MainNetParams)},
+	}
+	addrs, rescanFrom, err := w.importDumpEntries(entries)
+	if merr, ok := err.(waddrmgr.ManagerError); !ok ||
+		merr.ErrorCode != waddrmgr.ErrWrongNet {
+
+		t.Fatalf("importDumpEntries: got error %v, want ErrWrongNet",
+			err)
+	}
+	if len(addrs) != 1 {
+		t.Fatalf("Wrong number of imported addresses; got %d, want 1",
+			len(addrs))
+	}
+
+	if err := w.WatchAddresses(addrs, rescanFrom); err != nil {
+		t.Fatalf("WatchAddresses: unexpected error: %v", err)
+	}
+	var cps []*rescanCheckpoint
+	err = w.namespace.View(func(tx walletdb.Tx) error {
+		var err error
+		cps, err = fetchRescanCheckpoints(tx, params)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cps) != 1 || len(cps[0].addrs) != 1 ||
+		cps[0].addrs[0].EncodeAddress() != addrs[0].EncodeAddress() {
+
+		t.Fatalf("Wrong rescan checkpoints: %+v", cps)
+	}
+}
@@ -17,11 +17,29 @@
 package wallet
 
 import (
+	"errors"
+	"time"
+
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
 	"github.com/monetas/btcwallet/chain"
 	"github.com/monetas/btcwallet/txstore"
 	"github.com/monetas/btcwallet/waddrmgr"
+	"github.com/monetas/btcwallet/walletdb"
+)
+
+var (
+	// ErrRescanCancelled is the error result of a rescan which was
+	// cancelled before it finished.
+	ErrRescanCancelled = errors.New("rescan cancelled")
+
+	// ErrRescanNotFound describes an error where a rescan ID does not
+	// match any queued, running, or interrupted rescan.
+	ErrRescanNotFound = errors.New("rescan not found")
+
+	// ErrRescanShutdown is the error result of a rescan request which
+	// could not be handled because the wallet is shutting down.
+	ErrRescanShutdown = errors.New("wallet is shutting down")
 )
 
 // RescanProgressMsg reports the current progress made by a rescan for a
@@ -29,6 +47,8 @@ import (
 type RescanProgressMsg struct {
 	Addresses    []btcutil.Address
 	Notification *chain.RescanProgress
+
+	initialSync bool
 }
 
 // RescanFinishedMsg reports the addresses that were rescanned when a
@@ -36,38 +56,112 @@ type RescanProgressMsg struct {
 type RescanFinishedMsg struct {
 	Addresses    []btcutil.Address
 	Notification *chain.RescanFinished
+
+	partial bool
 }
 
 // RescanJob is a job to be processed by the RescanManager.  The job includes
 // a set of wallet addresses, a starting height to begin the rescan, and
-// outpoints spendable by the addresses thought to be unspent.  After the
-// rescan completes, the error result of the rescan RPC is sent on the Err
-// channel.
+// outpoints spendable by the addresses thought to be unspent.  The rescan
+// stops at EndBlock, or continues through the best block if it is nil.
+// After the rescan completes, the error result of the rescan RPC is sent on
+// the Err channel.
 type RescanJob struct {
 	InitialSync bool
 	Addrs       []btcutil.Address
 	OutPoints   []*wire.OutPoint
 	BlockStamp  waddrmgr.BlockStamp
+	EndBlock    *waddrmgr.BlockStamp
 	err         chan error
+
+	// replaces holds the IDs of interrupted rescans which are covered by
+	// this job.  Their checkpoints are removed once the job is queued.
+	replaces []uint64
+}
+
+// RescanState describes whether a rescan is waiting to be started, currently
+// running, or was interrupted before it could finish.
+type RescanState string
+
+// These constants define the possible states of a rescan.
+const (
+	RescanPending     RescanState = "pending"
+	RescanRunning     RescanState = "running"
+	RescanInterrupted RescanState = "interrupted"
+)
+
+// RescanStatus describes a rescan that has not yet finished.  Progress is the
+// most recent block the rescan has completed, and is where the rescan resumes
+// from if the wallet is restarted.
+type RescanStatus struct {
+	ID           uint64
+	State        RescanState
+	InitialSync  bool
+	NumAddrs     int
+	NumOutPoints int
+	Start        waddrmgr.BlockStamp
+	Progress     waddrmgr.BlockStamp
+	End          *waddrmgr.BlockStamp
 }
 
 // rescanBatch is a collection of one or more RescanJobs that were merged
 // together before a rescan is performed.
 type rescanBatch struct {
+	id          uint64
 	initialSync bool
 	addrs       []btcutil.Address
 	outpoints   []*wire.OutPoint
 	bs          waddrmgr.BlockStamp
+	progress    waddrmgr.BlockStamp
+	end         *waddrmgr.BlockStamp
+	cancelled   bool
 	errChans    []chan error
+
+	// progressSaved is when the progress of the batch was last saved, and
+	// progressDirty is set while newer progress is not saved yet.
+	progressSaved time.Time
+	progressDirty bool
 }
 
+// rescanResult is sent by the rescan RPC handler to the batch handler with
+// the result of the rescan RPC for a batch.
+type rescanResult struct {
+	batch *rescanBatch
+	err   error
+}
+
+type (
+	rescanStatusRequest chan []RescanStatus
+
+	rescanCancelRequest struct {
+		id  uint64
+		err chan error
+	}
+
+	rescanResumeRequest chan rescanResumeResponse
+
+	rescanResumeResponse struct {
+		initialSync []*rescanCheckpoint
+		err         error
+	}
+)
+
 // SubmitRescan submits a RescanJob to the RescanManager.  A channel is
 // returned with the final error of the rescan.  The channel is buffered
-// and does not need to be read to prevent a deadlock.
+// and does not need to be read to prevent a deadlock.  Read-only wallets
+// never run rescans and send ErrReadOnly.
 func (w *Wallet) SubmitRescan(job *RescanJob) <-chan error {
 	errChan := make(chan error, 1)
 	job.err = errChan
-	w.rescanAddJob <- job
+	if w.readOnly {
+		errChan <- ErrReadOnly
+		return errChan
+	}
+	select {
+	case w.rescanAddJob <- job:
+	case <-w.quit:
+		errChan <- ErrRescanShutdown
+	}
 	return errChan
 }
 
@@ -78,13 +172,15 @@ func (job *RescanJob) batch() *rescanBatch {
 		addrs:       job.Addrs,
 		outpoints:   job.OutPoints,
 		bs:          job.BlockStamp,
+		progress:    job.BlockStamp,
+		end:         job.EndBlock,
 		errChans:    []chan error{job.err},
 	}
 }
 
 // merge merges the work from k into j, setting the starting height to
-// the minimum of the two jobs.  This method does not check for
-// duplicate addresses or outpoints.
+// the minimum and the end height to the maximum of the two jobs.  This
+// method does not check for duplicate addresses or outpoints.
 func (b *rescanBatch) merge(job *RescanJob) {
 	if job.InitialSync {
 		b.initialSync = true
@@ -93,50 +189,237 @@ func (b *rescanBatch) merge(job *RescanJob) {
 	b.outpoints = append(b.outpoints, job.OutPoints...)
 	if job.BlockStamp.Height < b.bs.Height {
 		b.bs = job.BlockStamp
+		b.progress = job.BlockStamp
+	}
+	switch {
+	case job.EndBlock == nil:
+		b.end = nil
+	case b.end != nil && job.EndBlock.Height > b.end.Height:
+		b.end = job.EndBlock
 	}
 	b.errChans = append(b.errChans, job.err)
 }
 
 // done iterates through all error channels, duplicating sending the error
 // to inform callers that the rescan finished (or could not complete due
-// to an error).
+// to an error).  Callers are only informed once, so later calls are noops.
 func (b *rescanBatch) done(err error) {
 	for _, c := range b.errChans {
 		c <- err
 	}
+	b.errChans = nil
+}
+
+// partial returns whether the batch stops before the best block without
+// rescanning the entire wallet.
+func (b *rescanBatch) partial() bool {
+	return b.end != nil && !b.initialSync
+}
+
+// checkpoint returns the database representation of the batch.
+func (b *rescanBatch) checkpoint() *rescanCheckpoint {
+	return &rescanCheckpoint{
+		id:          b.id,
+		initialSync: b.initialSync,
+		addrs:       b.addrs,
+		outpoints:   b.outpoints,
+		start:       b.bs,
+		progress:    b.progress,
+		end:         b.end,
+	}
+}
+
+// status describes the batch with the given state.
+func (b *rescanBatch) status(state RescanState) RescanStatus {
+	return RescanStatus{
+		ID:           b.id,
+		State:        state,
+		InitialSync:  b.initialSync,
+		NumAddrs:     len(b.addrs),
+		NumOutPoints: len(b.outpoints),
+		Start:        b.bs,
+		Progress:     b.progress,
+		End:          b.end,
+	}
+}
+
+// newRescanBatch creates the batch for a job, assigning it a new rescan ID
+// and saving its initial checkpoint.
+func (w *Wallet) newRescanBatch(job *RescanJob) *rescanBatch {
+	b := job.batch()
+	err := w.namespace.Update(func(tx walletdb.Tx) error {
+		var err error
+		b.id, err = nextRescanID(tx)
+		if err != nil {
+			return err
+		}
+		if err := putRescanCheckpoint(tx, b.checkpoint()); err != nil {
+			return err
+		}
+		return deleteRescanCheckpoints(tx, job.replaces)
+	})
+	if err != nil {
+		log.Errorf("Cannot save rescan checkpoint: %v", err)
+	}
+	return b
+}
+
+// saveRescanCheckpoint writes the current state of a batch to the database so
+// it may be resumed if the wallet is restarted before the rescan finishes.
+// The checkpoints of the replaced rescans, which the batch now covers, are
+// removed in the same transaction.
+func (w *Wallet) saveRescanCheckpoint(b *rescanBatch, replaces []uint64) {
+	err := w.namespace.Update(func(tx walletdb.Tx) error {
+		if err := putRescanCheckpoint(tx, b.checkpoint()); err != nil {
+			return err
+		}
+		return deleteRescanCheckpoints(tx, replaces)
+	})
+	if err != nil {
+		log.Errorf("Cannot save rescan checkpoint: %v", err)
+	}
+}
+
+// rescanProgressSaveInterval is the minimum time between two writes of the
+// progress of a running rescan, so a rescan sending progress notifications
+// for every few blocks does not write to the database as often.  At most this
+// much progress is rescanned again when an interrupted rescan is resumed.
+const rescanProgressSaveInterval = 30 * time.Second
+
+// updateRescanProgress records new progress of a batch, and writes it to the
+// database unless the progress was saved less than rescanProgressSaveInterval
+// ago.
+func (w *Wallet) updateRescanProgress(b *rescanBatch, progress waddrmgr.BlockStamp) {
+	b.progress = progress
+	b.progressDirty = true
+	if time.Since(b.progressSaved) >= rescanProgressSaveInterval {
+		w.saveRescanProgress(b)
+	}
+}
+
+// saveRescanProgress writes the progress of a batch to the database, if it
+// was not saved yet.  The addresses and outpoints of the batch do not change
+// once it is running, so they are left as saved by its checkpoint.
+func (w *Wallet) saveRescanProgress(b *rescanBatch) {
+	if !b.progressDirty {
+		return
+	}
+	err := w.namespace.Update(func(tx walletdb.Tx) error {
+		return putRescanProgress(tx, b.id, &b.progress)
+	})
+	if err != nil {
+		log.Errorf("Cannot save rescan progress: %v", err)
+		return
+	}
+	b.progressSaved = time.Now()
+	b.progressDirty = false
+}
+
+// removeRescanCheckpoint removes the saved checkpoint of a finished or
+// cancelled rescan.
+func (w *Wallet) removeRescanCheckpoint(id uint64) {
+	err := w.namespace.Update(func(tx walletdb.Tx) error {
+		return deleteRescanCheckpoint(tx, id)
+	})
+	if err != nil {
+		log.Errorf("Cannot remove rescan checkpoint: %v", err)
+	}
 }
 
 // rescanBatchHandler handles incoming rescan request, serializing rescan
 // submissions, and possibly batching many waiting requests together so they
 // can be handled by a single rescan after the current one completes.
+//
+// The handler owns all state of the current and next batches.  Progress
+// notifications are checkpointed to the database, at most once per
+// rescanProgressSaveInterval and whenever a rescan stops without finishing,
+// so an interrupted rescan can be resumed from about the last completed block
+// rather than the original starting block.
 func (w *Wallet) rescanBatchHandler() {
 	var curBatch, nextBatch *rescanBatch
 
+	// resumed holds the interrupted rescans which were resumed while
+	// another batch was running.  They keep the IDs of their checkpoints,
+	// so they are never merged with other jobs, and run before nextBatch.
+	var resumed []*rescanBatch
+
+	// sendBatch is non-nil when curBatch must still be sent to the rescan
+	// RPC handler.
+	var sendBatch chan<- *rescanBatch
+
+	addJob := func(job *RescanJob) {
+		switch {
+		case curBatch == nil:
+			// Set current batch as this job and send request.
+			curBatch = w.newRescanBatch(job)
+			sendBatch = w.rescanBatch
+		case nextBatch == nil:
+			nextBatch = w.newRescanBatch(job)
+		default:
+			nextBatch.merge(job)
+			w.saveRescanCheckpoint(nextBatch, job.replaces)
+		}
+	}
+	resumeBatch := func(b *rescanBatch) {
+		if curBatch == nil {
+			curBatch = b
+			sendBatch = w.rescanBatch
+			return
+		}
+		resumed = append(resumed, b)
+	}
+	startNext := func() {
+		if len(resumed) != 0 {
+			curBatch = resumed[0]
+			resumed = resumed[1:]
+		} else {
+			curBatch, nextBatch = nextBatch, nil
+		}
+		sendBatch = nil
+		if curBatch != nil {
+			sendBatch = w.rescanBatch
+		}
+	}
+	// pending returns the batches waiting for the current one, in the
+	// order they will run.
+	pending := func() []*rescanBatch {
+		batches := make([]*rescanBatch, 0, len(resumed)+1)
+		batches = append(batches, resumed...)
+		if nextBatch != nil {
+			batches = append(batches, nextBatch)
+		}
+		return batches
+	}
+
 out:
 	for {
 		select {
 		case job := <-w.rescanAddJob:
-			if curBatch == nil {
-				// Set current batch as this job and send
-				// request.
-				curBatch = job.batch()
-				w.rescanBatch <- curBatch
-			} else {
-				// Create next batch if it doesn't exist, or
-				// merge the job.
-				if nextBatch == nil {
-					nextBatch = job.batch()
-				} else {
-					nextBatch.merge(job)
-				}
-			}
+			addJob(job)
+
+		case sendBatch <- curBatch:
+			sendBatch = nil
 
 		case n := <-w.rescanNotifications:
 			switch n := n.(type) {
 			case *chain.RescanProgress:
+				if curBatch == nil {
+					log.Warnf("Received rescan progress " +
+						"notification but no rescan " +
+						"currently running")
+					continue
+				}
+				if curBatch.cancelled {
+					continue
+				}
+				w.updateRescanProgress(curBatch, waddrmgr.BlockStamp{
+					Hash:   *n.Hash,
+					Height: n.Height,
+				})
 				w.rescanProgress <- &RescanProgressMsg{
 					Addresses:    curBatch.addrs,
 					Notification: n,
+					initialSync:  curBatch.initialSync,
 				}
 
 			case *chain.RescanFinished:
@@ -146,15 +429,32 @@ out:
 						"currently running")
 					continue
 				}
-				w.rescanFinished <- &RescanFinishedMsg{
-					Addresses:    curBatch.addrs,
-					Notification: n,
+				if !curBatch.cancelled {
+					w.removeRescanCheckpoint(curBatch.id)
+					w.rescanFinished <- &RescanFinishedMsg{
+						Addresses:    curBatch.addrs,
+						Notification: n,
+						partial:      curBatch.partial(),
+					}
 				}
+				startNext()
 
-				curBatch, nextBatch = nextBatch, nil
+			case *rescanResult:
+				err := n.err
+				if n.batch.cancelled {
+					err = ErrRescanCancelled
+				}
+				n.batch.done(err)
 
-				if curBatch != nil {
-					w.rescanBatch <- curBatch
+				// A failed rescan never sends a finished
+				// notification, so start the next batch now.
+				// The checkpoint is kept, with all progress
+				// received, so the rescan can be resumed later.
+				if n.err != nil && n.batch == curBatch {
+					if !curBatch.cancelled {
+						w.saveRescanProgress(curBatch)
+					}
+					startNext()
 				}
 
 			default:
@@ -162,15 +462,189 @@ out:
 				panic(n)
 			}
 
+		case req := <-w.rescanStatusRequests:
+			req <- w.rescanStatuses(curBatch, pending())
+
+		case req := <-w.rescanCancelRequests:
+			switch {
+			case curBatch != nil && curBatch.id == req.id:
+				// The chain server can not abort a running
+				// rescan, so instead the results are ignored
+				// and the caller is informed now.
+				curBatch.cancelled = true
+				curBatch.done(ErrRescanCancelled)
+				w.removeRescanCheckpoint(req.id)
+				req.err <- nil
+
+				// A batch which was not sent to the rescan RPC
+				// handler yet is dropped before it starts.
+				if sendBatch != nil {
+					startNext()
+				}
+
+			case nextBatch != nil && nextBatch.id == req.id:
+				nextBatch.done(ErrRescanCancelled)
+				w.removeRescanCheckpoint(req.id)
+				nextBatch = nil
+				req.err <- nil
+
+			case batchIndex(resumed, req.id) != -1:
+				i := batchIndex(resumed, req.id)
+				resumed[i].done(ErrRescanCancelled)
+				w.removeRescanCheckpoint(req.id)
+				resumed = append(resumed[:i], resumed[i+1:]...)
+				req.err <- nil
+
+			default:
+				req.err <- w.cancelInterruptedRescan(req.id)
+			}
+
+		case req := <-w.rescanResumeRequests:
+			batches, initialSync, err := w.interruptedRescanBatches(
+				curBatch, pending())
+			for _, b := range batches {
+				resumeBatch(b)
+			}
+			req <- rescanResumeResponse{initialSync, err}
+
 		case <-w.quit:
 			break out
 		}
 	}
 
+	// Inform the callers waiting on queued rescans, which are resumed
+	// from their checkpoints when the wallet is restarted.
+	if curBatch != nil {
+		if !curBatch.cancelled {
+			w.saveRescanProgress(curBatch)
+		}
+		curBatch.done(ErrRescanShutdown)
+	}
+	for _, b := range pending() {
+		b.done(ErrRescanShutdown)
+	}
 	close(w.rescanBatch)
 	w.wg.Done()
 }
 
+// rescanStatuses returns the status of the current and pending batches and all
+// checkpointed rescans that are not queued.
+func (w *Wallet) rescanStatuses(curBatch *rescanBatch, pending []*rescanBatch) []RescanStatus {
+	var statuses []RescanStatus
+	if curBatch != nil && !curBatch.cancelled {
+		statuses = append(statuses, curBatch.status(RescanRunning))
+	}
+	for _, b := range pending {
+		statuses = append(statuses, b.status(RescanPending))
+	}
+
+	// Read-only wallets may have no namespace, and so no checkpoints.
+	if w.namespace == nil {
+		return statuses
+	}
+	var cps []*rescanCheckpoint
+	err := w.namespace.View(func(tx walletdb.Tx) error {
+		var err error
+		cps, err = fetchRescanCheckpoints(tx, w.chainParams)
+		return err
+	})
+	if err != nil {
+		log.Errorf("Cannot fetch rescan checkpoints: %v", err)
+	}
+	for _, cp := range cps {
+		if isQueuedRescan(cp.id, curBatch, pending) {
+			continue
+		}
+		statuses = append(statuses, RescanStatus{
+			ID:           cp.id,
+			State:        RescanInterrupted,
+			InitialSync:  cp.initialSync,
+			NumAddrs:     len(cp.addrs),
+			NumOutPoints: len(cp.outpoints),
+			Start:        cp.start,
+			Progress:     cp.progress,
+			End:          cp.end,
+		})
+	}
+	return statuses
+}
+
+// isQueuedRescan returns whether the rescan ID matches the current batch or
+// any pending one.
+func isQueuedRescan(id uint64, curBatch *rescanBatch, pending []*rescanBatch) bool {
+	if curBatch != nil && curBatch.id == id {
+		return true
+	}
+	return batchIndex(pending, id) != -1
+}
+
+// batchIndex returns the index of the batch with the rescan ID, or -1 if
+// none of the batches has it.
+func batchIndex(batches []*rescanBatch, id uint64) int {
+	for i, b := range batches {
+		if b.id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// cancelInterruptedRescan removes the checkpoint of a rescan which is no
+// longer queued, preventing it from being resumed.
+func (w *Wallet) cancelInterruptedRescan(id uint64) error {
+	return w.namespace.Update(func(tx walletdb.Tx) error {
+		if !existsRescanCheckpoint(tx, id) {
+			return ErrRescanNotFound
+		}
+		return deleteRescanCheckpoint(tx, id)
+	})
+}
+
+// interruptedRescanBatches returns batches continuing every checkpointed
+// rescan that is not queued from its last completed block.  The batches keep
+// the IDs of their checkpoints, so clients can still refer to the rescans by
+// the IDs they were given before the interruption.
+//
+// Interrupted initial sync rescans are not resumed, but returned separately,
+// as they are continued by the initial sync rescan submitted after syncing
+// with the chain server.
+func (w *Wallet) interruptedRescanBatches(curBatch *rescanBatch,
+	pending []*rescanBatch) ([]*rescanBatch, []*rescanCheckpoint, error) {
+
+	var cps []*rescanCheckpoint
+	err := w.namespace.View(func(tx walletdb.Tx) error {
+		var err error
+		cps, err = fetchRescanCheckpoints(tx, w.chainParams)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	var batches []*rescanBatch
+	var initialSync []*rescanCheckpoint
+	for _, cp := range cps {
+		if isQueuedRescan(cp.id, curBatch, pending) {
+			continue
+		}
+		if cp.initialSync {
+			initialSync = append(initialSync, cp)
+			continue
+		}
+		log.Infof("Resuming rescan %d from block %v (height %d)",
+			cp.id, cp.progress.Hash, cp.progress.Height)
+		batches = append(batches, &rescanBatch{
+			id:          cp.id,
+			initialSync: cp.initialSync,
+			addrs:       cp.addrs,
+			outpoints:   cp.outpoints,
+			bs:          cp.start,
+			progress:    cp.progress,
+			end:         cp.end,
+		})
+	}
+	return batches, initialSync, nil
+}
+
 // rescanProgressHandler handles notifications for partially and fully completed
 // rescans by marking each rescanned address as partially or fully synced.
 func (w *Wallet) rescanProgressHandler() {
@@ -185,6 +659,12 @@ out:
 			log.Infof("Rescanned through block %v (height %d)",
 				n.Hash, n.Height)
 
+			// Only rescans syncing the entire wallet move the
+			// manager's sync state.  The progress of other rescans
+			// is recorded by their checkpoint.
+			if !msg.initialSync {
+				continue
+			}
 			bs := waddrmgr.BlockStamp{
 				Hash:   *n.Hash,
 				Height: n.Height,
@@ -202,6 +682,16 @@ out:
 			log.Infof("Finished rescan for %d %s (synced to block "+
 				"%s, height %d)", len(addrs), noun, n.Hash,
 				n.Height)
+
+			// The rescan may have inserted transactions in any
+			// block, which confirmation watches must now track.
+			w.retrackConfWatches()
+
+			// A rescan that stopped before the best block does not
+			// bring the wallet in sync with the chain.
+			if msg.partial {
+				continue
+			}
 			bs := waddrmgr.BlockStamp{n.Height, *n.Hash}
 			if err := w.Manager.SetSyncedTo(&bs); err != nil {
 				log.Errorf("Failed to update address manager "+
@@ -228,22 +718,33 @@ out:
 
 // rescanRPCHandler reads batch jobs sent by rescanBatchHandler and sends the
 // RPC requests to perform a rescan.  New jobs are not read until a rescan
-// finishes.
+// finishes.  The result of each rescan is passed back to the batch handler,
+// which informs the callers that submitted the jobs.
 func (w *Wallet) rescanRPCHandler() {
 	for batch := range w.rescanBatch {
 		// Log the newly-started rescan.
 		numAddrs := len(batch.addrs)
 		noun := pickNoun(numAddrs, "address", "addresses")
-		log.Infof("Started rescan from block %v (height %d) for %d %s",
-			batch.bs.Hash, batch.bs.Height, numAddrs, noun)
+		log.Infof("Started rescan %d from block %v (height %d) for %d %s",
+			batch.id, batch.progress.Hash, batch.progress.Height,
+			numAddrs, noun)
 
-		err := w.chainSvr.Rescan(&batch.bs.Hash, batch.addrs,
-			batch.outpoints)
+		var err error
+		if batch.end != nil {
+			err = w.chainSvr.RescanEndBlock(&batch.progress.Hash,
+				batch.addrs, batch.outpoints, &batch.end.Hash)
+		} else {
+			err = w.chainSvr.Rescan(&batch.progress.Hash,
+				batch.addrs, batch.outpoints)
+		}
 		if err != nil {
 			log.Errorf("Rescan for %d %s failed: %v", numAddrs,
 				noun, err)
 		}
-		batch.done(err)
+		select {
+		case w.rescanNotifications <- &rescanResult{batch, err}:
+		case <-w.quit:
+		}
 	}
 	w.wg.Done()
 }
@@ -253,6 +754,17 @@ func (w *Wallet) rescanRPCHandler() {
 // current best block in the main chain, and is considered an initial sync
 // rescan.
 func (w *Wallet) Rescan(addrs []btcutil.Address, unspent []txstore.Credit) error {
+	return w.initialSyncRescan(addrs, unspent, nil)
+}
+
+// initialSyncRescan performs an initial sync rescan like Rescan, continuing
+// the passed interrupted initial sync rescans as well.  Rather than running
+// alongside it, they are folded into the new rescan: it starts at the
+// earliest block any of them did not complete, includes their addresses and
+// outpoints, and replaces their checkpoints once queued.
+func (w *Wallet) initialSyncRescan(addrs []btcutil.Address,
+	unspent []txstore.Credit, interrupted []*rescanCheckpoint) error {
+
 	outpoints := make([]*wire.OutPoint, len(unspent))
 	for i, output := range unspent {
 		outpoints[i] = output.OutPoint()
@@ -265,6 +777,205 @@ func (w *Wallet) Rescan(addrs []btcutil.Address, unspent []txstore.Credit) error
 		BlockStamp:  w.Manager.SyncedTo(),
 	}
 
+	if len(interrupted) != 0 {
+		seenAddrs := make(map[string]struct{}, len(addrs))
+		for _, a := range addrs {
+			seenAddrs[a.EncodeAddress()] = struct{}{}
+		}
+		seenOutPoints := make(map[wire.OutPoint]struct{}, len(outpoints))
+		for _, op := range outpoints {
+			seenOutPoints[*op] = struct{}{}
+		}
+		for _, cp := range interrupted {
+			log.Infof("Continuing interrupted initial sync rescan "+
+				"%d from block %v (height %d)", cp.id,
+				cp.progress.Hash, cp.progress.Height)
+			if cp.progress.Height < job.BlockStamp.Height {
+				job.BlockStamp = cp.progress
+			}
+			for _, a := range cp.addrs {
+				if _, ok := seenAddrs[a.EncodeAddress()]; !ok {
+					seenAddrs[a.EncodeAddress()] = struct{}{}
+					job.Addrs = append(job.Addrs, a)
+				}
+			}
+			for _, op := range cp.outpoints {
+				if _, ok := seenOutPoints[*op]; !ok {
+					seenOutPoints[*op] = struct{}{}
+					job.OutPoints = append(job.OutPoints, op)
+				}
+			}
+			job.replaces = append(job.replaces, cp.id)
+		}
+	}
+
 	// Submit merged job and block until rescan completes.
 	return <-w.SubmitRescan(job)
 }
+
+// WatchAddresses submits a rescan starting at the passed block to find the
+// transactions of the passed addresses which were already mined, and requests
+// notifications from the chain server for those paying to the addresses.  The
+// rescan runs in the background and any error it ends with is logged.  Like
+// every rescan, it is checkpointed, so it is resumed after a chain server
+// disconnect or a restart.
+//
+// If the wallet has not been started yet, only the checkpoint of the rescan
+// is saved, and the rescan is resumed when the wallet syncs with the chain
+// server.
+func (w *Wallet) WatchAddresses(addrs []btcutil.Address, bs waddrmgr.BlockStamp) error {
+	job := &RescanJob{
+		Addrs:      addrs,
+		BlockStamp: bs,
+	}
+
+	w.chainSvrLock.Lock()
+	chainSvr := w.chainSvr
+	w.chainSvrLock.Unlock()
+	if chainSvr == nil {
+		return w.saveRescanJob(job)
+	}
+
+	errChan := w.SubmitRescan(job)
+	go func() {
+		if err := <-errChan; err != nil {
+			log.Errorf("Rescan for watched addresses failed: %v", err)
+		}
+	}()
+	return chainSvr.NotifyReceived(addrs)
+}
+
+// saveRescanJob saves a checkpoint for a rescan job which can not be
+// submitted as the wallet is not started.  It is resumed like any other
+// interrupted rescan.
+func (w *Wallet) saveRescanJob(job *RescanJob) error {
+	if w.readOnly {
+		return ErrReadOnly
+	}
+	b := job.batch()
+	return w.namespace.Update(func(tx walletdb.Tx) error {
+		var err error
+		b.id, err = nextRescanID(tx)
+		if err != nil {
+			return err
+		}
+		return putRescanCheckpoint(tx, b.checkpoint())
+	})
+}
+
+// addressesBirthday returns the earliest birthday of the passed addresses.
+// This is the earliest block any transaction relevant to the addresses may
+// appear in, and is used as the start of rescans for the addresses rather
+// than the genesis block.
+func (w *Wallet) addressesBirthday(addrs []btcutil.Address) (waddrmgr.BlockStamp, error) {
+	// Without any addresses, only spends of known unspent outputs can be
+	// found, and these can not appear before the synced block.
+	if len(addrs) == 0 {
+		return w.Manager.SyncedTo(), nil
+	}
+
+	var birthday waddrmgr.BlockStamp
+	for i, addr := range addrs {
+		bs, err := w.Manager.AddressBirthday(addr)
+		if err != nil {
+			return waddrmgr.BlockStamp{}, err
+		}
+		if i == 0 || bs.Height < birthday.Height {
+			birthday = bs
+		}
+	}
+	return birthday, nil
+}
+
+// RescanBlockchain rescans the block chain for transactions relevant to all
+// active addresses and unspent outputs of the wallet, starting at the start
+// block and stopping at the end block.  When start is nil, the rescan begins
+// at the earliest birthday of the wallet addresses.  When end is nil, the
+// rescan continues through the best block.  This blocks until the rescan
+// finishes, and returns the block the rescan started at.
+func (w *Wallet) RescanBlockchain(start, end *waddrmgr.BlockStamp) (waddrmgr.BlockStamp, error) {
+	addrs, unspent, err := w.activeData()
+	if err != nil {
+		return waddrmgr.BlockStamp{}, err
+	}
+	outpoints := make([]*wire.OutPoint, len(unspent))
+	for i, output := range unspent {
+		outpoints[i] = output.OutPoint()
+	}
+
+	if start == nil {
+		birthday, err := w.addressesBirthday(addrs)
+		if err != nil {
+			return waddrmgr.BlockStamp{}, err
+		}
+		start = &birthday
+	}
+
+	job := &RescanJob{
+		Addrs:      addrs,
+		OutPoints:  outpoints,
+		BlockStamp: *start,
+		EndBlock:   end,
+	}
+	return *start, <-w.SubmitRescan(job)
+}
+
+// RescanStatuses returns the status of every rescan that has not yet finished,
+// including rescans interrupted by a restart or chain server disconnect which
+// have not yet been resumed.
+//
+// Rescans are never run by a read-only or shut down wallet, so every
+// checkpointed rescan is reported as interrupted.
+func (w *Wallet) RescanStatuses() []RescanStatus {
+	if w.readOnly {
+		return w.rescanStatuses(nil, nil)
+	}
+	req := make(rescanStatusRequest, 1)
+	select {
+	case w.rescanStatusRequests <- req:
+		return <-req
+	case <-w.quit:
+		return w.rescanStatuses(nil, nil)
+	}
+}
+
+// CancelRescan cancels the queued, running, or interrupted rescan with the
+// given ID.  Callers waiting on a cancelled rescan receive ErrRescanCancelled.
+// ErrRescanNotFound is returned if there is no unfinished rescan with the ID.
+//
+// Chain servers do not support aborting a rescan once it has started, so a
+// running rescan continues to completion on the chain server, but its progress
+// is no longer recorded by the wallet.  ErrReadOnly is returned by read-only
+// wallets, which never run rescans.
+func (w *Wallet) CancelRescan(id uint64) error {
+	if w.readOnly {
+		return ErrReadOnly
+	}
+	err := make(chan error, 1)
+	select {
+	case w.rescanCancelRequests <- rescanCancelRequest{id: id, err: err}:
+		return <-err
+	case <-w.quit:
+		return ErrRescanShutdown
+	}
+}
+
+// resumeRescans queues a rescan for every checkpointed rescan which is not
+// currently queued.  Each rescan keeps its ID and continues from the last
+// block it was known to complete.
+//
+// Interrupted initial sync rescans are not queued, but returned, so they can
+// be continued by the next initial sync rescan.
+func (w *Wallet) resumeRescans() ([]*rescanCheckpoint, error) {
+	if w.readOnly {
+		return nil, ErrReadOnly
+	}
+	req := make(rescanResumeRequest, 1)
+	select {
+	case w.rescanResumeRequests <- req:
+		resp := <-req
+		return resp.initialSync, resp.err
+	case <-w.quit:
+		return nil, ErrRescanShutdown
+	}
+}
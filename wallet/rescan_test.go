@@ -0,0 +1,451 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package wallet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/monetas/btcwallet/chain"
+	"github.com/monetas/btcwallet/txstore"
+	"github.com/monetas/btcwallet/waddrmgr"
+	"github.com/monetas/btcwallet/walletdb"
+)
+
+// openCheckpointedWallet opens a wallet, without an address manager, whose
+// namespace holds a single rescan checkpoint with the returned ID.
+func openCheckpointedWallet(t *testing.T, readOnly bool) (*Wallet, uint64, func()) {
+	db, err := walletdb.Create("memdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	namespace, err := db.Namespace(walletNamespaceKey)
+	if err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	if err := createWalletNS(namespace); err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	var id uint64
+	err = namespace.Update(func(tx walletdb.Tx) error {
+		var err error
+		id, err = nextRescanID(tx)
+		if err != nil {
+			return err
+		}
+		return putRescanCheckpoint(tx, &rescanCheckpoint{
+			id:       id,
+			progress: waddrmgr.BlockStamp{Height: 50},
+		})
+	})
+	if err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+
+	w, err := Open(&Config{
+		ChainParams: &chaincfg.TestNet3Params,
+		Db:          &db,
+		TxStore:     txstore.New(""),
+		ReadOnly:    readOnly,
+	})
+	if err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	return w, id, func() { db.Close() }
+}
+
+// within fails the test if fn does not return before a timeout.
+func within(t *testing.T, what string, fn func()) {
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s did not return", what)
+	}
+}
+
+func TestRescanStatusesReadOnly(t *testing.T) {
+	w, id, teardown := openCheckpointedWallet(t, true)
+	defer teardown()
+
+	// A read-only wallet does not run the rescan handlers, so the status
+	// must be read from the checkpoints.
+	w.Start(nil)
+	defer func() {
+		w.Stop()
+		w.WaitForShutdown()
+	}()
+
+	var statuses []RescanStatus
+	within(t, "RescanStatuses", func() { statuses = w.RescanStatuses() })
+	if len(statuses) != 1 || statuses[0].ID != id ||
+		statuses[0].State != RescanInterrupted ||
+		statuses[0].Progress.Height != 50 {
+		t.Fatalf("Wrong rescan statuses: %+v", statuses)
+	}
+
+	// Requests which would run or modify rescans fail right away.
+	var err error
+	within(t, "CancelRescan", func() { err = w.CancelRescan(id) })
+	if err != ErrReadOnly {
+		t.Errorf("CancelRescan: got %v, want %v", err, ErrReadOnly)
+	}
+	within(t, "resumeRescans", func() { _, err = w.resumeRescans() })
+	if err != ErrReadOnly {
+		t.Errorf("resumeRescans: got %v, want %v", err, ErrReadOnly)
+	}
+	within(t, "SubmitRescan", func() { err = <-w.SubmitRescan(&RescanJob{}) })
+	if err != ErrReadOnly {
+		t.Errorf("SubmitRescan: got %v, want %v", err, ErrReadOnly)
+	}
+}
+
+func TestOpenReadOnlyWithoutNamespace(t *testing.T) {
+	db, err := walletdb.Create("memdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Wallets created before the wallet namespace existed are opened
+	// read-only without it.
+	w, err := Open(&Config{
+		ChainParams: &chaincfg.TestNet3Params,
+		Db:          &db,
+		TxStore:     txstore.New(""),
+		ReadOnly:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, err := db.NamespaceExists(walletNamespaceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("Read-only wallet created its namespace")
+	}
+	w.Start(nil)
+	defer func() {
+		w.Stop()
+		w.WaitForShutdown()
+	}()
+
+	var statuses []RescanStatus
+	within(t, "RescanStatuses", func() { statuses = w.RescanStatuses() })
+	if len(statuses) != 0 {
+		t.Errorf("Unexpected rescan statuses: %+v", statuses)
+	}
+	if watches := w.ConfirmationWatches(); len(watches) != 0 {
+		t.Errorf("Unexpected confirmation watches: %+v", watches)
+	}
+	_, err = w.WatchConfirmations(&wire.ShaHash{}, nil, 1)
+	if err != ErrReadOnly {
+		t.Errorf("WatchConfirmations: got %v, want %v", err, ErrReadOnly)
+	}
+}
+
+func TestRescanRequestsShutdown(t *testing.T) {
+	w, id, teardown := openCheckpointedWallet(t, false)
+	defer teardown()
+
+	// No handler goroutines run once the wallet is stopped, so requests
+	// must not block.
+	w.Stop()
+
+	var statuses []RescanStatus
+	within(t, "RescanStatuses", func() { statuses = w.RescanStatuses() })
+	if len(statuses) != 1 || statuses[0].ID != id {
+		t.Errorf("Wrong rescan statuses: %+v", statuses)
+	}
+
+	var err error
+	within(t, "CancelRescan", func() { err = w.CancelRescan(id) })
+	if err != ErrRescanShutdown {
+		t.Errorf("CancelRescan: got %v, want %v", err, ErrRescanShutdown)
+	}
+	within(t, "resumeRescans", func() { _, err = w.resumeRescans() })
+	if err != ErrRescanShutdown {
+		t.Errorf("resumeRescans: got %v, want %v", err, ErrRescanShutdown)
+	}
+	within(t, "SubmitRescan", func() { err = <-w.SubmitRescan(&RescanJob{}) })
+	if err != ErrRescanShutdown {
+		t.Errorf("SubmitRescan: got %v, want %v", err, ErrRescanShutdown)
+	}
+}
+
+func TestCancelRescanBeforeSend(t *testing.T) {
+	w, _, teardown := openCheckpointedWallet(t, false)
+	defer teardown()
+
+	// Only run the batch handler, so batches are not taken by the rescan
+	// RPC handler until read below.
+	w.wg.Add(1)
+	go w.rescanBatchHandler()
+	defer func() {
+		w.Stop()
+		w.WaitForShutdown()
+	}()
+
+	cancelledErr := w.SubmitRescan(&RescanJob{
+		BlockStamp: waddrmgr.BlockStamp{Height: 10},
+	})
+	var statuses []RescanStatus
+	within(t, "RescanStatuses", func() { statuses = w.RescanStatuses() })
+	var cancelledID uint64
+	for _, status := range statuses {
+		if status.State == RescanRunning {
+			cancelledID = status.ID
+		}
+	}
+	if cancelledID == 0 {
+		t.Fatalf("No running rescan: %+v", statuses)
+	}
+	var err error
+	within(t, "CancelRescan", func() { err = w.CancelRescan(cancelledID) })
+	if err != nil {
+		t.Fatalf("CancelRescan: unexpected error: %v", err)
+	}
+	if err := <-cancelledErr; err != ErrRescanCancelled {
+		t.Fatalf("Wrong rescan error; got %v, want %v", err,
+			ErrRescanCancelled)
+	}
+
+	// The cancelled batch must never reach the rescan RPC handler, which
+	// receives the batch submitted next instead.
+	w.SubmitRescan(&RescanJob{BlockStamp: waddrmgr.BlockStamp{Height: 20}})
+	select {
+	case b := <-w.rescanBatch:
+		if b.id == cancelledID || b.bs.Height != 20 {
+			t.Fatalf("Wrong batch sent; got rescan %d from height "+
+				"%d, want height 20", b.id, b.bs.Height)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("No batch sent")
+	}
+}
+
+func TestResumeRescanKeepsID(t *testing.T) {
+	w, id, teardown := openCheckpointedWallet(t, false)
+	defer teardown()
+
+	// Only run the batch handler, so the resumed batch is read below
+	// instead of by the rescan RPC handler.
+	w.wg.Add(1)
+	go w.rescanBatchHandler()
+	defer func() {
+		w.Stop()
+		w.WaitForShutdown()
+	}()
+
+	var err error
+	within(t, "resumeRescans", func() { _, err = w.resumeRescans() })
+	if err != nil {
+		t.Fatalf("resumeRescans: unexpected error: %v", err)
+	}
+	select {
+	case b := <-w.rescanBatch:
+		if b.id != id || b.progress.Height != 50 {
+			t.Fatalf("Wrong batch sent; got rescan %d from height "+
+				"%d, want rescan %d from height 50", b.id,
+				b.progress.Height, id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("No batch sent")
+	}
+
+	// Resuming again must not queue the running rescan twice.
+	within(t, "resumeRescans", func() { _, err = w.resumeRescans() })
+	if err != nil {
+		t.Fatalf("resumeRescans: unexpected error: %v", err)
+	}
+	var statuses []RescanStatus
+	within(t, "RescanStatuses", func() { statuses = w.RescanStatuses() })
+	if len(statuses) != 1 || statuses[0].ID != id ||
+		statuses[0].State != RescanRunning {
+
+		t.Fatalf("Wrong rescan statuses: %+v", statuses)
+	}
+
+	// The rescan can be cancelled with the ID it had before resuming.
+	within(t, "CancelRescan", func() { err = w.CancelRescan(id) })
+	if err != nil {
+		t.Fatalf("CancelRescan: unexpected error: %v", err)
+	}
+}
+
+// TestRescanProgressThrottled ensures rescan progress is not written for every
+// progress notification, but the last progress is saved when the wallet shuts
+// down during the rescan.
+func TestRescanProgressThrottled(t *testing.T) {
+	w, id, teardown := openCheckpointedWallet(t, false)
+	defer teardown()
+
+	w.wg.Add(1)
+	go w.rescanBatchHandler()
+	defer func() {
+		w.Stop()
+		w.WaitForShutdown()
+	}()
+
+	// Progress messages are normally read by the rescan progress handler.
+	drained := make(chan struct{})
+	defer close(drained)
+	go func() {
+		for {
+			select {
+			case <-w.rescanProgress:
+			case <-drained:
+				return
+			}
+		}
+	}()
+
+	var err error
+	within(t, "resumeRescans", func() { _, err = w.resumeRescans() })
+	if err != nil {
+		t.Fatalf("resumeRescans: unexpected error: %v", err)
+	}
+	select {
+	case <-w.rescanBatch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("No batch sent")
+	}
+
+	savedHeight := func() int32 {
+		var height int32
+		err := w.namespace.View(func(tx walletdb.Tx) error {
+			cps, err := fetchRescanCheckpoints(tx, w.chainParams)
+			if err != nil {
+				return err
+			}
+			for _, cp := range cps {
+				if cp.id == id {
+					height = cp.progress.Height
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Cannot fetch rescan checkpoints: %v", err)
+		}
+		return height
+	}
+	progress := func(height int32) {
+		hash := wire.ShaHash{byte(height)}
+		within(t, "rescan progress", func() {
+			w.rescanNotifications <- &chain.RescanProgress{
+				Hash:   &hash,
+				Height: height,
+			}
+		})
+	}
+
+	// The first progress is saved, but the following progress within
+	// the save interval is not.  Each notification is handled before the
+	// next one is received, so all but the last were handled once the
+	// last is sent.
+	progress(60)
+	progress(70)
+	progress(80)
+	if height := savedHeight(); height != 60 {
+		t.Fatalf("Wrong saved progress height; got %d, want 60", height)
+	}
+
+	// Shutting down saves the last progress received.
+	w.Stop()
+	w.WaitForShutdown()
+	if height := savedHeight(); height != 80 {
+		t.Fatalf("Wrong saved progress height; got %d, want 80", height)
+	}
+}
+
+// TestInitialSyncRescanNotResumed ensures an interrupted initial sync rescan
+// is not resumed alongside the initial sync rescan of the next chain sync,
+// and that its checkpoint is replaced by the rescan continuing it.
+func TestInitialSyncRescanNotResumed(t *testing.T) {
+	w, id, teardown := openCheckpointedWallet(t, false)
+	defer teardown()
+
+	err := w.namespace.Update(func(tx walletdb.Tx) error {
+		return putRescanCheckpoint(tx, &rescanCheckpoint{
+			id:          id,
+			initialSync: true,
+			progress:    waddrmgr.BlockStamp{Height: 50},
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.wg.Add(1)
+	go w.rescanBatchHandler()
+	defer func() {
+		w.Stop()
+		w.WaitForShutdown()
+	}()
+
+	var interrupted []*rescanCheckpoint
+	within(t, "resumeRescans", func() {
+		interrupted, err = w.resumeRescans()
+	})
+	if err != nil {
+		t.Fatalf("resumeRescans: unexpected error: %v", err)
+	}
+	if len(interrupted) != 1 || interrupted[0].id != id {
+		t.Fatalf("Wrong interrupted initial sync rescans: %+v",
+			interrupted)
+	}
+	var statuses []RescanStatus
+	within(t, "RescanStatuses", func() { statuses = w.RescanStatuses() })
+	if len(statuses) != 1 || statuses[0].State != RescanInterrupted {
+		t.Fatalf("Initial sync rescan was resumed: %+v", statuses)
+	}
+
+	w.SubmitRescan(&RescanJob{
+		InitialSync: true,
+		BlockStamp:  interrupted[0].progress,
+		replaces:    []uint64{id},
+	})
+	select {
+	case b := <-w.rescanBatch:
+		if b.id == id || b.progress.Height != 50 {
+			t.Fatalf("Wrong batch sent; got rescan %d from height "+
+				"%d, want a new rescan from height 50", b.id,
+				b.progress.Height)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("No batch sent")
+	}
+
+	within(t, "RescanStatuses", func() { statuses = w.RescanStatuses() })
+	for _, s := range statuses {
+		if s.ID == id {
+			t.Fatalf("Checkpoint of the replaced rescan %d remains", id)
+		}
+	}
+}
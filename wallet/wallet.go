@@ -38,6 +38,7 @@ import (
 	"github.com/monetas/btcwallet/txstore"
 	"github.com/monetas/btcwallet/waddrmgr"
 	"github.com/monetas/btcwallet/walletdb"
+	"github.com/monetas/btcwallet/walletdb/encdb"
 )
 
 const (
@@ -49,6 +50,10 @@ const (
 // the remote chain server.
 var ErrNotSynced = errors.New("wallet is not synchronized with the chain server")
 
+// ErrReadOnly describes an error where an operation cannot complete because
+// it would modify a wallet opened read-only.
+var ErrReadOnly = errors.New("wallet is read-only")
+
 var (
 	// waddrmgrNamespaceKey is the namespace key for the waddrmgr package.
 	waddrmgrNamespaceKey = []byte("waddrmgr")
@@ -64,9 +69,10 @@ func (noopLocker) Unlock() {}
 // addresses and keys),
 type Wallet struct {
 	// Data stores
-	db      walletdb.DB
-	Manager *waddrmgr.Manager
-	TxStore *txstore.Store
+	db        walletdb.DB
+	namespace walletdb.Namespace
+	Manager   *waddrmgr.Manager
+	TxStore   *txstore.Store
 
 	chainSvr        *chain.Client
 	chainSvrLock    sync.Locker
@@ -86,6 +92,12 @@ type Wallet struct {
 	rescanProgress      chan *RescanProgressMsg
 	rescanFinished      chan *RescanFinishedMsg
 
+	// Channels to query, cancel, and resume rescans.  These are handled
+	// by the same goroutine that batches rescan jobs.
+	rescanStatusRequests chan rescanStatusRequest
+	rescanCancelRequests chan rescanCancelRequest
+	rescanResumeRequests chan rescanResumeRequest
+
 	// Channel for transaction creation requests.
 	createTxRequests chan createTxRequest
 
@@ -94,6 +106,7 @@ type Wallet struct {
 	lockRequests       chan struct{}
 	holdUnlockRequests chan chan HeldUnlock
 	lockState          chan bool
+	unlockExpiry       chan time.Time
 	changePassphrase   chan changePassphraseRequest
 
 	// Notification channels so other components can listen in on wallet
@@ -104,40 +117,83 @@ type Wallet struct {
 	lockStateChanges   chan bool // true when locked
 	confirmedBalance   chan btcutil.Amount
 	unconfirmedBalance chan btcutil.Amount
+	txConfirmations    chan ConfirmationNotification
 	notificationLock   sync.Locker
 
+	// Registered confirmation watches, keyed by ID.
+	confWatches  map[uint64]*confWatch
+	confWatchMtx sync.Mutex
+
+	// Functions called with every credit added from a chain server
+	// notification.
+	creditHandlers   []func(txstore.Credit)
+	creditHandlerMtx sync.Mutex
+
 	chainParams *chaincfg.Params
 	Config      *Config
+	readOnly    bool
 	wg          sync.WaitGroup
 	quit        chan struct{}
 }
 
 // newWallet creates a new Wallet structure with the provided address manager
 // and transaction store.
-func newWallet(mgr *waddrmgr.Manager, txs *txstore.Store, db *walletdb.DB) *Wallet {
+func newWallet(mgr *waddrmgr.Manager, txs *txstore.Store, db *walletdb.DB,
+	namespace walletdb.Namespace) *Wallet {
+
 	return &Wallet{
-		db:                  *db,
-		Manager:             mgr,
-		TxStore:             txs,
-		chainSvrLock:        new(sync.Mutex),
-		lockedOutpoints:     map[wire.OutPoint]struct{}{},
-		FeeIncrement:        defaultFeeIncrement,
-		rescanAddJob:        make(chan *RescanJob),
-		rescanBatch:         make(chan *rescanBatch),
-		rescanNotifications: make(chan interface{}),
-		rescanProgress:      make(chan *RescanProgressMsg),
-		rescanFinished:      make(chan *RescanFinishedMsg),
-		createTxRequests:    make(chan createTxRequest),
-		unlockRequests:      make(chan unlockRequest),
-		lockRequests:        make(chan struct{}),
-		holdUnlockRequests:  make(chan chan HeldUnlock),
-		lockState:           make(chan bool),
-		changePassphrase:    make(chan changePassphraseRequest),
-		notificationLock:    new(sync.Mutex),
-		quit:                make(chan struct{}),
+		db:                   *db,
+		namespace:            namespace,
+		Manager:              mgr,
+		TxStore:              txs,
+		chainSvrLock:         new(sync.Mutex),
+		lockedOutpoints:      map[wire.OutPoint]struct{}{},
+		FeeIncrement:         defaultFeeIncrement,
+		rescanAddJob:         make(chan *RescanJob),
+		rescanBatch:          make(chan *rescanBatch),
+		rescanNotifications:  make(chan interface{}),
+		rescanProgress:       make(chan *RescanProgressMsg),
+		rescanFinished:       make(chan *RescanFinishedMsg),
+		rescanStatusRequests: make(chan rescanStatusRequest),
+		rescanCancelRequests: make(chan rescanCancelRequest),
+		rescanResumeRequests: make(chan rescanResumeRequest),
+		createTxRequests:     make(chan createTxRequest),
+		unlockRequests:       make(chan unlockRequest),
+		lockRequests:         make(chan struct{}),
+		holdUnlockRequests:   make(chan chan HeldUnlock),
+		lockState:            make(chan bool),
+		unlockExpiry:         make(chan time.Time),
+		changePassphrase:     make(chan changePassphraseRequest),
+		notificationLock:     new(sync.Mutex),
+		confWatches:          make(map[uint64]*confWatch),
+		quit:                 make(chan struct{}),
 	}
 }
 
+// Namespace returns the namespace of the wallet database with the passed key,
+// creating it if it does not exist yet.  This lets packages building on top of
+// the wallet, such as votingpool, keep their data in the wallet database.
+func (w *Wallet) Namespace(key []byte) (walletdb.Namespace, error) {
+	return w.db.Namespace(key)
+}
+
+// NamespaceExists returns whether the wallet database has a namespace with the
+// passed key, without creating it when it does not.
+func (w *Wallet) NamespaceExists(key []byte) (bool, error) {
+	return w.db.NamespaceExists(key)
+}
+
+// TxStoreCipher returns the cipher encrypting the transaction store of a wallet
+// whose database is db, which is the one of the database when it is encrypted.
+// Otherwise, nil is returned and the transaction store is not encrypted either.
+func TxStoreCipher(db walletdb.DB) txstore.Cipher {
+	// Avoid returning a nil *encdb.DataCipher in a non-nil interface.
+	if c := encdb.Cipher(db); c != nil {
+		return c
+	}
+	return nil
+}
+
 // ErrDuplicateListen is returned for any attempts to listen for the same
 // notification more than once.  If callers must pass along a notifiation to
 // multiple places, they must broadcast it themself.
@@ -154,6 +210,8 @@ func (w *Wallet) updateNotificationLock() {
 	case w.confirmedBalance == nil:
 		fallthrough
 	case w.unconfirmedBalance == nil:
+		fallthrough
+	case w.txConfirmations == nil:
 		return
 	}
 	w.notificationLock = noopLocker{}
@@ -168,6 +226,28 @@ func (w *Wallet) CreditAccount(c txstore.Credit) (uint32, error) {
 	return w.Manager.AddrAccount(addr)
 }
 
+// AddCreditHandler registers a function to be called with every credit added
+// to the transaction store for a transaction notified by the chain server,
+// including those found during rescans.  Unlike the Listen* methods, any number
+// of handlers may be registered.  Handlers are called synchronously from the
+// goroutine handling chain server notifications, so they must not block.
+func (w *Wallet) AddCreditHandler(handler func(txstore.Credit)) {
+	w.creditHandlerMtx.Lock()
+	w.creditHandlers = append(w.creditHandlers, handler)
+	w.creditHandlerMtx.Unlock()
+}
+
+// notifyCreditHandlers calls all registered credit handlers with the passed
+// credit.
+func (w *Wallet) notifyCreditHandlers(c txstore.Credit) {
+	w.creditHandlerMtx.Lock()
+	handlers := w.creditHandlers
+	w.creditHandlerMtx.Unlock()
+	for _, handler := range handlers {
+		handler(c)
+	}
+}
+
 // ListenConnectedBlocks returns a channel that passes all blocks that a wallet
 // has been marked in sync with. The channel must be read, or other wallet
 // methods will block.
@@ -254,21 +334,45 @@ func (w *Wallet) ListenUnconfirmedBalance() (<-chan btcutil.Amount, error) {
 	return w.unconfirmedBalance, nil
 }
 
-// markAddrsUsed marks the addresses credited by the given transaction
-// record as used.
-func (w *Wallet) markAddrsUsed(t *txstore.TxRecord) error {
+// ListenConfirmations returns a channel that passes a notification whenever a
+// transaction matching a confirmation watch reaches the watch's target, or is
+// reorganized back below it.  The channel must be read, or other wallet
+// methods will block.
+//
+// If this is called twice, ErrDuplicateListen is returned.
+func (w *Wallet) ListenConfirmations() (<-chan ConfirmationNotification, error) {
+	w.notificationLock.Lock()
+	defer w.notificationLock.Unlock()
+
+	if w.txConfirmations != nil {
+		return nil, ErrDuplicateListen
+	}
+	w.txConfirmations = make(chan ConfirmationNotification)
+	w.updateNotificationLock()
+	return w.txConfirmations, nil
+}
+
+// markAddrsUsed queues marking the addresses credited by the given
+// transaction record as used in the passed batch.  Failures are logged once
+// the batch is committed, as they do not affect other addresses.
+func (w *Wallet) markAddrsUsed(t *txstore.TxRecord, batch *walletdb.Batch) {
 	for _, c := range t.Credits() {
 		// Errors don't matter here.  If addrs is nil, the
 		// range below does nothing.
 		_, addrs, _, _ := c.Addresses(w.chainParams)
 		for _, addr := range addrs {
-			if err := w.Manager.MarkUsed(addr); err != nil {
-				return err
-			}
-			log.Infof("Marked address used %s", addr.EncodeAddress())
+			addr := addr
+			w.Manager.MarkUsedBatched(batch, addr, func(err error) {
+				if err != nil {
+					log.Errorf("Cannot mark address %s used: %v",
+						addr.EncodeAddress(), err)
+					return
+				}
+				log.Infof("Marked address used %s",
+					addr.EncodeAddress())
+			})
 		}
 	}
-	return nil
 }
 
 func (w *Wallet) notifyConnectedBlock(block waddrmgr.BlockStamp) {
@@ -311,6 +415,14 @@ func (w *Wallet) notifyUnconfirmedBalance(bal btcutil.Amount) {
 	w.notificationLock.Unlock()
 }
 
+func (w *Wallet) notifyConfirmations(n ConfirmationNotification) {
+	w.notificationLock.Lock()
+	if w.txConfirmations != nil {
+		w.txConfirmations <- n
+	}
+	w.notificationLock.Unlock()
+}
+
 // Start starts the goroutines necessary to manage a wallet.
 func (w *Wallet) Start(chainServer *chain.Client) {
 	select {
@@ -325,6 +437,14 @@ func (w *Wallet) Start(chainServer *chain.Client) {
 	w.chainSvr = chainServer
 	w.chainSvrLock = noopLocker{}
 
+	// A read-only wallet is never synced with the chain server, as that
+	// would modify it, so only the goroutine handling unlocks is needed.
+	if w.readOnly {
+		w.wg.Add(1)
+		go w.walletLocker()
+		return
+	}
+
 	w.wg.Add(7)
 	go w.diskWriter()
 	go w.handleChainNotifications()
@@ -461,7 +581,16 @@ func (w *Wallet) syncWithChain() error {
 		break
 	}
 
-	return w.Rescan(addrs, unspent)
+	// Queue any rescans which were interrupted by a restart or a chain
+	// server disconnect so they continue from their last checkpoint.
+	// Interrupted initial sync rescans are continued by this sync's
+	// rescan instead.
+	interrupted, err := w.resumeRescans()
+	if err != nil {
+		log.Errorf("Cannot resume interrupted rescans: %v", err)
+	}
+
+	return w.initialSyncRescan(addrs, unspent, interrupted)
 }
 
 type (
@@ -545,6 +674,7 @@ type (
 // walletLocker manages the locked/unlocked state of a wallet.
 func (w *Wallet) walletLocker() {
 	var timeout <-chan time.Time
+	var expiry time.Time
 	holdChan := make(HeldUnlock)
 out:
 	for {
@@ -558,8 +688,10 @@ out:
 			w.notifyLockStateChange(false)
 			if req.timeout == 0 {
 				timeout = nil
+				expiry = time.Time{}
 			} else {
 				timeout = time.After(req.timeout)
+				expiry = time.Now().Add(req.timeout)
 			}
 			req.err <- nil
 			continue
@@ -593,6 +725,9 @@ out:
 		case w.lockState <- w.Manager.IsLocked():
 			continue
 
+		case w.unlockExpiry <- expiry:
+			continue
+
 		case <-w.quit:
 			break out
 
@@ -603,6 +738,7 @@ out:
 		// Select statement fell through by an explicit lock or the
 		// timer expiring.  Lock the manager here.
 		timeout = nil
+		expiry = time.Time{}
 		err := w.Manager.Lock()
 		if err != nil {
 			log.Errorf("Could not lock wallet: %v", err)
@@ -638,6 +774,13 @@ func (w *Wallet) Locked() bool {
 	return <-w.lockState
 }
 
+// UnlockedUntil returns the time at which the wallet will be locked again by
+// the timeout of the last unlock.  The zero time is returned when the wallet
+// is locked, or was unlocked without a timeout.
+func (w *Wallet) UnlockedUntil() time.Time {
+	return <-w.unlockExpiry
+}
+
 // HoldUnlock prevents the wallet from being locked.  The HeldUnlock object
 // *must* be released, or the wallet will forever remain unlocked.
 //
@@ -1079,12 +1222,17 @@ func (w *Wallet) ImportPrivateKey(wif *btcutil.WIF, bs *waddrmgr.BlockStamp,
 	}
 
 	// Rescan blockchain for transactions with txout scripts paying to the
-	// imported address.
+	// imported address, beginning at the birthday of the key.
 	if rescan {
+		addrs := []btcutil.Address{addr.Address()}
+		birthday, err := w.addressesBirthday(addrs)
+		if err != nil {
+			return "", err
+		}
 		job := &RescanJob{
-			Addrs:      []btcutil.Address{addr.Address()},
+			Addrs:      addrs,
 			OutPoints:  nil,
-			BlockStamp: *bs,
+			BlockStamp: birthday,
 		}
 
 		// Submit rescan job and log when the import has completed.
@@ -1130,6 +1278,21 @@ func (w *Wallet) ExportWatchingWallet(pubPass string) (map[string]string, error)
 		_ = os.Remove(woDbPath)
 		return nil, err
 	}
+
+	// The copy of an encrypted database is encrypted as well, with the
+	// same public passphrase as the address manager.
+	encrypted, err := encdb.IsEncrypted(woDb)
+	if err == nil && encrypted {
+		var encDb walletdb.DB
+		encDb, err = walletdb.Open("encdb", woDb, []byte(pubPass))
+		if err == nil {
+			woDb = encDb
+		}
+	}
+	if err != nil {
+		woDb.Close()
+		return nil, err
+	}
 	defer woDb.Close()
 
 	namespace, err := woDb.Namespace(waddrmgrNamespaceKey)
@@ -1378,10 +1541,47 @@ func (w *Wallet) Db() walletdb.DB {
 	return w.db
 }
 
-// Open opens a wallet from disk.
-func Open(config *Config) *Wallet {
-	wallet := newWallet(config.Waddrmgr, config.TxStore, config.Db)
+// ReadOnly returns whether the wallet was opened read-only.
+func (w *Wallet) ReadOnly() bool {
+	return w.readOnly
+}
+
+// Open opens a wallet from disk.  The wallet namespace of the database is
+// created if it does not already exist, unless the wallet is opened read-only.
+// Read-only wallets created before the namespace existed are opened without
+// it, and have no rescan checkpoints or confirmation watches.
+func Open(config *Config) (*Wallet, error) {
+	exists := true
+	if config.ReadOnly {
+		var err error
+		exists, err = (*config.Db).NamespaceExists(walletNamespaceKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var namespace walletdb.Namespace
+	if exists {
+		var err error
+		namespace, err = (*config.Db).Namespace(walletNamespaceKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !config.ReadOnly {
+		if err := createWalletNS(namespace); err != nil {
+			return nil, err
+		}
+	}
+
+	wallet := newWallet(config.Waddrmgr, config.TxStore, config.Db,
+		namespace)
 	wallet.chainParams = config.ChainParams
+	wallet.readOnly = config.ReadOnly
+	if namespace != nil {
+		if err := wallet.loadConfWatches(); err != nil {
+			return nil, err
+		}
+	}
 
-	return wallet
+	return wallet, nil
 }
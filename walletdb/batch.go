@@ -0,0 +1,146 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package walletdb
+
+import (
+	"sync"
+	"time"
+)
+
+// Batch groups many small update closures for a namespace into few
+// read-write transactions, so that writes which are individually cheap do not
+// each pay for a commit.  Queued closures are committed together once the
+// maximum number of closures is queued, once the maximum delay has passed
+// since the first of them was queued, or when Flush is called, whichever
+// comes first.
+//
+// A closure returning an error does not fail the others of its batch.  The
+// closures queued before it are committed without it, and it is then run
+// alone in its own transaction so that its error is not caused by the others.
+// The remaining closures are batched again.  Closures may therefore run more
+// than once, and must not have side effects outside of the transaction.
+//
+// A Batch is safe for concurrent access.
+type Batch struct {
+	namespace Namespace
+	maxSize   int
+	maxDelay  time.Duration
+
+	// commitMtx serializes the commits of batches so they are committed in
+	// the order their closures were queued.
+	commitMtx sync.Mutex
+
+	mtx   sync.Mutex
+	calls []batchCall
+	timer *time.Timer
+}
+
+// batchCall is an update closure queued in a batch along with the func
+// called with its result.
+type batchCall struct {
+	fn   func(Tx) error
+	done func(error)
+}
+
+// NewBatch returns a new batch of updates to the passed namespace which are
+// committed once maxSize closures are queued or maxDelay has passed since the
+// first of them was queued.  A non-positive maxDelay disables the delay, so
+// closures are only committed when the batch is full or flushed.
+func NewBatch(namespace Namespace, maxSize int, maxDelay time.Duration) *Batch {
+	return &Batch{
+		namespace: namespace,
+		maxSize:   maxSize,
+		maxDelay:  maxDelay,
+	}
+}
+
+// Update queues the passed closure to be run in a read-write transaction
+// shared with the other closures of the batch.  The done func, when not nil,
+// is called with the error returned by the closure or, if the closure
+// succeeded, with the error committing its transaction.  It is called from
+// the goroutine which commits the batch, which may be the caller of Update
+// or Flush, or one started for the maximum delay.
+func (b *Batch) Update(fn func(Tx) error, done func(error)) {
+	b.mtx.Lock()
+	b.calls = append(b.calls, batchCall{fn: fn, done: done})
+	full := len(b.calls) >= b.maxSize
+	if !full && b.timer == nil && b.maxDelay > 0 {
+		b.timer = time.AfterFunc(b.maxDelay, func() { b.Flush() })
+	}
+	b.mtx.Unlock()
+
+	if full {
+		b.Flush()
+	}
+}
+
+// Flush commits all queued closures and returns the first error passed to
+// their done funcs, or nil when all of them were committed.
+func (b *Batch) Flush() error {
+	b.commitMtx.Lock()
+	defer b.commitMtx.Unlock()
+
+	b.mtx.Lock()
+	calls := b.calls
+	b.calls = nil
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.mtx.Unlock()
+
+	var firstErr error
+	b.run(calls, func(c batchCall, err error) {
+		if c.done != nil {
+			c.done(err)
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	})
+	return firstErr
+}
+
+// run runs the passed closures in as few transactions as possible, isolating
+// those returning an error, and reports the result of each.
+func (b *Batch) run(calls []batchCall, report func(batchCall, error)) {
+	for len(calls) != 0 {
+		failed := -1
+		err := b.namespace.Update(func(tx Tx) error {
+			for i, c := range calls {
+				if err := c.fn(tx); err != nil {
+					failed = i
+					return err
+				}
+			}
+			return nil
+		})
+		if failed == -1 {
+			for _, c := range calls {
+				report(c, err)
+			}
+			return
+		}
+
+		// Commit the closures preceding the failed one, then run it
+		// alone so its error is not caused by them, and continue with
+		// the closures following it.
+		b.run(calls[:failed], report)
+		report(calls[failed], b.namespace.Update(calls[failed].fn))
+		calls = calls[failed+1:]
+	}
+}
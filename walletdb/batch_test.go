@@ -0,0 +1,107 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package walletdb_test
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/monetas/btcwallet/walletdb"
+	_ "github.com/monetas/btcwallet/walletdb/bdb"
+)
+
+// putKey returns an update closure storing a value under the passed key.
+func putKey(key string) func(walletdb.Tx) error {
+	return func(tx walletdb.Tx) error {
+		return tx.RootBucket().Put([]byte(key), []byte("value"))
+	}
+}
+
+// TestBatch ensures that batched update closures are committed together once
+// the batch is full, flushed or delayed for long enough, and that closures
+// returning an error do not fail the others.
+func TestBatch(t *testing.T) {
+	dbPath := "batchtest.db"
+	db, err := walletdb.Create("bdb", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer os.Remove(dbPath)
+	defer db.Close()
+	ns, err := db.Namespace([]byte("batch"))
+	if err != nil {
+		t.Fatalf("Namespace: unexpected error: %v", err)
+	}
+	keys := []string{"k1", "k2", "k3", "k4", "k5", "k6", "k7"}
+
+	// Nothing is committed before the batch is full.
+	var results []error
+	done := func(err error) { results = append(results, err) }
+	batch := walletdb.NewBatch(ns, 3, 0)
+	batch.Update(putKey("k1"), done)
+	batch.Update(putKey("k2"), done)
+	checkKeys(t, ns, keys, map[string]bool{})
+	batch.Update(putKey("k3"), done)
+	checkKeys(t, ns, keys, map[string]bool{"k1": true, "k2": true,
+		"k3": true})
+	if len(results) != 3 || results[0] != nil || results[1] != nil ||
+		results[2] != nil {
+		t.Fatalf("Update: unexpected results %v", results)
+	}
+
+	// A failing closure only fails itself, and the closures queued around
+	// it are still committed.
+	results = nil
+	errFail := errors.New("fail")
+	batch.Update(putKey("k4"), done)
+	batch.Update(func(tx walletdb.Tx) error {
+		if err := putKey("k5")(tx); err != nil {
+			return err
+		}
+		return errFail
+	}, done)
+	if err := batch.Flush(); err != errFail {
+		t.Fatalf("Flush: unexpected error - got %v, want %v", err,
+			errFail)
+	}
+	if len(results) != 2 || results[0] != nil || results[1] != errFail {
+		t.Fatalf("Flush: unexpected results %v", results)
+	}
+	batch.Update(putKey("k6"), nil)
+	if err := batch.Flush(); err != nil {
+		t.Fatalf("Flush: unexpected error: %v", err)
+	}
+	checkKeys(t, ns, keys, map[string]bool{"k1": true, "k2": true,
+		"k3": true, "k4": true, "k6": true})
+
+	// Closures are committed once the maximum delay has passed.
+	batch = walletdb.NewBatch(ns, 100, 10*time.Millisecond)
+	committed := make(chan error, 1)
+	batch.Update(putKey("k7"), func(err error) { committed <- err })
+	select {
+	case err := <-committed:
+		if err != nil {
+			t.Fatalf("Update: unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Update: closure not committed after the delay")
+	}
+	checkKeys(t, ns, keys, map[string]bool{"k1": true, "k2": true,
+		"k3": true, "k4": true, "k6": true, "k7": true})
+}
@@ -130,6 +130,14 @@ func (b *bucket) ForEach(fn func(k, v []byte) error) error {
 	return convertErr((*bolt.Bucket)(b).ForEach(fn))
 }
 
+// Cursor returns a new cursor, allowing for iteration over the bucket's
+// key/value pairs and nested buckets in forward or backward order.
+//
+// This function is part of the walletdb.Bucket interface implementation.
+func (b *bucket) Cursor() walletdb.Cursor {
+	return (*cursor)((*bolt.Bucket)(b).Cursor())
+}
+
 // Writable returns whether or not the bucket is writable.
 //
 // This function is part of the walletdb.Bucket interface implementation.
@@ -167,6 +175,66 @@ func (b *bucket) Delete(key []byte) error {
 	return convertErr((*bolt.Bucket)(b).Delete(key))
 }
 
+// cursor represents a cursor over key/value pairs and nested buckets of a
+// bucket and implements the walletdb.Cursor interface.
+type cursor bolt.Cursor
+
+// Enforce cursor implements the walletdb.Cursor interface.
+var _ walletdb.Cursor = (*cursor)(nil)
+
+// Bucket returns the bucket the cursor was created from.
+//
+// This function is part of the walletdb.Cursor interface implementation.
+func (c *cursor) Bucket() walletdb.Bucket {
+	return (*bucket)((*bolt.Cursor)(c).Bucket())
+}
+
+// Delete removes the current key/value pair the cursor is at without
+// invalidating the cursor.  Returns ErrTxNotWritable if attempted against a
+// read-only transaction, or ErrIncompatibleValue if attempted when the cursor
+// points to a nested bucket.
+//
+// This function is part of the walletdb.Cursor interface implementation.
+func (c *cursor) Delete() error {
+	return convertErr((*bolt.Cursor)(c).Delete())
+}
+
+// First positions the cursor at the first key/value pair and returns the pair.
+//
+// This function is part of the walletdb.Cursor interface implementation.
+func (c *cursor) First() (key, value []byte) {
+	return (*bolt.Cursor)(c).First()
+}
+
+// Last positions the cursor at the last key/value pair and returns the pair.
+//
+// This function is part of the walletdb.Cursor interface implementation.
+func (c *cursor) Last() (key, value []byte) {
+	return (*bolt.Cursor)(c).Last()
+}
+
+// Next moves the cursor one key/value pair forward and returns the new pair.
+//
+// This function is part of the walletdb.Cursor interface implementation.
+func (c *cursor) Next() (key, value []byte) {
+	return (*bolt.Cursor)(c).Next()
+}
+
+// Prev moves the cursor one key/value pair backward and returns the new pair.
+//
+// This function is part of the walletdb.Cursor interface implementation.
+func (c *cursor) Prev() (key, value []byte) {
+	return (*bolt.Cursor)(c).Prev()
+}
+
+// Seek positions the cursor at the passed seek key.  If the key does not exist,
+// the cursor is moved to the next key after seek.  Returns the new pair.
+//
+// This function is part of the walletdb.Cursor interface implementation.
+func (c *cursor) Seek(seek []byte) (key, value []byte) {
+	return (*bolt.Cursor)(c).Seek(seek)
+}
+
 // transaction represents a database transaction.  It can either by read-only or
 // read-write and implements the walletdb.Bucket interface.  The transaction
 // provides a root bucket against which all read and writes occur.
@@ -323,6 +391,22 @@ func (db *db) Namespace(key []byte) (walletdb.Namespace, error) {
 	return &namespace{db: (*bolt.DB)(db), key: key}, nil
 }
 
+// NamespaceExists returns whether a namespace for the provided key exists.  The
+// namespace is not created when it does not.
+//
+// This function is part of the walletdb.Db interface implementation.
+func (db *db) NamespaceExists(key []byte) (bool, error) {
+	var exists bool
+	err := (*bolt.DB)(db).View(func(tx *bolt.Tx) error {
+		exists = tx.Bucket(key) != nil
+		return nil
+	})
+	if err != nil {
+		return false, convertErr(err)
+	}
+	return exists, nil
+}
+
 // DeleteNamespace deletes the namespace for the passed key.  ErrBucketNotFound
 // will be returned if the namespace does not exist.
 //
@@ -350,6 +434,72 @@ func (db *db) Close() error {
 	return convertErr((*bolt.DB)(db).Close())
 }
 
+// readOnlyNamespace is a namespace of a database opened read-only.  Only
+// read-only transactions can be started in it.  It implements the
+// walletdb.Namespace interface.
+type readOnlyNamespace struct {
+	*namespace
+}
+
+// Enforce readOnlyNamespace implements the walletdb.Namespace interface.
+var _ walletdb.Namespace = readOnlyNamespace{}
+
+// Begin starts a read-only transaction.  ErrTxNotWritable is returned when a
+// read-write transaction is requested.
+//
+// This function is part of the walletdb.Namespace interface implementation.
+func (ns readOnlyNamespace) Begin(writable bool) (walletdb.Tx, error) {
+	if writable {
+		return nil, walletdb.ErrTxNotWritable
+	}
+	return ns.namespace.Begin(false)
+}
+
+// Update always returns ErrTxNotWritable, as read-write transactions can't be
+// started in the namespace.
+//
+// This function is part of the walletdb.Namespace interface implementation.
+func (ns readOnlyNamespace) Update(fn func(walletdb.Tx) error) error {
+	return walletdb.ErrTxNotWritable
+}
+
+// readOnlyDB is a database which only allows read-only transactions, so it is
+// never modified.  It implements the walletdb.DB interface.
+type readOnlyDB struct {
+	*db
+}
+
+// Enforce readOnlyDB implements the walletdb.DB interface.
+var _ walletdb.DB = (*readOnlyDB)(nil)
+
+// Namespace returns a Namespace interface for the provided key.  Unlike the
+// namespaces of writable databases, namespaces are not created on first access,
+// and ErrBucketNotFound is returned if the namespace does not exist.
+//
+// This function is part of the walletdb.Db interface implementation.
+func (db *readOnlyDB) Namespace(key []byte) (walletdb.Namespace, error) {
+	boltDB := (*bolt.DB)(db.db)
+	err := boltDB.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(key) == nil {
+			return walletdb.ErrBucketNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, convertErr(err)
+	}
+
+	return readOnlyNamespace{&namespace{db: boltDB, key: key}}, nil
+}
+
+// DeleteNamespace always returns ErrTxNotWritable, as the database can't be
+// modified.
+//
+// This function is part of the walletdb.Db interface implementation.
+func (db *readOnlyDB) DeleteNamespace(key []byte) error {
+	return walletdb.ErrTxNotWritable
+}
+
 // filesExists reports whether the named file or directory exists.
 func fileExists(name string) bool {
 	if _, err := os.Stat(name); err != nil {
@@ -370,3 +520,19 @@ func openDB(dbPath string, create bool) (walletdb.DB, error) {
 	boltDB, err := bolt.Open(dbPath, 0600, nil)
 	return (*db)(boltDB), convertErr(err)
 }
+
+// openReadOnlyDB opens the existing database at the provided path in bolt's
+// read-only mode, which takes a shared lock on the file and does not require
+// write permission, and wraps it in a readOnlyDB.
+// walletdb.ErrDbDoesNotExist is returned if the database doesn't exist.
+func openReadOnlyDB(dbPath string) (walletdb.DB, error) {
+	if !fileExists(dbPath) {
+		return nil, walletdb.ErrDbDoesNotExist
+	}
+
+	boltDB, err := bolt.Open(dbPath, 0600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	return &readOnlyDB{(*db)(boltDB)}, nil
+}
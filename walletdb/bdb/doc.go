@@ -21,17 +21,44 @@ datastore.
 Usage
 
 This package is only a driver to the walletdb package and provides the database
-type of "bdb".  The only parameter the Open and Create functions take is the
-database path as a string:
+type of "bdb".  The only parameter the Create function takes is the database
+path as a string.  The Open function also takes the database path, optionally
+followed by a bool which opens the database read-only when true.  Only
+read-only transactions can be started in a database opened read-only, and
+its namespaces are not created on first access.  The file is opened in bolt's
+read-only mode, so it is not locked exclusively and may be read-only itself:
 
 	db, err := walletdb.Open("bdb", "path/to/database.db")
 	if err != nil {
 		// Handle error
 	}
 
+	db, err := walletdb.Open("bdb", "path/to/database.db", true)
+	if err != nil {
+		// Handle error
+	}
+
 	db, err := walletdb.Create("bdb", "path/to/database.db")
 	if err != nil {
 		// Handle error
 	}
+
+Maintenance
+
+The Check and Compact functions operate on closed database files.  Check
+verifies the page structure of a database, and Compact rewrites it into a new,
+densely packed file without the free pages left behind by deleted data.  Both
+fail with ErrDbLocked, instead of waiting, when the database is open in another
+process:
+
+	problems, err := bdb.Check("path/to/database.db")
+	if err != nil {
+		// Handle error
+	}
+
+	err = bdb.Compact("path/to/database.db", "path/to/compacted.db")
+	if err != nil {
+		// Handle error
+	}
 */
 package bdb
@@ -26,37 +26,53 @@ const (
 	dbType = "bdb"
 )
 
-// parseArgs parses the arguments from the walletdb Open/Create methods.
-func parseArgs(funcName string, args ...interface{}) (string, error) {
-	if len(args) != 1 {
-		return "", fmt.Errorf("invalid arguments to %s.%s -- "+
+// parseArgs parses the arguments from the walletdb Open/Create methods.  The
+// database path may be followed by a flag to open the database read-only,
+// which is only accepted when allowReadOnly is set.
+func parseArgs(funcName string, allowReadOnly bool, args ...interface{}) (string, bool, error) {
+	if len(args) != 1 && (!allowReadOnly || len(args) != 2) {
+		return "", false, fmt.Errorf("invalid arguments to %s.%s -- "+
 			"expected database path", dbType, funcName)
 	}
 
 	dbPath, ok := args[0].(string)
 	if !ok {
-		return "", fmt.Errorf("first argument to %s.%s is invalid -- "+
-			"expected database path string", dbType, funcName)
+		return "", false, fmt.Errorf("first argument to %s.%s is "+
+			"invalid -- expected database path string", dbType,
+			funcName)
 	}
 
-	return dbPath, nil
+	var readOnly bool
+	if len(args) == 2 {
+		readOnly, ok = args[1].(bool)
+		if !ok {
+			return "", false, fmt.Errorf("second argument to %s.%s "+
+				"is invalid -- expected read-only flag", dbType,
+				funcName)
+		}
+	}
+
+	return dbPath, readOnly, nil
 }
 
 // openDBDriver is the callback provided during driver registration that opens
 // an existing database for use.
 func openDBDriver(args ...interface{}) (walletdb.DB, error) {
-	dbPath, err := parseArgs("Open", args...)
+	dbPath, readOnly, err := parseArgs("Open", true, args...)
 	if err != nil {
 		return nil, err
 	}
 
+	if readOnly {
+		return openReadOnlyDB(dbPath)
+	}
 	return openDB(dbPath, false)
 }
 
 // createDBDriver is the callback provided during driver registration that
 // creates, initializes, and opens a database for use.
 func createDBDriver(args ...interface{}) (walletdb.DB, error) {
-	dbPath, err := parseArgs("Create", args...)
+	dbPath, _, err := parseArgs("Create", false, args...)
 	if err != nil {
 		return nil, err
 	}
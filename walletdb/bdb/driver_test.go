@@ -23,7 +23,7 @@ import (
 	"testing"
 
 	"github.com/monetas/btcwallet/walletdb"
-	_ "github.com/monetas/btcwallet/walletdb/bdb"
+	"github.com/monetas/btcwallet/walletdb/bdb"
 )
 
 // dbType is the database type name for this driver.
@@ -184,6 +184,275 @@ func TestPersistence(t *testing.T) {
 	}
 }
 
+// TestReadOnly ensures that a database opened read-only can be read but not
+// modified.
+func TestReadOnly(t *testing.T) {
+	// Create a new database with a namespace holding a value.
+	dbPath := "readonlytest.db"
+	db, err := walletdb.Create(dbType, dbPath)
+	if err != nil {
+		t.Errorf("Failed to create test database (%s) %v", dbType, err)
+		return
+	}
+	defer os.Remove(dbPath)
+	ns1Key := []byte("ns1")
+	ns1, err := db.Namespace(ns1Key)
+	if err != nil {
+		t.Errorf("Namespace: unexpected error: %v", err)
+		db.Close()
+		return
+	}
+	err = ns1.Update(func(tx walletdb.Tx) error {
+		return tx.RootBucket().Put([]byte("key"), []byte("value"))
+	})
+	db.Close()
+	if err != nil {
+		t.Errorf("ns1 Update: unexpected error: %v", err)
+		return
+	}
+
+	// Ensure that attempting to open a database with an invalid type for
+	// the read-only flag returns the expected error.
+	wantErr := fmt.Errorf("second argument to %s.Open is invalid -- "+
+		"expected read-only flag", dbType)
+	if _, err := walletdb.Open(dbType, dbPath, 1); err.Error() != wantErr.Error() {
+		t.Errorf("Open: did not receive expected error - got %v, "+
+			"want %v", err, wantErr)
+		return
+	}
+
+	// The database must not need to be writable, nor be locked
+	// exclusively, so it can be opened read-only more than once.
+	if err := os.Chmod(dbPath, 0400); err != nil {
+		t.Errorf("Chmod: unexpected error: %v", err)
+		return
+	}
+	db, err = walletdb.Open(dbType, dbPath, true)
+	if err != nil {
+		t.Errorf("Failed to open test database (%s) %v", dbType, err)
+		return
+	}
+	defer db.Close()
+	db2, err := walletdb.Open(dbType, dbPath, true)
+	if err != nil {
+		t.Errorf("Failed to open test database twice (%s) %v", dbType, err)
+		return
+	}
+	db2.Close()
+
+	// Ensure missing namespaces are not created.
+	wantErr = walletdb.ErrBucketNotFound
+	if _, err := db.Namespace([]byte("ns2")); err != wantErr {
+		t.Errorf("Namespace: did not receive expected error - got %v, "+
+			"want %v", err, wantErr)
+		return
+	}
+
+	// Ensure the existence of namespaces is reported.
+	for key, want := range map[string]bool{"ns1": true, "ns2": false} {
+		exists, err := db.NamespaceExists([]byte(key))
+		if err != nil || exists != want {
+			t.Errorf("NamespaceExists: got %v (error %v) for %s, "+
+				"want %v", exists, err, key, want)
+			return
+		}
+	}
+
+	// Ensure existing values can be read.
+	ns1, err = db.Namespace(ns1Key)
+	if err != nil {
+		t.Errorf("Namespace: unexpected error: %v", err)
+		return
+	}
+	err = ns1.View(func(tx walletdb.Tx) error {
+		gotVal := tx.RootBucket().Get([]byte("key"))
+		if !reflect.DeepEqual(gotVal, []byte("value")) {
+			return fmt.Errorf("Get: unexpected value - got %s, "+
+				"want value", gotVal)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("ns1 View: unexpected error: %v", err)
+		return
+	}
+
+	// Ensure every way of modifying the database fails.
+	wantErr = walletdb.ErrTxNotWritable
+	if _, err := ns1.Begin(true); err != wantErr {
+		t.Errorf("Begin: did not receive expected error - got %v, "+
+			"want %v", err, wantErr)
+		return
+	}
+	err = ns1.Update(func(tx walletdb.Tx) error { return nil })
+	if err != wantErr {
+		t.Errorf("Update: did not receive expected error - got %v, "+
+			"want %v", err, wantErr)
+		return
+	}
+	tx, err := ns1.Begin(false)
+	if err != nil {
+		t.Errorf("Begin: unexpected error: %v", err)
+		return
+	}
+	err = tx.RootBucket().Put([]byte("key"), []byte("other"))
+	tx.Rollback()
+	if err != wantErr {
+		t.Errorf("Put: did not receive expected error - got %v, "+
+			"want %v", err, wantErr)
+		return
+	}
+	if err := db.DeleteNamespace(ns1Key); err != wantErr {
+		t.Errorf("DeleteNamespace: did not receive expected error - "+
+			"got %v, want %v", err, wantErr)
+		return
+	}
+}
+
+// TestCompact ensures that compacting a database keeps all of its data while
+// shrinking the file, and that the compacted database passes the check.
+func TestCompact(t *testing.T) {
+	// Create a new database with a namespace holding a nested bucket and
+	// many values, most of which are deleted again.
+	dbPath := "compacttest.db"
+	compactedPath := "compacttest.compacted.db"
+	db, err := walletdb.Create(dbType, dbPath)
+	if err != nil {
+		t.Errorf("Failed to create test database (%s) %v", dbType, err)
+		return
+	}
+	defer os.Remove(dbPath)
+	nsKey := []byte("ns1")
+	ns, err := db.Namespace(nsKey)
+	if err != nil {
+		t.Errorf("Namespace: unexpected error: %v", err)
+		db.Close()
+		return
+	}
+	value := make([]byte, 512)
+	err = ns.Update(func(tx walletdb.Tx) error {
+		nested, err := tx.RootBucket().CreateBucket([]byte("nested"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			key := []byte(fmt.Sprintf("key%04d", i))
+			if err := nested.Put(key, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		err = ns.Update(func(tx walletdb.Tx) error {
+			nested := tx.RootBucket().Bucket([]byte("nested"))
+			for i := 10; i < 1000; i++ {
+				key := []byte(fmt.Sprintf("key%04d", i))
+				if err := nested.Delete(key); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	db.Close()
+	if err != nil {
+		t.Errorf("Update: unexpected error: %v", err)
+		return
+	}
+
+	// Ensure checking or compacting a database open elsewhere fails
+	// instead of waiting for it to be closed.
+	db, err = walletdb.Open(dbType, dbPath)
+	if err != nil {
+		t.Errorf("Failed to open test database (%s) %v", dbType, err)
+		return
+	}
+	if _, err := bdb.Check(dbPath); err != bdb.ErrDbLocked {
+		t.Errorf("Check: did not receive expected error - got %v, "+
+			"want %v", err, bdb.ErrDbLocked)
+	}
+	if err := bdb.Compact(dbPath, compactedPath); err != bdb.ErrDbLocked {
+		t.Errorf("Compact: did not receive expected error - got %v, "+
+			"want %v", err, bdb.ErrDbLocked)
+	}
+	db.Close()
+
+	if err := bdb.Compact(dbPath, compactedPath); err != nil {
+		t.Errorf("Compact: unexpected error: %v", err)
+		return
+	}
+	defer os.Remove(compactedPath)
+
+	// Ensure compacting into an existing database fails.
+	wantErr := walletdb.ErrDbExists
+	if err := bdb.Compact(dbPath, compactedPath); err != wantErr {
+		t.Errorf("Compact: did not receive expected error - got %v, "+
+			"want %v", err, wantErr)
+		return
+	}
+
+	fi, err := os.Stat(dbPath)
+	if err != nil {
+		t.Errorf("Stat: unexpected error: %v", err)
+		return
+	}
+	compactedFi, err := os.Stat(compactedPath)
+	if err != nil {
+		t.Errorf("Stat: unexpected error: %v", err)
+		return
+	}
+	if compactedFi.Size() >= fi.Size() {
+		t.Errorf("Compact: compacted size %d is not below the original "+
+			"size %d", compactedFi.Size(), fi.Size())
+	}
+
+	problems, err := bdb.Check(compactedPath)
+	if err != nil {
+		t.Errorf("Check: unexpected error: %v", err)
+		return
+	}
+	if len(problems) != 0 {
+		t.Errorf("Check: unexpected problems: %v", problems)
+		return
+	}
+
+	// Ensure the remaining values were copied.
+	db, err = walletdb.Open(dbType, compactedPath, true)
+	if err != nil {
+		t.Errorf("Failed to open test database (%s) %v", dbType, err)
+		return
+	}
+	defer db.Close()
+	ns, err = db.Namespace(nsKey)
+	if err != nil {
+		t.Errorf("Namespace: unexpected error: %v", err)
+		return
+	}
+	err = ns.View(func(tx walletdb.Tx) error {
+		nested := tx.RootBucket().Bucket([]byte("nested"))
+		if nested == nil {
+			return fmt.Errorf("Bucket: nested bucket not found")
+		}
+		n := 0
+		err := nested.ForEach(func(k, v []byte) error {
+			if !reflect.DeepEqual(v, value) {
+				return fmt.Errorf("ForEach: unexpected value "+
+					"for key %s", k)
+			}
+			n++
+			return nil
+		})
+		if err == nil && n != 10 {
+			err = fmt.Errorf("ForEach: got %d keys, want 10", n)
+		}
+		return err
+	})
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+}
+
 // TestInterface performs all interfaces tests for this database driver.
 func TestInterface(t *testing.T) {
 	// Create a new database to run tests against.
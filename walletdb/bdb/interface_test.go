@@ -27,6 +27,7 @@ package bdb_test
 import (
 	"fmt"
 	"reflect"
+	"sort"
 	"testing"
 
 	"github.com/monetas/btcwallet/walletdb"
@@ -105,6 +106,104 @@ func testDeleteValues(tc *testContext, bucket walletdb.Bucket, values map[string
 	return true
 }
 
+// testCursorInterface ensures the cursor interface is working properly by
+// exercising all of its functions against the provided bucket, which must hold
+// exactly the provided key/value pairs (at least two of them).
+func testCursorInterface(tc *testContext, bucket walletdb.Bucket, values map[string]string) bool {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	// Iterate forwards and make sure all keys are visited in order.
+	cursor := bucket.Cursor()
+	i := 0
+	for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+		if i >= len(keys) || string(k) != keys[i] {
+			tc.t.Errorf("Cursor.Next: unexpected key '%s'", k)
+			return false
+		}
+		if !reflect.DeepEqual(v, []byte(values[keys[i]])) {
+			tc.t.Errorf("Cursor.Next: value for key '%s' does not "+
+				"match - got %s, want %s", k, v, values[keys[i]])
+			return false
+		}
+		i++
+	}
+	if i != len(keys) {
+		tc.t.Errorf("Cursor.Next: iterated %d keys, want %d", i,
+			len(keys))
+		return false
+	}
+
+	// Iterate backwards and make sure all keys are visited in reverse
+	// order.
+	i = len(keys) - 1
+	for k, _ := cursor.Last(); k != nil; k, _ = cursor.Prev() {
+		if i < 0 || string(k) != keys[i] {
+			tc.t.Errorf("Cursor.Prev: unexpected key '%s'", k)
+			return false
+		}
+		i--
+	}
+	if i != -1 {
+		tc.t.Errorf("Cursor.Prev: iterated %d keys, want %d",
+			len(keys)-1-i, len(keys))
+		return false
+	}
+
+	// Seeking an existing key must position the cursor at it, while
+	// seeking a missing one must position it at the following key, if any.
+	if k, v := cursor.Seek([]byte(keys[1])); string(k) != keys[1] ||
+		!reflect.DeepEqual(v, []byte(values[keys[1]])) {
+		tc.t.Errorf("Cursor.Seek: unexpected pair - got %s/%s, "+
+			"want %s/%s", k, v, keys[1], values[keys[1]])
+		return false
+	}
+	if k, _ := cursor.Seek([]byte(keys[0] + "\x00")); string(k) != keys[1] {
+		tc.t.Errorf("Cursor.Seek: unexpected key - got %s, want %s",
+			k, keys[1])
+		return false
+	}
+	if k, _ := cursor.Seek([]byte(keys[len(keys)-1] + "\x00")); k != nil {
+		tc.t.Errorf("Cursor.Seek: unexpected key %s past the last "+
+			"one", k)
+		return false
+	}
+
+	// The cursor's bucket must be the one it was created from.
+	gotValue := cursor.Bucket().Get([]byte(keys[0]))
+	if !reflect.DeepEqual(gotValue, []byte(values[keys[0]])) {
+		tc.t.Errorf("Cursor.Bucket: unexpected value for key '%s' - "+
+			"got %s, want %s", keys[0], gotValue, values[keys[0]])
+		return false
+	}
+
+	// Deleting through the cursor must remove the current key and leave
+	// the cursor usable.
+	cursor.First()
+	if err := cursor.Delete(); err != nil {
+		tc.t.Errorf("Cursor.Delete: unexpected error: %v", err)
+		return false
+	}
+	if v := bucket.Get([]byte(keys[0])); v != nil {
+		tc.t.Errorf("Cursor.Delete: key '%s' still exists", keys[0])
+		return false
+	}
+	if k, _ := cursor.First(); string(k) != keys[1] {
+		tc.t.Errorf("Cursor.First: unexpected key after delete - got "+
+			"%s, want %s", k, keys[1])
+		return false
+	}
+	if err := bucket.Put([]byte(keys[0]), []byte(values[keys[0]])); err != nil {
+		tc.t.Errorf("Put: unexpected error: %v", err)
+		return false
+	}
+
+	return true
+}
+
 // testNestedBucket reruns the testBucketInterface against a nested bucket along
 // with a counter to only test a couple of level deep.
 func testNestedBucket(tc *testContext, testBucket walletdb.Bucket) bool {
@@ -182,6 +281,11 @@ func testBucketInterface(tc *testContext, bucket walletdb.Bucket) bool {
 			}
 		}
 
+		// Iterate, seek and delete the keys using a cursor.
+		if !testCursorInterface(tc, bucket, keyValues) {
+			return false
+		}
+
 		// Delete the keys and ensure they were deleted.
 		if !testDeleteValues(tc, bucket, keyValues) {
 			return false
@@ -306,6 +410,13 @@ func testBucketInterface(tc *testContext, bucket walletdb.Bucket) bool {
 				"bucket")
 			return false
 		}
+
+		// Cursor.Delete should fail with bucket that is not writable.
+		if err := bucket.Cursor().Delete(); err != wantErr {
+			tc.t.Errorf("Cursor.Delete did not fail with unwritable " +
+				"bucket")
+			return false
+		}
 	}
 
 	return true
@@ -671,6 +782,22 @@ func testNamespaceAndTxInterfaces(tc *testContext, namespaceKey string) bool {
 	return true
 }
 
+// testNamespaceExists ensures NamespaceExists reports whether the namespace
+// with the passed key exists as expected.
+func testNamespaceExists(tc *testContext, key []byte, want bool) bool {
+	exists, err := tc.db.NamespaceExists(key)
+	if err != nil {
+		tc.t.Errorf("NamespaceExists: unexpected error: %v", err)
+		return false
+	}
+	if exists != want {
+		tc.t.Errorf("NamespaceExists: unexpected result for %q - got %v, "+
+			"want %v", key, exists, want)
+		return false
+	}
+	return true
+}
+
 // testAdditionalErrors performs some tests for error cases not covered
 // elsewhere in the tests and therefore improves negative test coverage.
 func testAdditionalErrors(tc *testContext) bool {
@@ -682,10 +809,16 @@ func testAdditionalErrors(tc *testContext) bool {
 		tc.t.Errorf("Namespace: unexpected error: %v", err)
 		return false
 	}
+	if !testNamespaceExists(tc, ns3Key, true) {
+		return false
+	}
 	if err := tc.db.DeleteNamespace(ns3Key); err != nil {
 		tc.t.Errorf("DeleteNamespace: unexpected error: %v", err)
 		return false
 	}
+	if !testNamespaceExists(tc, ns3Key, false) {
+		return false
+	}
 
 	// Ensure Begin fails when the namespace bucket does not exist.
 	wantErr := walletdb.ErrBucketNotFound
@@ -0,0 +1,136 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package bdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/btcsuite/bolt"
+	"github.com/monetas/btcwallet/walletdb"
+)
+
+// maintOpenTimeout is how long Check and Compact wait for the lock of a
+// database held by another process before failing with ErrDbLocked.
+const maintOpenTimeout = time.Second
+
+// ErrDbLocked is returned by Check and Compact when the database is locked by
+// another process, such as a running btcwallet.
+var ErrDbLocked = errors.New("database is in use by another process")
+
+// openMaint opens the existing database at the provided path for maintenance.
+// ErrDbLocked is returned if it is locked by another process.
+func openMaint(dbPath string) (*bolt.DB, error) {
+	boltDB, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: maintOpenTimeout})
+	if err == bolt.ErrTimeout {
+		return nil, ErrDbLocked
+	}
+	return boltDB, convertErr(err)
+}
+
+// Check verifies the structure of the database at the provided path, such as
+// that all of its pages are reachable and not freed twice, and returns the
+// problems found.  The database must not be open: ErrDbLocked is returned if
+// another process has it open.  walletdb.ErrDbDoesNotExist is returned if the
+// database doesn't exist.
+func Check(dbPath string) ([]error, error) {
+	if !fileExists(dbPath) {
+		return nil, walletdb.ErrDbDoesNotExist
+	}
+
+	boltDB, err := openMaint(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer boltDB.Close()
+
+	var problems []error
+	err = boltDB.View(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			problems = append(problems, err)
+		}
+		return nil
+	})
+	return problems, convertErr(err)
+}
+
+// Compact writes a compacted copy of the database at srcPath to a new
+// database at dstPath.  Unlike Copy, which writes the pages of the database
+// as they are, all namespaces are rewritten into densely filled pages without
+// the free pages left behind by deleted data.  The source database must not be
+// open: ErrDbLocked is returned if another process has it open.
+// walletdb.ErrDbDoesNotExist is returned if the source database doesn't exist
+// and walletdb.ErrDbExists if the destination does.
+func Compact(srcPath, dstPath string) error {
+	if !fileExists(srcPath) {
+		return walletdb.ErrDbDoesNotExist
+	}
+	if fileExists(dstPath) {
+		return walletdb.ErrDbExists
+	}
+
+	src, err := openMaint(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := bolt.Open(dstPath, 0600, nil)
+	if err != nil {
+		return convertErr(err)
+	}
+
+	// The destination transaction is nested within the source one so the
+	// copied keys and values remain valid until it is committed.
+	err = src.View(func(srcTx *bolt.Tx) error {
+		return dst.Update(func(dstTx *bolt.Tx) error {
+			return srcTx.ForEach(func(name []byte, b *bolt.Bucket) error {
+				dstBucket, err := dstTx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+				return compactBucket(dstBucket, b)
+			})
+		})
+	})
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dstPath)
+		return fmt.Errorf("failed to compact database: %v", convertErr(err))
+	}
+	return nil
+}
+
+// compactBucket recursively copies the key/value pairs and nested buckets of
+// src into dst.  As the keys are inserted in order, the pages of dst are
+// filled completely.
+func compactBucket(dst, src *bolt.Bucket) error {
+	dst.FillPercent = 1.0
+	return src.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return dst.Put(k, v)
+		}
+		dstNested, err := dst.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+		return compactBucket(dstNested, src.Bucket(k))
+	})
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package walletdb
+
+// CopyNamespace copies every bucket and key/value pair of the namespace with
+// the passed key in the src database into the namespace with the same key in
+// the dst database, creating it when necessary.  Keys which already exist in
+// the destination are overwritten.  The copy is performed in a single
+// read-write transaction of the destination database, so it either fully
+// succeeds or leaves it untouched.
+//
+// This is mostly useful to migrate the contents of a database to another one
+// of a different type, such as an encrypted one.
+func CopyNamespace(dst, src DB, key []byte) error {
+	srcNamespace, err := src.Namespace(key)
+	if err != nil {
+		return err
+	}
+	dstNamespace, err := dst.Namespace(key)
+	if err != nil {
+		return err
+	}
+
+	// The destination transaction is nested within the source one as
+	// drivers may require the keys and values passed to Put to remain
+	// valid until the transaction is committed.
+	return srcNamespace.View(func(srcTx Tx) error {
+		return dstNamespace.Update(func(dstTx Tx) error {
+			return copyBucket(dstTx.RootBucket(), srcTx.RootBucket())
+		})
+	})
+}
+
+// copyBucket recursively copies the key/value pairs and nested buckets of src
+// into dst.
+func copyBucket(dst, src Bucket) error {
+	return src.ForEach(func(k, v []byte) error {
+		if srcNested := src.Bucket(k); srcNested != nil {
+			dstNested, err := dst.CreateBucketIfNotExists(k)
+			if err != nil {
+				return err
+			}
+			return copyBucket(dstNested, srcNested)
+		}
+		return dst.Put(k, v)
+	})
+}
@@ -140,3 +140,68 @@ func TestCreateOpenUnsupported(t *testing.T) {
 		return
 	}
 }
+
+// TestCopyNamespace ensures that copying a namespace to another database
+// copies all of its values and nested buckets.
+func TestCopyNamespace(t *testing.T) {
+	srcPath, dstPath := "copysrc.db", "copydst.db"
+	defer os.Remove(srcPath)
+	defer os.Remove(dstPath)
+	src, err := walletdb.Create("bdb", srcPath)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer src.Close()
+	dst, err := walletdb.Create("bdb", dstPath)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer dst.Close()
+
+	nsKey, nestedKey := []byte("ns"), []byte("nested")
+	key, value := []byte("key"), []byte("value")
+	ns, err := src.Namespace(nsKey)
+	if err != nil {
+		t.Fatalf("Namespace: unexpected error: %v", err)
+	}
+	err = ns.Update(func(tx walletdb.Tx) error {
+		rootBucket := tx.RootBucket()
+		if err := rootBucket.Put(key, value); err != nil {
+			return err
+		}
+		nested, err := rootBucket.CreateBucket(nestedKey)
+		if err != nil {
+			return err
+		}
+		return nested.Put(key, value)
+	})
+	if err != nil {
+		t.Fatalf("Update: unexpected error: %v", err)
+	}
+
+	if err := walletdb.CopyNamespace(dst, src, nsKey); err != nil {
+		t.Fatalf("CopyNamespace: unexpected error: %v", err)
+	}
+
+	ns, err = dst.Namespace(nsKey)
+	if err != nil {
+		t.Fatalf("Namespace: unexpected error: %v", err)
+	}
+	err = ns.View(func(tx walletdb.Tx) error {
+		rootBucket := tx.RootBucket()
+		nested := rootBucket.Bucket(nestedKey)
+		if nested == nil {
+			return fmt.Errorf("nested bucket was not copied")
+		}
+		for _, b := range []walletdb.Bucket{rootBucket, nested} {
+			if got := b.Get(key); string(got) != string(value) {
+				return fmt.Errorf("unexpected value - got %s, "+
+					"want %s", got, value)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("View: %v", err)
+	}
+}
@@ -44,6 +44,7 @@ A quick overview of the features walletdb provides are as follows:
    worrying about conflicts
  - Read-only and read-write transactions with both manual and managed modes
  - Nested buckets
+ - Ordered iteration and range scans through cursors
  - Supports registration of backend databases
  - Comprehensive test coverage
 
@@ -87,6 +88,14 @@ call Commit or Rollback when they are finished with it.  Leaving transactions
 open for long periods of time can have several adverse effects, so it is
 recommended that managed transactions are used instead.
 
+Batched Transactions
+
+Each read-write transaction is committed to disk on its own, which is costly
+for many small writes.  A Batch groups the update closures queued with its
+Update function into a single transaction, which is committed once enough
+closures are queued, once a maximum delay has passed, or when the batch is
+flushed.  A closure returning an error only fails itself.
+
 Buckets
 
 The Bucket interface provides the ability to manipulate key/value pairs and
@@ -97,6 +106,17 @@ CreateBucket, CreateBucketIfNotExists, and DeleteBucket functions work with
 buckets.  The ForEach function allows the caller to provide a function to be
 called with each key/value pair and nested bucket in the current bucket.
 
+Cursors
+
+The Cursor function on the Bucket interface returns a Cursor, which iterates
+over the key/value pairs and nested buckets of a bucket ordered by the byte
+values of their keys.  Cursors can be positioned at the First or Last key, or
+at the first key equal to or greater than a given one with Seek, and moved in
+either direction with Next and Prev.  This makes it possible to perform range
+scans, or look up the highest key, without iterating over the whole bucket.
+Keys that need to be scanned in numerical order should thus be encoded in
+big-endian.
+
 Root Bucket
 
 As discussed above, all of the functions which are used to manipulate key/value
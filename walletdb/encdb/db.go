@@ -0,0 +1,861 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package encdb
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"sort"
+
+	"github.com/btcsuite/golangcrypto/nacl/secretbox"
+	"github.com/monetas/btcwallet/internal/zero"
+	"github.com/monetas/btcwallet/snacl"
+	"github.com/monetas/btcwallet/walletdb"
+)
+
+const (
+	// latestVersion is the most recent version of the encryption
+	// parameters stored in the metadata namespace.
+	latestVersion = 1
+
+	// cryptoKeysSize is the size of the serialized crypto keys: the key
+	// used to encrypt keys and values followed by the key used to derive
+	// the nonces of encrypted keys.
+	cryptoKeysSize = 2 * snacl.KeySize
+)
+
+var (
+	// metaNamespaceKey is the key of the namespace of the underlying
+	// database which holds, in plain text, everything needed to derive the
+	// encryption keys from the passphrase.  Encrypted keys are always
+	// longer than snacl.Overhead, so no encrypted namespace key can collide
+	// with it.
+	metaNamespaceKey = []byte("encdb")
+
+	// Keys of the values stored in the metadata namespace.
+	versionKey    = []byte("version")
+	masterKeyKey  = []byte("masterkey")
+	cryptoKeysKey = []byte("cryptokeys")
+)
+
+// dataCipherKey is the path DataCipher binds the data it encrypts to, in place
+// of the path of a value.  Its first four bytes, read as the length prefix of
+// a path element, exceed its size, so data encrypted by DataCipher can't be
+// passed off as the value of any key.
+var dataCipherKey = []byte("datacipher")
+
+// cryptoKeys houses the keys used to encrypt the contents of a database.
+//
+// Values are encrypted with a random nonce.  Keys, on the other hand, must
+// always encrypt to the same ciphertext so they can be looked up, which is why
+// their nonce is the HMAC-SHA256 of the plain text key, truncated to the nonce
+// size.  As a consequence, equal keys are recognizable in the underlying
+// database even when they live in different buckets.
+//
+// The SHA-256 of the path of a value, made of the encrypted keys of its
+// namespace, of every bucket it is nested in and of the value itself, is
+// encrypted along with the value, so a value moved to another key, bucket or
+// namespace fails to decrypt.
+type cryptoKeys struct {
+	data  *snacl.CryptoKey
+	nonce *snacl.CryptoKey
+
+	// zeroed is set once the keys have been cleared.
+	zeroed bool
+}
+
+// generateCryptoKeys returns new random crypto keys.
+func generateCryptoKeys() (*cryptoKeys, error) {
+	data, err := snacl.GenerateCryptoKey()
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := snacl.GenerateCryptoKey()
+	if err != nil {
+		data.Zero()
+		return nil, err
+	}
+	return &cryptoKeys{data: data, nonce: nonce}, nil
+}
+
+// serialize returns the crypto keys in a format suitable for encryption with
+// the master key.
+func (k *cryptoKeys) serialize() []byte {
+	b := make([]byte, 0, cryptoKeysSize)
+	b = append(b, k.data[:]...)
+	return append(b, k.nonce[:]...)
+}
+
+// deserializeCryptoKeys returns the crypto keys serialized in b.
+func deserializeCryptoKeys(b []byte) (*cryptoKeys, error) {
+	if len(b) != cryptoKeysSize {
+		return nil, snacl.ErrMalformed
+	}
+	var data, nonce snacl.CryptoKey
+	copy(data[:], b[:snacl.KeySize])
+	copy(nonce[:], b[snacl.KeySize:])
+	return &cryptoKeys{data: &data, nonce: &nonce}, nil
+}
+
+// zero clears the crypto keys.  They are no longer usable after this call.
+func (k *cryptoKeys) zero() {
+	k.data.Zero()
+	k.nonce.Zero()
+	k.zeroed = true
+}
+
+// encryptKey deterministically encrypts the passed key.  Empty keys are
+// returned unchanged so the underlying database reports them as missing.
+func (k *cryptoKeys) encryptKey(key []byte) []byte {
+	if len(key) == 0 {
+		return key
+	}
+
+	mac := hmac.New(sha256.New, k.nonce[:])
+	mac.Write(key)
+	var nonce [snacl.NonceSize]byte
+	copy(nonce[:], mac.Sum(nil))
+
+	// The output has the same <nonce><ciphertext> format as the one of
+	// snacl.CryptoKey.Encrypt, so decryptKey can simply use Decrypt.
+	out := make([]byte, snacl.NonceSize, snacl.NonceSize+len(key)+snacl.Overhead)
+	copy(out, nonce[:])
+	return secretbox.Seal(out, key, &nonce, (*[snacl.KeySize]byte)(k.data))
+}
+
+// decryptKey decrypts a key encrypted with encryptKey.
+func (k *cryptoKeys) decryptKey(encKey []byte) ([]byte, error) {
+	return k.data.Decrypt(encKey)
+}
+
+// appendPath returns a new path made of the passed path followed by the passed
+// encrypted key, prefixed with its length so that no two different sequences
+// of keys result in the same path.
+func appendPath(path, encKey []byte) []byte {
+	newPath := make([]byte, len(path)+4+len(encKey))
+	copy(newPath, path)
+	binary.BigEndian.PutUint32(newPath[len(path):], uint32(len(encKey)))
+	copy(newPath[len(path)+4:], encKey)
+	return newPath
+}
+
+// encryptValue encrypts the passed value, stored at the passed path, with a
+// random nonce.
+func (k *cryptoKeys) encryptValue(path, value []byte) ([]byte, error) {
+	keyHash := sha256.Sum256(path)
+	plaintext := make([]byte, 0, len(keyHash)+len(value))
+	plaintext = append(plaintext, keyHash[:]...)
+	plaintext = append(plaintext, value...)
+	defer zero.Bytes(plaintext)
+	return k.data.Encrypt(plaintext)
+}
+
+// decryptValue decrypts a value encrypted with encryptValue for the passed
+// path.  snacl.ErrDecryptFailed is returned when the value was modified or
+// encrypted for another path.  Unlike secretbox, it never returns a nil slice
+// for an empty value so decrypted values can't be mistaken for missing ones.
+func (k *cryptoKeys) decryptValue(path, encValue []byte) ([]byte, error) {
+	plaintext, err := k.data.Decrypt(encValue)
+	if err != nil {
+		return nil, err
+	}
+	keyHash := sha256.Sum256(path)
+	if len(plaintext) < len(keyHash) ||
+		!hmac.Equal(plaintext[:len(keyHash)], keyHash[:]) {
+
+		return nil, snacl.ErrDecryptFailed
+	}
+	return plaintext[len(keyHash):], nil
+}
+
+// entry is a key of a bucket along with its encrypted form, as stored in the
+// underlying database.
+type entry struct {
+	key    []byte
+	encKey []byte
+}
+
+// entriesByKey implements sort.Interface to sort entries by their plain text
+// key.
+type entriesByKey []entry
+
+func (s entriesByKey) Len() int           { return len(s) }
+func (s entriesByKey) Less(i, j int) bool { return bytes.Compare(s[i].key, s[j].key) < 0 }
+func (s entriesByKey) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// bucket is an internal type used to represent a collection of key/value pairs
+// and implements the walletdb.Bucket interface.  It encrypts keys and values
+// before handing them to the bucket of the underlying database, and decrypts
+// them on the way back.
+type bucket struct {
+	bucket walletdb.Bucket
+	tx     *transaction
+
+	// path is the path of the bucket, which the values stored in it are
+	// bound to; see appendPath.
+	path []byte
+}
+
+// Enforce bucket implements the walletdb.Bucket interface.
+var _ walletdb.Bucket = (*bucket)(nil)
+
+// wrapBucket returns the passed bucket of the underlying database, at the
+// passed path, wrapped in a bucket of the passed transaction, or nil when it
+// is nil.
+func wrapBucket(b walletdb.Bucket, tx *transaction, path []byte) walletdb.Bucket {
+	if b == nil {
+		return nil
+	}
+	return &bucket{bucket: b, tx: tx, path: path}
+}
+
+// Bucket retrieves a nested bucket with the given key.  Returns nil if
+// the bucket does not exist.
+//
+// This function is part of the walletdb.Bucket interface implementation.
+func (b *bucket) Bucket(key []byte) walletdb.Bucket {
+	encKey := b.tx.keys.encryptKey(key)
+	return wrapBucket(b.bucket.Bucket(encKey), b.tx, appendPath(b.path, encKey))
+}
+
+// CreateBucket creates and returns a new nested bucket with the given key.
+// Returns ErrBucketExists if the bucket already exists, ErrBucketNameRequired
+// if the key is empty, or ErrIncompatibleValue if the key value is otherwise
+// invalid.
+//
+// This function is part of the walletdb.Bucket interface implementation.
+func (b *bucket) CreateBucket(key []byte) (walletdb.Bucket, error) {
+	encKey := b.tx.keys.encryptKey(key)
+	newBucket, err := b.bucket.CreateBucket(encKey)
+	if err != nil {
+		return nil, err
+	}
+	return wrapBucket(newBucket, b.tx, appendPath(b.path, encKey)), nil
+}
+
+// CreateBucketIfNotExists creates and returns a new nested bucket with the
+// given key if it does not already exist.  Returns ErrBucketNameRequired if the
+// key is empty or ErrIncompatibleValue if the key value is otherwise invalid.
+//
+// This function is part of the walletdb.Bucket interface implementation.
+func (b *bucket) CreateBucketIfNotExists(key []byte) (walletdb.Bucket, error) {
+	encKey := b.tx.keys.encryptKey(key)
+	newBucket, err := b.bucket.CreateBucketIfNotExists(encKey)
+	if err != nil {
+		return nil, err
+	}
+	return wrapBucket(newBucket, b.tx, appendPath(b.path, encKey)), nil
+}
+
+// DeleteBucket removes a nested bucket with the given key.  Returns
+// ErrTxNotWritable if attempted against a read-only transaction and
+// ErrBucketNotFound if the specified bucket does not exist.
+//
+// This function is part of the walletdb.Bucket interface implementation.
+func (b *bucket) DeleteBucket(key []byte) error {
+	return b.bucket.DeleteBucket(b.tx.keys.encryptKey(key))
+}
+
+// entries returns the keys of all the values and nested buckets of the bucket
+// ordered by their plain text byte values.  The underlying database orders
+// them by their encrypted form, which is meaningless.
+func (b *bucket) entries() ([]entry, error) {
+	var entries []entry
+	err := b.bucket.ForEach(func(k, v []byte) error {
+		key, err := b.tx.keys.decryptKey(k)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{key: key, encKey: k})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(entriesByKey(entries))
+	return entries, nil
+}
+
+// get returns the decrypted value stored under the passed encrypted key, or nil
+// if there is none or it names a nested bucket.
+func (b *bucket) get(encKey []byte) ([]byte, error) {
+	encValue := b.bucket.Get(encKey)
+	if encValue == nil {
+		return nil, nil
+	}
+	return b.tx.keys.decryptValue(appendPath(b.path, encKey), encValue)
+}
+
+// ForEach invokes the passed function with every key/value pair in the bucket,
+// ordered by key.  This includes nested buckets, in which case the value is
+// nil, but it does not include the key/value pairs within those nested
+// buckets.  Returns snacl.ErrDecryptFailed if any key or value of the bucket
+// can't be decrypted.
+//
+// NOTE: The keys of the bucket are decrypted and sorted before the first call
+// to the passed function, so iterating requires memory proportional to the
+// size of the bucket's keys.
+//
+// This function is part of the walletdb.Bucket interface implementation.
+func (b *bucket) ForEach(fn func(k, v []byte) error) error {
+	entries, err := b.entries()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		value, err := b.get(e.encKey)
+		if err != nil {
+			return err
+		}
+		if err := fn(e.key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Cursor returns a new cursor, allowing for iteration over the bucket's
+// key/value pairs and nested buckets in forward or backward order.
+//
+// This function is part of the walletdb.Bucket interface implementation.
+func (b *bucket) Cursor() walletdb.Cursor {
+	return &cursor{bucket: b}
+}
+
+// Writable returns whether or not the bucket is writable.
+//
+// This function is part of the walletdb.Bucket interface implementation.
+func (b *bucket) Writable() bool {
+	return b.bucket.Writable()
+}
+
+// Put saves the specified key/value pair to the bucket.  Keys that do not
+// already exist are added and keys that already exist are overwritten.  Returns
+// ErrTxNotWritable if attempted against a read-only transaction.
+//
+// This function is part of the walletdb.Bucket interface implementation.
+func (b *bucket) Put(key, value []byte) error {
+	encKey := b.tx.keys.encryptKey(key)
+	encValue, err := b.tx.keys.encryptValue(appendPath(b.path, encKey), value)
+	if err != nil {
+		return err
+	}
+	return b.bucket.Put(encKey, encValue)
+}
+
+// Get returns the value for the given key.  Returns nil if the key does
+// not exist in this bucket or names a nested bucket.  A value which can't be
+// decrypted is returned as nil too, and fails the transaction so it is not
+// mistaken for a missing one.
+//
+// This function is part of the walletdb.Bucket interface implementation.
+func (b *bucket) Get(key []byte) []byte {
+	value, err := b.get(b.tx.keys.encryptKey(key))
+	if err != nil {
+		b.tx.fail(err)
+		return nil
+	}
+	return value
+}
+
+// Delete removes the specified key from the bucket.  Deleting a key that does
+// not exist does not return an error.  Returns ErrTxNotWritable if attempted
+// against a read-only transaction.
+//
+// This function is part of the walletdb.Bucket interface implementation.
+func (b *bucket) Delete(key []byte) error {
+	return b.bucket.Delete(b.tx.keys.encryptKey(key))
+}
+
+// cursor represents a cursor over key/value pairs and nested buckets of a
+// bucket and implements the walletdb.Cursor interface.  The keys of the
+// bucket are decrypted and sorted whenever the cursor is positioned with
+// First, Last or Seek, which is why modifications made after that invalidate
+// the cursor.  Once a key or value can't be decrypted, the cursor stays past
+// the end of the bucket and the transaction fails.
+type cursor struct {
+	bucket  *bucket
+	entries []entry
+	pos     int
+	err     error
+}
+
+// Enforce cursor implements the walletdb.Cursor interface.
+var _ walletdb.Cursor = (*cursor)(nil)
+
+// setErr records a decryption error of the cursor, after which it returns no
+// more key/value pairs, and fails the transaction.
+func (c *cursor) setErr(err error) {
+	c.err = err
+	c.entries = nil
+	c.bucket.tx.fail(err)
+}
+
+// load refreshes the sorted keys of the cursor's bucket.
+func (c *cursor) load() {
+	if c.err != nil {
+		return
+	}
+	entries, err := c.bucket.entries()
+	if err != nil {
+		c.setErr(err)
+		return
+	}
+	c.entries = entries
+}
+
+// current returns the key/value pair the cursor is at, or nil values if it is
+// positioned past either end of the bucket.
+func (c *cursor) current() (key, value []byte) {
+	if c.pos < 0 || c.pos >= len(c.entries) {
+		return nil, nil
+	}
+	e := c.entries[c.pos]
+	value, err := c.bucket.get(e.encKey)
+	if err != nil {
+		c.setErr(err)
+		return nil, nil
+	}
+	return e.key, value
+}
+
+// Bucket returns the bucket the cursor was created from.
+//
+// This function is part of the walletdb.Cursor interface implementation.
+func (c *cursor) Bucket() walletdb.Bucket {
+	return c.bucket
+}
+
+// Delete removes the current key/value pair the cursor is at without
+// invalidating the cursor.  Returns ErrTxNotWritable if attempted against a
+// read-only transaction, or ErrIncompatibleValue if attempted when the cursor
+// points to a nested bucket.
+//
+// This function is part of the walletdb.Cursor interface implementation.
+func (c *cursor) Delete() error {
+	if !c.bucket.Writable() {
+		return walletdb.ErrTxNotWritable
+	}
+	if c.pos < 0 || c.pos >= len(c.entries) {
+		return nil
+	}
+	return c.bucket.bucket.Delete(c.entries[c.pos].encKey)
+}
+
+// First positions the cursor at the first key/value pair and returns the pair.
+//
+// This function is part of the walletdb.Cursor interface implementation.
+func (c *cursor) First() (key, value []byte) {
+	c.load()
+	c.pos = 0
+	return c.current()
+}
+
+// Last positions the cursor at the last key/value pair and returns the pair.
+//
+// This function is part of the walletdb.Cursor interface implementation.
+func (c *cursor) Last() (key, value []byte) {
+	c.load()
+	c.pos = len(c.entries) - 1
+	return c.current()
+}
+
+// Next moves the cursor one key/value pair forward and returns the new pair.
+//
+// This function is part of the walletdb.Cursor interface implementation.
+func (c *cursor) Next() (key, value []byte) {
+	if c.pos < len(c.entries) {
+		c.pos++
+	}
+	return c.current()
+}
+
+// Prev moves the cursor one key/value pair backward and returns the new pair.
+//
+// This function is part of the walletdb.Cursor interface implementation.
+func (c *cursor) Prev() (key, value []byte) {
+	if c.pos >= 0 {
+		c.pos--
+	}
+	return c.current()
+}
+
+// Seek positions the cursor at the passed seek key.  If the key does not exist,
+// the cursor is moved to the next key after seek.  Returns the new pair.
+//
+// This function is part of the walletdb.Cursor interface implementation.
+func (c *cursor) Seek(seek []byte) (key, value []byte) {
+	c.load()
+	c.pos = sort.Search(len(c.entries), func(i int) bool {
+		return bytes.Compare(c.entries[i].key, seek) >= 0
+	})
+	return c.current()
+}
+
+// transaction represents a database transaction of the underlying database
+// and implements the walletdb.Tx interface.
+type transaction struct {
+	tx   walletdb.Tx
+	keys *cryptoKeys
+
+	// path is the path of the root bucket of the transaction's namespace;
+	// see appendPath.
+	path []byte
+
+	// err is the first error decrypting a key or value which could not be
+	// returned to the caller, as Get and the cursor functions have no
+	// error result.  It fails the transaction.
+	err error
+}
+
+// Enforce transaction implements the walletdb.Tx interface.
+var _ walletdb.Tx = (*transaction)(nil)
+
+// fail records an error which fails the transaction.  Only the first one is
+// kept.
+func (tx *transaction) fail(err error) {
+	if tx.err == nil {
+		tx.err = err
+	}
+}
+
+// RootBucket returns the top-most bucket for the namespace the transaction was
+// created from.
+//
+// This function is part of the walletdb.Tx interface implementation.
+func (tx *transaction) RootBucket() walletdb.Bucket {
+	return wrapBucket(tx.tx.RootBucket(), tx, tx.path)
+}
+
+// Commit commits all changes that have been made through the root bucket and
+// all of its sub-buckets to the underlying database.  If a value read in the
+// transaction could not be decrypted, the transaction is rolled back instead
+// and the decryption error is returned.
+//
+// This function is part of the walletdb.Tx interface implementation.
+func (tx *transaction) Commit() error {
+	if tx.err != nil {
+		_ = tx.tx.Rollback()
+		return tx.err
+	}
+	return tx.tx.Commit()
+}
+
+// Rollback undoes all changes that have been made to the root bucket and all of
+// its sub-buckets.
+//
+// This function is part of the walletdb.Tx interface implementation.
+func (tx *transaction) Rollback() error {
+	return tx.tx.Rollback()
+}
+
+// namespace represents a namespace of the underlying database, stored under an
+// encrypted key, and implements the walletdb.Namespace interface.
+type namespace struct {
+	ns   walletdb.Namespace
+	keys *cryptoKeys
+
+	// path is the path of the root bucket of the namespace; see appendPath.
+	path []byte
+}
+
+// Enforce namespace implements the walletdb.Namespace interface.
+var _ walletdb.Namespace = (*namespace)(nil)
+
+// Begin starts a transaction which is either read-only or read-write depending
+// on the specified flag.  The concurrency guarantees are the ones of the
+// underlying database.
+//
+// NOTE: The transaction must be closed by calling Rollback or Commit on it when
+// it is no longer needed.  Failure to do so may prevent any other read-write
+// transaction from starting.
+//
+// This function is part of the walletdb.Namespace interface implementation.
+func (ns *namespace) Begin(writable bool) (walletdb.Tx, error) {
+	tx, err := ns.ns.Begin(writable)
+	if err != nil {
+		return nil, err
+	}
+	return &transaction{tx: tx, keys: ns.keys, path: ns.path}, nil
+}
+
+// managed returns a function, suitable for the View and Update functions of the
+// underlying namespace, which calls fn with the wrapped transaction.  A
+// decryption error recorded by the transaction is returned when fn does not
+// return an error itself.
+func (ns *namespace) managed(fn func(walletdb.Tx) error) func(walletdb.Tx) error {
+	return func(utx walletdb.Tx) error {
+		tx := &transaction{tx: utx, keys: ns.keys, path: ns.path}
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return tx.err
+	}
+}
+
+// View invokes the passed function in the context of a managed read-only
+// transaction.  Any errors returned from the user-supplied function are
+// returned from this function, as is the first error decrypting a value read
+// by it.
+//
+// This function is part of the walletdb.Namespace interface implementation.
+func (ns *namespace) View(fn func(walletdb.Tx) error) error {
+	return ns.ns.View(ns.managed(fn))
+}
+
+// Update invokes the passed function in the context of a managed read-write
+// transaction.  Any errors returned from the user-supplied function, or from
+// decrypting a value read by it, will cause the transaction to be rolled back
+// and are returned from this function.  Otherwise, the transaction is
+// commited when the user-supplied function returns a nil error.
+//
+// This function is part of the walletdb.Namespace interface implementation.
+func (ns *namespace) Update(fn func(walletdb.Tx) error) error {
+	return ns.ns.Update(ns.managed(fn))
+}
+
+// db represents a collection of namespaces stored, encrypted, in an underlying
+// database and implements the walletdb.DB interface.
+type db struct {
+	db   walletdb.DB
+	keys *cryptoKeys
+}
+
+// Enforce db implements the walletdb.DB interface.
+var _ walletdb.DB = (*db)(nil)
+
+// Namespace returns a Namespace interface for the provided key.  See the
+// Namespace interface documentation for more details.  Namespaces are created
+// in the underlying database, under an encrypted key, on first access.
+//
+// This function is part of the walletdb.DB interface implementation.
+func (db *db) Namespace(key []byte) (walletdb.Namespace, error) {
+	encKey := db.keys.encryptKey(key)
+	ns, err := db.db.Namespace(encKey)
+	if err != nil {
+		return nil, err
+	}
+	return &namespace{ns: ns, keys: db.keys, path: appendPath(nil, encKey)}, nil
+}
+
+// NamespaceExists returns whether a namespace for the provided key exists in the
+// underlying database.  The namespace is not created when it does not.
+//
+// This function is part of the walletdb.DB interface implementation.
+func (db *db) NamespaceExists(key []byte) (bool, error) {
+	return db.db.NamespaceExists(db.keys.encryptKey(key))
+}
+
+// DeleteNamespace deletes the namespace for the passed key.  ErrBucketNotFound
+// will be returned if the namespace does not exist.
+//
+// This function is part of the walletdb.DB interface implementation.
+func (db *db) DeleteNamespace(key []byte) error {
+	return db.db.DeleteNamespace(db.keys.encryptKey(key))
+}
+
+// Copy writes a copy of the underlying database to the provided writer.  The
+// copy stays encrypted and can only be opened by wrapping it again with the
+// same passphrase.
+//
+// This function is part of the walletdb.DB interface implementation.
+func (db *db) Copy(w io.Writer) error {
+	return db.db.Copy(w)
+}
+
+// Close cleanly shuts down the underlying database and clears the encryption
+// keys from memory.
+//
+// This function is part of the walletdb.DB interface implementation.
+func (db *db) Close() error {
+	if err := db.db.Close(); err != nil {
+		return err
+	}
+	db.keys.zero()
+	return nil
+}
+
+// createDB sets up encryption for the passed underlying database, deriving the
+// master key from the passphrase, and returns a database wrapping it.  Returns
+// ErrDbExists if the underlying database is already encrypted.
+func createDB(udb walletdb.DB, passphrase []byte) (walletdb.DB, error) {
+	metaNS, err := udb.Namespace(metaNamespaceKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys *cryptoKeys
+	err = metaNS.Update(func(tx walletdb.Tx) error {
+		meta := tx.RootBucket()
+		if meta.Get(masterKeyKey) != nil {
+			return walletdb.ErrDbExists
+		}
+
+		masterKey, err := snacl.NewSecretKey(&passphrase,
+			snacl.DefaultN, snacl.DefaultR, snacl.DefaultP)
+		if err != nil {
+			return err
+		}
+		defer masterKey.Zero()
+
+		keys, err = generateCryptoKeys()
+		if err != nil {
+			return err
+		}
+		serializedKeys := keys.serialize()
+		defer zero.Bytes(serializedKeys)
+		encKeys, err := masterKey.Encrypt(serializedKeys)
+		if err != nil {
+			return err
+		}
+
+		var version [4]byte
+		binary.LittleEndian.PutUint32(version[:], latestVersion)
+		if err := meta.Put(versionKey, version[:]); err != nil {
+			return err
+		}
+		if err := meta.Put(masterKeyKey, masterKey.Marshal()); err != nil {
+			return err
+		}
+		return meta.Put(cryptoKeysKey, encKeys)
+	})
+	if err != nil {
+		if keys != nil {
+			keys.zero()
+		}
+		return nil, err
+	}
+
+	return &db{db: udb, keys: keys}, nil
+}
+
+// openDB derives the encryption keys of the passed underlying database from the
+// passphrase and returns a database wrapping it.  Returns ErrDbDoesNotExist if
+// the underlying database is not encrypted and snacl.ErrInvalidPassword if the
+// passphrase is wrong.
+func openDB(udb walletdb.DB, passphrase []byte) (walletdb.DB, error) {
+	exists, err := udb.NamespaceExists(metaNamespaceKey)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, walletdb.ErrDbDoesNotExist
+	}
+	metaNS, err := udb.Namespace(metaNamespaceKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys *cryptoKeys
+	err = metaNS.View(func(tx walletdb.Tx) error {
+		meta := tx.RootBucket()
+		masterKeyParams := meta.Get(masterKeyKey)
+		if masterKeyParams == nil {
+			return walletdb.ErrDbDoesNotExist
+		}
+		version := meta.Get(versionKey)
+		if len(version) != 4 {
+			return walletdb.ErrInvalid
+		}
+		if binary.LittleEndian.Uint32(version) > latestVersion {
+			return walletdb.ErrInvalid
+		}
+
+		var masterKey snacl.SecretKey
+		if err := masterKey.Unmarshal(masterKeyParams); err != nil {
+			return err
+		}
+		defer masterKey.Zero()
+		if err := masterKey.DeriveKey(&passphrase); err != nil {
+			return err
+		}
+
+		serializedKeys, err := masterKey.Decrypt(meta.Get(cryptoKeysKey))
+		if err != nil {
+			return err
+		}
+		defer zero.Bytes(serializedKeys)
+		keys, err = deserializeCryptoKeys(serializedKeys)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &db{db: udb, keys: keys}, nil
+}
+
+// IsEncrypted returns whether the passed database was set up for encryption by
+// this driver.  It is meant to be called on a freshly opened database to find
+// out whether it must be wrapped before use.
+func IsEncrypted(udb walletdb.DB) (bool, error) {
+	// Check for the metadata namespace first so it is not created in
+	// unencrypted databases.
+	exists, err := udb.NamespaceExists(metaNamespaceKey)
+	if err != nil || !exists {
+		return false, err
+	}
+	metaNS, err := udb.Namespace(metaNamespaceKey)
+	if err != nil {
+		return false, err
+	}
+	var encrypted bool
+	err = metaNS.View(func(tx walletdb.Tx) error {
+		encrypted = tx.RootBucket().Get(masterKeyKey) != nil
+		return nil
+	})
+	return encrypted, err
+}
+
+// DataCipher encrypts and decrypts arbitrary data with the keys of an encrypted
+// database, so that data kept outside of the database, such as files stored
+// next to it, can be protected by the same passphrase.
+type DataCipher struct {
+	keys *cryptoKeys
+}
+
+// Cipher returns a DataCipher using the keys of the passed database, or nil if
+// it was not returned by this driver.  The cipher returns
+// walletdb.ErrDbNotOpen once the database is closed, as closing it clears the
+// keys.
+func Cipher(walletDb walletdb.DB) *DataCipher {
+	encDb, ok := walletDb.(*db)
+	if !ok {
+		return nil
+	}
+	return &DataCipher{keys: encDb.keys}
+}
+
+// Encrypt encrypts the passed data with a random nonce.
+func (c *DataCipher) Encrypt(data []byte) ([]byte, error) {
+	if c.keys.zeroed {
+		return nil, walletdb.ErrDbNotOpen
+	}
+	return c.keys.encryptValue(dataCipherKey, data)
+}
+
+// Decrypt decrypts data encrypted with Encrypt.  snacl.ErrDecryptFailed is
+// returned when it was encrypted with different keys or was modified.
+func (c *DataCipher) Decrypt(data []byte) ([]byte, error) {
+	if c.keys.zeroed {
+		return nil, walletdb.ErrDbNotOpen
+	}
+	return c.keys.decryptValue(dataCipherKey, data)
+}
@@ -0,0 +1,206 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package encdb
+
+import (
+	"testing"
+
+	"github.com/monetas/btcwallet/snacl"
+	"github.com/monetas/btcwallet/walletdb"
+	_ "github.com/monetas/btcwallet/walletdb/memdb"
+)
+
+// TestTamperedValues ensures values which were modified or moved to another
+// key in the underlying database fail the transactions reading them instead of
+// being reported as missing.
+func TestTamperedValues(t *testing.T) {
+	udb, err := walletdb.Create("memdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encDb, err := createDB(udb, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer encDb.Close()
+	keys := encDb.(*db).keys
+
+	nsKey := []byte("ns")
+	ns, err := encDb.Namespace(nsKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ns.Update(func(tx walletdb.Tx) error {
+		root := tx.RootBucket()
+		if err := root.Put([]byte("a"), []byte("value a")); err != nil {
+			return err
+		}
+		return root.Put([]byte("b"), []byte("value b"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Swap the encrypted values of the two keys.
+	uns, err := udb.Namespace(keys.encryptKey(nsKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encKeyA := keys.encryptKey([]byte("a"))
+	encKeyB := keys.encryptKey([]byte("b"))
+	err = uns.Update(func(tx walletdb.Tx) error {
+		root := tx.RootBucket()
+		encValueA := append([]byte(nil), root.Get(encKeyA)...)
+		encValueB := append([]byte(nil), root.Get(encKeyB)...)
+		if err := root.Put(encKeyA, encValueB); err != nil {
+			return err
+		}
+		return root.Put(encKeyB, encValueA)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reads := []struct {
+		name string
+		read func(walletdb.Bucket)
+	}{
+		{"Get", func(b walletdb.Bucket) {
+			if v := b.Get([]byte("a")); v != nil {
+				t.Errorf("Get: got %q for a moved value", v)
+			}
+		}},
+		{"Cursor", func(b walletdb.Bucket) {
+			c := b.Cursor()
+			if k, _ := c.First(); k != nil {
+				t.Errorf("Cursor: got key %q for a moved value", k)
+			}
+			// The cursor stays past the end of the bucket.
+			if k, _ := c.Last(); k != nil {
+				t.Errorf("Cursor: got key %q after an error", k)
+			}
+		}},
+	}
+	for _, test := range reads {
+		err := ns.View(func(tx walletdb.Tx) error {
+			test.read(tx.RootBucket())
+			return nil
+		})
+		if err != snacl.ErrDecryptFailed {
+			t.Errorf("%s: View: got error %v, want %v", test.name,
+				err, snacl.ErrDecryptFailed)
+		}
+
+		// Unmanaged transactions are rolled back by Commit.
+		tx, err := ns.Begin(true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		test.read(tx.RootBucket())
+		if err := tx.RootBucket().Put([]byte("c"), []byte("c")); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != snacl.ErrDecryptFailed {
+			t.Errorf("%s: Commit: got error %v, want %v", test.name,
+				err, snacl.ErrDecryptFailed)
+		}
+		err = ns.View(func(tx walletdb.Tx) error {
+			if v := tx.RootBucket().Get([]byte("c")); v != nil {
+				t.Errorf("%s: failed transaction was committed",
+					test.name)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestValuesMovedBetweenBuckets ensures values moved to the same key of another
+// bucket in the underlying database fail to decrypt, even though equal keys
+// encrypt to the same ciphertext in every bucket.
+func TestValuesMovedBetweenBuckets(t *testing.T) {
+	udb, err := walletdb.Create("memdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encDb, err := createDB(udb, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer encDb.Close()
+	keys := encDb.(*db).keys
+
+	nsKey := []byte("ns")
+	ns, err := encDb.Namespace(nsKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := []byte("syncedTo")
+	err = ns.Update(func(tx walletdb.Tx) error {
+		for _, name := range []string{"a", "b"} {
+			b, err := tx.RootBucket().CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			if err := b.Put(key, []byte("value "+name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Overwrite the value of bucket b with the one of bucket a.
+	uns, err := udb.Namespace(keys.encryptKey(nsKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encKey := keys.encryptKey(key)
+	err = uns.Update(func(tx walletdb.Tx) error {
+		root := tx.RootBucket()
+		a := root.Bucket(keys.encryptKey([]byte("a")))
+		b := root.Bucket(keys.encryptKey([]byte("b")))
+		return b.Put(encKey, append([]byte(nil), a.Get(encKey)...))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ns.View(func(tx walletdb.Tx) error {
+		if v := tx.RootBucket().Bucket([]byte("a")).Get(key); string(v) != "value a" {
+			t.Errorf("Get: got %q from the untouched bucket, want %q", v,
+				"value a")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ns.View(func(tx walletdb.Tx) error {
+		if v := tx.RootBucket().Bucket([]byte("b")).Get(key); v != nil {
+			t.Errorf("Get: got %q for a moved value", v)
+		}
+		return nil
+	})
+	if err != snacl.ErrDecryptFailed {
+		t.Errorf("View: got error %v, want %v", err, snacl.ErrDecryptFailed)
+	}
+}
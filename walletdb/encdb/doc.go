@@ -0,0 +1,76 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+/*
+Package encdb implements an instance of walletdb that transparently encrypts
+the contents of another walletdb database.
+
+Every key and value, including namespace and bucket names, is encrypted before
+it is handed to the underlying database, which can be of any type.  The
+encryption keys are random and stored in the underlying database encrypted
+with a master key derived from a passphrase via snacl, along with the scrypt
+parameters needed to derive it again.  Only these parameters and the version
+of the format are stored in plain text.
+
+Keys are encrypted deterministically so they can be looked up, which means the
+underlying database reveals when two keys are equal, but not their contents.
+Values are encrypted with a random nonce, along with a hash of their path (the
+encrypted keys of their namespace, of the buckets they are nested in and of the
+key they are stored under), so values can't be moved to other keys, buckets or
+namespaces undetected.
+Since the order of encrypted keys is meaningless, the keys of a bucket are
+decrypted and sorted whenever it is iterated with ForEach or a cursor, so
+iteration requires memory proportional to the size of the bucket's keys.
+
+Get and cursors can't return errors, so a key or value which fails to decrypt
+fails the transaction instead: View and Update return the error, and Commit
+rolls back and returns it.  Tampered or corrupted data is never reported as
+missing.
+
+Usage
+
+This package is only a driver to the walletdb package and provides the database
+type of "encdb".  The Create function sets up encryption for an open database
+of any other type, which should not hold any data yet, and takes the database
+and the passphrase as parameters:
+
+	udb, err := walletdb.Create("bdb", "path/to/database.db")
+	if err != nil {
+		// Handle error
+	}
+
+	db, err := walletdb.Create("encdb", udb, []byte("passphrase"))
+	if err != nil {
+		// Handle error
+	}
+
+The Open function takes the same parameters.  It returns
+walletdb.ErrDbDoesNotExist if the underlying database was never set up for
+encryption, and snacl.ErrInvalidPassword if the passphrase is wrong:
+
+	db, err := walletdb.Open("encdb", udb, []byte("passphrase"))
+	if err != nil {
+		// Handle error
+	}
+
+Closing the returned database closes the underlying one.  Existing unencrypted
+databases can be migrated by copying their namespaces into a new encrypted one
+with walletdb.CopyNamespace.
+
+Data kept outside of the database, such as files stored next to it, can be
+encrypted with the same keys using the DataCipher returned by Cipher.
+*/
+package encdb
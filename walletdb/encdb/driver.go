@@ -0,0 +1,87 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package encdb
+
+import (
+	"fmt"
+
+	"github.com/monetas/btcwallet/walletdb"
+)
+
+const (
+	dbType = "encdb"
+)
+
+// parseArgs parses the arguments from the walletdb Open/Create methods.
+func parseArgs(funcName string, args ...interface{}) (walletdb.DB, []byte, error) {
+	if len(args) != 2 {
+		return nil, nil, fmt.Errorf("invalid arguments to %s.%s -- "+
+			"expected underlying database and passphrase", dbType,
+			funcName)
+	}
+
+	udb, ok := args[0].(walletdb.DB)
+	if !ok {
+		return nil, nil, fmt.Errorf("first argument to %s.%s is "+
+			"invalid -- expected underlying walletdb.DB", dbType,
+			funcName)
+	}
+
+	passphrase, ok := args[1].([]byte)
+	if !ok {
+		return nil, nil, fmt.Errorf("second argument to %s.%s is "+
+			"invalid -- expected passphrase []byte", dbType,
+			funcName)
+	}
+
+	return udb, passphrase, nil
+}
+
+// openDBDriver is the callback provided during driver registration that opens
+// an existing encrypted database.
+func openDBDriver(args ...interface{}) (walletdb.DB, error) {
+	udb, passphrase, err := parseArgs("Open", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return openDB(udb, passphrase)
+}
+
+// createDBDriver is the callback provided during driver registration that sets
+// up encryption for an underlying database.
+func createDBDriver(args ...interface{}) (walletdb.DB, error) {
+	udb, passphrase, err := parseArgs("Create", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return createDB(udb, passphrase)
+}
+
+func init() {
+	// Register the driver.
+	driver := walletdb.Driver{
+		DbType: dbType,
+		Create: createDBDriver,
+		Open:   openDBDriver,
+	}
+	if err := walletdb.RegisterDriver(driver); err != nil {
+		panic(fmt.Sprintf("Failed to regiser database driver '%s': %v",
+			dbType, err))
+	}
+}
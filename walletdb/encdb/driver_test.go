@@ -0,0 +1,380 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package encdb_test
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/monetas/btcwallet/snacl"
+	"github.com/monetas/btcwallet/walletdb"
+	"github.com/monetas/btcwallet/walletdb/encdb"
+	_ "github.com/monetas/btcwallet/walletdb/memdb"
+)
+
+// dbType is the database type name for this driver.
+const dbType = "encdb"
+
+// passphrase is the passphrase used to encrypt the test databases.
+var passphrase = []byte("passphrase")
+
+// createTestDB returns a new encrypted database along with the underlying
+// in-memory database it wraps.
+func createTestDB(t *testing.T) (walletdb.DB, walletdb.DB) {
+	udb, err := walletdb.Create("memdb")
+	if err != nil {
+		t.Fatalf("Failed to create underlying database: %v", err)
+	}
+	db, err := walletdb.Create(dbType, udb, passphrase)
+	if err != nil {
+		udb.Close()
+		t.Fatalf("Failed to create test database (%s) %v", dbType, err)
+	}
+	return db, udb
+}
+
+// TestCreateOpenFail ensures that errors related to creating and opening a
+// database are handled properly.
+func TestCreateOpenFail(t *testing.T) {
+	// Ensure that attempting to open a database with the wrong number of
+	// parameters returns the expected error.
+	wantErr := fmt.Errorf("invalid arguments to %s.Open -- expected "+
+		"underlying database and passphrase", dbType)
+	if _, err := walletdb.Open(dbType, 1); err.Error() != wantErr.Error() {
+		t.Errorf("Open: did not receive expected error - got %v, "+
+			"want %v", err, wantErr)
+		return
+	}
+
+	// Ensure that attempting to open a database with an invalid type for
+	// the first parameter returns the expected error.
+	wantErr = fmt.Errorf("first argument to %s.Open is invalid -- "+
+		"expected underlying walletdb.DB", dbType)
+	if _, err := walletdb.Open(dbType, 1, passphrase); err.Error() != wantErr.Error() {
+		t.Errorf("Open: did not receive expected error - got %v, "+
+			"want %v", err, wantErr)
+		return
+	}
+
+	udb, err := walletdb.Create("memdb")
+	if err != nil {
+		t.Errorf("Failed to create underlying database: %v", err)
+		return
+	}
+	defer udb.Close()
+
+	// Ensure that attempting to create a database with an invalid type
+	// for the second parameter returns the expected error.
+	wantErr = fmt.Errorf("second argument to %s.Create is invalid -- "+
+		"expected passphrase []byte", dbType)
+	if _, err := walletdb.Create(dbType, udb, "passphrase"); err.Error() != wantErr.Error() {
+		t.Errorf("Create: did not receive expected error - got %v, "+
+			"want %v", err, wantErr)
+		return
+	}
+
+	// Ensure that attempting to open a database which was never set up
+	// for encryption returns the expected error.
+	wantErr = walletdb.ErrDbDoesNotExist
+	if _, err := walletdb.Open(dbType, udb, passphrase); err != wantErr {
+		t.Errorf("Open: did not receive expected error - got %v, "+
+			"want %v", err, wantErr)
+		return
+	}
+
+	if _, err := walletdb.Create(dbType, udb, passphrase); err != nil {
+		t.Errorf("Create: unexpected error: %v", err)
+		return
+	}
+
+	// Ensure that attempting to set up encryption twice returns the
+	// expected error.
+	wantErr = walletdb.ErrDbExists
+	if _, err := walletdb.Create(dbType, udb, passphrase); err != wantErr {
+		t.Errorf("Create: did not receive expected error - got %v, "+
+			"want %v", err, wantErr)
+		return
+	}
+
+	// Ensure that attempting to open a database with the wrong passphrase
+	// returns the expected error.
+	wantErr = snacl.ErrInvalidPassword
+	if _, err := walletdb.Open(dbType, udb, []byte("wrong")); err != wantErr {
+		t.Errorf("Open: did not receive expected error - got %v, "+
+			"want %v", err, wantErr)
+		return
+	}
+}
+
+// TestCopyOpen ensures that values stored are encrypted in the underlying
+// database and still valid after copying it and opening the copy with the
+// same passphrase.
+func TestCopyOpen(t *testing.T) {
+	db, udb := createTestDB(t)
+	defer db.Close()
+
+	// Create a namespace with a nested bucket and put some values into
+	// both so they can be tested for existence in the copy.
+	storeValues := map[string]string{
+		"ns1key1": "foo1",
+		"ns1key2": "foo2",
+		"ns1key3": "foo3",
+	}
+	ns1Key := []byte("ns1")
+	nestedKey := []byte("nested")
+	ns1, err := db.Namespace(ns1Key)
+	if err != nil {
+		t.Errorf("Namespace: unexpected error: %v", err)
+		return
+	}
+	err = ns1.Update(func(tx walletdb.Tx) error {
+		rootBucket := tx.RootBucket()
+		nested, err := rootBucket.CreateBucket(nestedKey)
+		if err != nil {
+			return fmt.Errorf("CreateBucket: unexpected error: %v", err)
+		}
+		for k, v := range storeValues {
+			if err := rootBucket.Put([]byte(k), []byte(v)); err != nil {
+				return fmt.Errorf("Put: unexpected error: %v", err)
+			}
+			if err := nested.Put([]byte(k), []byte(v)); err != nil {
+				return fmt.Errorf("Put: unexpected error: %v", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("ns1 Update: unexpected error: %v", err)
+		return
+	}
+
+	// Copy the database and ensure none of the keys and values stored
+	// appear in plain text.
+	var buf bytes.Buffer
+	if err := db.Copy(&buf); err != nil {
+		t.Errorf("Copy: unexpected error: %v", err)
+		return
+	}
+	plainText := [][]byte{ns1Key, nestedKey}
+	for k, v := range storeValues {
+		plainText = append(plainText, []byte(k), []byte(v))
+	}
+	for _, p := range plainText {
+		if bytes.Contains(buf.Bytes(), p) {
+			t.Errorf("Copy: '%s' is stored in plain text", p)
+			return
+		}
+	}
+
+	// Open the copy.
+	udbCopy, err := walletdb.Open("memdb", &buf)
+	if err != nil {
+		t.Errorf("Failed to open underlying database copy: %v", err)
+		return
+	}
+	dbCopy, err := walletdb.Open(dbType, udbCopy, passphrase)
+	if err != nil {
+		udbCopy.Close()
+		t.Errorf("Failed to open test database copy (%s) %v", dbType, err)
+		return
+	}
+	defer dbCopy.Close()
+
+	// Ensure the values previously stored still exist and are correct.
+	ns1, err = dbCopy.Namespace(ns1Key)
+	if err != nil {
+		t.Errorf("Namespace: unexpected error: %v", err)
+		return
+	}
+	err = ns1.View(func(tx walletdb.Tx) error {
+		rootBucket := tx.RootBucket()
+		nested := rootBucket.Bucket(nestedKey)
+		if nested == nil {
+			return fmt.Errorf("Bucket: nested bucket does not exist")
+		}
+		for k, v := range storeValues {
+			for _, b := range []walletdb.Bucket{rootBucket, nested} {
+				gotVal := b.Get([]byte(k))
+				if !reflect.DeepEqual(gotVal, []byte(v)) {
+					return fmt.Errorf("Get: key '%s' does not "+
+						"match expected value - got %s, want %s",
+						k, gotVal, v)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("ns1 View: unexpected error: %v", err)
+		return
+	}
+
+	// Ensure the underlying database of the original is reported as
+	// encrypted.
+	if _, err := walletdb.Open(dbType, udb, passphrase); err != nil {
+		t.Errorf("Open: unexpected error: %v", err)
+	}
+}
+
+// TestMigrate ensures that the contents of an unencrypted database can be
+// migrated to an encrypted one.
+func TestMigrate(t *testing.T) {
+	plainDB, err := walletdb.Create("memdb")
+	if err != nil {
+		t.Errorf("Failed to create database: %v", err)
+		return
+	}
+	defer plainDB.Close()
+
+	nsKey, key, value := []byte("ns"), []byte("key"), []byte("value")
+	ns, err := plainDB.Namespace(nsKey)
+	if err != nil {
+		t.Errorf("Namespace: unexpected error: %v", err)
+		return
+	}
+	err = ns.Update(func(tx walletdb.Tx) error {
+		return tx.RootBucket().Put(key, value)
+	})
+	if err != nil {
+		t.Errorf("Update: unexpected error: %v", err)
+		return
+	}
+
+	db, _ := createTestDB(t)
+	defer db.Close()
+	if err := walletdb.CopyNamespace(db, plainDB, nsKey); err != nil {
+		t.Errorf("CopyNamespace: unexpected error: %v", err)
+		return
+	}
+
+	ns, err = db.Namespace(nsKey)
+	if err != nil {
+		t.Errorf("Namespace: unexpected error: %v", err)
+		return
+	}
+	err = ns.View(func(tx walletdb.Tx) error {
+		if got := tx.RootBucket().Get(key); !bytes.Equal(got, value) {
+			return fmt.Errorf("Get: unexpected value - got %s, "+
+				"want %s", got, value)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("View: %v", err)
+	}
+}
+
+// TestIsEncrypted ensures encrypted databases are detected, and that checking
+// an unencrypted database or trying to open it does not modify it.
+func TestIsEncrypted(t *testing.T) {
+	udb, err := walletdb.Create("memdb")
+	if err != nil {
+		t.Errorf("Failed to create underlying database: %v", err)
+		return
+	}
+	defer udb.Close()
+
+	if encrypted, err := encdb.IsEncrypted(udb); err != nil || encrypted {
+		t.Errorf("IsEncrypted: got %v (error %v), want false", encrypted,
+			err)
+		return
+	}
+	if _, err := walletdb.Open(dbType, udb, passphrase); err != walletdb.ErrDbDoesNotExist {
+		t.Errorf("Open: did not receive expected error - got %v, "+
+			"want %v", err, walletdb.ErrDbDoesNotExist)
+		return
+	}
+	if exists, err := udb.NamespaceExists([]byte("encdb")); err != nil || exists {
+		t.Errorf("NamespaceExists: metadata namespace created in an "+
+			"unencrypted database (error %v)", err)
+		return
+	}
+
+	if _, err := walletdb.Create(dbType, udb, passphrase); err != nil {
+		t.Errorf("Create: unexpected error: %v", err)
+		return
+	}
+	if encrypted, err := encdb.IsEncrypted(udb); err != nil || !encrypted {
+		t.Errorf("IsEncrypted: got %v (error %v), want true", encrypted,
+			err)
+	}
+}
+
+// TestCipher ensures data encrypted with the cipher of a database can only be
+// decrypted with the keys of that database, and that the cipher can't be used
+// once the database is closed.
+func TestCipher(t *testing.T) {
+	db, _ := createTestDB(t)
+	if encdb.Cipher(db) == nil {
+		db.Close()
+		t.Errorf("Cipher: no cipher for an encrypted database")
+		return
+	}
+	cipher := encdb.Cipher(db)
+
+	data := []byte("transaction store")
+	encrypted, err := cipher.Encrypt(data)
+	if err != nil {
+		db.Close()
+		t.Errorf("Encrypt: unexpected error: %v", err)
+		return
+	}
+	if bytes.Contains(encrypted, data) {
+		db.Close()
+		t.Errorf("Encrypt: data is not encrypted")
+		return
+	}
+	decrypted, err := cipher.Decrypt(encrypted)
+	if err != nil || !bytes.Equal(decrypted, data) {
+		db.Close()
+		t.Errorf("Decrypt: got %q (error %v), want %q", decrypted, err,
+			data)
+		return
+	}
+
+	otherDb, udb := createTestDB(t)
+	defer otherDb.Close()
+	if _, err := encdb.Cipher(otherDb).Decrypt(encrypted); err != snacl.ErrDecryptFailed {
+		db.Close()
+		t.Errorf("Decrypt: did not receive expected error - got %v, "+
+			"want %v", err, snacl.ErrDecryptFailed)
+		return
+	}
+	if encdb.Cipher(udb) != nil {
+		db.Close()
+		t.Errorf("Cipher: got a cipher for an unencrypted database")
+		return
+	}
+
+	db.Close()
+	if _, err := cipher.Encrypt(data); err != walletdb.ErrDbNotOpen {
+		t.Errorf("Encrypt: did not receive expected error - got %v, "+
+			"want %v", err, walletdb.ErrDbNotOpen)
+	}
+}
+
+// TestInterface performs all interfaces tests for this database driver.
+func TestInterface(t *testing.T) {
+	// Create a new database to run tests against.
+	db, _ := createTestDB(t)
+	defer db.Close()
+
+	// Run all of the interface tests against the database.
+	testInterface(t, db)
+}
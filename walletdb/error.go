@@ -90,3 +90,14 @@ var (
 	// delete a non-bucket key on an existing bucket key.
 	ErrIncompatibleValue = errors.New("incompatible value")
 )
+
+// Errors that can occur when migrating the data stored in a namespace.
+var (
+	// ErrMigrationOrder is returned when the migrations of a schema are not
+	// ordered by strictly increasing version.
+	ErrMigrationOrder = errors.New("migrations not ordered by version")
+
+	// ErrNewerVersion is returned when the data stored in a namespace is at
+	// a version newer than the latest one of its schema.
+	ErrNewerVersion = errors.New("namespace version newer than supported")
+)
@@ -59,6 +59,14 @@ type Bucket interface {
 	// implementations.
 	ForEach(func(k, v []byte) error) error
 
+	// Cursor returns a new cursor, allowing for iteration over the bucket's
+	// key/value pairs and nested buckets in forward or backward order.
+	// Keys are ordered by their byte values.
+	//
+	// NOTE: The cursor is only valid during the transaction the bucket was
+	// obtained from.
+	Cursor() Cursor
+
 	// Writable returns whether or not the bucket is writable.
 	Writable() bool
 
@@ -84,6 +92,51 @@ type Bucket interface {
 	Delete(key []byte) error
 }
 
+// Cursor represents a cursor over the key/value pairs and nested buckets of a
+// bucket.  Keys are visited in the order of their byte values.
+//
+// Note that open cursors are not tracked on bucket changes and any
+// modifications to the bucket, with the exception of Cursor.Delete, invalidate
+// the cursor.  After invalidation, the cursor must be repositioned, or the keys
+// and values returned may be unpredictable.
+//
+// NOTE: The keys and values returned by the cursor functions are only valid
+// during a transaction.  Attempting to access them after a transaction has
+// ended results in undefined behavior.  The value returned for a nested
+// bucket is nil.
+type Cursor interface {
+	// Bucket returns the bucket the cursor was created from.
+	Bucket() Bucket
+
+	// Delete removes the current key/value pair the cursor is at without
+	// invalidating the cursor.  Returns ErrTxNotWritable if attempted
+	// against a read-only transaction, or ErrIncompatibleValue if
+	// attempted when the cursor points to a nested bucket.
+	Delete() error
+
+	// First positions the cursor at the first key/value pair and returns
+	// the pair.  A nil key is returned if the bucket is empty.
+	First() (key, value []byte)
+
+	// Last positions the cursor at the last key/value pair and returns the
+	// pair.  A nil key is returned if the bucket is empty.
+	Last() (key, value []byte)
+
+	// Next moves the cursor one key/value pair forward and returns the new
+	// pair.  A nil key is returned once the end of the bucket is reached.
+	Next() (key, value []byte)
+
+	// Prev moves the cursor one key/value pair backward and returns the new
+	// pair.  A nil key is returned once the start of the bucket is
+	// reached.
+	Prev() (key, value []byte)
+
+	// Seek positions the cursor at the passed seek key.  If the key does
+	// not exist, the cursor is moved to the next key after seek.  Returns
+	// the new pair, or a nil key if there are no keys at or after seek.
+	Seek(seek []byte) (key, value []byte)
+}
+
 // Tx represents a database transaction.  It can either by read-only or
 // read-write.  The transaction provides a root bucket against which all read
 // and writes occur.
@@ -151,6 +204,10 @@ type DB interface {
 	// database on first access.
 	Namespace(key []byte) (Namespace, error)
 
+	// NamespaceExists returns whether a namespace for the passed key
+	// exists.  Unlike Namespace, it never creates the namespace.
+	NamespaceExists(key []byte) (bool, error)
+
 	// DeleteNamespace deletes the namespace for the passed key.
 	// ErrBucketNotFound will be returned if the namespace does not exist.
 	DeleteNamespace(key []byte) error
@@ -0,0 +1,885 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package memdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/monetas/btcwallet/walletdb"
+)
+
+const (
+	// maxKeySize is the maximum length of a key, in bytes.  It matches the
+	// limit of the bdb driver so both behave the same.
+	maxKeySize = 32768
+
+	// maxValueSize is the maximum length of a value, in bytes.  It matches
+	// the limit of the bdb driver so both behave the same.
+	maxValueSize = (1 << 31) - 2
+
+	// maxCopyDepth is the maximum nesting depth of the buckets of a copy
+	// read by Open, counting namespaces as the first level.  Copies are
+	// read recursively, so the depth is bounded for a corrupt copy not to
+	// exhaust the stack.  Wallets nest buckets only a few levels deep.
+	maxCopyDepth = 256
+)
+
+// node holds the key/value pairs and nested buckets of a bucket.  A key can
+// either name a value or a nested bucket, but not both.
+//
+// Nodes reachable from the committed root of a database are never modified.
+// Read-write transactions copy a node, along with its parents up to the root,
+// the first time they modify it and share all the other nodes with the
+// committed tree.  The root of the copy replaces the committed one when the
+// transaction is committed.  This gives every transaction a consistent view of
+// the database at the time it was created.
+type node struct {
+	values  map[string][]byte
+	buckets map[string]*node
+}
+
+// newNode returns a new empty node.
+func newNode() *node {
+	return &node{
+		values:  make(map[string][]byte),
+		buckets: make(map[string]*node),
+	}
+}
+
+// copy returns a shallow copy of the node.  Values are immutable once stored
+// and nested buckets are copied on their own when they are modified, so both
+// are shared with the original.
+func (n *node) copy() *node {
+	c := &node{
+		values:  make(map[string][]byte, len(n.values)),
+		buckets: make(map[string]*node, len(n.buckets)),
+	}
+	for k, v := range n.values {
+		c.values[k] = v
+	}
+	for k, b := range n.buckets {
+		c.buckets[k] = b
+	}
+	return c
+}
+
+// sortedKeys returns the keys of all the values and nested buckets of the node
+// ordered by their byte values.
+func (n *node) sortedKeys() []string {
+	keys := make([]string, 0, len(n.values)+len(n.buckets))
+	for k := range n.values {
+		keys = append(keys, k)
+	}
+	for k := range n.buckets {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// pair returns the key and value for the given key of the node, the value
+// being nil for nested buckets.
+func (n *node) pair(key string) ([]byte, []byte) {
+	return []byte(key), n.values[key]
+}
+
+// bucket is an internal type used to represent a collection of key/value pairs
+// and implements the walletdb.Bucket interface.
+//
+// The parent bucket and key are kept so that read-write transactions can
+// replace the node in its parent when it is copied on write.  The bucket
+// standing for the root of the tree has no parent.
+type bucket struct {
+	tx     *transaction
+	parent *bucket
+	key    string
+	node   *node
+}
+
+// Enforce bucket implements the walletdb.Bucket interface.
+var _ walletdb.Bucket = (*bucket)(nil)
+
+// current returns the node holding the contents of the bucket.  In read-write
+// transactions the node is looked up again in the parent, since it may have
+// been copied through another bucket for the same key since this one was
+// created.
+func (b *bucket) current() *node {
+	if b.parent == nil || !b.tx.writable {
+		return b.node
+	}
+	if n := b.parent.current().buckets[b.key]; n != nil {
+		b.node = n
+	}
+	return b.node
+}
+
+// writableNode returns the node holding the contents of the bucket, copying it
+// and its parents first unless the transaction already did so.  The bucket's
+// transaction must be writable.
+func (b *bucket) writableNode() *node {
+	n := b.current()
+	if b.parent == nil || b.tx.owned[n] {
+		return n
+	}
+
+	c := n.copy()
+	b.tx.owned[c] = true
+	// A bucket that was deleted keeps working on its own detached copy.
+	if parent := b.parent.writableNode(); parent.buckets[b.key] == n {
+		parent.buckets[b.key] = c
+	}
+	b.node = c
+	return c
+}
+
+// checkWritable returns an error if the bucket's transaction is closed or is
+// not writable.
+func (b *bucket) checkWritable() error {
+	if b.tx.closed {
+		return walletdb.ErrTxClosed
+	}
+	if !b.tx.writable {
+		return walletdb.ErrTxNotWritable
+	}
+	return nil
+}
+
+// Bucket retrieves a nested bucket with the given key.  Returns nil if
+// the bucket does not exist.
+//
+// This function is part of the walletdb.Bucket interface implementation.
+func (b *bucket) Bucket(key []byte) walletdb.Bucket {
+	n, ok := b.current().buckets[string(key)]
+	if !ok {
+		return nil
+	}
+	return &bucket{tx: b.tx, parent: b, key: string(key), node: n}
+}
+
+// CreateBucket creates and returns a new nested bucket with the given key.
+// Returns ErrBucketExists if the bucket already exists, ErrBucketNameRequired
+// if the key is empty, or ErrIncompatibleValue if the key is already used by
+// a value.
+//
+// This function is part of the walletdb.Bucket interface implementation.
+func (b *bucket) CreateBucket(key []byte) (walletdb.Bucket, error) {
+	if err := b.checkWritable(); err != nil {
+		return nil, err
+	}
+	if len(key) == 0 {
+		return nil, walletdb.ErrBucketNameRequired
+	}
+	if len(key) > maxKeySize {
+		return nil, walletdb.ErrKeyTooLarge
+	}
+	if _, ok := b.current().buckets[string(key)]; ok {
+		return nil, walletdb.ErrBucketExists
+	}
+	if _, ok := b.current().values[string(key)]; ok {
+		return nil, walletdb.ErrIncompatibleValue
+	}
+
+	n := newNode()
+	b.tx.owned[n] = true
+	b.writableNode().buckets[string(key)] = n
+	return &bucket{tx: b.tx, parent: b, key: string(key), node: n}, nil
+}
+
+// CreateBucketIfNotExists creates and returns a new nested bucket with the
+// given key if it does not already exist.  Returns ErrBucketNameRequired if the
+// key is empty or ErrIncompatibleValue if the key is already used by a value.
+//
+// This function is part of the walletdb.Bucket interface implementation.
+func (b *bucket) CreateBucketIfNotExists(key []byte) (walletdb.Bucket, error) {
+	newBucket, err := b.CreateBucket(key)
+	if err == walletdb.ErrBucketExists {
+		return b.Bucket(key), nil
+	}
+	return newBucket, err
+}
+
+// DeleteBucket removes a nested bucket with the given key.  Returns
+// ErrTxNotWritable if attempted against a read-only transaction,
+// ErrIncompatibleValue if the key does not name a bucket, and
+// ErrBucketNotFound if the specified bucket does not exist.
+//
+// This function is part of the walletdb.Bucket interface implementation.
+func (b *bucket) DeleteBucket(key []byte) error {
+	if err := b.checkWritable(); err != nil {
+		return err
+	}
+	if _, ok := b.current().buckets[string(key)]; !ok {
+		if _, ok := b.current().values[string(key)]; ok || len(key) == 0 {
+			return walletdb.ErrIncompatibleValue
+		}
+		return walletdb.ErrBucketNotFound
+	}
+	delete(b.writableNode().buckets, string(key))
+	return nil
+}
+
+// ForEach invokes the passed function with every key/value pair in the bucket,
+// ordered by key.  This includes nested buckets, in which case the value is
+// nil, but it does not include the key/value pairs within those nested
+// buckets.
+//
+// This function is part of the walletdb.Bucket interface implementation.
+func (b *bucket) ForEach(fn func(k, v []byte) error) error {
+	n := b.current()
+	for _, k := range n.sortedKeys() {
+		if err := fn(n.pair(k)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Cursor returns a new cursor, allowing for iteration over the bucket's
+// key/value pairs and nested buckets in forward or backward order.
+//
+// This function is part of the walletdb.Bucket interface implementation.
+func (b *bucket) Cursor() walletdb.Cursor {
+	return &cursor{bucket: b}
+}
+
+// Writable returns whether or not the bucket is writable.
+//
+// This function is part of the walletdb.Bucket interface implementation.
+func (b *bucket) Writable() bool {
+	return b.tx.writable
+}
+
+// Put saves the specified key/value pair to the bucket.  Keys that do not
+// already exist are added and keys that already exist are overwritten.  Returns
+// ErrTxNotWritable if attempted against a read-only transaction.
+//
+// This function is part of the walletdb.Bucket interface implementation.
+func (b *bucket) Put(key, value []byte) error {
+	if err := b.checkWritable(); err != nil {
+		return err
+	}
+	if len(key) == 0 {
+		return walletdb.ErrKeyRequired
+	}
+	if len(key) > maxKeySize {
+		return walletdb.ErrKeyTooLarge
+	}
+	if int64(len(value)) > maxValueSize {
+		return walletdb.ErrValueTooLarge
+	}
+	if _, ok := b.current().buckets[string(key)]; ok {
+		return walletdb.ErrIncompatibleValue
+	}
+
+	// Copy the value as the caller is free to modify it once Put returns.
+	b.writableNode().values[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+// Get returns the value for the given key.  Returns nil if the key does
+// not exist in this bucket or names a nested bucket.
+//
+// This function is part of the walletdb.Bucket interface implementation.
+func (b *bucket) Get(key []byte) []byte {
+	return b.current().values[string(key)]
+}
+
+// Delete removes the specified key from the bucket.  Deleting a key that does
+// not exist does not return an error.  Returns ErrTxNotWritable if attempted
+// against a read-only transaction.
+//
+// This function is part of the walletdb.Bucket interface implementation.
+func (b *bucket) Delete(key []byte) error {
+	if err := b.checkWritable(); err != nil {
+		return err
+	}
+	n := b.current()
+	if _, ok := n.buckets[string(key)]; ok {
+		return walletdb.ErrIncompatibleValue
+	}
+	if _, ok := n.values[string(key)]; !ok {
+		return nil
+	}
+	delete(b.writableNode().values, string(key))
+	return nil
+}
+
+// cursor represents a cursor over key/value pairs and nested buckets of a
+// bucket and implements the walletdb.Cursor interface.  The keys of the
+// bucket are sorted whenever the cursor is positioned with First, Last or
+// Seek, which is why modifications made after that invalidate the cursor.
+type cursor struct {
+	bucket *bucket
+	keys   []string
+	pos    int
+}
+
+// Enforce cursor implements the walletdb.Cursor interface.
+var _ walletdb.Cursor = (*cursor)(nil)
+
+// current returns the key/value pair the cursor is at, or nil values if it is
+// positioned past either end of the bucket.
+func (c *cursor) current() (key, value []byte) {
+	if c.pos < 0 || c.pos >= len(c.keys) {
+		return nil, nil
+	}
+	return c.bucket.current().pair(c.keys[c.pos])
+}
+
+// Bucket returns the bucket the cursor was created from.
+//
+// This function is part of the walletdb.Cursor interface implementation.
+func (c *cursor) Bucket() walletdb.Bucket {
+	return c.bucket
+}
+
+// Delete removes the current key/value pair the cursor is at without
+// invalidating the cursor.  Returns ErrTxNotWritable if attempted against a
+// read-only transaction, or ErrIncompatibleValue if attempted when the cursor
+// points to a nested bucket.
+//
+// This function is part of the walletdb.Cursor interface implementation.
+func (c *cursor) Delete() error {
+	if err := c.bucket.checkWritable(); err != nil {
+		return err
+	}
+	key, _ := c.current()
+	if key == nil {
+		return nil
+	}
+	return c.bucket.Delete(key)
+}
+
+// First positions the cursor at the first key/value pair and returns the pair.
+//
+// This function is part of the walletdb.Cursor interface implementation.
+func (c *cursor) First() (key, value []byte) {
+	c.keys = c.bucket.current().sortedKeys()
+	c.pos = 0
+	return c.current()
+}
+
+// Last positions the cursor at the last key/value pair and returns the pair.
+//
+// This function is part of the walletdb.Cursor interface implementation.
+func (c *cursor) Last() (key, value []byte) {
+	c.keys = c.bucket.current().sortedKeys()
+	c.pos = len(c.keys) - 1
+	return c.current()
+}
+
+// Next moves the cursor one key/value pair forward and returns the new pair.
+//
+// This function is part of the walletdb.Cursor interface implementation.
+func (c *cursor) Next() (key, value []byte) {
+	if c.pos < len(c.keys) {
+		c.pos++
+	}
+	return c.current()
+}
+
+// Prev moves the cursor one key/value pair backward and returns the new pair.
+//
+// This function is part of the walletdb.Cursor interface implementation.
+func (c *cursor) Prev() (key, value []byte) {
+	if c.pos >= 0 {
+		c.pos--
+	}
+	return c.current()
+}
+
+// Seek positions the cursor at the passed seek key.  If the key does not exist,
+// the cursor is moved to the next key after seek.  Returns the new pair.
+//
+// This function is part of the walletdb.Cursor interface implementation.
+func (c *cursor) Seek(seek []byte) (key, value []byte) {
+	c.keys = c.bucket.current().sortedKeys()
+	c.pos = sort.SearchStrings(c.keys, string(seek))
+	return c.current()
+}
+
+// transaction represents a database transaction.  It can either by read-only or
+// read-write and implements the walletdb.Tx interface.  The transaction
+// provides a root bucket against which all read and writes occur.
+type transaction struct {
+	db       *db
+	root     *node
+	key      string
+	writable bool
+	managed  bool
+	closed   bool
+
+	// owned holds the nodes created or copied by a read-write transaction,
+	// which are the only ones it is allowed to modify.
+	owned map[*node]bool
+}
+
+// Enforce transaction implements the walletdb.Tx interface.
+var _ walletdb.Tx = (*transaction)(nil)
+
+// RootBucket returns the top-most bucket for the namespace the transaction was
+// created from.
+//
+// This function is part of the walletdb.Tx interface implementation.
+func (tx *transaction) RootBucket() walletdb.Bucket {
+	root := &bucket{tx: tx, node: tx.root}
+	return &bucket{tx: tx, parent: root, key: tx.key,
+		node: tx.root.buckets[tx.key]}
+}
+
+// Commit commits all changes that have been made through the root bucket and
+// all of its sub-buckets.
+//
+// This function is part of the walletdb.Tx interface implementation.
+func (tx *transaction) Commit() error {
+	if tx.managed {
+		panic("managed transaction commit not allowed")
+	}
+	return tx.commit()
+}
+
+// commit is the implementation of Commit, which can also be used by managed
+// transactions.
+func (tx *transaction) commit() error {
+	if tx.closed {
+		return walletdb.ErrTxClosed
+	}
+	if !tx.writable {
+		return walletdb.ErrTxNotWritable
+	}
+
+	tx.db.mtx.Lock()
+	tx.db.root = tx.root
+	tx.db.mtx.Unlock()
+	tx.close()
+	return nil
+}
+
+// Rollback undoes all changes that have been made to the root bucket and all of
+// its sub-buckets.
+//
+// This function is part of the walletdb.Tx interface implementation.
+func (tx *transaction) Rollback() error {
+	if tx.managed {
+		panic("managed transaction rollback not allowed")
+	}
+	return tx.rollback()
+}
+
+// rollback is the implementation of Rollback, which can also be used by
+// managed transactions.
+func (tx *transaction) rollback() error {
+	if tx.closed {
+		return walletdb.ErrTxClosed
+	}
+	tx.close()
+	return nil
+}
+
+// close marks the transaction as closed and, for read-write transactions,
+// allows the next one to start.
+func (tx *transaction) close() {
+	tx.closed = true
+	tx.owned = nil
+	if tx.writable {
+		tx.db.writeMtx.Unlock()
+	}
+}
+
+// namespace represents a database namespace that is inteded to support the
+// concept of a single entity that controls the opening, creating, and closing
+// of a database while providing other entities their own namespace to work in.
+// It implements the walletdb.Namespace interface.
+type namespace struct {
+	db  *db
+	key string
+}
+
+// Enforce namespace implements the walletdb.Namespace interface.
+var _ walletdb.Namespace = (*namespace)(nil)
+
+// Begin starts a transaction which is either read-only or read-write depending
+// on the specified flag.  Multiple read-only transactions can be started
+// simultaneously while only a single read-write transaction can be started at a
+// time.  The call will block when starting a read-write transaction when one is
+// already open.
+//
+// NOTE: The transaction must be closed by calling Rollback or Commit on it when
+// it is no longer needed.  Failure to do so will prevent any other read-write
+// transaction from starting.
+//
+// This function is part of the walletdb.Namespace interface implementation.
+func (ns *namespace) Begin(writable bool) (walletdb.Tx, error) {
+	return ns.begin(writable)
+}
+
+// begin is the implementation of Begin, returning the concrete transaction
+// type so View and Update can mark it as managed.
+func (ns *namespace) begin(writable bool) (*transaction, error) {
+	if writable {
+		ns.db.writeMtx.Lock()
+	}
+
+	ns.db.mtx.RLock()
+	root, closed := ns.db.root, ns.db.closed
+	ns.db.mtx.RUnlock()
+
+	if closed || root.buckets[ns.key] == nil {
+		if writable {
+			ns.db.writeMtx.Unlock()
+		}
+		if closed {
+			return nil, walletdb.ErrDbNotOpen
+		}
+		return nil, walletdb.ErrBucketNotFound
+	}
+
+	tx := &transaction{
+		db:       ns.db,
+		root:     root,
+		key:      ns.key,
+		writable: writable,
+	}
+	if writable {
+		tx.root = root.copy()
+		tx.owned = map[*node]bool{tx.root: true}
+	}
+	return tx, nil
+}
+
+// View invokes the passed function in the context of a managed read-only
+// transaction.  Any errors returned from the user-supplied function are
+// returned from this function.
+//
+// Calling Rollback on the transaction passed to the user-supplied function will
+// result in a panic.
+//
+// This function is part of the walletdb.Namespace interface implementation.
+func (ns *namespace) View(fn func(walletdb.Tx) error) error {
+	tx, err := ns.begin(false)
+	if err != nil {
+		return err
+	}
+	defer tx.rollback()
+
+	tx.managed = true
+	return fn(tx)
+}
+
+// Update invokes the passed function in the context of a managed read-write
+// transaction.  Any errors returned from the user-supplied function will cause
+// the transaction to be rolled back and are returned from this function.
+// Otherwise, the transaction is commited when the user-supplied function
+// returns a nil error.
+//
+// Calling Rollback on the transaction passed to the user-supplied function will
+// result in a panic.
+//
+// This function is part of the walletdb.Namespace interface implementation.
+func (ns *namespace) Update(fn func(walletdb.Tx) error) error {
+	tx, err := ns.begin(true)
+	if err != nil {
+		return err
+	}
+	// Make sure the transaction rolls back in the event of a panic.
+	defer func() {
+		if !tx.closed {
+			tx.rollback()
+		}
+	}()
+
+	tx.managed = true
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.commit()
+}
+
+// db represents a collection of namespaces which are held in memory and
+// implements the walletdb.Db interface.  All database access is performed
+// through transactions which are obtained through the specific Namespace.
+type db struct {
+	// writeMtx is held by the read-write transaction in progress, if any.
+	writeMtx sync.Mutex
+
+	// mtx protects the fields below.
+	mtx    sync.RWMutex
+	root   *node
+	closed bool
+}
+
+// Enforce db implements the walletdb.Db interface.
+var _ walletdb.DB = (*db)(nil)
+
+// Namespace returns a Namespace interface for the provided key.  See the
+// Namespace interface documentation for more details.  Attempting to access a
+// Namespace on a database that has been closed will result in ErrDbNotOpen.
+// Namespaces are created in the database on first access.
+//
+// This function is part of the walletdb.Db interface implementation.
+func (db *db) Namespace(key []byte) (walletdb.Namespace, error) {
+	if len(key) == 0 {
+		return nil, walletdb.ErrBucketNameRequired
+	}
+
+	err := db.updateRoot(func(root *node) error {
+		if root.buckets[string(key)] == nil {
+			root.buckets[string(key)] = newNode()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &namespace{db: db, key: string(key)}, nil
+}
+
+// NamespaceExists returns whether a namespace for the provided key exists.  The
+// namespace is not created when it does not.
+//
+// This function is part of the walletdb.Db interface implementation.
+func (db *db) NamespaceExists(key []byte) (bool, error) {
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+	if db.closed {
+		return false, walletdb.ErrDbNotOpen
+	}
+	return db.root.buckets[string(key)] != nil, nil
+}
+
+// DeleteNamespace deletes the namespace for the passed key.  ErrBucketNotFound
+// will be returned if the namespace does not exist.
+//
+// This function is part of the walletdb.Db interface implementation.
+func (db *db) DeleteNamespace(key []byte) error {
+	return db.updateRoot(func(root *node) error {
+		if root.buckets[string(key)] == nil {
+			return walletdb.ErrBucketNotFound
+		}
+		delete(root.buckets, string(key))
+		return nil
+	})
+}
+
+// updateRoot waits for any read-write transaction in progress to finish and
+// then replaces the root of the database with a copy modified by the passed
+// function.  Namespaces are shared between the old and new roots, so the
+// function must not modify them.
+func (db *db) updateRoot(fn func(root *node) error) error {
+	db.writeMtx.Lock()
+	defer db.writeMtx.Unlock()
+
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	if db.closed {
+		return walletdb.ErrDbNotOpen
+	}
+
+	root := db.root.copy()
+	if err := fn(root); err != nil {
+		return err
+	}
+	db.root = root
+	return nil
+}
+
+// Copy writes a serialized copy of the database to the provided writer.  The
+// copy reflects the last committed state of the database and can be loaded
+// back by passing a reader for it to walletdb.Open.
+//
+// This function is part of the walletdb.Db interface implementation.
+func (db *db) Copy(w io.Writer) error {
+	db.mtx.RLock()
+	root, closed := db.root, db.closed
+	db.mtx.RUnlock()
+	if closed {
+		return walletdb.ErrDbNotOpen
+	}
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(copyMagic); err != nil {
+		return err
+	}
+	if err := writeNode(bw, root); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Close releases the memory held by the database.  Any data not copied out
+// with Copy is lost.  The call blocks until the read-write transaction in
+// progress, if any, is finished.
+//
+// This function is part of the walletdb.Db interface implementation.
+func (db *db) Close() error {
+	db.writeMtx.Lock()
+	defer db.writeMtx.Unlock()
+
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	db.closed = true
+	db.root = newNode()
+	return nil
+}
+
+// copyMagic identifies the serialization written by Copy.
+var copyMagic = []byte("walletdb-memdb\x01")
+
+// Types of the entries of a serialized node.
+const (
+	entryValue byte = iota
+	entryBucket
+)
+
+// writeNode serializes the given node to w.  The serialization is the number
+// of entries followed by every entry ordered by key, each of them comprising
+// its type, key and either the value or the serialization of the nested
+// bucket.  All lengths are encoded as unsigned varints.
+func writeNode(w *bufio.Writer, n *node) error {
+	keys := n.sortedKeys()
+	if err := writeUvarint(w, uint64(len(keys))); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if nested, ok := n.buckets[k]; ok {
+			if err := w.WriteByte(entryBucket); err != nil {
+				return err
+			}
+			if err := writeBytes(w, []byte(k)); err != nil {
+				return err
+			}
+			if err := writeNode(w, nested); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := w.WriteByte(entryValue); err != nil {
+			return err
+		}
+		if err := writeBytes(w, []byte(k)); err != nil {
+			return err
+		}
+		if err := writeBytes(w, n.values[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeUvarint writes x to w encoded as an unsigned varint.
+func writeUvarint(w *bufio.Writer, x uint64) error {
+	var buf [binary.MaxVarintLen64]byte
+	_, err := w.Write(buf[:binary.PutUvarint(buf[:], x)])
+	return err
+}
+
+// writeBytes writes b to w prefixed by its length.
+func writeBytes(w *bufio.Writer, b []byte) error {
+	if err := writeUvarint(w, uint64(len(b))); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// readNode deserializes a node serialized with writeNode, nested in depth
+// buckets, failing with ErrInvalid if its nested buckets are deeper than
+// maxCopyDepth.
+func readNode(r *bufio.Reader, depth int) (*node, error) {
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	n := newNode()
+	for i := uint64(0); i < count; i++ {
+		entryType, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		key, err := readBytes(r, maxKeySize)
+		if err != nil {
+			return nil, err
+		}
+		switch entryType {
+		case entryValue:
+			value, err := readBytes(r, maxValueSize)
+			if err != nil {
+				return nil, err
+			}
+			n.values[string(key)] = value
+		case entryBucket:
+			if depth >= maxCopyDepth {
+				return nil, walletdb.ErrInvalid
+			}
+			nested, err := readNode(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			n.buckets[string(key)] = nested
+		default:
+			return nil, walletdb.ErrInvalid
+		}
+	}
+	return n, nil
+}
+
+// readBytes reads a byte slice written with writeBytes, failing with
+// ErrInvalid if it is longer than max.  The bytes are copied into a growing
+// buffer rather than allocated up front, since the length is read from the
+// input and a corrupt copy must not cause a huge allocation.
+func readBytes(r *bufio.Reader, max uint64) ([]byte, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if length > max {
+		return nil, walletdb.ErrInvalid
+	}
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(length)); err != nil {
+		return nil, err
+	}
+	if buf.Len() == 0 {
+		return []byte{}, nil
+	}
+	return buf.Bytes(), nil
+}
+
+// createDB returns a new empty database.
+func createDB() walletdb.DB {
+	return &db{root: newNode()}
+}
+
+// openDB returns a database loaded from a copy written by Copy.  ErrInvalid is
+// returned if the reader does not hold such a copy.
+func openDB(r io.Reader) (walletdb.DB, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(copyMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || !bytes.Equal(magic, copyMagic) {
+		return nil, walletdb.ErrInvalid
+	}
+	root, err := readNode(br, 0)
+	if err != nil {
+		return nil, walletdb.ErrInvalid
+	}
+	return &db{root: root}, nil
+}
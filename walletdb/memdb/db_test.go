@@ -0,0 +1,78 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package memdb
+
+import "testing"
+
+// TestUnmodifiedBucketsShared ensures that committing a read-write
+// transaction copies only the buckets it modified and their parents, leaving
+// every other bucket shared with the previous root.
+func TestUnmodifiedBucketsShared(t *testing.T) {
+	db := createDB().(*db)
+	ns, err := db.Namespace([]byte("ns"))
+	if err != nil {
+		t.Fatalf("Namespace: unexpected error: %v", err)
+	}
+	_, err = db.Namespace([]byte("untouched"))
+	if err != nil {
+		t.Fatalf("Namespace: unexpected error: %v", err)
+	}
+
+	tx, err := ns.Begin(true)
+	if err != nil {
+		t.Fatalf("Begin: unexpected error: %v", err)
+	}
+	for _, name := range []string{"modified", "sibling"} {
+		if _, err := tx.RootBucket().CreateBucket([]byte(name)); err != nil {
+			t.Fatalf("CreateBucket: unexpected error: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: unexpected error: %v", err)
+	}
+
+	before := db.root
+	tx, err = ns.Begin(true)
+	if err != nil {
+		t.Fatalf("Begin: unexpected error: %v", err)
+	}
+	err = tx.RootBucket().Bucket([]byte("modified")).Put([]byte("k"), []byte("v"))
+	if err != nil {
+		t.Fatalf("Put: unexpected error: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: unexpected error: %v", err)
+	}
+	after := db.root
+
+	if after.buckets["untouched"] != before.buckets["untouched"] {
+		t.Errorf("untouched namespace was copied")
+	}
+	nsBefore, nsAfter := before.buckets["ns"], after.buckets["ns"]
+	if nsAfter == nsBefore {
+		t.Fatalf("namespace of the modified bucket was not copied")
+	}
+	if nsAfter.buckets["sibling"] != nsBefore.buckets["sibling"] {
+		t.Errorf("sibling of the modified bucket was copied")
+	}
+	if nsAfter.buckets["modified"] == nsBefore.buckets["modified"] {
+		t.Errorf("modified bucket was not copied")
+	}
+	if v := nsBefore.buckets["modified"].values["k"]; v != nil {
+		t.Errorf("committed bucket was modified in place")
+	}
+}
@@ -0,0 +1,51 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+/*
+Package memdb implements an instance of walletdb that keeps all data in memory.
+
+It is intended for tests and ephemeral wallets, which have no need for their
+data to outlive the process.  Read-write transactions copy the buckets they
+modify, and the buckets leading to them, the first time they modify them, so
+their cost grows with the size of those buckets rather than with the size of
+the database.  The copy replaces the committed tree on commit.
+
+Usage
+
+This package is only a driver to the walletdb package and provides the database
+type of "memdb".  The Create function takes no parameters and returns a new
+empty database:
+
+	db, err := walletdb.Create("memdb")
+	if err != nil {
+		// Handle error
+	}
+
+The contents of a database are lost when it is closed, but they can be saved
+with the Copy function of the database and loaded back by passing an io.Reader
+for the copy to the Open function:
+
+	var buf bytes.Buffer
+	if err := db.Copy(&buf); err != nil {
+		// Handle error
+	}
+
+	db, err := walletdb.Open("memdb", &buf)
+	if err != nil {
+		// Handle error
+	}
+*/
+package memdb
@@ -0,0 +1,69 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package memdb
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/monetas/btcwallet/walletdb"
+)
+
+const (
+	dbType = "memdb"
+)
+
+// openDBDriver is the callback provided during driver registration that loads
+// a database from a copy previously written with DB.Copy.
+func openDBDriver(args ...interface{}) (walletdb.DB, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("invalid arguments to %s.Open -- "+
+			"expected database copy reader", dbType)
+	}
+
+	r, ok := args[0].(io.Reader)
+	if !ok {
+		return nil, fmt.Errorf("first argument to %s.Open is invalid -- "+
+			"expected database copy io.Reader", dbType)
+	}
+
+	return openDB(r)
+}
+
+// createDBDriver is the callback provided during driver registration that
+// creates a new empty database.
+func createDBDriver(args ...interface{}) (walletdb.DB, error) {
+	if len(args) != 0 {
+		return nil, fmt.Errorf("invalid arguments to %s.Create -- "+
+			"expected no arguments", dbType)
+	}
+
+	return createDB(), nil
+}
+
+func init() {
+	// Register the driver.
+	driver := walletdb.Driver{
+		DbType: dbType,
+		Create: createDBDriver,
+		Open:   openDBDriver,
+	}
+	if err := walletdb.RegisterDriver(driver); err != nil {
+		panic(fmt.Sprintf("Failed to regiser database driver '%s': %v",
+			dbType, err))
+	}
+}
@@ -0,0 +1,409 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package memdb_test
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/monetas/btcwallet/walletdb"
+	_ "github.com/monetas/btcwallet/walletdb/memdb"
+)
+
+// dbType is the database type name for this driver.
+const dbType = "memdb"
+
+// TestCreateOpenFail ensures that errors related to creating and opening a
+// database are handled properly.
+func TestCreateOpenFail(t *testing.T) {
+	// Ensure that attempting to open a database with the wrong number of
+	// parameters returns the expected error.
+	wantErr := fmt.Errorf("invalid arguments to %s.Open -- expected "+
+		"database copy reader", dbType)
+	if _, err := walletdb.Open(dbType, 1, 2, 3); err.Error() != wantErr.Error() {
+		t.Errorf("Open: did not receive expected error - got %v, "+
+			"want %v", err, wantErr)
+		return
+	}
+
+	// Ensure that attempting to open a database with an invalid type for
+	// the first parameter returns the expected error.
+	wantErr = fmt.Errorf("first argument to %s.Open is invalid -- "+
+		"expected database copy io.Reader", dbType)
+	if _, err := walletdb.Open(dbType, 1); err.Error() != wantErr.Error() {
+		t.Errorf("Open: did not receive expected error - got %v, "+
+			"want %v", err, wantErr)
+		return
+	}
+
+	// Ensure that attempting to open something other than a database
+	// copy returns the expected error.
+	wantErr = walletdb.ErrInvalid
+	if _, err := walletdb.Open(dbType, bytes.NewBufferString("foo")); err != wantErr {
+		t.Errorf("Open: did not receive expected error - got %v, "+
+			"want %v", err, wantErr)
+		return
+	}
+
+	// Ensure that a copy claiming a value longer than the remaining input
+	// returns the expected error.
+	var truncated bytes.Buffer
+	truncated.WriteString("walletdb-memdb\x01")
+	truncated.Write([]byte{1, 0, 1, 'k', 0xfe, 0xff, 0xff, 0xff, 0x07})
+	if _, err := walletdb.Open(dbType, &truncated); err != wantErr {
+		t.Errorf("Open: did not receive expected error - got %v, "+
+			"want %v", err, wantErr)
+		return
+	}
+
+	// Ensure that a copy nesting buckets deeper than supported returns
+	// the expected error instead of exhausting the stack.
+	var deep bytes.Buffer
+	deep.WriteString("walletdb-memdb\x01")
+	for i := 0; i < 100000; i++ {
+		deep.Write([]byte{1, 1, 1, 'b'})
+	}
+	deep.WriteByte(0)
+	if _, err := walletdb.Open(dbType, &deep); err != wantErr {
+		t.Errorf("Open: did not receive expected error - got %v, "+
+			"want %v", err, wantErr)
+		return
+	}
+
+	// Ensure that attempting to create a database with parameters returns
+	// the expected error.
+	wantErr = fmt.Errorf("invalid arguments to %s.Create -- expected "+
+		"no arguments", dbType)
+	if _, err := walletdb.Create(dbType, 1); err.Error() != wantErr.Error() {
+		t.Errorf("Create: did not receive expected error - got %v, "+
+			"want %v", err, wantErr)
+		return
+	}
+
+	// Ensure operations against a closed database return the expected
+	// error.
+	db, err := walletdb.Create(dbType)
+	if err != nil {
+		t.Errorf("Create: unexpected error: %v", err)
+		return
+	}
+	ns1, err := db.Namespace([]byte("ns1"))
+	if err != nil {
+		t.Errorf("Namespace: unexpected error: %v", err)
+		return
+	}
+	db.Close()
+
+	wantErr = walletdb.ErrDbNotOpen
+	if _, err := db.Namespace([]byte("ns1")); err != wantErr {
+		t.Errorf("Namespace: did not receive expected error - got %v, "+
+			"want %v", err, wantErr)
+		return
+	}
+	if _, err := ns1.Begin(false); err != wantErr {
+		t.Errorf("Begin: did not receive expected error - got %v, "+
+			"want %v", err, wantErr)
+		return
+	}
+	if err := db.Copy(&bytes.Buffer{}); err != wantErr {
+		t.Errorf("Copy: did not receive expected error - got %v, "+
+			"want %v", err, wantErr)
+		return
+	}
+}
+
+// TestCopyOpen ensures that values stored are still valid after copying the
+// database and opening the copy.
+func TestCopyOpen(t *testing.T) {
+	db, err := walletdb.Create(dbType)
+	if err != nil {
+		t.Errorf("Failed to create test database (%s) %v", dbType, err)
+		return
+	}
+	defer db.Close()
+
+	// Create a namespace with a nested bucket and put some values into
+	// both so they can be tested for existence in the copy.
+	storeValues := map[string]string{
+		"ns1key1": "foo1",
+		"ns1key2": "foo2",
+		"ns1key3": "foo3",
+	}
+	ns1Key := []byte("ns1")
+	nestedKey := []byte("nested")
+	ns1, err := db.Namespace(ns1Key)
+	if err != nil {
+		t.Errorf("Namespace: unexpected error: %v", err)
+		return
+	}
+	err = ns1.Update(func(tx walletdb.Tx) error {
+		rootBucket := tx.RootBucket()
+		nested, err := rootBucket.CreateBucket(nestedKey)
+		if err != nil {
+			return fmt.Errorf("CreateBucket: unexpected error: %v", err)
+		}
+		for k, v := range storeValues {
+			if err := rootBucket.Put([]byte(k), []byte(v)); err != nil {
+				return fmt.Errorf("Put: unexpected error: %v", err)
+			}
+			if err := nested.Put([]byte(k), []byte(v)); err != nil {
+				return fmt.Errorf("Put: unexpected error: %v", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("ns1 Update: unexpected error: %v", err)
+		return
+	}
+
+	// Copy the database and open the copy.
+	var buf bytes.Buffer
+	if err := db.Copy(&buf); err != nil {
+		t.Errorf("Copy: unexpected error: %v", err)
+		return
+	}
+	dbCopy, err := walletdb.Open(dbType, &buf)
+	if err != nil {
+		t.Errorf("Failed to open test database copy (%s) %v", dbType, err)
+		return
+	}
+	defer dbCopy.Close()
+
+	// Ensure the values previously stored still exist and are correct.
+	ns1, err = dbCopy.Namespace(ns1Key)
+	if err != nil {
+		t.Errorf("Namespace: unexpected error: %v", err)
+		return
+	}
+	err = ns1.View(func(tx walletdb.Tx) error {
+		rootBucket := tx.RootBucket()
+		nested := rootBucket.Bucket(nestedKey)
+		if nested == nil {
+			return fmt.Errorf("Bucket: nested bucket does not exist")
+		}
+		for k, v := range storeValues {
+			for _, b := range []walletdb.Bucket{rootBucket, nested} {
+				gotVal := b.Get([]byte(k))
+				if !reflect.DeepEqual(gotVal, []byte(v)) {
+					return fmt.Errorf("Get: key '%s' does not "+
+						"match expected value - got %s, want %s",
+						k, gotVal, v)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("ns1 View: unexpected error: %v", err)
+		return
+	}
+}
+
+// TestTxIsolation ensures that read-only transactions don't see changes
+// committed after they were started.
+func TestTxIsolation(t *testing.T) {
+	db, err := walletdb.Create(dbType)
+	if err != nil {
+		t.Errorf("Failed to create test database (%s) %v", dbType, err)
+		return
+	}
+	defer db.Close()
+
+	ns1, err := db.Namespace([]byte("ns1"))
+	if err != nil {
+		t.Errorf("Namespace: unexpected error: %v", err)
+		return
+	}
+	readTx, err := ns1.Begin(false)
+	if err != nil {
+		t.Errorf("Begin: unexpected error: %v", err)
+		return
+	}
+	defer readTx.Rollback()
+
+	key, value := []byte("key"), []byte("value")
+	err = ns1.Update(func(tx walletdb.Tx) error {
+		return tx.RootBucket().Put(key, value)
+	})
+	if err != nil {
+		t.Errorf("ns1 Update: unexpected error: %v", err)
+		return
+	}
+
+	if got := readTx.RootBucket().Get(key); got != nil {
+		t.Errorf("Get: read-only transaction sees value %s committed "+
+			"after it started", got)
+		return
+	}
+	err = ns1.View(func(tx walletdb.Tx) error {
+		if got := tx.RootBucket().Get(key); !bytes.Equal(got, value) {
+			return fmt.Errorf("Get: unexpected value - got %s, "+
+				"want %s", got, value)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("ns1 View: unexpected error: %v", err)
+		return
+	}
+}
+
+// TestCopyOnWrite ensures that modifications made to nested buckets by a
+// read-write transaction are seen through every handle to those buckets within
+// the transaction, but neither by read-only transactions nor by the database
+// once the transaction is rolled back.
+func TestCopyOnWrite(t *testing.T) {
+	db, err := walletdb.Create(dbType)
+	if err != nil {
+		t.Errorf("Failed to create test database (%s) %v", dbType, err)
+		return
+	}
+	defer db.Close()
+
+	ns1, err := db.Namespace([]byte("ns1"))
+	if err != nil {
+		t.Errorf("Namespace: unexpected error: %v", err)
+		return
+	}
+	outer, inner, other := []byte("outer"), []byte("inner"), []byte("other")
+	key, value := []byte("key"), []byte("value")
+	err = ns1.Update(func(tx walletdb.Tx) error {
+		outerBucket, err := tx.RootBucket().CreateBucket(outer)
+		if err != nil {
+			return err
+		}
+		innerBucket, err := outerBucket.CreateBucket(inner)
+		if err != nil {
+			return err
+		}
+		if err := innerBucket.Put(key, value); err != nil {
+			return err
+		}
+		otherBucket, err := tx.RootBucket().CreateBucket(other)
+		if err != nil {
+			return err
+		}
+		return otherBucket.Put(key, value)
+	})
+	if err != nil {
+		t.Errorf("ns1 Update: unexpected error: %v", err)
+		return
+	}
+
+	readTx, err := ns1.Begin(false)
+	if err != nil {
+		t.Errorf("Begin: unexpected error: %v", err)
+		return
+	}
+	defer readTx.Rollback()
+
+	// Write through two handles to the same nested bucket and one to a
+	// bucket deleted afterwards, then roll back.
+	writeTx, err := ns1.Begin(true)
+	if err != nil {
+		t.Errorf("Begin: unexpected error: %v", err)
+		return
+	}
+	root := writeTx.RootBucket()
+	handle1 := root.Bucket(outer).Bucket(inner)
+	handle2 := root.Bucket(outer).Bucket(inner)
+	key1, key2 := []byte("key1"), []byte("key2")
+	if err := handle1.Put(key1, value); err != nil {
+		t.Errorf("Put: unexpected error: %v", err)
+		return
+	}
+	if err := handle2.Put(key2, value); err != nil {
+		t.Errorf("Put: unexpected error: %v", err)
+		return
+	}
+	for i, handle := range []walletdb.Bucket{handle1, handle2,
+		root.Bucket(outer).Bucket(inner)} {
+
+		for _, k := range [][]byte{key1, key2} {
+			if got := handle.Get(k); !bytes.Equal(got, value) {
+				t.Errorf("Get #%d: unexpected value for %s - "+
+					"got %s, want %s", i, k, got, value)
+			}
+		}
+	}
+	otherBucket := root.Bucket(other)
+	if err := root.DeleteBucket(other); err != nil {
+		t.Errorf("DeleteBucket: unexpected error: %v", err)
+		return
+	}
+	if err := otherBucket.Put(key1, value); err != nil {
+		t.Errorf("Put: unexpected error: %v", err)
+		return
+	}
+	if root.Bucket(other) != nil {
+		t.Errorf("Bucket: deleted bucket was recreated by a write " +
+			"through an existing handle")
+	}
+	if err := writeTx.Rollback(); err != nil {
+		t.Errorf("Rollback: unexpected error: %v", err)
+		return
+	}
+
+	// Neither the read-only transaction started before nor a new one may
+	// see any of the changes.
+	checkUnchanged := func(tx walletdb.Tx) error {
+		innerBucket := tx.RootBucket().Bucket(outer).Bucket(inner)
+		otherBucket := tx.RootBucket().Bucket(other)
+		if otherBucket == nil {
+			return fmt.Errorf("Bucket: rolled back deletion of %s "+
+				"was kept", other)
+		}
+		for _, bucket := range []walletdb.Bucket{innerBucket, otherBucket} {
+			if got := bucket.Get(key); !bytes.Equal(got, value) {
+				return fmt.Errorf("Get: unexpected value - "+
+					"got %s, want %s", got, value)
+			}
+			for _, k := range [][]byte{key1, key2} {
+				if got := bucket.Get(k); got != nil {
+					return fmt.Errorf("Get: rolled back "+
+						"value for %s is visible", k)
+				}
+			}
+		}
+		return nil
+	}
+	if err := checkUnchanged(readTx); err != nil {
+		t.Errorf("read-only transaction: %v", err)
+		return
+	}
+	if err := ns1.View(checkUnchanged); err != nil {
+		t.Errorf("ns1 View: %v", err)
+		return
+	}
+}
+
+// TestInterface performs all interfaces tests for this database driver.
+func TestInterface(t *testing.T) {
+	// Create a new database to run tests against.
+	db, err := walletdb.Create(dbType)
+	if err != nil {
+		t.Errorf("Failed to create test database (%s) %v", dbType, err)
+		return
+	}
+	defer db.Close()
+
+	// Run all of the interface tests against the database.
+	testInterface(t, db)
+}
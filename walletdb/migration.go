@@ -0,0 +1,229 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package walletdb
+
+import (
+	"encoding/binary"
+	"os"
+)
+
+// schemaVersionKey is the key, in the root bucket of a namespace, of the
+// version record kept for schemas which don't provide their own FetchVersion
+// and PutVersion functions.
+var schemaVersionKey = []byte("schemaversion")
+
+// Migration describes an upgrade of the data stored in a namespace to a new
+// version.
+type Migration struct {
+	// Version is the version of the data once the migration is applied.
+	Version uint32
+
+	// Description briefly describes the changes made by the migration.
+	Description string
+
+	// Migrate performs the migration through the passed read-write
+	// transaction of the namespace.  It must not commit or roll back the
+	// transaction, nor update the version record.
+	Migrate func(tx Tx) error
+}
+
+// Schema describes how to bring the data stored in a namespace up to date.
+// The version of the data is kept in a record of the namespace which is
+// updated in the same transaction as the migrations, so a failed migration
+// never leaves the namespace at an inconsistent version.
+type Schema struct {
+	// Migrations holds every migration of the schema, ordered by strictly
+	// increasing version.  The latest version of the schema is the one of
+	// its last migration.
+	Migrations []Migration
+
+	// FetchVersion and PutVersion read and write the version record of
+	// the namespace.  They allow namespaces which kept their version
+	// before the introduction of schemas to keep using the same record.
+	// When nil, the version is stored in the root bucket of the namespace
+	// and data without a version record is at version 0.
+	FetchVersion func(tx Tx) (uint32, error)
+	PutVersion   func(tx Tx, version uint32) error
+}
+
+// MigrateOptions controls how the migrations of a schema are applied.
+type MigrateOptions struct {
+	// DryRun causes the pending migrations to be applied in a transaction
+	// which is rolled back instead of being committed.  This makes it
+	// possible to check they succeed without changing the namespace.
+	DryRun bool
+
+	// Backup, when not nil, is called before any migration is applied to
+	// the namespace, and the migrations are aborted if it fails.  It is
+	// not called during dry runs or when there are no pending migrations.
+	// BackupFile returns a function suitable for it.
+	Backup func() error
+}
+
+// LatestVersion returns the latest version of the schema.
+func (s *Schema) LatestVersion() uint32 {
+	if len(s.Migrations) == 0 {
+		return 0
+	}
+	return s.Migrations[len(s.Migrations)-1].Version
+}
+
+// fetchVersion returns the version of the data stored in the namespace of the
+// passed transaction.
+func (s *Schema) fetchVersion(tx Tx) (uint32, error) {
+	if s.FetchVersion != nil {
+		return s.FetchVersion(tx)
+	}
+	verBytes := tx.RootBucket().Get(schemaVersionKey)
+	if verBytes == nil {
+		return 0, nil
+	}
+	if len(verBytes) != 4 {
+		return 0, ErrInvalid
+	}
+	return binary.LittleEndian.Uint32(verBytes), nil
+}
+
+// putVersion stores the version of the data stored in the namespace of the
+// passed transaction.
+func (s *Schema) putVersion(tx Tx, version uint32) error {
+	if s.PutVersion != nil {
+		return s.PutVersion(tx, version)
+	}
+	var verBytes [4]byte
+	binary.LittleEndian.PutUint32(verBytes[:], version)
+	return tx.RootBucket().Put(schemaVersionKey, verBytes[:])
+}
+
+// pending returns the migrations needed to bring data at the passed version up
+// to the latest version of the schema.
+func (s *Schema) pending(version uint32) ([]Migration, error) {
+	for i := 1; i < len(s.Migrations); i++ {
+		if s.Migrations[i].Version <= s.Migrations[i-1].Version {
+			return nil, ErrMigrationOrder
+		}
+	}
+	if version > s.LatestVersion() {
+		return nil, ErrNewerVersion
+	}
+	for i, m := range s.Migrations {
+		if m.Version > version {
+			return s.Migrations[i:], nil
+		}
+	}
+	return nil, nil
+}
+
+// Version returns the version of the data stored in the namespace.
+func (s *Schema) Version(namespace Namespace) (uint32, error) {
+	var version uint32
+	err := namespace.View(func(tx Tx) error {
+		var err error
+		version, err = s.fetchVersion(tx)
+		return err
+	})
+	return version, err
+}
+
+// PutLatestVersion records the data stored in the namespace of the passed
+// transaction as being at the latest version of the schema.  It is meant to be
+// called in the transaction which initializes a new namespace, so its data is
+// not needlessly migrated afterwards.
+func (s *Schema) PutLatestVersion(tx Tx) error {
+	return s.putVersion(tx, s.LatestVersion())
+}
+
+// Migrate applies, in order, every migration needed to bring the data stored in
+// the namespace up to the latest version of the schema and returns them.  All
+// of the migrations are applied in a single read-write transaction along with
+// the update of the version record, so either all of them are applied or none
+// is.  When opts is nil, the migrations are applied without a backup.
+//
+// ErrNewerVersion is returned when the data is at a version newer than the
+// latest one of the schema, and any error returned by a migration is returned
+// as is.
+func (s *Schema) Migrate(namespace Namespace, opts *MigrateOptions) ([]Migration, error) {
+	if opts == nil {
+		opts = &MigrateOptions{}
+	}
+
+	// Find out whether anything needs to be done before taking a backup
+	// and starting a read-write transaction.
+	version, err := s.Version(namespace)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := s.pending(version)
+	if err != nil || len(pending) == 0 {
+		return nil, err
+	}
+	if opts.Backup != nil && !opts.DryRun {
+		if err := opts.Backup(); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := namespace.Begin(true)
+	if err != nil {
+		return nil, err
+	}
+
+	// The namespace may have been migrated since its version was fetched,
+	// so look for the pending migrations again within the transaction.
+	version, err = s.fetchVersion(tx)
+	if err == nil {
+		pending, err = s.pending(version)
+	}
+	for i := 0; err == nil && i < len(pending); i++ {
+		err = pending[i].Migrate(tx)
+		if err == nil {
+			err = s.putVersion(tx, pending[i].Version)
+		}
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	if opts.DryRun {
+		err = tx.Rollback()
+	} else {
+		err = tx.Commit()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+// BackupFile returns a function, suitable for MigrateOptions.Backup, which
+// writes a copy of the database to a new file at the passed path using the
+// Copy function of the database.  The function fails if the file already
+// exists.
+func BackupFile(db DB, path string) func() error {
+	return func() error {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		if err := db.Copy(f); err != nil {
+			f.Close()
+			os.Remove(path)
+			return err
+		}
+		return f.Close()
+	}
+}
@@ -0,0 +1,176 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package walletdb_test
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/monetas/btcwallet/walletdb"
+	_ "github.com/monetas/btcwallet/walletdb/bdb"
+)
+
+// putMigration returns a migration to the passed version which stores a value
+// under the passed key.
+func putMigration(version uint32, key string) walletdb.Migration {
+	return walletdb.Migration{
+		Version:     version,
+		Description: "put " + key,
+		Migrate: func(tx walletdb.Tx) error {
+			return tx.RootBucket().Put([]byte(key), []byte("value"))
+		},
+	}
+}
+
+// checkKeys ensures the root bucket of the namespace holds exactly the wanted
+// keys among the passed ones.
+func checkKeys(t *testing.T, ns walletdb.Namespace, keys []string, want map[string]bool) {
+	err := ns.View(func(tx walletdb.Tx) error {
+		for _, k := range keys {
+			got := tx.RootBucket().Get([]byte(k)) != nil
+			if got != want[k] {
+				t.Errorf("key %s: exists %v, want %v", k, got, want[k])
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View: unexpected error: %v", err)
+	}
+}
+
+// checkVersion ensures the namespace is at the wanted version of the schema.
+func checkVersion(t *testing.T, schema *walletdb.Schema, ns walletdb.Namespace, want uint32) {
+	version, err := schema.Version(ns)
+	if err != nil {
+		t.Fatalf("Version: unexpected error: %v", err)
+	}
+	if version != want {
+		t.Fatalf("Version: got %d, want %d", version, want)
+	}
+}
+
+// TestSchemaMigrate ensures migrations are applied in order, only once, and
+// that dry runs, backups and failed migrations are handled properly.
+func TestSchemaMigrate(t *testing.T) {
+	dbPath, backupPath := "migratetest.db", "migratetest.db.bak"
+	defer os.Remove(dbPath)
+	defer os.Remove(backupPath)
+	db, err := walletdb.Create("bdb", dbPath)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+	ns, err := db.Namespace([]byte("ns"))
+	if err != nil {
+		t.Fatalf("Namespace: unexpected error: %v", err)
+	}
+
+	keys := []string{"v1", "v2", "v3"}
+	schema := &walletdb.Schema{
+		Migrations: []walletdb.Migration{
+			putMigration(1, "v1"),
+			putMigration(2, "v2"),
+		},
+	}
+	if v := schema.LatestVersion(); v != 2 {
+		t.Fatalf("LatestVersion: got %d, want 2", v)
+	}
+	checkVersion(t, schema, ns, 0)
+
+	// A dry run must report the pending migrations without applying them
+	// or taking a backup.
+	backups := 0
+	opts := &walletdb.MigrateOptions{
+		DryRun: true,
+		Backup: func() error {
+			backups++
+			return walletdb.BackupFile(db, backupPath)()
+		},
+	}
+	applied, err := schema.Migrate(ns, opts)
+	if err != nil {
+		t.Fatalf("Migrate: unexpected error: %v", err)
+	}
+	if len(applied) != 2 {
+		t.Fatalf("Migrate: got %d migrations, want 2", len(applied))
+	}
+	if backups != 0 {
+		t.Fatalf("Migrate: backup taken during dry run")
+	}
+	checkVersion(t, schema, ns, 0)
+	checkKeys(t, ns, keys, nil)
+
+	// Applying the migrations must take a backup first.
+	opts.DryRun = false
+	applied, err = schema.Migrate(ns, opts)
+	if err != nil {
+		t.Fatalf("Migrate: unexpected error: %v", err)
+	}
+	if len(applied) != 2 || applied[0].Version != 1 || applied[1].Version != 2 {
+		t.Fatalf("Migrate: unexpected migrations %v", applied)
+	}
+	if _, err := os.Stat(backupPath); backups != 1 || err != nil {
+		t.Fatalf("Migrate: backup not taken (%d backups, stat: %v)",
+			backups, err)
+	}
+	checkVersion(t, schema, ns, 2)
+	checkKeys(t, ns, keys, map[string]bool{"v1": true, "v2": true})
+
+	// Nothing is left to do once the namespace is up to date.
+	applied, err = schema.Migrate(ns, opts)
+	if err != nil || len(applied) != 0 || backups != 1 {
+		t.Fatalf("Migrate: unexpected result - migrations %v, "+
+			"backups %d, err %v", applied, backups, err)
+	}
+
+	// A failed migration must leave the namespace untouched.
+	opts.Backup = nil
+	migrateErr := errors.New("migration failed")
+	schema.Migrations = append(schema.Migrations, walletdb.Migration{
+		Version: 3,
+		Migrate: func(tx walletdb.Tx) error {
+			if err := tx.RootBucket().Put([]byte("v3"), []byte("value")); err != nil {
+				return err
+			}
+			return migrateErr
+		},
+	})
+	if _, err := schema.Migrate(ns, opts); err != migrateErr {
+		t.Fatalf("Migrate: got error %v, want %v", err, migrateErr)
+	}
+	checkVersion(t, schema, ns, 2)
+	checkKeys(t, ns, keys, map[string]bool{"v1": true, "v2": true})
+
+	// Data newer than the schema must be rejected.
+	schema.Migrations = schema.Migrations[:1]
+	if _, err := schema.Migrate(ns, nil); err != walletdb.ErrNewerVersion {
+		t.Fatalf("Migrate: got error %v, want %v", err,
+			walletdb.ErrNewerVersion)
+	}
+
+	// Migrations must be ordered by version.
+	schema.Migrations = []walletdb.Migration{
+		putMigration(4, "v4"),
+		putMigration(3, "v3"),
+	}
+	if _, err := schema.Migrate(ns, nil); err != walletdb.ErrMigrationOrder {
+		t.Fatalf("Migrate: got error %v, want %v", err,
+			walletdb.ErrMigrationOrder)
+	}
+}
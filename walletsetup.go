@@ -20,10 +20,12 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/btcsuite/btcd/btcec"
 	"github.com/btcsuite/btcd/chaincfg"
@@ -31,17 +33,50 @@ import (
 	"github.com/btcsuite/btcutil"
 	"github.com/btcsuite/btcutil/hdkeychain"
 	"github.com/monetas/btcwallet/legacy/keystore"
+	"github.com/monetas/btcwallet/rename"
 	"github.com/monetas/btcwallet/txstore"
+	"github.com/monetas/btcwallet/votingpool"
 	"github.com/monetas/btcwallet/waddrmgr"
 	"github.com/monetas/btcwallet/wallet"
 	"github.com/monetas/btcwallet/walletdb"
-	_ "github.com/monetas/btcwallet/walletdb/bdb"
+	"github.com/monetas/btcwallet/walletdb/bdb"
+	"github.com/monetas/btcwallet/walletdb/encdb"
 	"github.com/btcsuite/golangcrypto/ssh/terminal"
 )
 
 var (
 	// waddrmgrNamespaceKey is the namespace key for the waddrmgr package.
 	waddrmgrNamespaceKey = []byte("waddrmgr")
+
+	// votingPoolNamespaceKey is the namespace key for the votingpool
+	// package.
+	votingPoolNamespaceKey = []byte("votingpool")
+
+	// walletNamespaceKey is the namespace key for the wallet package.
+	walletNamespaceKey = []byte("wallet")
+
+	// walletDbNamespaceKeys holds the keys of every namespace of the
+	// wallet database.  These are the namespaces copied when migrating
+	// the database.
+	walletDbNamespaceKeys = [][]byte{
+		waddrmgrNamespaceKey,
+		walletNamespaceKey,
+		votingPoolNamespaceKey,
+		webhookNamespaceKey,
+	}
+
+	// errDbEncryptionPass describes the error returned when database
+	// encryption is requested without a public passphrase to derive the
+	// encryption keys from.
+	errDbEncryptionPass = errors.New("Encrypting the wallet database " +
+		"requires a public passphrase other than the default one.")
+
+	// errDbEncryptionUpgrades describes the error returned when database
+	// encryption is requested for a wallet database with pending upgrades,
+	// which must be applied first so their backup is not left unencrypted.
+	errDbEncryptionUpgrades = errors.New("The wallet database must be " +
+		"upgraded before it is encrypted.  Open the wallet once to " +
+		"upgrade it, and remove the backup written before the upgrade.")
 )
 
 // networkDir returns the directory name of a network directory to hold wallet
@@ -456,6 +491,9 @@ func createWallet(cfg *config) error {
 	if err != nil {
 		return err
 	}
+	if cfg.EncryptDB && string(pubPass) == defaultPubPassphrase {
+		return errDbEncryptionPass
+	}
 
 	// Ascertain the wallet generation seed.  This will either be an
 	// automatically generated value the user has already confirmed or a
@@ -469,8 +507,13 @@ func createWallet(cfg *config) error {
 	dbPath := filepath.Join(netDir, walletDbName)
 	fmt.Println("Creating the wallet...")
 
-	// Create the wallet database backed by bolt db.
-	db, err := walletdb.Create("bdb", dbPath)
+	// Create the wallet database backed by bolt db, encrypted with the
+	// public passphrase when requested.
+	var encryptPass []byte
+	if cfg.EncryptDB {
+		encryptPass = pubPass
+	}
+	db, err := createDb(dbPath, encryptPass)
 	if err != nil {
 		return err
 	}
@@ -533,7 +576,10 @@ func createSimulationWallet(cfg *config) error {
 	dbPath := filepath.Join(netDir, walletDbName)
 	fmt.Println("Creating the wallet...")
 
-	// Create the wallet database backed by bolt db.
+	// Create the wallet database backed by bolt db.  Despite being
+	// temporary, the simulation wallet can't be kept in a memdb database:
+	// it is opened again from dbPath once created, both by this process
+	// and by later runs with --createtemp which load the existing wallet.
 	db, err := walletdb.Create("bdb", dbPath)
 	if err != nil {
 		return err
@@ -558,9 +604,29 @@ func createSimulationWallet(cfg *config) error {
 	return nil
 }
 
+// createDb creates a new wallet database backed by bolt db at dbPath.  When
+// encryptPass is not nil, all of the contents of the database are encrypted
+// with keys derived from it.
+func createDb(dbPath string, encryptPass []byte) (walletdb.DB, error) {
+	db, err := walletdb.Create("bdb", dbPath)
+	if err != nil {
+		return nil, err
+	}
+	if encryptPass == nil {
+		return db, nil
+	}
+
+	encDb, err := walletdb.Create("encdb", db, encryptPass)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return encDb, nil
+}
+
 // openDb opens and returns a *walletdb.DB (boltdb here) given the
-// directory and dbname
-func openDb(directory string, dbname string) (*walletdb.DB, error) {
+// directory and dbname.  Encrypted databases are decrypted with pubPass.
+func openDb(directory string, dbname string, pubPass string, readOnly bool) (*walletdb.DB, error) {
 	dbPath := filepath.Join(directory, dbname)
 
 	// Ensure that the network directory exists.
@@ -569,18 +635,417 @@ func openDb(directory string, dbname string) (*walletdb.DB, error) {
 	}
 
 	// Open the database using the boltdb backend.
-	db, err := walletdb.Open("bdb", dbPath)
+	db, err := walletdb.Open("bdb", dbPath, readOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	// Wrap the database when it was created encrypted, or has since been
+	// migrated to an encrypted one.
+	encrypted, err := encdb.IsEncrypted(db)
+	if err == nil && encrypted {
+		var encDb walletdb.DB
+		encDb, err = walletdb.Open("encdb", db, []byte(pubPass))
+		if err == nil {
+			db = encDb
+		}
+	}
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
 	return &db, nil
 }
 
+// encryptWalletDb migrates the existing unencrypted wallet database to an
+// encrypted one whose keys are derived from the configured public passphrase.
+// The namespaces of the wallet are copied into a new database which then
+// atomically replaces the original.
+func encryptWalletDb(cfg *config) error {
+	if cfg.WalletPass == defaultPubPassphrase {
+		return errDbEncryptionPass
+	}
+
+	netDir := networkDir(cfg.DataDir, activeNet.Params)
+	dbPath := filepath.Join(netDir, walletDbName)
+	db, err := walletdb.Open("bdb", dbPath)
+	if err != nil {
+		return err
+	}
+	encrypted, err := encdb.IsEncrypted(db)
+	if err == nil && encrypted {
+		err = errors.New("The wallet database is already encrypted.")
+	}
+	if err != nil {
+		db.Close()
+		return err
+	}
+
+	// Make sure the configured public passphrase is the one of the address
+	// manager, as the same passphrase is used to open both.  Upgrades are
+	// not applied, as they would leave behind an unencrypted backup of the
+	// database.
+	dryRun := walletDbMigrateOptions(db, netDir, true)
+	mgr, err := openWaddrmgr(&db, waddrmgrNamespaceKey, cfg.WalletPass,
+		activeNet.Params, dryRun)
+	if merr, ok := err.(waddrmgr.ManagerError); ok &&
+		merr.ErrorCode == waddrmgr.ErrUpgrade {
+		err = errDbEncryptionUpgrades
+	}
+	if err != nil {
+		db.Close()
+		return err
+	}
+	mgr.Close()
+	vpExists, err := db.NamespaceExists(votingPoolNamespaceKey)
+	if err == nil && vpExists {
+		var vpNamespace walletdb.Namespace
+		vpNamespace, err = db.Namespace(votingPoolNamespaceKey)
+		if err == nil {
+			var pending []walletdb.Migration
+			pending, err = votingpool.Upgrade(vpNamespace, dryRun)
+			if err == nil && len(pending) != 0 {
+				err = errDbEncryptionUpgrades
+			}
+		}
+	}
+	if err != nil {
+		db.Close()
+		return err
+	}
+
+	// Copy every namespace into a new encrypted database next to the
+	// existing one, removing any leftover of a previous failed attempt.
+	fmt.Println("Encrypting the wallet database...")
+	tmpPath := dbPath + ".tmp"
+	if err := os.RemoveAll(tmpPath); err != nil {
+		db.Close()
+		return err
+	}
+	encDb, err := createDb(tmpPath, []byte(cfg.WalletPass))
+	if err != nil {
+		db.Close()
+		return err
+	}
+	for _, key := range walletDbNamespaceKeys {
+		err = walletdb.CopyNamespace(encDb, db, key)
+		if err != nil {
+			break
+		}
+	}
+	encDb.Close()
+	db.Close()
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	if err := rename.Atomic(tmpPath, dbPath); err != nil {
+		return err
+	}
+
+	// Encrypt the transaction store with the keys of the new database.
+	// Should this fail, it is encrypted the next time the wallet is
+	// opened.
+	encDbPtr, err := openDb(netDir, walletDbName, cfg.WalletPass, false)
+	if err != nil {
+		return err
+	}
+	defer (*encDbPtr).Close()
+	txs, err := txstore.OpenDir(netDir, wallet.TxStoreCipher(*encDbPtr))
+	if err == nil {
+		err = txs.WriteIfDirty()
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	fmt.Println("The wallet database has been encrypted successfully.")
+	return nil
+}
+
+// restoreWalletBackup replaces the wallet database and transaction store of
+// the active network with the contents of the configured backup archive.
+func restoreWalletBackup(cfg *config) error {
+	netDir := networkDir(cfg.DataDir, activeNet.Params)
+	archivePath := cleanAndExpandPath(cfg.RestoreBackup)
+	err := wallet.RestoreBackup(archivePath, filepath.Join(netDir,
+		walletDbName), []byte(cfg.WalletPass), activeNet.Params)
+	if err != nil {
+		return err
+	}
+	fmt.Println("The wallet backup has been restored successfully.")
+	return nil
+}
+
+// walletDbMigrateOptions returns the options used to upgrade the namespaces of
+// the wallet database in the network directory.  A copy of the database is
+// written next to it before the first namespace is upgraded.
+func walletDbMigrateOptions(db walletdb.DB, netDir string, dryRun bool) *walletdb.MigrateOptions {
+	backupPath := filepath.Join(netDir, fmt.Sprintf("%s.%d.bak",
+		walletDbName, time.Now().Unix()))
+	backup := walletdb.BackupFile(db, backupPath)
+	backedUp := false
+	return &walletdb.MigrateOptions{
+		DryRun: dryRun,
+		Backup: func() error {
+			if backedUp {
+				return nil
+			}
+			if err := backup(); err != nil {
+				return err
+			}
+			backedUp = true
+			log.Infof("Wrote a backup of the wallet database to %s "+
+				"before upgrading it", backupPath)
+			return nil
+		},
+	}
+}
+
+// checkWalletDbUpgrades applies the pending upgrades of the wallet database
+// namespaces without committing them, to check that they succeed, and reports
+// them.
+func checkWalletDbUpgrades(cfg *config) error {
+	netDir := networkDir(cfg.DataDir, activeNet.Params)
+	db, err := openDb(netDir, walletDbName, cfg.WalletPass, false)
+	if err != nil {
+		return err
+	}
+	defer (*db).Close()
+	opts := walletDbMigrateOptions(*db, netDir, true)
+
+	// The address manager can't be loaded at an old version, so a dry run
+	// with pending upgrades is reported as an upgrade error.
+	mgr, err := openWaddrmgr(db, waddrmgrNamespaceKey, cfg.WalletPass,
+		activeNet.Params, opts)
+	if err == nil {
+		mgr.Close()
+		fmt.Println("The address manager is up to date.")
+	} else if merr, ok := err.(waddrmgr.ManagerError); ok &&
+		merr.ErrorCode == waddrmgr.ErrUpgrade {
+		fmt.Println("Address manager:", err)
+	} else {
+		return err
+	}
+
+	// Opening a missing namespace would create it, so the dry run would
+	// modify the database.
+	exists, err := (*db).NamespaceExists(votingPoolNamespaceKey)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		fmt.Println("There is no voting pool namespace to upgrade.")
+		return nil
+	}
+	namespace, err := (*db).Namespace(votingPoolNamespaceKey)
+	if err != nil {
+		return err
+	}
+	pending, err := votingpool.Upgrade(namespace, opts)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		fmt.Println("The voting pool namespace is up to date.")
+	}
+	for _, m := range pending {
+		fmt.Printf("Voting pool: upgrade to version %d (%s) succeeded\n",
+			m.Version, m.Description)
+	}
+	return nil
+}
+
+// maintainWalletDb checks the wallet database file, the address manager and
+// the transaction store of the active network and prints a report of the
+// problems found.  With the repair option, the repairable problems of the
+// address manager are fixed after backing up the database, and an
+// inconsistent transaction store is moved aside so it is rebuilt by a rescan
+// on the next start.  With the compact option, the database file is compacted
+// afterwards.  An error is returned if problems remain.
+func maintainWalletDb(cfg *config) error {
+	netDir := networkDir(cfg.DataDir, activeNet.Params)
+	dbPath := filepath.Join(netDir, walletDbName)
+	check := cfg.CheckDB || cfg.RepairDB
+	var remaining int
+
+	if check {
+		fmt.Println("Checking wallet database file", dbPath)
+		problems, err := bdb.Check(dbPath)
+		if err != nil {
+			return err
+		}
+		for _, p := range problems {
+			fmt.Println("  Not repairable:", p)
+		}
+		if len(problems) != 0 {
+			// The contents of a damaged file can't be trusted to
+			// check or repair them, nor to compact them.
+			return fmt.Errorf("the wallet database file is "+
+				"damaged (%d problems) and must be restored "+
+				"from a backup", len(problems))
+		}
+		fmt.Println("  OK")
+
+		n, err := maintainWaddrmgr(cfg, netDir)
+		if err != nil {
+			return err
+		}
+		remaining += n
+
+		n, err = maintainTxStore(cfg, netDir)
+		if err != nil {
+			return err
+		}
+		remaining += n
+	}
+
+	if cfg.CompactDB {
+		fmt.Println("Compacting wallet database file", dbPath)
+		fi, err := os.Stat(dbPath)
+		if err != nil {
+			return err
+		}
+		tmpPath := fmt.Sprintf("%s.%d.compact", dbPath, time.Now().Unix())
+		if err := bdb.Compact(dbPath, tmpPath); err != nil {
+			return err
+		}
+		compactedFi, err := os.Stat(tmpPath)
+		if err == nil {
+			err = rename.Atomic(tmpPath, dbPath)
+		}
+		if err != nil {
+			_ = os.Remove(tmpPath)
+			return err
+		}
+		fmt.Printf("  Compacted from %d to %d bytes\n", fi.Size(),
+			compactedFi.Size())
+	}
+
+	if remaining != 0 {
+		return fmt.Errorf("%d problems remain", remaining)
+	}
+	return nil
+}
+
+// maintainWaddrmgr checks the address manager in the wallet database of the
+// network directory, repairing it if requested, and reports the problems
+// found.  The number of problems which remain is returned.
+func maintainWaddrmgr(cfg *config, netDir string) (int, error) {
+	fmt.Println("Checking address manager")
+	db, err := openDb(netDir, walletDbName, cfg.WalletPass, !cfg.RepairDB)
+	if err != nil {
+		return 0, err
+	}
+	defer (*db).Close()
+	namespace, err := (*db).Namespace(waddrmgrNamespaceKey)
+	if err != nil {
+		return 0, err
+	}
+
+	problems, err := waddrmgr.CheckIntegrity(namespace, false)
+	if err != nil {
+		return 0, err
+	}
+	repairable := 0
+	for _, p := range problems {
+		if p.Repairable {
+			repairable++
+		}
+	}
+	repair := cfg.RepairDB && repairable != 0
+	if repair {
+		// Back up the database before writing to it, as the migration
+		// of namespaces does.
+		backupPath := filepath.Join(netDir, fmt.Sprintf("%s.%d.bak",
+			walletDbName, time.Now().Unix()))
+		if err := walletdb.BackupFile(*db, backupPath)(); err != nil {
+			return 0, err
+		}
+		fmt.Println("  Wrote a backup of the wallet database to",
+			backupPath)
+		problems, err = waddrmgr.CheckIntegrity(namespace, true)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	remaining := 0
+	for _, p := range problems {
+		switch {
+		case p.Repairable && repair:
+			fmt.Println("  Repaired:", p.Description)
+		case p.Repairable:
+			fmt.Println("  Repairable with --repairdb:", p.Description)
+			remaining++
+		default:
+			fmt.Println("  Not repairable:", p.Description)
+			remaining++
+		}
+	}
+	if len(problems) == 0 {
+		fmt.Println("  OK")
+	}
+	return remaining, nil
+}
+
+// maintainTxStore checks the transaction store in the network directory and
+// reports the problems found.  As an inconsistent store can only be rebuilt
+// by a rescan, repairing it moves the file aside so the wallet creates a new
+// store and rescans on the next start.  The transaction store of an encrypted
+// wallet database is decrypted with the keys of the database.  The number of
+// problems which remain is returned.
+func maintainTxStore(cfg *config, netDir string) (int, error) {
+	repair := cfg.RepairDB
+	txsPath := filepath.Join(netDir, txstore.Filename)
+	fmt.Println("Checking transaction store", txsPath)
+	db, err := openDb(netDir, walletDbName, cfg.WalletPass, true)
+	if err != nil {
+		return 0, err
+	}
+	txs, err := txstore.OpenDir(netDir, wallet.TxStoreCipher(*db))
+	(*db).Close()
+	if os.IsNotExist(err) {
+		fmt.Println("  Missing, it will be rebuilt by a rescan on the " +
+			"next start")
+		return 0, nil
+	}
+
+	var problems []string
+	if err != nil {
+		problems = []string{fmt.Sprintf("unreadable: %v", err)}
+	} else {
+		problems = txs.CheckIntegrity()
+	}
+	if len(problems) == 0 {
+		fmt.Println("  OK")
+		return 0, nil
+	}
+
+	status := "Repairable with --repairdb by a rescan:"
+	if repair {
+		status = "Repaired by a rescan on the next start:"
+	}
+	for _, p := range problems {
+		fmt.Println(" ", status, p)
+	}
+	if !repair {
+		return len(problems), nil
+	}
+
+	movedPath := fmt.Sprintf("%s.%d.corrupt", txsPath, time.Now().Unix())
+	if err := os.Rename(txsPath, movedPath); err != nil {
+		return 0, err
+	}
+	fmt.Println("  Moved the transaction store to", movedPath)
+	return 0, nil
+}
+
 // openWaddrmgr returns an address manager given a database, namespace,
-// public pass and the chain params
+// public pass, the chain params and the options used to upgrade it.
 // It prompts for seed and private passphrase required in case of upgrades
 func openWaddrmgr(db *walletdb.DB, namespaceKey []byte, pass string,
-	chainParams *chaincfg.Params) (*waddrmgr.Manager, error) {
+	chainParams *chaincfg.Params, migrate *walletdb.MigrateOptions) (*waddrmgr.Manager, error) {
 
 	// Get the namespace for the address manager.
 	namespace, err := (*db).Namespace(namespaceKey)
@@ -591,6 +1056,7 @@ func openWaddrmgr(db *walletdb.DB, namespaceKey []byte, pass string,
 	config := &waddrmgr.Options{
 		ObtainSeed:        promptSeed,
 		ObtainPrivatePass: promptPrivPassPhrase,
+		Migration:         migrate,
 	}
 	// Open address manager and transaction store.
 	//	var txs *txstore.Store
@@ -604,31 +1070,59 @@ func openWaddrmgr(db *walletdb.DB, namespaceKey []byte, pass string,
 func openWallet() (*wallet.Wallet, error) {
 	netdir := networkDir(cfg.DataDir, activeNet.Params)
 
-	db, err := openDb(netdir, walletDbName)
+	db, err := openDb(netdir, walletDbName, cfg.WalletPass, cfg.ReadOnly)
 	if err != nil {
 		log.Errorf("%v", err)
 		return nil, err
 	}
 
+	// Upgrade the voting pool namespace along with the address manager,
+	// sharing a single backup of the database.  A read-only wallet can't
+	// be upgraded, so it must already be at the latest versions.  Wallets
+	// without voting pools have no namespace to upgrade, and none is
+	// created for them.
+	var migrate *walletdb.MigrateOptions
+	if !cfg.ReadOnly {
+		migrate = walletDbMigrateOptions(*db, netdir, false)
+		vpExists, err := (*db).NamespaceExists(votingPoolNamespaceKey)
+		if err == nil && vpExists {
+			var vpNamespace walletdb.Namespace
+			vpNamespace, err = (*db).Namespace(votingPoolNamespaceKey)
+			if err == nil {
+				_, err = votingpool.Upgrade(vpNamespace, migrate)
+			}
+		}
+		if err != nil {
+			log.Errorf("%v", err)
+			return nil, err
+		}
+	}
+
 	var txs *txstore.Store
 	mgr, err := openWaddrmgr(db, waddrmgrNamespaceKey, cfg.WalletPass,
-		activeNet.Params)
+		activeNet.Params, migrate)
 	if err == nil {
-		txs, err = txstore.OpenDir(netdir)
+		txs, err = txstore.OpenDir(netdir, wallet.TxStoreCipher(*db))
+	}
+	if err == nil && !cfg.ReadOnly {
+		// Encrypt the transaction store right away if it was written
+		// before the database was encrypted.
+		err = txs.WriteIfDirty()
 	}
 	if err != nil {
 		// Special case: if the address manager was successfully read
 		// (mgr != nil) but the transaction store was not, create a
 		// new txstore and write it out to disk.  Write an unsynced
 		// manager back to disk so on future opens, the empty txstore
-		// is not considered fully synced.
-		if mgr == nil {
+		// is not considered fully synced.  This is not possible for a
+		// read-only wallet.
+		if mgr == nil || cfg.ReadOnly {
 			log.Errorf("%v", err)
 			return nil, err
 		}
 
 		txs = txstore.New(netdir)
-		txs.MarkDirty()
+		txs.SetCipher(wallet.TxStoreCipher(*db))
 		err = txs.WriteIfDirty()
 		if err != nil {
 			log.Errorf("%v", err)
@@ -642,9 +1136,17 @@ func openWallet() (*wallet.Wallet, error) {
 		TxStore:     txs,
 		Waddrmgr:    mgr,
 		ChainParams: activeNet.Params,
+		ReadOnly:    cfg.ReadOnly,
+	}
+	w, err := wallet.Open(walletConfig)
+	if err != nil {
+		log.Errorf("%v", err)
+		return nil, err
 	}
 	log.Infof("Opened wallet files") // TODO: log balance? last sync height?
-	w := wallet.Open(walletConfig)
+	if cfg.ReadOnly {
+		log.Infof("The wallet is read-only and will not be synced")
+	}
 
 	return w, nil
 }
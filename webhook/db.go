@@ -0,0 +1,201 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package webhook
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/monetas/btcwallet/walletdb"
+)
+
+var (
+	// queueBucketName is the bucket holding all deliveries which have not
+	// yet succeeded, in one nested bucket per URL named after the URL.
+	// Keys of the nested buckets are the 8 byte big-endian event IDs, so
+	// the deliveries to every URL iterate in the order events were sent.
+	queueBucketName = []byte("queue")
+
+	// lastEventIDName is the key (root bucket) of the most recently
+	// assigned event ID.
+	lastEventIDName = []byte("lasteventid")
+)
+
+// errMalformedDelivery describes a queued delivery that could not be
+// deserialized.
+var errMalformedDelivery = errors.New("malformed webhook delivery")
+
+// delivery is a single event queued for delivery to a single URL.
+type delivery struct {
+	key         []byte
+	attempts    uint32
+	nextAttempt time.Time
+	url         string
+	payload     []byte
+}
+
+// createQueue creates the buckets used by the dispatcher if they do not
+// already exist.
+func createQueue(namespace walletdb.Namespace) error {
+	return namespace.Update(func(tx walletdb.Tx) error {
+		_, err := tx.RootBucket().CreateBucketIfNotExists(queueBucketName)
+		if err != nil {
+			return fmt.Errorf("cannot create webhook queue bucket: %v",
+				err)
+		}
+		return nil
+	})
+}
+
+// nextEventID increments and returns the last assigned event ID.
+func nextEventID(tx walletdb.Tx) (uint64, error) {
+	var id uint64
+	if buf := tx.RootBucket().Get(lastEventIDName); len(buf) == 8 {
+		id = binary.BigEndian.Uint64(buf)
+	}
+	id++
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], id)
+	if err := tx.RootBucket().Put(lastEventIDName, buf[:]); err != nil {
+		return 0, fmt.Errorf("cannot store last event ID: %v", err)
+	}
+	return id, nil
+}
+
+// deliveryKey returns the database key of the delivery of an event within the
+// bucket of its URL.
+func deliveryKey(eventID uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, eventID)
+	return key
+}
+
+// serializeDelivery returns the serialization of a queued delivery.
+func serializeDelivery(d *delivery) []byte {
+	// The serialized delivery format is:
+	//   <attempts><nextattempt><urllen><url><payload>
+	//
+	// 4 bytes attempts + 8 bytes next attempt time (unix nanoseconds) +
+	// 2 bytes URL length + URL + payload
+	buf := make([]byte, 4+8+2+len(d.url)+len(d.payload))
+	binary.LittleEndian.PutUint32(buf[0:4], d.attempts)
+	binary.LittleEndian.PutUint64(buf[4:12], uint64(d.nextAttempt.UnixNano()))
+	binary.LittleEndian.PutUint16(buf[12:14], uint16(len(d.url)))
+	offset := 14
+	offset += copy(buf[offset:], d.url)
+	copy(buf[offset:], d.payload)
+	return buf
+}
+
+// deserializeDelivery deserializes the queued delivery with the given key.
+func deserializeDelivery(key, buf []byte) (*delivery, error) {
+	if len(buf) < 14 {
+		return nil, errMalformedDelivery
+	}
+	urlLen := int(binary.LittleEndian.Uint16(buf[12:14]))
+	if len(buf) < 14+urlLen {
+		return nil, errMalformedDelivery
+	}
+
+	d := &delivery{
+		key:         make([]byte, len(key)),
+		attempts:    binary.LittleEndian.Uint32(buf[0:4]),
+		nextAttempt: time.Unix(0, int64(binary.LittleEndian.Uint64(buf[4:12]))),
+		url:         string(buf[14 : 14+urlLen]),
+		payload:     make([]byte, len(buf)-14-urlLen),
+	}
+	copy(d.key, key)
+	copy(d.payload, buf[14+urlLen:])
+	return d, nil
+}
+
+// putDelivery stores a queued delivery in the bucket of its URL, replacing any
+// previous delivery with the same key.
+func putDelivery(tx walletdb.Tx, d *delivery) error {
+	queue := tx.RootBucket().Bucket(queueBucketName)
+	bucket, err := queue.CreateBucketIfNotExists([]byte(d.url))
+	if err != nil {
+		return fmt.Errorf("cannot create webhook queue for %s: %v",
+			d.url, err)
+	}
+	if err := bucket.Put(d.key, serializeDelivery(d)); err != nil {
+		return fmt.Errorf("cannot store webhook delivery: %v", err)
+	}
+	return nil
+}
+
+// deleteDelivery removes a queued delivery to a URL and returns whether it was
+// still queued.
+func deleteDelivery(tx walletdb.Tx, url string, key []byte) (bool, error) {
+	if !existsDelivery(tx, url, key) {
+		return false, nil
+	}
+	bucket := tx.RootBucket().Bucket(queueBucketName).Bucket([]byte(url))
+	if err := bucket.Delete(key); err != nil {
+		return false, fmt.Errorf("cannot remove webhook delivery: %v", err)
+	}
+	return true, nil
+}
+
+// existsDelivery returns whether a delivery to a URL is queued.
+func existsDelivery(tx walletdb.Tx, url string, key []byte) bool {
+	bucket := tx.RootBucket().Bucket(queueBucketName).Bucket([]byte(url))
+	return bucket != nil && bucket.Get(key) != nil
+}
+
+// fetchFirstDelivery returns the oldest delivery queued for a URL, or nil if
+// none are queued.
+func fetchFirstDelivery(tx walletdb.Tx, url string) (*delivery, error) {
+	bucket := tx.RootBucket().Bucket(queueBucketName).Bucket([]byte(url))
+	if bucket == nil {
+		return nil, nil
+	}
+	k, v := bucket.Cursor().First()
+	if k == nil {
+		return nil, nil
+	}
+	return deserializeDelivery(k, v)
+}
+
+// countDeliveries returns the number of deliveries queued for every URL which
+// has any.
+func countDeliveries(tx walletdb.Tx) (map[string]int, error) {
+	counts := make(map[string]int)
+	queue := tx.RootBucket().Bucket(queueBucketName)
+	var urls []string
+	err := queue.ForEach(func(k, v []byte) error {
+		if v == nil {
+			urls = append(urls, string(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, url := range urls {
+		err := queue.Bucket([]byte(url)).ForEach(func(k, v []byte) error {
+			counts[url]++
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return counts, nil
+}
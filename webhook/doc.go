@@ -0,0 +1,33 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+// Package webhook implements the delivery of wallet events to HTTP endpoints.
+//
+// Events are queued in a walletdb namespace before they are delivered, so no
+// queued events are lost when the wallet is restarted or an endpoint is
+// briefly unavailable.  Sent events are saved to the namespace by a goroutine
+// of the dispatcher, so sending an event does not wait for the database; an
+// event sent right before the process exits may therefore be lost.  Events
+// which fail to be saved are kept in memory and saved again later.  Each event
+// is POSTed as a JSON object to every configured URL, and failed deliveries
+// are retried with exponential backoff.  Every URL has
+// its own queue, delivered in the order events were sent and independently of
+// the other URLs, so an unavailable endpoint does not delay the others.
+// Deliveries are dropped after too many failed attempts, and the oldest
+// delivery to a URL is dropped when too many are queued for it.  Requests are
+// signed with an HMAC-SHA256 of the request body so receivers can verify the
+// sender.
+package webhook
@@ -0,0 +1,616 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/monetas/btcwallet/walletdb"
+)
+
+const (
+	// SignatureHeader is the HTTP header holding the hex encoded
+	// HMAC-SHA256 of the request body.
+	SignatureHeader = "X-Btcwallet-Signature"
+
+	// EventHeader is the HTTP header holding the type of the event.
+	EventHeader = "X-Btcwallet-Event"
+
+	// DefaultInitialBackoff is the default time to wait before retrying a
+	// failed delivery the first time.
+	DefaultInitialBackoff = 5 * time.Second
+
+	// DefaultMaxBackoff is the default maximum time to wait between
+	// retries of a failed delivery.
+	DefaultMaxBackoff = time.Hour
+
+	// DefaultTimeout is the default timeout of a single delivery request.
+	DefaultTimeout = 30 * time.Second
+
+	// DefaultMaxAttempts is the default number of attempts after which a
+	// delivery is dropped.  With the default backoffs, a delivery is
+	// retried for about a day and a half.
+	DefaultMaxAttempts = 48
+
+	// DefaultMaxQueued is the default maximum number of deliveries queued
+	// for a single URL.
+	DefaultMaxQueued = 10000
+)
+
+// ErrNoURLs describes an error where a dispatcher is created without any URLs
+// to deliver events to.
+var ErrNoURLs = errors.New("no webhook URLs configured")
+
+// Config describes the endpoints events are delivered to and how failed
+// deliveries are retried.  Zero values are replaced with their defaults.
+type Config struct {
+	// URLs are the endpoints every event is delivered to.
+	URLs []string
+
+	// Secret is the key used to sign request bodies.
+	Secret []byte
+
+	// MaxAttempts is the number of attempts after which a delivery is
+	// dropped.
+	MaxAttempts uint32
+
+	// MaxQueued is the maximum number of deliveries queued for a single
+	// URL.  The oldest delivery to a URL is dropped when an event is sent
+	// while its queue is full.
+	MaxQueued int
+
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Timeout        time.Duration
+}
+
+// Event is the JSON object POSTed for every wallet event.
+type Event struct {
+	ID   uint64          `json:"id"`
+	Type string          `json:"type"`
+	Time int64           `json:"time"`
+	Data json.RawMessage `json:"data"`
+}
+
+// urlQueue is the queue of deliveries to a single URL.  Every URL is
+// delivered to by its own goroutine, so an unavailable endpoint does not delay
+// deliveries to the others.
+type urlQueue struct {
+	url string
+
+	// wake is signaled when new deliveries are queued.
+	wake chan struct{}
+
+	// queued is the number of deliveries in the queue.  It is protected
+	// by the queue mutex of the dispatcher.
+	queued int
+}
+
+// sentEvent is an event passed to Send which is not yet queued in the
+// database.
+type sentEvent struct {
+	eventType string
+	time      int64
+	data      json.RawMessage
+}
+
+// Dispatcher queues events and delivers them to the configured URLs.
+type Dispatcher struct {
+	namespace walletdb.Namespace
+	config    Config
+	client    *http.Client
+
+	// queues holds the queues of the configured URLs, of which there are
+	// numConfigured, followed by those of URLs which are no longer
+	// configured but still have deliveries queued from before a restart.
+	queues        []*urlQueue
+	numConfigured int
+
+	// queueMtx is held while modifying the database queue along with the
+	// number of deliveries of the URL queues.
+	queueMtx sync.Mutex
+
+	// sent holds the events passed to Send which are not yet queued in
+	// the database, in the order they were sent.  The queue goroutine is
+	// signaled through saveWake when events are added.  Events which fail
+	// to be saved are put back and saved again later, but no more than
+	// MaxQueued of them are kept.
+	sent     []*sentEvent
+	sentMtx  sync.Mutex
+	saveWake chan struct{}
+
+	wg      sync.WaitGroup
+	quit    chan struct{}
+	quitMtx sync.Mutex
+}
+
+// New creates a dispatcher which queues deliveries in the passed namespace.
+// Deliveries queued before a restart are retried once the dispatcher is
+// started.
+func New(namespace walletdb.Namespace, config *Config) (*Dispatcher, error) {
+	if len(config.URLs) == 0 {
+		return nil, ErrNoURLs
+	}
+	if err := createQueue(namespace); err != nil {
+		return nil, err
+	}
+	var counts map[string]int
+	err := namespace.View(func(tx walletdb.Tx) error {
+		var err error
+		counts, err = countDeliveries(tx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Dispatcher{
+		namespace: namespace,
+		config:    *config,
+		saveWake:  make(chan struct{}, 1),
+		quit:      make(chan struct{}),
+	}
+	addQueue := func(url string) {
+		d.queues = append(d.queues, &urlQueue{
+			url:    url,
+			wake:   make(chan struct{}, 1),
+			queued: counts[url],
+		})
+		delete(counts, url)
+	}
+	for _, url := range config.URLs {
+		if !d.hasQueue(url) {
+			addQueue(url)
+		}
+	}
+	d.numConfigured = len(d.queues)
+	for url := range counts {
+		addQueue(url)
+	}
+
+	if d.config.MaxAttempts == 0 {
+		d.config.MaxAttempts = DefaultMaxAttempts
+	}
+	if d.config.MaxQueued <= 0 {
+		d.config.MaxQueued = DefaultMaxQueued
+	}
+	if d.config.InitialBackoff <= 0 {
+		d.config.InitialBackoff = DefaultInitialBackoff
+	}
+	if d.config.MaxBackoff <= 0 {
+		d.config.MaxBackoff = DefaultMaxBackoff
+	}
+	if d.config.Timeout <= 0 {
+		d.config.Timeout = DefaultTimeout
+	}
+	d.client = &http.Client{Timeout: d.config.Timeout}
+	return d, nil
+}
+
+// hasQueue returns whether the dispatcher has a queue for a URL.
+func (d *Dispatcher) hasQueue(url string) bool {
+	for _, q := range d.queues {
+		if q.url == url {
+			return true
+		}
+	}
+	return false
+}
+
+// Start starts the goroutines queueing sent events in the database and
+// delivering queued events.
+func (d *Dispatcher) Start() {
+	d.wg.Add(len(d.queues) + 1)
+	go d.queueHandler()
+	for _, q := range d.queues {
+		go d.deliveryHandler(q)
+	}
+}
+
+// Stop signals the dispatcher to stop delivering events.  Events which are
+// not yet delivered remain queued, and events sent afterwards are queued by
+// Send itself.
+func (d *Dispatcher) Stop() {
+	d.quitMtx.Lock()
+	defer d.quitMtx.Unlock()
+
+	select {
+	case <-d.quit:
+	default:
+		close(d.quit)
+	}
+}
+
+// WaitForShutdown blocks until the delivery goroutines have finished.
+func (d *Dispatcher) WaitForShutdown() {
+	d.wg.Wait()
+}
+
+// Send queues an event of the given type for delivery to every configured
+// URL.  The data is marshaled as the data field of the event.  Send does not
+// wait for the database: the event is saved by the queue goroutine of the
+// dispatcher, so Send may be called from goroutines which must not block.
+// Events sent before the dispatcher is started are saved once it is, and
+// events sent after it is stopped are saved before Send returns.
+//
+// NOTE: Until an event is saved it is only held in memory, and is lost if the
+// process exits.  The queue goroutine saves events as soon as they are sent,
+// and keeps retrying while the database fails, so this window normally lasts
+// no longer than a database write.
+func (d *Dispatcher) Send(eventType string, data interface{}) error {
+	rawData, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	d.sentMtx.Lock()
+	d.sent = append(d.sent, &sentEvent{
+		eventType: eventType,
+		time:      time.Now().Unix(),
+		data:      rawData,
+	})
+	d.sentMtx.Unlock()
+
+	select {
+	case <-d.quit:
+		// The queue goroutine is stopping or stopped, so it may not
+		// save the event.
+		return d.saveSent()
+	default:
+	}
+
+	// Wake the queue goroutine if it is not already awake.
+	select {
+	case d.saveWake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// queueHandler queues the sent events in the database whenever it is
+// signaled that events were sent, and once more when the dispatcher is
+// stopped.  Saving is retried after InitialBackoff while it fails.
+func (d *Dispatcher) queueHandler() {
+	var retry <-chan time.Time
+out:
+	for {
+		select {
+		case <-d.saveWake:
+		case <-retry:
+		case <-d.quit:
+			break out
+		}
+
+		retry = nil
+		if err := d.saveSent(); err != nil {
+			log.Errorf("Cannot queue webhook events, retrying in %v: %v",
+				d.config.InitialBackoff, err)
+			retry = time.After(d.config.InitialBackoff)
+		}
+	}
+	if err := d.saveSent(); err != nil {
+		log.Errorf("Cannot queue webhook events: %v", err)
+	}
+	d.wg.Done()
+}
+
+// saveSent queues the events passed to Send for delivery to every configured
+// URL, assigning them IDs in the order they were sent, and wakes the delivery
+// goroutines.  The events are put back in front of the sent list if they
+// cannot be saved, so a later call saves them; see restoreSent.
+func (d *Dispatcher) saveSent() error {
+	// The queue mutex is taken first so that events taken from the sent
+	// list by concurrent callers are saved in order.
+	d.queueMtx.Lock()
+	defer d.queueMtx.Unlock()
+
+	d.sentMtx.Lock()
+	events := d.sent
+	d.sent = nil
+	d.sentMtx.Unlock()
+	if len(events) == 0 {
+		return nil
+	}
+
+	queues := d.queues[:d.numConfigured]
+	queued := make([]int, len(queues))
+	dropped := make([]int, len(queues))
+	for i, q := range queues {
+		queued[i] = q.queued
+	}
+	err := d.namespace.Update(func(tx walletdb.Tx) error {
+		for _, e := range events {
+			id, err := nextEventID(tx)
+			if err != nil {
+				return err
+			}
+			payload, err := json.Marshal(&Event{
+				ID:   id,
+				Type: e.eventType,
+				Time: e.time,
+				Data: e.data,
+			})
+			if err != nil {
+				return err
+			}
+			for i, q := range queues {
+				if queued[i] >= d.config.MaxQueued {
+					oldest, err := fetchFirstDelivery(tx, q.url)
+					if err != nil {
+						return err
+					}
+					if oldest != nil {
+						_, err := deleteDelivery(tx, q.url,
+							oldest.key)
+						if err != nil {
+							return err
+						}
+						queued[i]--
+						dropped[i]++
+					}
+				}
+				err := putDelivery(tx, &delivery{
+					key:     deliveryKey(id),
+					url:     q.url,
+					payload: payload,
+				})
+				if err != nil {
+					return err
+				}
+				queued[i]++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		d.restoreSent(events)
+		return err
+	}
+
+	for i, q := range queues {
+		if dropped[i] != 0 {
+			log.Warnf("Dropped %d oldest webhook deliveries to %s: "+
+				"%d deliveries queued", dropped[i], q.url, queued[i])
+		}
+		q.queued = queued[i]
+
+		// Wake the delivery goroutine if it is not already awake.
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// restoreSent puts events which could not be saved back in front of the sent
+// list, ahead of the events sent since.  The oldest events are dropped when
+// more than MaxQueued events would be kept, as they would be by the queues in
+// the database.
+func (d *Dispatcher) restoreSent(events []*sentEvent) {
+	d.sentMtx.Lock()
+	defer d.sentMtx.Unlock()
+
+	d.sent = append(events, d.sent...)
+	if excess := len(d.sent) - d.config.MaxQueued; excess > 0 {
+		log.Warnf("Dropped %d oldest unsaved webhook events", excess)
+		d.sent = d.sent[excess:]
+	}
+}
+
+// Pending returns the number of deliveries which have not yet succeeded,
+// including those of sent events which are not yet queued in the database.
+func (d *Dispatcher) Pending() (int, error) {
+	d.queueMtx.Lock()
+	defer d.queueMtx.Unlock()
+
+	var n int
+	for _, q := range d.queues {
+		n += q.queued
+	}
+	d.sentMtx.Lock()
+	n += len(d.sent) * d.numConfigured
+	d.sentMtx.Unlock()
+	return n, nil
+}
+
+// Signature returns the hex encoded HMAC-SHA256 of a request body.  This is
+// the value of the SignatureHeader of every request, and may be used by
+// receivers to verify requests.
+func Signature(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoff returns the time to wait before the next attempt of a delivery
+// which has failed the given number of times.
+func (d *Dispatcher) backoff(attempts uint32) time.Duration {
+	wait := d.config.InitialBackoff
+	for i := uint32(1); i < attempts; i++ {
+		wait *= 2
+		if wait >= d.config.MaxBackoff {
+			return d.config.MaxBackoff
+		}
+	}
+	return wait
+}
+
+// deliveryHandler delivers the deliveries queued for a URL which are due, and
+// then waits for either new deliveries or the next retry.
+func (d *Dispatcher) deliveryHandler(q *urlQueue) {
+	timer := time.NewTimer(0)
+
+	// failures counts the consecutive times the queue could not be read or
+	// updated.  The queue is retried with a backoff, as no new event may
+	// wake the handler.
+	var failures uint32
+out:
+	for {
+		select {
+		case <-q.wake:
+		case <-timer.C:
+		case <-d.quit:
+			break out
+		}
+
+		next, err := d.deliverDue(q)
+		if err != nil {
+			failures++
+			next = time.Now().Add(d.backoff(failures))
+			log.Errorf("Cannot deliver webhook events to %s, "+
+				"retrying at %v: %v", q.url, next, err)
+		} else {
+			failures = 0
+		}
+
+		// Stop the timer, draining it if it already fired, so it can be
+		// reset for the next retry.
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if !next.IsZero() {
+			timer.Reset(next.Sub(time.Now()))
+		}
+	}
+	timer.Stop()
+	d.wg.Done()
+}
+
+// deliverDue attempts the deliveries queued for a URL in the order they were
+// sent, as long as they are due and succeed, and returns the time of the next
+// attempt, or the zero time if no deliveries remain queued.  Only the oldest
+// delivery is read from the database at a time.
+func (d *Dispatcher) deliverDue(q *urlQueue) (time.Time, error) {
+	for {
+		select {
+		case <-d.quit:
+			return time.Time{}, nil
+		default:
+		}
+
+		var dl *delivery
+		err := d.namespace.View(func(tx walletdb.Tx) error {
+			var err error
+			dl, err = fetchFirstDelivery(tx, q.url)
+			return err
+		})
+		if err != nil || dl == nil {
+			return time.Time{}, err
+		}
+		if time.Now().Before(dl.nextAttempt) {
+			return dl.nextAttempt, nil
+		}
+
+		err = d.post(dl)
+		if err == nil {
+			if err := d.removeDelivery(q, dl); err != nil {
+				return time.Time{}, err
+			}
+			continue
+		}
+
+		dl.attempts++
+		if dl.attempts >= d.config.MaxAttempts {
+			log.Warnf("Dropping webhook delivery to %s after %d "+
+				"failed attempts: %v", dl.url, dl.attempts, err)
+			if err := d.removeDelivery(q, dl); err != nil {
+				return time.Time{}, err
+			}
+			continue
+		}
+
+		dl.nextAttempt = time.Now().Add(d.backoff(dl.attempts))
+		log.Infof("Webhook delivery to %s failed (attempt %d), "+
+			"retrying at %v: %v", dl.url, dl.attempts,
+			dl.nextAttempt, err)
+		d.queueMtx.Lock()
+		err = d.namespace.Update(func(tx walletdb.Tx) error {
+			// The delivery may have been dropped by Send while
+			// it was attempted.
+			if !existsDelivery(tx, q.url, dl.key) {
+				return nil
+			}
+			return putDelivery(tx, dl)
+		})
+		d.queueMtx.Unlock()
+		if err != nil {
+			return time.Time{}, err
+		}
+		return dl.nextAttempt, nil
+	}
+}
+
+// removeDelivery removes a delivery from the queue of its URL, unless Send
+// already dropped it.
+func (d *Dispatcher) removeDelivery(q *urlQueue, dl *delivery) error {
+	d.queueMtx.Lock()
+	defer d.queueMtx.Unlock()
+
+	var removed bool
+	err := d.namespace.Update(func(tx walletdb.Tx) error {
+		var err error
+		removed, err = deleteDelivery(tx, q.url, dl.key)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if removed {
+		q.queued--
+	}
+	return nil
+}
+
+// post sends a single delivery.  Any response other than a 2xx status is an
+// error.
+func (d *Dispatcher) post(dl *delivery) error {
+	req, err := http.NewRequest("POST", dl.url, bytes.NewReader(dl.payload))
+	if err != nil {
+		return err
+	}
+	var event struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(dl.payload, &event); err == nil {
+		req.Header.Set(EventHeader, event.Type)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Signature(d.config.Secret, dl.payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return nil
+}
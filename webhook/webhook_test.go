@@ -0,0 +1,482 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/monetas/btcwallet/walletdb"
+	_ "github.com/monetas/btcwallet/walletdb/memdb"
+)
+
+// setupNamespace creates a new database and namespace for the dispatcher
+// queue and returns a teardown function to remove it.
+func setupNamespace(t *testing.T) (walletdb.Namespace, func()) {
+	db, err := walletdb.Create("memdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	teardown := func() {
+		db.Close()
+	}
+	namespace, err := db.Namespace([]byte("webhook"))
+	if err != nil {
+		teardown()
+		t.Fatal(err)
+	}
+	return namespace, teardown
+}
+
+func TestDeliveryRetry(t *testing.T) {
+	namespace, teardown := setupNamespace(t)
+	defer teardown()
+
+	secret := []byte("secret")
+	events := make(chan *Event, 2)
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Header.Get(SignatureHeader) != Signature(secret, body) {
+				t.Errorf("Bad signature %q", r.Header.Get(SignatureHeader))
+			}
+			// Fail the first request so the delivery is retried.
+			if requests == 1 {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			var e Event
+			if err := json.Unmarshal(body, &e); err != nil {
+				t.Fatal(err)
+			}
+			events <- &e
+		}))
+	defer srv.Close()
+
+	d, err := New(namespace, &Config{
+		URLs:           []string{srv.URL},
+		Secret:         secret,
+		InitialBackoff: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Queue the event before starting the dispatcher to ensure queued
+	// events are delivered on start.
+	if err := d.Send("test", map[string]int{"value": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := d.Pending(); err != nil || n != 1 {
+		t.Fatalf("Pending: got %d (err %v), want 1", n, err)
+	}
+
+	d.Start()
+	defer func() {
+		d.Stop()
+		d.WaitForShutdown()
+	}()
+
+	select {
+	case e := <-events:
+		if e.ID != 1 || e.Type != "test" || string(e.Data) != `{"value":1}` {
+			t.Fatalf("Unexpected event %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for event delivery")
+	}
+	if requests != 2 {
+		t.Fatalf("Wrong number of requests; got %d, want 2", requests)
+	}
+
+	// The delivered event must be removed from the queue.  The queue is
+	// updated after the response is read, so allow some time.
+	for i := 0; ; i++ {
+		n, err := d.Pending()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("Delivered event is still queued")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := &Dispatcher{config: Config{
+		InitialBackoff: time.Second,
+		MaxBackoff:     10 * time.Second,
+	}}
+	tests := []struct {
+		attempts uint32
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+	for _, test := range tests {
+		if got := d.backoff(test.attempts); got != test.want {
+			t.Errorf("backoff(%d): got %v, want %v", test.attempts,
+				got, test.want)
+		}
+	}
+}
+
+func TestUnavailableURLDoesNotDelay(t *testing.T) {
+	namespace, teardown := setupNamespace(t)
+	defer teardown()
+
+	// The unavailable endpoint does not answer until the test is done.
+	release := make(chan struct{})
+	dead := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			<-release
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}))
+	defer dead.Close()
+
+	events := make(chan *Event, 3)
+	alive := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			var e Event
+			if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+				t.Error(err)
+				return
+			}
+			events <- &e
+		}))
+	defer alive.Close()
+
+	d, err := New(namespace, &Config{
+		URLs:    []string{dead.URL, alive.URL},
+		Timeout: time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Start()
+	defer func() {
+		close(release)
+		d.Stop()
+		d.WaitForShutdown()
+	}()
+
+	for i := 1; i <= 3; i++ {
+		if err := d.Send("test", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := uint64(1); i <= 3; i++ {
+		select {
+		case e := <-events:
+			if e.ID != i {
+				t.Fatalf("Wrong event delivered; got %d, want %d",
+					e.ID, i)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Event %d was not delivered", i)
+		}
+	}
+}
+
+func TestMaxQueued(t *testing.T) {
+	namespace, teardown := setupNamespace(t)
+	defer teardown()
+
+	url := "http://127.0.0.1:1/"
+	d, err := New(namespace, &Config{URLs: []string{url}, MaxQueued: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.config.MaxAttempts != DefaultMaxAttempts {
+		t.Fatalf("Wrong default max attempts; got %d, want %d",
+			d.config.MaxAttempts, DefaultMaxAttempts)
+	}
+
+	// The dispatcher is not started, so all events remain queued and the
+	// oldest one is dropped when the third is saved.
+	for i := 0; i < 3; i++ {
+		if err := d.Send("test", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := d.Pending(); err != nil || n != 3 {
+		t.Fatalf("Pending before saving: got %d (err %v), want 3", n, err)
+	}
+	if err := d.saveSent(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := d.Pending(); err != nil || n != 2 {
+		t.Fatalf("Pending: got %d (err %v), want 2", n, err)
+	}
+	err = namespace.View(func(tx walletdb.Tx) error {
+		dl, err := fetchFirstDelivery(tx, url)
+		if err != nil {
+			return err
+		}
+		if dl == nil || !bytes.Equal(dl.key, deliveryKey(2)) {
+			t.Fatalf("Wrong oldest delivery: %+v", dl)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnconfiguredURLQueue(t *testing.T) {
+	namespace, teardown := setupNamespace(t)
+	defer teardown()
+
+	// Queue deliveries of two events to two URLs.
+	urls := []string{"http://127.0.0.1:1/a", "http://127.0.0.1:1/b"}
+	d, err := New(namespace, &Config{URLs: urls})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id := 1; id <= 2; id++ {
+		if err := d.Send("test", id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.saveSent(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the first URL is still configured, but the deliveries to both
+	// remain queued.
+	d, err = New(namespace, &Config{URLs: urls[:1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := d.Pending(); err != nil || n != 4 {
+		t.Fatalf("Pending: got %d (err %v), want 4", n, err)
+	}
+	if len(d.queues) != 2 {
+		t.Fatalf("Wrong number of URL queues; got %d, want 2",
+			len(d.queues))
+	}
+	err = namespace.View(func(tx walletdb.Tx) error {
+		for _, url := range urls {
+			dl, err := fetchFirstDelivery(tx, url)
+			if err != nil {
+				return err
+			}
+			if dl == nil || !bytes.Equal(dl.key, deliveryKey(1)) {
+				t.Fatalf("Wrong oldest delivery to %s: %+v", url, dl)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// failingNamespace is a namespace whose read-write transactions fail while
+// failing is set.
+type failingNamespace struct {
+	walletdb.Namespace
+	failing int32
+}
+
+var errUpdateFailed = errors.New("update failed")
+
+func (ns *failingNamespace) setFailing(fail bool) {
+	var failing int32
+	if fail {
+		failing = 1
+	}
+	atomic.StoreInt32(&ns.failing, failing)
+}
+
+func (ns *failingNamespace) Update(fn func(walletdb.Tx) error) error {
+	if atomic.LoadInt32(&ns.failing) != 0 {
+		return errUpdateFailed
+	}
+	return ns.Namespace.Update(fn)
+}
+
+func TestSaveSentFailure(t *testing.T) {
+	namespace, teardown := setupNamespace(t)
+	defer teardown()
+
+	url := "http://127.0.0.1:1/"
+	ns := &failingNamespace{Namespace: namespace}
+	d, err := New(ns, &Config{URLs: []string{url}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 2; i++ {
+		if err := d.Send("test", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Events which cannot be saved must be kept for the next attempt.
+	ns.setFailing(true)
+	if err := d.saveSent(); err != errUpdateFailed {
+		t.Fatalf("saveSent: got error %v, want %v", err, errUpdateFailed)
+	}
+	if err := d.Send("test", 3); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := d.Pending(); err != nil || n != 3 {
+		t.Fatalf("Pending: got %d (err %v), want 3", n, err)
+	}
+
+	ns.setFailing(false)
+	if err := d.saveSent(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := d.Pending(); err != nil || n != 3 {
+		t.Fatalf("Pending: got %d (err %v), want 3", n, err)
+	}
+	// The events must be queued in the order they were sent.
+	for i := 1; i <= 3; i++ {
+		var dl *delivery
+		err := namespace.View(func(tx walletdb.Tx) error {
+			var err error
+			dl, err = fetchFirstDelivery(tx, url)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var e Event
+		if err := json.Unmarshal(dl.payload, &e); err != nil {
+			t.Fatal(err)
+		}
+		if string(e.Data) != strconv.Itoa(i) {
+			t.Fatalf("Wrong event data; got %s, want %d", e.Data, i)
+		}
+		if err := d.removeDelivery(d.queues[0], dl); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestDeliveryQueueFailureRetry ensures a queue which cannot be updated after
+// a delivery is retried without another event waking it.
+func TestDeliveryQueueFailureRetry(t *testing.T) {
+	namespace, teardown := setupNamespace(t)
+	defer teardown()
+
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+		}))
+	defer srv.Close()
+
+	ns := &failingNamespace{Namespace: namespace}
+	d, err := New(ns, &Config{
+		URLs:           []string{srv.URL},
+		InitialBackoff: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Send("test", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.saveSent(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The delivery succeeds, but cannot be removed from the queue.
+	ns.setFailing(true)
+	d.Start()
+	defer func() {
+		d.Stop()
+		d.WaitForShutdown()
+	}()
+	for i := 0; atomic.LoadInt32(&requests) == 0; i++ {
+		if i == 100 {
+			t.Fatal("Timeout waiting for event delivery")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Once the database recovers, the queue must be retried and the
+	// delivery removed.
+	ns.setFailing(false)
+	for i := 0; ; i++ {
+		n, err := d.Pending()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("Delivery is still queued")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSendAfterStop(t *testing.T) {
+	namespace, teardown := setupNamespace(t)
+	defer teardown()
+
+	d, err := New(namespace, &Config{URLs: []string{"http://127.0.0.1:1/"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Start()
+	d.Stop()
+	d.WaitForShutdown()
+
+	// No goroutine saves events anymore, so Send must.
+	if err := d.Send("test", 1); err != nil {
+		t.Fatal(err)
+	}
+	err = namespace.View(func(tx walletdb.Tx) error {
+		dl, err := fetchFirstDelivery(tx, "http://127.0.0.1:1/")
+		if err != nil {
+			return err
+		}
+		if dl == nil {
+			t.Fatal("Event sent after stopping was not queued")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,131 @@
+/*
+ * Copyright (c) 2015 Conformal Systems LLC <info@conformal.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package main
+
+import (
+	"github.com/btcsuite/btcutil"
+	"github.com/monetas/btcwallet/wallet"
+	"github.com/monetas/btcwallet/webhook"
+)
+
+// webhookNamespaceKey is the wallet database namespace holding the queue of
+// webhook deliveries.
+var webhookNamespaceKey = []byte("webhook")
+
+// Types of webhook events.
+const (
+	webhookBlockConnected = "blockconnected"
+	webhookCredit         = "credit"
+	webhookBalance        = "balance"
+	webhookConfirmations  = "confirmations"
+)
+
+// webhookBlock is the data of a blockconnected webhook event.
+type webhookBlock struct {
+	Hash   string `json:"hash"`
+	Height int32  `json:"height"`
+}
+
+// webhookBalanceData is the data of a balance webhook event.
+type webhookBalanceData struct {
+	Balance   float64 `json:"balance"`
+	Confirmed bool    `json:"confirmed"`
+}
+
+// webhookConfirmationsData is the data of a confirmations webhook event.
+type webhookConfirmationsData struct {
+	Watch         uint64 `json:"watch"`
+	TxID          string `json:"txid"`
+	Confirmations int32  `json:"confirmations"`
+	Target        int32  `json:"target"`
+	Reached       bool   `json:"reached"`
+}
+
+// openWebhooks creates the dispatcher for the webhook URLs of the config,
+// queueing deliveries in the wallet database.  A nil dispatcher is returned
+// if no URLs are configured or the wallet is read-only.
+func openWebhooks(w *wallet.Wallet) (*webhook.Dispatcher, error) {
+	if len(cfg.WebhookURLs) == 0 {
+		return nil, nil
+	}
+	if w.ReadOnly() {
+		log.Warnf("Webhooks are disabled for read-only wallets")
+		return nil, nil
+	}
+	namespace, err := w.Db().Namespace(webhookNamespaceKey)
+	if err != nil {
+		return nil, err
+	}
+	return webhook.New(namespace, &webhook.Config{
+		URLs:   cfg.WebhookURLs,
+		Secret: []byte(cfg.WebhookSecret),
+	})
+}
+
+// sendWebhook queues a webhook event for connected blocks, transaction
+// credits (both new and mined), balance changes, and confirmation watches.
+// Other notifications are not delivered to webhooks.
+//
+// Webhook events are taken from the notifications of the RPC server instead
+// of being received from the wallet directly, because the wallet's Listen*
+// channels each have a single receiver, which is the RPC server.  It is called
+// from the goroutine serving websocket clients, so the dispatcher saves the
+// events to the database from its own goroutine.
+func (s *rpcServer) sendWebhook(n wsClientNotification) {
+	var eventType string
+	var data interface{}
+	switch n := n.(type) {
+	case blockConnected:
+		eventType = webhookBlockConnected
+		data = &webhookBlock{Hash: n.Hash.String(), Height: n.Height}
+
+	case txCredit:
+		_, ltr, err := n.toJSON(s.wallet)
+		if err != nil {
+			log.Errorf("Cannot create webhook event for transaction "+
+				"credit: %v", err)
+			return
+		}
+		eventType = webhookCredit
+		data = &ltr
+
+	case confirmedBalance:
+		eventType = webhookBalance
+		data = &webhookBalanceData{btcutil.Amount(n).ToBTC(), true}
+
+	case unconfirmedBalance:
+		eventType = webhookBalance
+		data = &webhookBalanceData{btcutil.Amount(n).ToBTC(), false}
+
+	case txConfirmations:
+		eventType = webhookConfirmations
+		data = &webhookConfirmationsData{
+			Watch:         n.WatchID,
+			TxID:          n.TxHash.String(),
+			Confirmations: n.Confirmations,
+			Target:        n.Target,
+			Reached:       n.Reached,
+		}
+
+	default:
+		return
+	}
+
+	if err := s.webhooks.Send(eventType, data); err != nil {
+		log.Errorf("Cannot queue webhook event: %v", err)
+	}
+}